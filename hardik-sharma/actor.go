@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+const actorHeader = "X-Actor"
+
+const maxActorLength = 128
+
+const (
+	anonymousActor = "anonymous"
+	// systemActor starts the history of customers stored before it existed
+	// and purges the trash on schedule
+	systemActor = "system"
+)
+
+type actorKey struct{}
+
+func withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := r.Header.Get(actorHeader)
+		if !validActor(actor) {
+			actor = anonymousActor
+		}
+
+		next.ServeHTTP(w, r.WithContext(contextWithActor(r.Context(), actor)))
+	})
+}
+
+func validActor(actor string) bool {
+	if actor == "" || len(actor) > maxActorLength {
+		return false
+	}
+
+	for i := 0; i < len(actor); i++ {
+		if actor[i] < 0x20 || actor[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return anonymousActor
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_withActor(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		wantActor string
+	}{
+		{
+			name:      "named actor is kept",
+			header:    "hardik sharma",
+			wantActor: "hardik sharma",
+		},
+		{
+			name:      "missing actor is anonymous",
+			header:    "",
+			wantActor: anonymousActor,
+		},
+		{
+			name:      "actor with control characters is anonymous",
+			header:    "hardik\tsharma",
+			wantActor: anonymousActor,
+		},
+		{
+			name:      "overlong actor is anonymous",
+			header:    strings.Repeat("a", maxActorLength+1),
+			wantActor: anonymousActor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := withActor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = actorFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest("DELETE", "/api/customers/hs", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Actor", tt.header)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.Equal(t, tt.wantActor, seen, "expect actor to be same")
+		})
+	}
+}
+
+func Test_actorFromContext(t *testing.T) {
+	assert.Equal(t, anonymousActor, actorFromContext(context.Background()), "expect context without actor to be anonymous")
+	assert.Equal(t, "hardik", actorFromContext(contextWithActor(context.Background(), "hardik")), "expect actor to be same")
+}
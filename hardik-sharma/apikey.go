@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const apiKeyPrefix = "ck"
+
+// APIKey is a stored API key. Secrets are long random strings, so a plain
+// SHA-256 is as good as a slow password hash.
+type APIKey struct {
+	bun.BaseModel `bun:"table:api_keys,alias:api_key"`
+
+	Id        string     `json:"id" bun:"id,pk"`
+	Subject   string     `json:"subject" bun:"subject"`
+	Hash      string     `json:"-" bun:"hash"`
+	Roles     []string   `json:"roles" bun:"roles"`
+	Tenant    string     `json:"tenant" bun:"tenant_id"`
+	CreatedAt time.Time  `json:"createdAt" bun:"created_at"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bun:"revoked_at,nullzero"`
+}
+
+// newAPIKey also returns the key to hand out, ck_<id>_<secret>.
+func newAPIKey(subject string, tenant string, roles []string, now time.Time) (APIKey, string, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+
+	key := APIKey{
+		Id:        hex.EncodeToString(id),
+		Subject:   subject,
+		Roles:     append([]string{}, roles...),
+		Tenant:    tenant,
+		CreatedAt: now.UTC().Truncate(time.Microsecond),
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = hashAPIKeySecret(encoded)
+
+	return key, apiKeyPrefix + "_" + key.Id + "_" + encoded, nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseAPIKey(key string) (id string, secret string, ok bool) {
+	prefix, rest, ok := strings.Cut(key, "_")
+	if !ok || prefix != apiKeyPrefix {
+		return "", "", false
+	}
+
+	id, secret, ok = strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// APIKeyStore keeps API keys.
+type APIKeyStore interface {
+	createAPIKey(ctx context.Context, key APIKey) error
+	apiKey(ctx context.Context, id string) (APIKey, error)
+	listAPIKeys(ctx context.Context) ([]APIKey, error)
+	revokeAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+type sqlAPIKeyStore struct {
+	db *bun.DB
+}
+
+func NewSQLAPIKeyStore(db *bun.DB) *sqlAPIKeyStore {
+	return &sqlAPIKeyStore{db: db}
+}
+
+func (s *sqlAPIKeyStore) createAPIKey(ctx context.Context, key APIKey) error {
+	_, err := s.db.NewInsert().Model(&key).Exec(ctx)
+	return err
+}
+
+func (s *sqlAPIKeyStore) apiKey(ctx context.Context, id string) (APIKey, error) {
+	var key APIKey
+	err := s.db.NewSelect().Model(&key).Where("id = ?", id).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrNotFound
+	}
+
+	return key, err
+}
+
+func (s *sqlAPIKeyStore) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := s.db.NewSelect().Model(&keys).Order("created_at", "id").Scan(ctx)
+	return keys, err
+}
+
+func (s *sqlAPIKeyStore) revokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	res, err := s.db.NewUpdate().
+		Model((*APIKey)(nil)).
+		Set("revoked_at = coalesce(revoked_at, ?)", at.UTC()).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// APIKeyAuthenticator accepts the X-API-Key keys of its store.
+type APIKeyAuthenticator struct {
+	keys APIKeyStore
+}
+
+func NewAPIKeyAuthenticator(keys APIKeyStore) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{keys: keys}
+}
+
+func (a *APIKeyAuthenticator) challenge() string {
+	return `ApiKey realm="customers"`
+}
+
+func (a *APIKeyAuthenticator) authenticate(r *http.Request) (Principal, error) {
+	presented := apiKeyOf(r)
+	if presented == "" {
+		return Principal{}, errNoCredentials
+	}
+
+	id, secret, ok := parseAPIKey(presented)
+	if !ok {
+		return Principal{}, fmt.Errorf("%w: malformed api key", ErrUnauthenticated)
+	}
+
+	key, err := a.keys.apiKey(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		return Principal{}, fmt.Errorf("%w: unknown api key", ErrUnauthenticated)
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(key.Hash)) != 1 {
+		return Principal{}, fmt.Errorf("%w: unknown api key", ErrUnauthenticated)
+	}
+
+	if key.RevokedAt != nil {
+		return Principal{}, fmt.Errorf("%w: api key revoked", ErrUnauthenticated)
+	}
+
+	return Principal{Subject: key.Subject, Method: AuthAPIKey, Roles: key.Roles, Tenant: key.Tenant}, nil
+}
+
+func runAPIKeyCommand(ctx context.Context, keys APIKeyStore, args []string, out io.Writer) error {
+	usage := errors.New("usage: apikey create [-tenant <tenant>] <subject> [role...] | list | revoke <id>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		tenant := fs.String("tenant", DefaultTenant, "tenant of the key")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() < 1 || !validActor(fs.Arg(0)) {
+			return usage
+		}
+		if !validTenant(*tenant) {
+			return fmt.Errorf("invalid tenant %q, use lowercase letters, digits, - and _", *tenant)
+		}
+
+		key, handedOut, err := newAPIKey(fs.Arg(0), *tenant, fs.Args()[1:], time.Now())
+		if err != nil {
+			return err
+		}
+		if err := keys.createAPIKey(ctx, key); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "created key %s for %s of tenant %s with roles %s, it won't be shown again:\n%s\n", key.Id, key.Subject, key.Tenant, formatRoles(key.Roles), handedOut)
+	case "list":
+		if len(args) != 1 {
+			return usage
+		}
+
+		list, err := keys.listAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, key := range list {
+			state := "active"
+			if key.RevokedAt != nil {
+				state = "revoked " + key.RevokedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key.Id, key.Subject, key.Tenant, formatRoles(key.Roles), key.CreatedAt.UTC().Format(time.RFC3339), state)
+		}
+		return w.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return usage
+		}
+
+		if err := keys.revokeAPIKey(ctx, args[1], time.Now()); err != nil {
+			return fmt.Errorf("api key %s: %w", args[1], err)
+		}
+		fmt.Fprintf(out, "revoked key %s\n", args[1])
+	default:
+		return fmt.Errorf("unknown apikey command %q", args[0])
+	}
+
+	return nil
+}
+
+func formatRoles(roles []string) string {
+	if len(roles) == 0 {
+		return "-"
+	}
+	return strings.Join(roles, ",")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createTestAPIKey stores a key for subject of the default tenant with roles
+// and returns the key handed out.
+func createTestAPIKey(t *testing.T, keys APIKeyStore, subject string, roles ...string) (APIKey, string) {
+	return createTenantAPIKey(t, keys, DefaultTenant, subject, roles...)
+}
+
+// createTenantAPIKey is createTestAPIKey for a key of tenant.
+func createTenantAPIKey(t *testing.T, keys APIKeyStore, tenant string, subject string, roles ...string) (APIKey, string) {
+	key, handedOut, err := newAPIKey(subject, tenant, roles, conformanceDeletedAt)
+	if err != nil {
+		t.Fatal("failed to make api key:", err)
+	}
+	if err := keys.createAPIKey(context.Background(), key); err != nil {
+		t.Fatal("failed to store api key:", err)
+	}
+	return key, handedOut
+}
+
+func Test_newAPIKey(t *testing.T) {
+	key, handedOut, err := newAPIKey("hardik", DefaultTenant, []string{"editor"}, conformanceDeletedAt)
+	assert.NoError(t, err, "expect a key")
+
+	id, secret, ok := parseAPIKey(handedOut)
+	assert.True(t, ok, "expect the key to parse")
+	assert.Equal(t, key.Id, id, "expect the key to carry its id")
+	assert.Equal(t, hashAPIKeySecret(secret), key.Hash, "expect only the hash of the secret to be kept")
+	assert.NotContains(t, key.Hash, secret, "expect the secret not to be stored")
+
+	_, other, _ := newAPIKey("hardik", DefaultTenant, nil, conformanceDeletedAt)
+	assert.NotEqual(t, handedOut, other, "expect every key to be different")
+}
+
+func Test_parseAPIKey(t *testing.T) {
+	tests := []struct {
+		key        string
+		wantId     string
+		wantSecret string
+		wantOk     bool
+	}{
+		{key: "ck_0123abcd_s3cr_et", wantId: "0123abcd", wantSecret: "s3cr_et", wantOk: true},
+		{key: "xx_0123abcd_secret"},
+		{key: "ck_0123abcd"},
+		{key: "ck__secret"},
+		{key: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			gotId, gotSecret, gotOk := parseAPIKey(tt.key)
+
+			assert.Equal(t, tt.wantOk, gotOk, "expect ok to be same")
+			assert.Equal(t, tt.wantId, gotId, "expect id to be same")
+			assert.Equal(t, tt.wantSecret, gotSecret, "expect secret to be same")
+		})
+	}
+}
+
+func Test_sqlAPIKeyStore(t *testing.T) {
+	keys := NewSQLAPIKeyStore(setupSQLite(t, nil))
+	ctx := context.Background()
+
+	hardik, _ := createTenantAPIKey(t, keys, "acme", "hardik", "editor", "admin")
+	varshil, _ := createTestAPIKey(t, keys, "varshil")
+
+	got, err := keys.apiKey(ctx, hardik.Id)
+	assert.NoError(t, err, "expect key to be found")
+	got.CreatedAt = got.CreatedAt.UTC()
+	assert.Equal(t, hardik, got, "expect key as stored")
+
+	_, err = keys.apiKey(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound, "expect unknown key not to be found")
+
+	assert.NoError(t, keys.revokeAPIKey(ctx, varshil.Id, conformanceDeletedAt), "expect key to be revoked")
+	assert.ErrorIs(t, keys.revokeAPIKey(ctx, "unknown", conformanceDeletedAt), ErrNotFound, "expect unknown key not to be found")
+
+	list, err := keys.listAPIKeys(ctx)
+	assert.NoError(t, err, "expect keys to be listed")
+	revokedAt := map[string]bool{}
+	for _, key := range list {
+		revokedAt[key.Subject] = key.RevokedAt != nil
+	}
+	assert.Equal(t, map[string]bool{"hardik": false, "varshil": true}, revokedAt, "expect every key, only varshil's revoked")
+}
+
+func TestAPIKeyAuthenticator_authenticate(t *testing.T) {
+	keys := NewSQLAPIKeyStore(setupSQLite(t, nil))
+	_, hardik := createTenantAPIKey(t, keys, "acme", "hardik", "viewer")
+	revoked, varshil := createTestAPIKey(t, keys, "varshil")
+	if err := keys.revokeAPIKey(context.Background(), revoked.Id, conformanceDeletedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, _ := parseAPIKey(hardik)
+
+	tests := []struct {
+		name          string
+		key           string
+		wantPrincipal Principal
+		wantErr       error
+	}{
+		{
+			name:          "valid key",
+			key:           hardik,
+			wantPrincipal: Principal{Subject: "hardik", Method: AuthAPIKey, Roles: []string{"viewer"}, Tenant: "acme"},
+		},
+		{
+			name:    "no key",
+			wantErr: errNoCredentials,
+		},
+		{
+			name:    "wrong secret",
+			key:     apiKeyPrefix + "_" + id + "_wrong",
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "unknown id",
+			key:     apiKeyPrefix + "_ffffffffffffffff_secret",
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "revoked key",
+			key:     varshil,
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "malformed key",
+			key:     "hunter2",
+			wantErr: ErrUnauthenticated,
+		},
+	}
+
+	authenticator := NewAPIKeyAuthenticator(keys)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/customers", nil)
+			if tt.key != "" {
+				r.Header.Set(apiKeyHeader, tt.key)
+			}
+
+			gotPrincipal, gotErr := authenticator.authenticate(r)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expect error to be same")
+			assert.Equal(t, tt.wantPrincipal, gotPrincipal, "expect principal to be same")
+		})
+	}
+}
+
+func Test_runAPIKeyCommand(t *testing.T) {
+	keys := NewSQLAPIKeyStore(setupSQLite(t, nil))
+	ctx := context.Background()
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := runAPIKeyCommand(ctx, keys, args, &out)
+		return out.String(), err
+	}
+
+	out, err := run("create", "-tenant", "acme", "hardik", "viewer", "editor")
+	assert.NoError(t, err, "expect key to be created")
+	handedOut := regexp.MustCompile(`ck_\S+`).FindString(out)
+	id, _, ok := parseAPIKey(handedOut)
+	assert.True(t, ok, "expect the key to be printed")
+
+	r := httptest.NewRequest("GET", "/api/customers", nil)
+	r.Header.Set(apiKeyHeader, handedOut)
+	principal, err := NewAPIKeyAuthenticator(keys).authenticate(r)
+	assert.NoError(t, err, "expect the printed key to authenticate")
+	assert.Equal(t, "hardik", principal.Subject, "expect the key to be for its subject")
+	assert.Equal(t, []string{"viewer", "editor"}, principal.Roles, "expect the key to have its roles")
+	assert.Equal(t, "acme", principal.Tenant, "expect the key to be of its tenant")
+
+	out, err = run("revoke", id)
+	assert.NoError(t, err, "expect key to be revoked")
+	assert.Equal(t, "revoked key "+id+"\n", out, "expect revocation to be reported")
+
+	out, err = run("list")
+	assert.NoError(t, err, "expect keys to be listed")
+	assert.True(t, strings.HasPrefix(out, id+"  hardik  acme  viewer,editor  2"), "expect id, subject, tenant, roles and creation time, got %q", out)
+	assert.Contains(t, out, "revoked 2", "expect key to be listed as revoked")
+
+	for _, args := range [][]string{{}, {"create"}, {"revoke"}, {"rotate"}, {"create", "bad\nname"}, {"create", "-tenant", "Acme", "hardik"}, {"create", "-tenant", "acme"}} {
+		_, err := run(args...)
+		assert.Error(t, err, "expect %q to be rejected", args)
+	}
+
+	_, err = run("revoke", "unknown")
+	assert.ErrorIs(t, err, ErrNotFound, "expect unknown key not to be found")
+}
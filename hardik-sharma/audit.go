@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+var ErrInvalidAuditFilter = errors.New("invalid audit filter")
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditPatch   = "patch"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+var auditOperations = map[string]bool{
+	AuditCreate:  true,
+	AuditUpdate:  true,
+	AuditPatch:   true,
+	AuditDelete:  true,
+	AuditRestore: true,
+	AuditPurge:   true,
+}
+
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEntry records one attempt to change a customer, a failed purge has
+// no TargetId. Hash covers every other field, so changing, removing or
+// inserting an entry breaks the chain that runs through the entries of
+// every tenant.
+type AuditEntry struct {
+	bun.BaseModel `bun:"table:audit_log,alias:audit"`
+
+	Seq       int64     `json:"seq" bun:"seq,pk"`
+	Tenant    string    `json:"tenant" bun:"tenant_id"`
+	At        time.Time `json:"at" bun:"at"`
+	Actor     string    `json:"actor" bun:"actor"`
+	SourceIP  string    `json:"sourceIp" bun:"source_ip"`
+	RequestId string    `json:"requestId" bun:"request_id"`
+	Operation string    `json:"operation" bun:"operation"`
+	TargetId  string    `json:"targetId" bun:"target_id"`
+	Outcome   string    `json:"outcome" bun:"outcome"`
+	Reason    string    `json:"reason,omitempty" bun:"reason"`
+	PrevHash  string    `json:"prevHash" bun:"prev_hash"`
+	Hash      string    `json:"hash" bun:"hash"`
+}
+
+func hashAuditEntry(entry AuditEntry) string {
+	fields := []string{
+		strconv.FormatInt(entry.Seq, 10),
+		entry.At.UTC().Format(time.RFC3339Nano),
+		entry.Actor,
+		entry.SourceIP,
+		entry.RequestId,
+		entry.Operation,
+		entry.TargetId,
+		entry.Outcome,
+		entry.Reason,
+		entry.PrevHash,
+	}
+	// entries recorded before there were tenants are of the default one
+	// and keep their hash
+	if entry.Tenant != "" && entry.Tenant != DefaultTenant {
+		fields = append(fields, entry.Tenant)
+	}
+	content, _ := json.Marshal(fields)
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func chainAuditEntry(last *AuditEntry, entry AuditEntry) AuditEntry {
+	if entry.Tenant == "" {
+		entry.Tenant = DefaultTenant
+	}
+	entry.Seq, entry.PrevHash = 1, ""
+	if last != nil {
+		entry.Seq, entry.PrevHash = last.Seq+1, last.Hash
+	}
+	entry.At = entry.At.UTC()
+	entry.Hash = hashAuditEntry(entry)
+	return entry
+}
+
+// AuditLog keeps audit entries, serializing appends so that the chain
+// never forks.
+type AuditLog interface {
+	append(ctx context.Context, entry AuditEntry) (AuditEntry, error)
+	// query returns the entries newest first, filter is normalized
+	query(ctx context.Context, filter AuditFilter) (AuditPage, error)
+}
+
+// AuditFilter selects audit entries, empty fields match everything.
+type AuditFilter struct {
+	Tenant    string
+	Actor     string
+	Operation string
+	TargetId  string
+	Outcome   string
+	Since     time.Time
+	Until     time.Time
+	Before    int64
+	Limit     int
+
+	seqs []int64
+}
+
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Next    string       `json:"next,omitempty"`
+}
+
+func (f AuditFilter) normalize() (AuditFilter, error) {
+	if f.Operation != "" && !auditOperations[f.Operation] {
+		return f, fmt.Errorf("%w: unknown operation %q", ErrInvalidAuditFilter, f.Operation)
+	}
+
+	if f.Outcome != "" && f.Outcome != AuditSuccess && f.Outcome != AuditFailure {
+		return f, fmt.Errorf("%w: outcome must be %s or %s", ErrInvalidAuditFilter, AuditSuccess, AuditFailure)
+	}
+
+	if f.Limit < 0 || f.Limit > MaxPageLimit {
+		return f, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidAuditFilter, MaxPageLimit)
+	}
+
+	if f.Limit == 0 {
+		f.Limit = DefaultPageLimit
+	}
+
+	if f.Before < 0 {
+		return f, fmt.Errorf("%w: invalid cursor", ErrInvalidAuditFilter)
+	}
+
+	// stored times are in UTC, which the SQL logs compare as text
+	f.Since, f.Until = f.Since.UTC(), f.Until.UTC()
+
+	return f, nil
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	return (f.Tenant == "" || entry.Tenant == f.Tenant) &&
+		(f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.Operation == "" || entry.Operation == f.Operation) &&
+		(f.TargetId == "" || entry.TargetId == f.TargetId) &&
+		(f.Outcome == "" || entry.Outcome == f.Outcome) &&
+		(f.Since.IsZero() || !entry.At.Before(f.Since)) &&
+		(f.Until.IsZero() || !entry.At.After(f.Until)) &&
+		(f.Before == 0 || entry.Seq < f.Before) &&
+		(f.seqs == nil || slices.Contains(f.seqs, entry.Seq))
+}
+
+func newAuditPage(entries []AuditEntry, filter AuditFilter) AuditPage {
+	page := AuditPage{Entries: entries}
+
+	if len(entries) > filter.Limit {
+		page.Entries = entries[:filter.Limit]
+		page.Next = strconv.FormatInt(page.Entries[filter.Limit-1].Seq, 10)
+	}
+
+	return page
+}
+
+// AuditVerification is the result of checking the hash chain.
+type AuditVerification struct {
+	Valid    bool  `json:"valid"`
+	Entries  int64 `json:"entries"`
+	BrokenAt int64 `json:"brokenAt,omitempty"`
+}
+
+// verifyAuditLog checks the entries of tenant page by page, each against
+// the entry before it, whichever tenant that belongs to.
+func verifyAuditLog(ctx context.Context, log AuditLog, tenant string) (AuditVerification, error) {
+	result := AuditVerification{Valid: true}
+	broken := func(seq int64) {
+		result.Valid = false
+		result.BrokenAt = seq
+	}
+
+	filter := AuditFilter{Tenant: tenant, Limit: MaxPageLimit}
+	for {
+		page, err := log.query(ctx, filter)
+		if err != nil {
+			return AuditVerification{}, err
+		}
+
+		previous, err := previousAuditHashes(ctx, log, page.Entries)
+		if err != nil {
+			return AuditVerification{}, err
+		}
+
+		for _, entry := range page.Entries {
+			result.Entries++
+
+			if hashAuditEntry(entry) != entry.Hash {
+				broken(entry.Seq)
+			}
+			// the first entry follows nothing, its hash is ""
+			hash, ok := previous[entry.Seq-1]
+			if entry.Seq > 1 && !ok || hash != entry.PrevHash {
+				broken(entry.Seq)
+			}
+		}
+
+		if page.Next == "" {
+			break
+		}
+		filter.Before = page.Entries[len(page.Entries)-1].Seq
+	}
+
+	return result, nil
+}
+
+func previousAuditHashes(ctx context.Context, log AuditLog, entries []AuditEntry) (map[int64]string, error) {
+	seqs := []int64{}
+	for _, entry := range entries {
+		if entry.Seq > 1 {
+			seqs = append(seqs, entry.Seq-1)
+		}
+	}
+
+	hashes := map[int64]string{}
+	if len(seqs) == 0 {
+		return hashes, nil
+	}
+
+	page, err := log.query(ctx, AuditFilter{Limit: len(seqs), seqs: seqs})
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range page.Entries {
+		hashes[entry.Seq] = entry.Hash
+	}
+	return hashes, nil
+}
+
+// MemoryAuditLog keeps entries until the process exits.
+type MemoryAuditLog struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+func NewMemoryAuditLog() *MemoryAuditLog {
+	return &MemoryAuditLog{entries: []AuditEntry{}}
+}
+
+func (m *MemoryAuditLog) append(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return AuditEntry{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry = chainAuditEntry(m.last(), entry)
+	m.entries = append(m.entries, entry)
+	return entry, nil
+}
+
+func (m *MemoryAuditLog) last() *AuditEntry {
+	if len(m.entries) == 0 {
+		return nil
+	}
+	return &m.entries[len(m.entries)-1]
+}
+
+func (m *MemoryAuditLog) query(ctx context.Context, filter AuditFilter) (AuditPage, error) {
+	if err := ctx.Err(); err != nil {
+		return AuditPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := []AuditEntry{}
+	for i := len(m.entries) - 1; i >= 0 && len(matched) <= filter.Limit; i-- {
+		if filter.matches(m.entries[i]) {
+			matched = append(matched, m.entries[i])
+		}
+	}
+
+	return newAuditPage(matched, filter), nil
+}
+
+const sourceIPHeader = "X-Forwarded-For"
+
+type sourceIPKey struct{}
+
+// withSourceIP only believes X-Forwarded-For of trusted proxies.
+func withSourceIP(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded := strings.Join(r.Header.Values(sourceIPHeader), ",")
+			ip := forwardedIP(remoteIP(r.RemoteAddr), forwarded, trustedProxies)
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sourceIPKey{}, ip)))
+		})
+	}
+}
+
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func trustedIP(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedIP walks X-Forwarded-For back from the connection for as long
+// as the hop at hand is a trusted proxy, which makes the next address to
+// the left the one it saw. Whatever the client sent itself is further left
+// still and never reached.
+func forwardedIP(remote string, forwarded string, trusted []*net.IPNet) string {
+	ip := remote
+	if forwarded == "" {
+		return ip
+	}
+
+	hops := strings.Split(forwarded, ",")
+	for i := len(hops) - 1; i >= 0 && trustedIP(ip, trusted); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range splitList(list) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", item)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", item)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func sourceIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(sourceIPKey{}).(string)
+	return ip
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestFileAuditLog(t *testing.T, dir string) *FileAuditLog {
+	log, err := OpenFileAuditLog(dir)
+	if err != nil {
+		t.Fatal("failed to open audit log:", err)
+	}
+	t.Cleanup(func() { log.Close() })
+
+	return log
+}
+
+func TestFileAuditLog(t *testing.T) {
+	RunAuditLogConformance(t, func(t *testing.T) AuditLog {
+		return openTestFileAuditLog(t, t.TempDir())
+	})
+}
+
+func TestFileAuditLog_reopen(t *testing.T) {
+	tests := []struct {
+		name        string
+		damage      func(t *testing.T, path string)
+		wantEntries int
+		wantValid   bool
+		wantErr     error
+	}{
+		{
+			name:        "intact file",
+			damage:      func(t *testing.T, path string) {},
+			wantEntries: 4,
+			wantValid:   true,
+		},
+		{
+			name: "last entry torn by a crash",
+			damage: func(t *testing.T, path string) {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(path, info.Size()-5); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantEntries: 3,
+			wantValid:   true,
+		},
+		{
+			name: "entry edited",
+			damage: func(t *testing.T, path string) {
+				raw, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				raw = bytes.Replace(raw, []byte(`"actor":"bob"`), []byte(`"actor":"eve"`), 1)
+				if err := os.WriteFile(path, raw, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantEntries: 4,
+		},
+		{
+			name: "damaged entry followed by others",
+			damage: func(t *testing.T, path string) {
+				raw, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				raw[0] = '['
+				if err := os.WriteFile(path, raw, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: ErrCorruptAuditLog,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			log := openTestFileAuditLog(t, dir)
+			appendAuditEntries(t, log, auditEntries)
+			log.Close()
+
+			tt.damage(t, filepath.Join(dir, auditFileName))
+
+			reopened, err := OpenFileAuditLog(dir)
+			assert.ErrorIs(t, err, tt.wantErr, "expect error to be same")
+			if err != nil {
+				return
+			}
+			defer reopened.Close()
+
+			verification, err := verifyAuditLog(context.Background(), reopened, DefaultTenant)
+			assert.NoError(t, err, "expect verification to succeed")
+			assert.Equal(t, int64(tt.wantEntries), verification.Entries, "expect entries to be read back")
+			assert.Equal(t, tt.wantValid, verification.Valid, "expect chain validity to be same")
+
+			// appends after a torn entry must follow the last intact one
+			if _, err := reopened.append(context.Background(), auditEntries[0]); err != nil {
+				t.Fatal("failed to append audit entry:", err)
+			}
+			reopened.Close()
+
+			again := openTestFileAuditLog(t, dir)
+			verification, _ = verifyAuditLog(context.Background(), again, DefaultTenant)
+			assert.Equal(t, int64(tt.wantEntries+1), verification.Entries, "expect later appends to survive")
+			assert.Equal(t, tt.wantValid, verification.Valid, "expect later appends to extend the chain")
+		})
+	}
+}
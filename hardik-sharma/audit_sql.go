@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/uptrace/bun"
+)
+
+const auditLockKey = 20231204
+
+type sqlAuditLog struct {
+	db   *bun.DB
+	lock func(ctx context.Context, tx bun.Tx) error
+}
+
+func NewPostgresAuditLog(db *bun.DB) *sqlAuditLog {
+	return &sqlAuditLog{db: db, lock: func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", int64(auditLockKey))
+		return err
+	}}
+}
+
+// NewSQLiteAuditLog needs no lock, transactions lock the whole database.
+func NewSQLiteAuditLog(db *bun.DB) *sqlAuditLog {
+	return &sqlAuditLog{db: db, lock: func(ctx context.Context, tx bun.Tx) error {
+		return nil
+	}}
+}
+
+func (a *sqlAuditLog) append(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	err := a.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := a.lock(ctx, tx); err != nil {
+			return err
+		}
+
+		var last *AuditEntry
+		var latest AuditEntry
+		err := tx.NewSelect().Model(&latest).Order("seq DESC").Limit(1).Scan(ctx)
+		if err == nil {
+			last = &latest
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		entry = chainAuditEntry(last, entry)
+		_, err = tx.NewInsert().Model(&entry).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	return entry, nil
+}
+
+func (a *sqlAuditLog) query(ctx context.Context, filter AuditFilter) (AuditPage, error) {
+	entries := []AuditEntry{}
+	q := a.db.NewSelect().Model(&entries)
+
+	if filter.Tenant != "" {
+		q = q.Where("tenant_id = ?", filter.Tenant)
+	}
+	if filter.Actor != "" {
+		q = q.Where("actor = ?", filter.Actor)
+	}
+	if filter.Operation != "" {
+		q = q.Where("operation = ?", filter.Operation)
+	}
+	if filter.TargetId != "" {
+		q = q.Where("target_id = ?", filter.TargetId)
+	}
+	if filter.Outcome != "" {
+		q = q.Where("outcome = ?", filter.Outcome)
+	}
+
+	if !filter.Since.IsZero() {
+		q = q.Where("at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		q = q.Where("at <= ?", filter.Until)
+	}
+	if filter.Before != 0 {
+		q = q.Where("seq < ?", filter.Before)
+	}
+	if filter.seqs != nil {
+		q = q.Where("seq IN (?)", bun.In(filter.seqs))
+	}
+
+	if err := q.Order("seq DESC").Limit(filter.Limit + 1).Scan(ctx); err != nil {
+		return AuditPage{}, err
+	}
+
+	return newAuditPage(entries, filter), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_sqliteAuditLog(t *testing.T) {
+	RunAuditLogConformance(t, func(t *testing.T) AuditLog {
+		return NewSQLiteAuditLog(setupSQLite(t, nil))
+	})
+}
+
+func Test_sqliteAuditLog_appendOnly(t *testing.T) {
+	db := setupSQLite(t, nil)
+	log := NewSQLiteAuditLog(db)
+	appendAuditEntries(t, log, auditEntries)
+
+	_, err := db.ExecContext(context.Background(), "UPDATE audit_log SET actor = 'eve' WHERE seq = 2")
+	assert.ErrorContains(t, err, "append-only", "expect entries to be immutable")
+
+	_, err = db.ExecContext(context.Background(), "DELETE FROM audit_log WHERE seq = 4")
+	assert.ErrorContains(t, err, "append-only", "expect entries to be kept")
+
+	// someone able to drop the trigger still can't hide the change
+	if _, err := db.ExecContext(context.Background(), "DROP TRIGGER audit_log_no_update"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(context.Background(), "UPDATE audit_log SET actor = 'eve' WHERE seq = 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	verification, err := verifyAuditLog(context.Background(), log, DefaultTenant)
+	assert.NoError(t, err, "expect verification to succeed")
+	assert.Equal(t, AuditVerification{Entries: 4, BrokenAt: 2}, verification, "expect the changed entry to be found")
+}
+
+func Test_postgresAuditLog(t *testing.T) {
+	RunAuditLogConformance(t, func(t *testing.T) AuditLog {
+		db := setupDB(t, nil)
+
+		// the table refuses to be emptied, which tests may do
+		_, err := db.ExecContext(context.Background(), `
+			ALTER TABLE audit_log DISABLE TRIGGER audit_log_append_only;
+			TRUNCATE TABLE audit_log;
+			ALTER TABLE audit_log ENABLE TRIGGER audit_log_append_only;`)
+		if err != nil {
+			t.Fatal("failed to empty audit log:", err)
+		}
+
+		return NewPostgresAuditLog(db)
+	})
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// AuditLogFactory returns an empty audit log. It is called once per case
+// and should clean up after itself through t.
+type AuditLogFactory func(t *testing.T) AuditLog
+
+// RunAuditLogConformance checks the contract every AuditLog shares, like
+// RunRepoConformance does for repos.
+func RunAuditLogConformance(t *testing.T, newLog AuditLogFactory) {
+	t.Run("append", func(t *testing.T) { testAuditLogAppend(t, newLog) })
+	t.Run("query", func(t *testing.T) { testAuditLogQuery(t, newLog) })
+	t.Run("pages", func(t *testing.T) { testAuditLogPages(t, newLog) })
+	t.Run("tenants", func(t *testing.T) { testAuditLogTenants(t, newLog) })
+	t.Run("canceled context", func(t *testing.T) { testAuditLogCanceledContext(t, newLog) })
+	t.Run("concurrent appends", func(t *testing.T) { testAuditLogConcurrentAppends(t, newLog) })
+}
+
+// auditEntries are the attempts recorded by the conformance tests, an hour
+// apart starting at conformanceDeletedAt.
+var auditEntries = []AuditEntry{
+	{Actor: "alice", SourceIP: "10.0.0.1", RequestId: "r1", Operation: AuditCreate, TargetId: "hs", Outcome: AuditSuccess},
+	{Actor: "bob", SourceIP: "10.0.0.2", RequestId: "r2", Operation: AuditUpdate, TargetId: "hs", Outcome: AuditFailure, Reason: "version_conflict"},
+	{Actor: "alice", SourceIP: "10.0.0.1", RequestId: "r3", Operation: AuditCreate, TargetId: "hm", Outcome: AuditFailure, Reason: "validation_failed"},
+	{Actor: "bob", SourceIP: "10.0.0.2", RequestId: "r4", Operation: AuditDelete, TargetId: "hs", Outcome: AuditSuccess},
+}
+
+func auditEntryAt(entry AuditEntry, hours int) AuditEntry {
+	entry.At = conformanceDeletedAt.Add(time.Duration(hours) * time.Hour)
+	return entry
+}
+
+// appendAuditEntries records entries and returns them as stored.
+func appendAuditEntries(t *testing.T, log AuditLog, entries []AuditEntry) []AuditEntry {
+	stored := make([]AuditEntry, len(entries))
+	for i, entry := range entries {
+		var err error
+		if stored[i], err = log.append(context.Background(), auditEntryAt(entry, i)); err != nil {
+			t.Fatal("failed to append audit entry:", err)
+		}
+	}
+
+	return stored
+}
+
+func queryAuditLog(t *testing.T, log AuditLog, filter AuditFilter) AuditPage {
+	filter, err := filter.normalize()
+	if err != nil {
+		t.Fatal("invalid filter:", err)
+	}
+
+	page, err := log.query(context.Background(), filter)
+	if err != nil {
+		t.Fatal("failed to query audit log:", err)
+	}
+
+	for i := range page.Entries {
+		page.Entries[i].At = page.Entries[i].At.UTC()
+	}
+	return page
+}
+
+func auditSeqs(entries []AuditEntry) []int64 {
+	seqs := []int64{}
+	for _, entry := range entries {
+		seqs = append(seqs, entry.Seq)
+	}
+	return seqs
+}
+
+func testAuditLogAppend(t *testing.T, newLog AuditLogFactory) {
+	log := newLog(t)
+	stored := appendAuditEntries(t, log, auditEntries)
+
+	for i, entry := range stored {
+		assert.Equal(t, int64(i+1), entry.Seq, "expect entries to be numbered in order")
+		assert.Equal(t, hashAuditEntry(entry), entry.Hash, "expect entry %d to be hashed", entry.Seq)
+		if i == 0 {
+			assert.Empty(t, entry.PrevHash, "expect the first entry to follow nothing")
+		} else {
+			assert.Equal(t, stored[i-1].Hash, entry.PrevHash, "expect entry %d to follow the one before", entry.Seq)
+		}
+	}
+
+	page := queryAuditLog(t, log, AuditFilter{})
+	assert.Equal(t, []AuditEntry{stored[3], stored[2], stored[1], stored[0]}, page.Entries, "expect entries as stored, newest first")
+
+	verification, err := verifyAuditLog(context.Background(), log, DefaultTenant)
+	assert.NoError(t, err, "expect verification to succeed")
+	assert.Equal(t, AuditVerification{Valid: true, Entries: 4}, verification, "expect chain to be intact")
+}
+
+func testAuditLogQuery(t *testing.T, newLog AuditLogFactory) {
+	log := newLog(t)
+	appendAuditEntries(t, log, auditEntries)
+
+	tests := []struct {
+		name     string
+		filter   AuditFilter
+		wantSeqs []int64
+	}{
+		{name: "everything", filter: AuditFilter{}, wantSeqs: []int64{4, 3, 2, 1}},
+		{name: "by actor", filter: AuditFilter{Actor: "alice"}, wantSeqs: []int64{3, 1}},
+		{name: "by operation", filter: AuditFilter{Operation: AuditCreate}, wantSeqs: []int64{3, 1}},
+		{name: "by target", filter: AuditFilter{TargetId: "hs"}, wantSeqs: []int64{4, 2, 1}},
+		{name: "by outcome", filter: AuditFilter{Outcome: AuditFailure}, wantSeqs: []int64{3, 2}},
+		{name: "since", filter: AuditFilter{Since: conformanceDeletedAt.Add(2 * time.Hour)}, wantSeqs: []int64{4, 3}},
+		{name: "until", filter: AuditFilter{Until: conformanceDeletedAt.Add(time.Hour)}, wantSeqs: []int64{2, 1}},
+		{name: "since in another zone", filter: AuditFilter{Since: conformanceDeletedAt.Add(3 * time.Hour).In(time.FixedZone("IST", 19800))}, wantSeqs: []int64{4}},
+		{name: "combined", filter: AuditFilter{Actor: "bob", TargetId: "hs", Outcome: AuditSuccess}, wantSeqs: []int64{4}},
+		{name: "nothing matches", filter: AuditFilter{Actor: "mallory"}, wantSeqs: []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := queryAuditLog(t, log, tt.filter)
+
+			assert.Equal(t, tt.wantSeqs, auditSeqs(page.Entries), "expect matching entries, newest first")
+			assert.Empty(t, page.Next, "expect a single page")
+		})
+	}
+}
+
+func testAuditLogPages(t *testing.T, newLog AuditLogFactory) {
+	log := newLog(t)
+	appendAuditEntries(t, log, auditEntries)
+
+	filter := AuditFilter{TargetId: "hs", Limit: 2}
+	first := queryAuditLog(t, log, filter)
+	assert.Equal(t, []int64{4, 2}, auditSeqs(first.Entries), "expect the newest entries first")
+	assert.Equal(t, "2", first.Next, "expect the next page to continue below the last entry")
+
+	filter.Before, _ = strconv.ParseInt(first.Next, 10, 64)
+	second := queryAuditLog(t, log, filter)
+	assert.Equal(t, []int64{1}, auditSeqs(second.Entries), "expect the rest on the second page")
+	assert.Empty(t, second.Next, "expect no further page")
+}
+
+func testAuditLogTenants(t *testing.T, newLog AuditLogFactory) {
+	log := newLog(t)
+	entries := append(auditEntries[:2:2], AuditEntry{Tenant: "acme", Actor: "paramveer", SourceIP: "10.0.0.3", RequestId: "r5", Operation: AuditCreate, TargetId: "hs", Outcome: AuditSuccess})
+	appendAuditEntries(t, log, append(entries, auditEntries[2:]...))
+
+	assert.Equal(t, []int64{3}, auditSeqs(queryAuditLog(t, log, AuditFilter{Tenant: "acme"}).Entries), "expect entries of the tenant")
+	assert.Equal(t, []int64{5, 4, 2, 1}, auditSeqs(queryAuditLog(t, log, AuditFilter{Tenant: DefaultTenant}).Entries), "expect entries of the default tenant")
+
+	for tenant, want := range map[string]AuditVerification{
+		"acme":        {Valid: true, Entries: 1},
+		DefaultTenant: {Valid: true, Entries: 4},
+		"other":       {Valid: true},
+	} {
+		verification, err := verifyAuditLog(context.Background(), log, tenant)
+		assert.NoError(t, err, "expect verification to succeed")
+		assert.Equal(t, want, verification, "expect only the entries of %s to be counted", tenant)
+	}
+}
+
+func testAuditLogCanceledContext(t *testing.T, newLog AuditLogFactory) {
+	log := newLog(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := log.append(ctx, auditEntries[0])
+	assert.ErrorIs(t, err, context.Canceled, "expect append to give up")
+
+	_, err = log.query(ctx, AuditFilter{Limit: DefaultPageLimit})
+	assert.ErrorIs(t, err, context.Canceled, "expect query to give up")
+}
+
+func testAuditLogConcurrentAppends(t *testing.T, newLog AuditLogFactory) {
+	log := newLog(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := log.append(context.Background(), auditEntryAt(auditEntries[i%len(auditEntries)], i)); err != nil {
+				t.Error("failed to append audit entry:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	verification, err := verifyAuditLog(context.Background(), log, DefaultTenant)
+	assert.NoError(t, err, "expect verification to succeed")
+	assert.Equal(t, AuditVerification{Valid: true, Entries: 20}, verification, "expect one unbroken chain")
+}
+
+func TestMemoryAuditLog(t *testing.T) {
+	RunAuditLogConformance(t, func(t *testing.T) AuditLog {
+		return NewMemoryAuditLog()
+	})
+}
+
+func Test_verifyAuditLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		tenant string
+		tamper func(entries []AuditEntry) []AuditEntry
+		want   AuditVerification
+	}{
+		{
+			name:   "untouched",
+			tenant: DefaultTenant,
+			tamper: func(entries []AuditEntry) []AuditEntry { return entries },
+			want:   AuditVerification{Valid: true, Entries: 3},
+		},
+		{
+			name:   "untouched, other tenant",
+			tenant: "acme",
+			tamper: func(entries []AuditEntry) []AuditEntry { return entries },
+			want:   AuditVerification{Valid: true, Entries: 1},
+		},
+		{
+			name:   "empty",
+			tenant: DefaultTenant,
+			tamper: func(entries []AuditEntry) []AuditEntry { return nil },
+			want:   AuditVerification{Valid: true},
+		},
+		{
+			name:   "changed field",
+			tenant: DefaultTenant,
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				entries[1].Outcome = AuditSuccess
+				return entries
+			},
+			want: AuditVerification{Entries: 3, BrokenAt: 2},
+		},
+		{
+			name:   "changed field of another tenant",
+			tenant: "acme",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				entries[1].Outcome = AuditSuccess
+				return entries
+			},
+			want: AuditVerification{Valid: true, Entries: 1},
+		},
+		{
+			name:   "changed field hashed again",
+			tenant: "acme",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				entries[1].Actor = "alice"
+				entries[1].Hash = hashAuditEntry(entries[1])
+				return entries
+			},
+			want: AuditVerification{Entries: 1, BrokenAt: 3},
+		},
+		{
+			name:   "removed entry of another tenant",
+			tenant: DefaultTenant,
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				return append(entries[:2], entries[3:]...)
+			},
+			want: AuditVerification{Entries: 3, BrokenAt: 4},
+		},
+		{
+			name:   "removed first entry",
+			tenant: DefaultTenant,
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				return entries[1:]
+			},
+			want: AuditVerification{Entries: 2, BrokenAt: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := append([]AuditEntry{}, auditEntries...)
+			entries[2].Tenant = "acme"
+
+			log := NewMemoryAuditLog()
+			stored := appendAuditEntries(t, log, entries)
+			log.entries = tt.tamper(stored)
+
+			got, err := verifyAuditLog(context.Background(), log, tt.tenant)
+
+			assert.NoError(t, err, "expect verification to succeed")
+			assert.Equal(t, tt.want, got, "expect verification to be same")
+		})
+	}
+}
+
+func Test_verifyAuditLog_pages(t *testing.T) {
+	log := NewMemoryAuditLog()
+	for i := 0; i < MaxPageLimit+5; i++ {
+		if _, err := log.append(context.Background(), auditEntries[0]); err != nil {
+			t.Fatal("failed to append audit entry:", err)
+		}
+	}
+	log.entries[2].TargetId = "hm"
+
+	got, err := verifyAuditLog(context.Background(), log, DefaultTenant)
+
+	assert.NoError(t, err, "expect verification to succeed")
+	assert.Equal(t, AuditVerification{Entries: MaxPageLimit + 5, BrokenAt: 3}, got, "expect every page to be checked")
+}
+
+func Test_hashAuditEntry(t *testing.T) {
+	entry := chainAuditEntry(nil, auditEntryAt(auditEntries[0], 0))
+
+	inIST := entry
+	inIST.At = entry.At.In(time.FixedZone("IST", 19800))
+	assert.Equal(t, entry.Hash, hashAuditEntry(inIST), "expect the hash not to depend on the time zone")
+
+	// fields run into each other when joined without separators
+	shifted := entry
+	shifted.Actor, shifted.SourceIP = entry.Actor+"1", entry.SourceIP[1:]
+	assert.NotEqual(t, entry.Hash, hashAuditEntry(shifted), "expect fields to be hashed apart")
+
+	// entries of the default tenant hash as they did before tenants
+	untenanted := entry
+	untenanted.Tenant = ""
+	assert.Equal(t, DefaultTenant, entry.Tenant, "expect entries without a tenant to be of the default one")
+	assert.Equal(t, entry.Hash, hashAuditEntry(untenanted), "expect the default tenant not to change the hash")
+
+	other := entry
+	other.Tenant = "acme"
+	assert.NotEqual(t, entry.Hash, hashAuditEntry(other), "expect the tenant to be hashed")
+}
+
+func TestAuditFilter_normalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  AuditFilter
+		want    AuditFilter
+		wantErr error
+	}{
+		{
+			name:   "defaults",
+			filter: AuditFilter{},
+			want:   AuditFilter{Limit: DefaultPageLimit},
+		},
+		{
+			name:   "times in UTC",
+			filter: AuditFilter{Since: conformanceDeletedAt.In(time.FixedZone("IST", 19800)), Limit: 5},
+			want:   AuditFilter{Since: conformanceDeletedAt, Limit: 5},
+		},
+		{
+			name:    "unknown operation",
+			filter:  AuditFilter{Operation: "archive"},
+			wantErr: ErrInvalidAuditFilter,
+		},
+		{
+			name:    "unknown outcome",
+			filter:  AuditFilter{Outcome: "maybe"},
+			wantErr: ErrInvalidAuditFilter,
+		},
+		{
+			name:    "limit too large",
+			filter:  AuditFilter{Limit: MaxPageLimit + 1},
+			wantErr: ErrInvalidAuditFilter,
+		},
+		{
+			name:    "negative cursor",
+			filter:  AuditFilter{Before: -1},
+			wantErr: ErrInvalidAuditFilter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.normalize()
+
+			assert.ErrorIs(t, err, tt.wantErr, "expect error to be same")
+			if tt.wantErr == nil {
+				assert.Equal(t, tt.want, got, "expect filter to be same")
+			}
+		})
+	}
+}
+
+func Test_withSourceIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5123", want: "203.0.113.7"},
+		{name: "forwarded by a trusted network", remoteAddr: "10.1.2.3:5123", forwarded: []string{"198.51.100.4, 10.1.2.2"}, want: "198.51.100.4"},
+		{name: "forwarded by a trusted address", remoteAddr: "192.168.1.1:5123", forwarded: []string{"198.51.100.4"}, want: "198.51.100.4"},
+		{name: "forwarded by anyone else", remoteAddr: "203.0.113.7:5123", forwarded: []string{"198.51.100.4"}, want: "203.0.113.7"},
+		{name: "trusted proxy without header", remoteAddr: "10.1.2.3:5123", want: "10.1.2.3"},
+		{name: "address made up by the client", remoteAddr: "10.1.2.3:5123", forwarded: []string{"127.0.0.1, 198.51.100.4"}, want: "198.51.100.4"},
+		{name: "address made up by the client through trusted proxies", remoteAddr: "10.1.2.3:5123", forwarded: []string{"127.0.0.1, 198.51.100.4, 192.168.1.1"}, want: "198.51.100.4"},
+		{name: "forwarded in several headers", remoteAddr: "10.1.2.3:5123", forwarded: []string{"127.0.0.1", "198.51.100.4, 10.1.2.2"}, want: "198.51.100.4"},
+		{name: "only trusted proxies", remoteAddr: "10.1.2.3:5123", forwarded: []string{"10.1.2.1, 10.1.2.2"}, want: "10.1.2.1"},
+		{name: "invalid address", remoteAddr: "10.1.2.3:5123", forwarded: []string{"198.51.100.4, unknown"}, want: "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := withSourceIP(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = sourceIPFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest("GET", "/api/customers", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, forwarded := range tt.forwarded {
+				r.Header.Add(sourceIPHeader, forwarded)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.Equal(t, tt.want, got, "expect source ip to be same")
+		})
+	}
+}
+
+func Test_parseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none", list: "", want: []string{}},
+		{name: "networks and addresses", list: "10.0.0.0/8, 127.0.0.1,::1", want: []string{"10.0.0.0/8", "127.0.0.1/32", "::1/128"}},
+		{name: "invalid address", list: "10.0.0.300", wantErr: true},
+		{name: "invalid network", list: "10.0.0.0/33", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := parseTrustedProxies(tt.list)
+
+			if tt.wantErr {
+				assert.Error(t, err, "expect list to be rejected")
+				return
+			}
+
+			got := []string{}
+			for _, network := range networks {
+				got = append(got, network.String())
+			}
+			assert.NoError(t, err, "expect list to be accepted")
+			assert.Equal(t, tt.want, got, "expect networks to be same")
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// errNoCredentials lets the next Authenticator try.
+var errNoCredentials = errors.New("no credentials")
+
+const (
+	AuthAPIKey = "apikey"
+	AuthJWT    = "jwt"
+)
+
+var authMethods = []string{AuthAPIKey, AuthJWT}
+
+const apiKeyHeader = "X-API-Key"
+
+// Principal is who an authenticated request is made by.
+type Principal struct {
+	Subject string   `json:"subject"`
+	Method  string   `json:"method"`
+	Roles   []string `json:"roles,omitempty"`
+	Tenant  string   `json:"tenant"`
+}
+
+// Authenticator checks one kind of credentials of a request.
+type Authenticator interface {
+	// authenticate returns errNoCredentials when the request has none of
+	// its credentials and an ErrUnauthenticated error when they are wrong
+	authenticate(r *http.Request) (Principal, error)
+	// challenge is the WWW-Authenticate value asking for its credentials
+	challenge() string
+}
+
+type principalKey struct{}
+
+// withAuthentication lets the first authenticator finding its credentials
+// decide, without any every request is let through.
+func withAuthentication(authenticators []Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(authenticators) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := authenticate(r, authenticators)
+			if err != nil {
+				for _, authenticator := range authenticators {
+					w.Header().Add("WWW-Authenticate", authenticator.challenge())
+				}
+				writeProblem(w, r, err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), principalKey{}, principal)
+			ctx = contextWithTenant(ctx, principal.Tenant)
+			next.ServeHTTP(w, r.WithContext(contextWithActor(ctx, principal.Subject)))
+		})
+	}
+}
+
+func authenticate(r *http.Request, authenticators []Authenticator) (Principal, error) {
+	for _, authenticator := range authenticators {
+		principal, err := authenticator.authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return Principal{}, err
+		}
+
+		// subjects are recorded as actors, so they must be as safe to
+		// log as the X-Actor header
+		if !validActor(principal.Subject) {
+			return Principal{}, fmt.Errorf("%w: invalid subject", ErrUnauthenticated)
+		}
+		if !validTenant(principal.Tenant) {
+			return Principal{}, fmt.Errorf("%w: invalid tenant", ErrUnauthenticated)
+		}
+		return principal, nil
+	}
+
+	return Principal{}, fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+}
+
+func principalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// bearerToken also reads the access_token query parameter, browsers can't
+// set headers on websocket upgrades.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+func apiKeyOf(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		return r.URL.Query().Get("api_key")
+	}
+	return ""
+}
+
+func newAuthenticators(config AuthConfig, keys APIKeyStore) ([]Authenticator, error) {
+	var authenticators []Authenticator
+	for _, method := range splitList(config.Methods) {
+		switch method {
+		case AuthAPIKey:
+			if keys == nil {
+				return nil, errors.New("api keys need the postgres or sqlite repo")
+			}
+			authenticators = append(authenticators, NewAPIKeyAuthenticator(keys))
+		case AuthJWT:
+			authenticator, err := NewJWTAuthenticator(config)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, authenticator)
+		default:
+			return nil, fmt.Errorf("unknown auth method %q", method)
+		}
+	}
+
+	return authenticators, nil
+}
+
+// checkOrigin always accepts clients that aren't browsers, they send no
+// Origin.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || slices.Contains(allowed, "*") {
+			return true
+		}
+
+		for _, candidate := range allowed {
+			if strings.EqualFold(strings.TrimSuffix(candidate, "/"), origin) {
+				return true
+			}
+		}
+
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
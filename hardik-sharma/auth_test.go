@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// newAuthHandler serves customers to callers with a JWT signed for the
+// tests or the API key it returns, made for varshil.
+func newAuthHandler(t *testing.T, opts ...HandlerOption) (http.Handler, *Service, string) {
+	keys := NewSQLAPIKeyStore(setupSQLite(t, nil))
+	_, apiKey := createTestAPIKey(t, keys, "varshil")
+
+	service := NewService(NewInMemoryRepo(), WithIdGenerator(fixedId("hs")), WithClock(steppingClock()))
+	t.Cleanup(service.Close)
+
+	opts = append(opts, WithAuthenticators(NewAPIKeyAuthenticator(keys), newTestJWTAuthenticator(t)))
+	return registerRoutes(NewCustomerHandler(service, opts...)), service, apiKey
+}
+
+func Test_withAuthentication(t *testing.T) {
+	handler, service, apiKey := newAuthHandler(t)
+	body := `{"customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}}`
+
+	tests := []struct {
+		name          string
+		headers       map[string]string
+		wantCode      int
+		wantBody      string
+		wantChallenge []string
+		wantActor     string
+	}{
+		{
+			name:          "no credentials",
+			headers:       map[string]string{"X-Actor": "hardik"},
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      `{"type": "/problems/unauthenticated", "title": "authentication required", "status": 401, "code": "unauthenticated", "detail": "unauthenticated: no credentials"}`,
+			wantChallenge: []string{`ApiKey realm="customers"`, `Bearer realm="customers"`},
+		},
+		{
+			name:          "expired token",
+			headers:       map[string]string{"Authorization": "Bearer " + signJWT(t, "HS256", "hs", validClaims(map[string]any{"exp": testJWTNow.Add(-time.Hour).Unix()}))},
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      `{"type": "/problems/unauthenticated", "title": "authentication required", "status": 401, "code": "unauthenticated", "detail": "unauthenticated: token expired"}`,
+			wantChallenge: []string{`ApiKey realm="customers"`, `Bearer realm="customers"`},
+		},
+		{
+			name:          "subject unfit to be an actor",
+			headers:       map[string]string{"Authorization": "Bearer " + signJWT(t, "HS256", "hs", validClaims(map[string]any{"sub": "line\nbreak"}))},
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      `{"type": "/problems/unauthenticated", "title": "authentication required", "status": 401, "code": "unauthenticated", "detail": "unauthenticated: invalid subject"}`,
+			wantChallenge: []string{`ApiKey realm="customers"`, `Bearer realm="customers"`},
+		},
+		{
+			name:      "valid token, claimed actor ignored",
+			headers:   map[string]string{"Authorization": "Bearer " + signJWT(t, "RS256", "rs", validClaims(nil)), "X-Actor": "admin"},
+			wantCode:  http.StatusCreated,
+			wantActor: "hardik",
+		},
+		{
+			name:      "valid api key",
+			headers:   map[string]string{"X-API-Key": apiKey},
+			wantCode:  http.StatusCreated,
+			wantActor: "varshil",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/api/customers", strings.NewReader(body))
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantCode, w.Code, "expect status code to be same")
+			assert.Equal(t, tt.wantChallenge, w.Header().Values("WWW-Authenticate"), "expect challenges to be same")
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
+			}
+			if tt.wantActor == "" {
+				return
+			}
+
+			page, err := service.queryAudit(context.Background(), AuditFilter{Limit: 1})
+			assert.NoError(t, err, "expect audit log to be read")
+			assert.Equal(t, tt.wantActor, page.Entries[0].Actor, "expect the principal to be the actor")
+
+			// the next case creates the customer again
+			service.deleteCustomer(context.Background(), "hs", 0)
+			service.customerRepo.purge(context.Background(), time.Now().Add(time.Hour))
+		})
+	}
+}
+
+func Test_withAuthentication_disabled(t *testing.T) {
+	var gotActor string
+	var gotAuthenticated bool
+	handler := withActor(withAuthentication(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotActor = actorFromContext(r.Context())
+		_, gotAuthenticated = principalFromContext(r.Context())
+	})))
+
+	r := httptest.NewRequest("GET", "/api/customers", nil)
+	r.Header.Set("X-Actor", "hardik")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "hardik", gotActor, "expect the claimed actor without authentication")
+	assert.False(t, gotAuthenticated, "expect no principal")
+}
+
+func TestCustomerHandler_websocketAuthentication(t *testing.T) {
+	handler, _, apiKey := newAuthHandler(t, WithWebsocketOrigins([]string{"https://ui.example.com"}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	tests := []struct {
+		name     string
+		query    string
+		headers  http.Header
+		wantCode int
+	}{
+		{
+			name:     "no credentials",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "token in the query",
+			query:    "?access_token=" + signJWT(t, "HS256", "hs", validClaims(nil)),
+			wantCode: http.StatusSwitchingProtocols,
+		},
+		{
+			name:     "api key in the query",
+			query:    "?api_key=" + apiKey,
+			wantCode: http.StatusSwitchingProtocols,
+		},
+		{
+			name:     "token in the header",
+			headers:  http.Header{"Authorization": {"Bearer " + signJWT(t, "RS256", "rs", validClaims(nil))}},
+			wantCode: http.StatusSwitchingProtocols,
+		},
+		{
+			name:     "allowed origin",
+			query:    "?api_key=" + apiKey,
+			headers:  http.Header{"Origin": {"https://ui.example.com"}},
+			wantCode: http.StatusSwitchingProtocols,
+		},
+		{
+			name:     "other origin",
+			query:    "?api_key=" + apiKey,
+			headers:  http.Header{"Origin": {"https://evil.example.com"}},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, res, err := websocket.DefaultDialer.Dial(url+tt.query, tt.headers)
+			if err == nil {
+				conn.Close()
+			}
+
+			if assert.NotNil(t, res, "expect a response") {
+				assert.Equal(t, tt.wantCode, res.StatusCode, "expect status code to be same")
+			}
+		})
+	}
+}
+
+func TestCustomerHandler_tenants(t *testing.T) {
+	keys := NewSQLAPIKeyStore(setupSQLite(t, nil))
+	_, acmeKey := createTenantAPIKey(t, keys, "acme", "hardik")
+	_, defaultKey := createTestAPIKey(t, keys, "varshil")
+	jwtAuthenticator := newTestJWTAuthenticator(t)
+	jwtAuthenticator.tenantClaim = "tenant"
+
+	service := NewService(NewTenantRepos(func() Repo { return NewInMemoryRepo() }), WithIdGenerator(fixedId("hs")))
+	t.Cleanup(service.Close)
+	server := httptest.NewServer(registerRoutes(NewCustomerHandler(service, WithAuthenticators(NewAPIKeyAuthenticator(keys), jwtAuthenticator))))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?api_key="+defaultKey, nil)
+	if err != nil {
+		t.Fatalf("failed to establish websocket connection: %v", err)
+	}
+	defer conn.Close()
+
+	requests := []struct {
+		name     string
+		method   string
+		path     string
+		headers  map[string]string
+		body     string
+		wantCode int
+		wantName string
+	}{
+		{
+			name:     "create in a tenant",
+			method:   "POST",
+			path:     "/api/customers",
+			headers:  map[string]string{"X-API-Key": acmeKey},
+			body:     `{"customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}}`,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "customer of another tenant",
+			method:   "GET",
+			path:     "/api/customers/hs",
+			headers:  map[string]string{"X-API-Key": defaultKey},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "same id in another tenant",
+			method:   "POST",
+			path:     "/api/customers",
+			headers:  map[string]string{"X-API-Key": defaultKey},
+			body:     `{"customerDetails": {"name": "varshil", "address": "udr", "contactNo": "+918888888888"}}`,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "tenant of a token",
+			method:   "GET",
+			path:     "/api/customers/hs",
+			headers:  map[string]string{"Authorization": "Bearer " + signJWT(t, "HS256", "hs", validClaims(map[string]any{"tenant": "acme"}))},
+			wantCode: http.StatusOK,
+			wantName: "hardik",
+		},
+		{
+			name:     "invalid tenant of a token",
+			method:   "GET",
+			path:     "/api/customers/hs",
+			headers:  map[string]string{"Authorization": "Bearer " + signJWT(t, "HS256", "hs", validClaims(map[string]any{"tenant": "Acme"}))},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+	for _, request := range requests {
+		r, _ := http.NewRequest(request.method, server.URL+request.path, strings.NewReader(request.body))
+		for name, value := range request.headers {
+			r.Header.Set(name, value)
+		}
+
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("http request failed :%v", err)
+		}
+		var details CustomerDetails
+		json.NewDecoder(res.Body).Decode(&details)
+		res.Body.Close()
+
+		assert.Equal(t, request.wantCode, res.StatusCode, "expect status code of %s to be same", request.name)
+		if request.wantName != "" {
+			assert.Equal(t, request.wantName, details.Name, "expect customer of %s to be same", request.name)
+		}
+	}
+
+	var event ChangeEvent
+	if err := json.Unmarshal([]byte(readWebsocketMessage(t, conn)), &event); err != nil {
+		t.Fatal("invalid event:", err)
+	}
+	if assert.NotNil(t, event.Customer, "expect a customer change") {
+		assert.Equal(t, "varshil", event.Customer.CustomerDetails.Name, "expect only changes of the subscriber's tenant")
+	}
+}
+
+func Test_checkOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{name: "no origin", origin: "", want: true},
+		{name: "same host", origin: "http://customers.example.com", want: true},
+		{name: "other host", origin: "http://localhost:9000", want: false},
+		{name: "listed origin", allowed: []string{"http://localhost:9000/"}, origin: "http://LOCALHOST:9000", want: true},
+		{name: "unlisted origin", allowed: []string{"http://localhost:9000"}, origin: "http://localhost:3000", want: false},
+		{name: "any origin", allowed: []string{"*"}, origin: "http://localhost:3000", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://customers.example.com/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+
+			assert.Equal(t, tt.want, checkOrigin(tt.allowed)(r), "expect origin check to be same")
+		})
+	}
+}
+
+func Test_newAuthenticators(t *testing.T) {
+	jwks := writeJWKS(t, testJWKS())
+	keys := NewSQLAPIKeyStore(setupSQLite(t, nil))
+
+	tests := []struct {
+		name    string
+		config  AuthConfig
+		keys    APIKeyStore
+		want    int
+		wantErr bool
+	}{
+		{name: "none", config: AuthConfig{}},
+		{name: "both", config: AuthConfig{Methods: "apikey, jwt", JWKSFile: jwks}, keys: keys, want: 2},
+		{name: "api keys without a store", config: AuthConfig{Methods: "apikey"}, wantErr: true},
+		{name: "missing jwks file", config: AuthConfig{Methods: "jwt", JWKSFile: jwks + ".missing"}, wantErr: true},
+		{name: "unknown method", config: AuthConfig{Methods: "basic"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newAuthenticators(tt.config, tt.keys)
+
+			assert.Equal(t, tt.wantErr, err != nil, "expect error to be %v, got %v", tt.wantErr, err)
+			assert.Len(t, got, tt.want, "expect authenticators to be same")
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Permission is an action a role may take, each route requires one.
+type Permission string
+
+const (
+	PermRead    Permission = "read"
+	PermCreate  Permission = "create"
+	PermUpdate  Permission = "update"
+	PermDelete  Permission = "delete"
+	PermRestore Permission = "restore"
+	PermPurge   Permission = "purge"
+	PermAudit   Permission = "audit"
+	PermMetrics Permission = "metrics"
+)
+
+var permissions = []Permission{PermRead, PermCreate, PermUpdate, PermDelete, PermRestore, PermPurge, PermAudit, PermMetrics}
+
+const DefaultRoles = "viewer=read; editor=read,create,update; admin=*"
+
+// Policy decides what the roles of a principal allow.
+type Policy struct {
+	grants map[string][]Permission
+}
+
+// ParsePolicy reads grants such as "viewer=read; admin=*".
+func ParsePolicy(spec string) (*Policy, error) {
+	p := &Policy{grants: map[string][]Permission{}}
+	for _, grant := range strings.Split(spec, ";") {
+		if strings.TrimSpace(grant) == "" {
+			continue
+		}
+
+		role, list, ok := strings.Cut(grant, "=")
+		role = strings.TrimSpace(role)
+		if !ok || role == "" {
+			return nil, fmt.Errorf("invalid role grant %q, expected role=permission,...", strings.TrimSpace(grant))
+		}
+		if _, ok := p.grants[role]; ok {
+			return nil, fmt.Errorf("role %q granted twice", role)
+		}
+
+		granted := []Permission{}
+		for _, name := range splitList(list) {
+			if name == "*" {
+				granted = append(granted, permissions...)
+				continue
+			}
+			if !slices.Contains(permissions, Permission(name)) {
+				return nil, fmt.Errorf("unknown permission %q of role %q", name, role)
+			}
+			granted = append(granted, Permission(name))
+		}
+		p.grants[role] = granted
+	}
+
+	if len(p.grants) == 0 {
+		return nil, errors.New("no roles granted")
+	}
+	return p, nil
+}
+
+func (p *Policy) authorize(principal Principal, permission Permission) error {
+	for _, role := range principal.Roles {
+		if slices.Contains(p.grants[role], permission) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s may not %s", ErrForbidden, principal.Subject, permission)
+}
+
+// withPermission lets every request through without a policy, which is how
+// the server runs without authentication.
+func withPermission(policy *Policy, permission Permission, next http.HandlerFunc) http.HandlerFunc {
+	if policy == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := principalFromContext(r.Context())
+		if !ok {
+			writeProblem(w, r, fmt.Errorf("%w: no credentials", ErrUnauthenticated))
+			return
+		}
+
+		if err := policy.authorize(principal, permission); err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		wantGrants map[string][]Permission
+		wantErr    bool
+	}{
+		{
+			name: "default roles",
+			spec: DefaultRoles,
+			wantGrants: map[string][]Permission{
+				"viewer": {PermRead},
+				"editor": {PermRead, PermCreate, PermUpdate},
+				"admin":  permissions,
+			},
+		},
+		{
+			name: "role granted nothing",
+			spec: "auditor=audit,read;guest=;",
+			wantGrants: map[string][]Permission{
+				"auditor": {PermAudit, PermRead},
+				"guest":   {},
+			},
+		},
+		{
+			name:    "unknown permission",
+			spec:    "viewer=read,write",
+			wantErr: true,
+		},
+		{
+			name:    "role without permissions",
+			spec:    "viewer",
+			wantErr: true,
+		},
+		{
+			name:    "role granted twice",
+			spec:    "viewer=read; viewer=create",
+			wantErr: true,
+		},
+		{
+			name:    "no roles",
+			spec:    " ; ",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicy(tt.spec)
+
+			if tt.wantErr {
+				assert.Error(t, err, "expect policy to be rejected")
+				return
+			}
+			assert.NoError(t, err, "expect policy to parse")
+			assert.Equal(t, tt.wantGrants, got.grants, "expect grants to be same")
+		})
+	}
+}
+
+func TestPolicy_authorize(t *testing.T) {
+	policy, err := ParsePolicy(DefaultRoles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		roles      []string
+		permission Permission
+		wantErr    error
+	}{
+		{name: "viewer reads", roles: []string{"viewer"}, permission: PermRead},
+		{name: "viewer creates", roles: []string{"viewer"}, permission: PermCreate, wantErr: ErrForbidden},
+		{name: "editor updates", roles: []string{"editor"}, permission: PermUpdate},
+		{name: "editor deletes", roles: []string{"editor"}, permission: PermDelete, wantErr: ErrForbidden},
+		{name: "any role granting it", roles: []string{"viewer", "admin"}, permission: PermPurge},
+		{name: "unknown role", roles: []string{"root"}, permission: PermRead, wantErr: ErrForbidden},
+		{name: "no roles", permission: PermRead, wantErr: ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.authorize(Principal{Subject: "hardik", Roles: tt.roles}, tt.permission)
+
+			assert.ErrorIs(t, err, tt.wantErr, "expect error to be same")
+		})
+	}
+}
+
+func TestCustomerHandler_authorization(t *testing.T) {
+	policy, err := ParsePolicy(DefaultRoles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, _, _ := newAuthHandler(t, WithPolicy(policy))
+
+	readers := []string{"viewer", "editor", "admin"}
+	writers := []string{"editor", "admin"}
+	admins := []string{"admin"}
+
+	tests := []struct {
+		method     string
+		path       string
+		permission Permission
+		allowed    []string
+	}{
+		{method: "GET", path: "/api/customers", permission: PermRead, allowed: readers},
+		{method: "GET", path: "/api/customers/hs", permission: PermRead, allowed: readers},
+		{method: "GET", path: "/api/customers/search?q=hardik", permission: PermRead, allowed: readers},
+		{method: "GET", path: "/api/customers/hs/history", permission: PermRead, allowed: readers},
+		{method: "GET", path: "/api/customers/trash", permission: PermRead, allowed: readers},
+		{method: "GET", path: "/ws", permission: PermRead, allowed: readers},
+		{method: "POST", path: "/api/customers", permission: PermCreate, allowed: writers},
+		{method: "PUT", path: "/api/customers", permission: PermUpdate, allowed: writers},
+		{method: "PATCH", path: "/api/customers/hs", permission: PermUpdate, allowed: writers},
+		{method: "DELETE", path: "/api/customers/hs", permission: PermDelete, allowed: admins},
+		{method: "POST", path: "/api/customers/trash/hs/restore", permission: PermRestore, allowed: admins},
+		{method: "POST", path: "/api/customers/trash/purge", permission: PermPurge, allowed: admins},
+		{method: "GET", path: "/api/audit", permission: PermAudit, allowed: admins},
+		{method: "GET", path: "/api/audit/verify", permission: PermAudit, allowed: admins},
+		{method: "GET", path: "/debug/vars", permission: PermMetrics, allowed: admins},
+	}
+
+	for _, tt := range tests {
+		for _, role := range []string{"viewer", "editor", "admin", "other"} {
+			t.Run(tt.method+" "+tt.path+" as "+role, func(t *testing.T) {
+				token := signJWT(t, "HS256", "hs", validClaims(map[string]any{"roles": []string{role}}))
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}"))
+				r.Header.Set("Authorization", "Bearer "+token)
+
+				handler.ServeHTTP(w, r)
+
+				if slices.Contains(tt.allowed, role) {
+					assert.NotEqual(t, http.StatusForbidden, w.Code, "expect %s to be allowed", role)
+					return
+				}
+				assert.Equal(t, http.StatusForbidden, w.Code, "expect %s to be refused", role)
+				assert.JSONEq(t, `{"type": "/problems/forbidden", "title": "permission denied", "status": 403, "code": "forbidden", "detail": "forbidden: hardik may not `+string(tt.permission)+`"}`, withoutRequestId(t, w.Body.String()), "expect body to be same")
+			})
+		}
+	}
+}
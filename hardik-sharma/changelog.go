@@ -0,0 +1,74 @@
+package main
+
+import "sort"
+
+const DefaultChangeLogSize = 1024
+
+// changeLog keeps the most recent change events so reconnecting
+// subscribers can catch up without a full snapshot. It is not safe for
+// concurrent use, Service guards it with notifyMu.
+//
+// Every event with a sequence above floor is kept. Sequences may have gaps,
+// so floor rather than the first kept event decides whether a resume point
+// is still covered. Until floor is known the log is unsynced and can't
+// serve any resume.
+type changeLog struct {
+	limit  int
+	floor  uint64
+	synced bool
+	events []ChangeEvent
+}
+
+func newChangeLog(limit int) *changeLog {
+	return &changeLog{limit: limit, synced: true}
+}
+
+// start declares that no event after sequence has happened yet.
+func (l *changeLog) start(sequence uint64) {
+	l.events = nil
+	l.floor = sequence
+	l.synced = true
+}
+
+// reset forgets everything, used when events may have been missed. The log
+// syncs again with the next event appended.
+func (l *changeLog) reset() {
+	l.events = nil
+	l.synced = false
+}
+
+func (l *changeLog) append(event ChangeEvent) {
+	if !l.synced {
+		l.floor = event.Sequence - 1
+		l.synced = true
+	}
+
+	if l.limit <= 0 {
+		l.floor = event.Sequence
+		return
+	}
+
+	l.events = append(l.events, event)
+	if len(l.events) > l.limit {
+		evicted := len(l.events) - l.limit
+		l.floor = l.events[evicted-1].Sequence
+		l.events = l.events[evicted:]
+	}
+}
+
+// since returns every event after sequence since, up to and including
+// current. It reports false when some of those events are no longer kept
+// or since is not a sequence this log has handed out.
+func (l *changeLog) since(since uint64, current uint64) ([]ChangeEvent, bool) {
+	if !l.synced || since > current || since < l.floor {
+		return nil, false
+	}
+
+	first := sort.Search(len(l.events), func(i int) bool {
+		return l.events[i].Sequence > since
+	})
+
+	replay := make([]ChangeEvent, len(l.events)-first)
+	copy(replay, l.events[first:])
+	return replay, true
+}
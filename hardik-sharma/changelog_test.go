@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_changeLog_since(t *testing.T) {
+	log := newChangeLog(3)
+	for seq := uint64(11); seq <= 15; seq++ {
+		log.append(ChangeEvent{Type: EventCustomerUpdated, Sequence: seq})
+	}
+
+	tests := []struct {
+		name    string
+		since   uint64
+		wantSeq []uint64
+		wantOk  bool
+	}{
+		{name: "up to date", since: 15, wantSeq: []uint64{}, wantOk: true},
+		{name: "one behind", since: 14, wantSeq: []uint64{15}, wantOk: true},
+		{name: "oldest kept event missed", since: 12, wantSeq: []uint64{13, 14, 15}, wantOk: true},
+		{name: "gap older than the log", since: 11, wantOk: false},
+		{name: "ahead of the log", since: 16, wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotEvents, gotOk := log.since(tt.since, 15)
+
+			assert.Equal(t, tt.wantOk, gotOk, "expected availability to be same")
+
+			if tt.wantOk {
+				gotSeq := []uint64{}
+				for _, event := range gotEvents {
+					gotSeq = append(gotSeq, event.Sequence)
+				}
+				assert.Equal(t, tt.wantSeq, gotSeq, "expected replayed sequences to be same")
+			}
+		})
+	}
+}
+
+func Test_changeLog_disabled(t *testing.T) {
+	log := newChangeLog(0)
+	log.append(ChangeEvent{Type: EventCustomerCreated, Sequence: 1})
+
+	_, gotOk := log.since(0, 1)
+
+	assert.False(t, gotOk, "expected a disabled log to always ask for a snapshot")
+}
+
+func Test_changeLog_sequenceGaps(t *testing.T) {
+	log := newChangeLog(2)
+	log.start(10)
+	for _, seq := range []uint64{12, 15, 19} {
+		log.append(ChangeEvent{Type: EventCustomerUpdated, Sequence: seq})
+	}
+
+	tests := []struct {
+		name    string
+		since   uint64
+		wantSeq []uint64
+		wantOk  bool
+	}{
+		{name: "between kept events", since: 17, wantSeq: []uint64{19}, wantOk: true},
+		{name: "at the evicted event", since: 12, wantSeq: []uint64{15, 19}, wantOk: true},
+		{name: "before the evicted event", since: 11, wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotEvents, gotOk := log.since(tt.since, 19)
+
+			assert.Equal(t, tt.wantOk, gotOk, "expected availability to be same")
+
+			if tt.wantOk {
+				gotSeq := []uint64{}
+				for _, event := range gotEvents {
+					gotSeq = append(gotSeq, event.Sequence)
+				}
+				assert.Equal(t, tt.wantSeq, gotSeq, "expected replayed sequences to be same")
+			}
+		})
+	}
+}
+
+func Test_changeLog_reset(t *testing.T) {
+	log := newChangeLog(4)
+	log.append(ChangeEvent{Type: EventCustomerCreated, Sequence: 1})
+	log.reset()
+
+	_, gotOk := log.since(1, 1)
+	assert.False(t, gotOk, "expected an unsynced log to ask for a snapshot")
+
+	log.append(ChangeEvent{Type: EventCustomerUpdated, Sequence: 7})
+
+	_, gotOk = log.since(1, 7)
+	assert.False(t, gotOk, "expected events before the reset to stay unavailable")
+
+	gotEvents, gotOk := log.since(6, 7)
+	assert.True(t, gotOk, "expected the log to sync with the next event")
+	assert.Len(t, gotEvents, 1, "expected only the event after the reset")
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const configUsage = "YAML config file, settings are named like the flags and may be grouped in sections"
+
+const envPrefix = "CUSTOMERS_"
+
+// Config holds every setting of the server.
+type Config struct {
+	Listen        string
+	Repo          string
+	IdScheme      string
+	PhoneRegion   string
+	RequireSchema bool
+	DB            DBConfig
+	SQLite        SQLiteConfig
+	File          FileConfig
+	Server        ServerTimeouts
+	Operations    OperationTimeouts
+	Notify        NotifyConfig
+	Websocket     WebsocketLimits
+	Trash         TrashConfig
+	Audit         AuditConfig
+	Auth          AuthConfig
+}
+
+type DBConfig struct {
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// RowLevelSecurity sets customers.tenant_id in every transaction
+	RowLevelSecurity bool
+}
+
+type SQLiteConfig struct {
+	Path string
+}
+
+type FileConfig struct {
+	Dir           string
+	Fsync         string
+	SyncInterval  time.Duration
+	SnapshotEvery int
+}
+
+// ServerTimeouts are those of http.Server. Write should stay above the
+// operation timeouts so that handlers can still report theirs.
+type ServerTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	// Shutdown is how long requests in flight may take to finish on
+	// SIGINT or SIGTERM
+	Shutdown time.Duration
+}
+
+type NotifyConfig struct {
+	QueueSize     int
+	Overflow      string
+	ChangeLogSize int
+	ChangeFeed    bool
+}
+
+type TrashConfig struct {
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+type AuditConfig struct {
+	TrustedProxies string
+}
+
+// AuthConfig selects how callers authenticate, an empty Methods lets every
+// request through.
+type AuthConfig struct {
+	Methods          string
+	JWKSFile         string
+	JWTIssuer        string
+	JWTAudience      string
+	JWTRolesClaim    string
+	JWTTenantClaim   string
+	Roles            string
+	WebsocketOrigins string
+}
+
+var repoBackends = []string{"postgres", "sqlite", "file", "memory"}
+
+func defaultConfig() Config {
+	return Config{
+		Listen:      ":8080",
+		Repo:        "postgres",
+		IdScheme:    "uuidv7",
+		PhoneRegion: DefaultPhoneRegion,
+		DB: DBConfig{
+			MaxOpenConns:    20,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		SQLite: SQLiteConfig{
+			Path: "customers.db",
+		},
+		File: FileConfig{
+			Dir:           "data",
+			Fsync:         "always",
+			SyncInterval:  DefaultSyncInterval,
+			SnapshotEvery: DefaultSnapshotEvery,
+		},
+		Server: ServerTimeouts{
+			ReadHeader: 5 * time.Second,
+			Read:       10 * time.Second,
+			Write:      15 * time.Second,
+			Idle:       60 * time.Second,
+			Shutdown:   15 * time.Second,
+		},
+		Operations: DefaultOperationTimeouts,
+		Notify: NotifyConfig{
+			QueueSize:     DefaultNotifyQueueSize,
+			Overflow:      "coalesce",
+			ChangeLogSize: DefaultChangeLogSize,
+			ChangeFeed:    true,
+		},
+		Websocket: DefaultWebsocketLimits,
+		Trash: TrashConfig{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Auth: AuthConfig{
+			JWTRolesClaim: "roles",
+			Roles:         DefaultRoles,
+		},
+	}
+}
+
+func newConfigFlagSet(c *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("customers", flag.ContinueOnError)
+
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address the server listens on")
+	fs.StringVar(&c.Repo, "repo", c.Repo, "storage backend, one of "+strings.Join(repoBackends, ", "))
+	fs.StringVar(&c.IdScheme, "id-scheme", c.IdScheme, "customer id scheme, one of uuidv7 or ulid")
+	fs.StringVar(&c.PhoneRegion, "phone-region", c.PhoneRegion, "region of contact numbers given without country code, such as IN or US")
+	fs.BoolVar(&c.RequireSchema, "require-schema", c.RequireSchema, "refuse to start while migrations are pending, instead of only warning")
+
+	fs.StringVar(&c.DB.DSN, "db-dsn", c.DB.DSN, "postgres connection string")
+	fs.IntVar(&c.DB.MaxOpenConns, "db-max-open-conns", c.DB.MaxOpenConns, "most open database connections, 0 for no limit")
+	fs.IntVar(&c.DB.MaxIdleConns, "db-max-idle-conns", c.DB.MaxIdleConns, "most idle database connections kept")
+	fs.DurationVar(&c.DB.ConnMaxLifetime, "db-conn-max-lifetime", c.DB.ConnMaxLifetime, "time after which database connections are replaced, 0 for never")
+	fs.DurationVar(&c.DB.ConnMaxIdleTime, "db-conn-max-idle-time", c.DB.ConnMaxIdleTime, "time after which idle database connections are closed, 0 for never")
+	fs.BoolVar(&c.DB.RowLevelSecurity, "db-row-level-security", c.DB.RowLevelSecurity, "set the tenant of every query for the postgres row level security policies of the customer tables")
+
+	fs.StringVar(&c.SQLite.Path, "sqlite-path", c.SQLite.Path, "sqlite database file, created when missing")
+
+	fs.StringVar(&c.File.Dir, "file-dir", c.File.Dir, "directory of the file repo, created when missing")
+	fs.StringVar(&c.File.Fsync, "file-fsync", c.File.Fsync, "when the file repo syncs its log to disk, one of always, interval or never")
+	fs.DurationVar(&c.File.SyncInterval, "file-sync-interval", c.File.SyncInterval, "time between syncs of the file repo log with -file-fsync interval")
+	fs.IntVar(&c.File.SnapshotEvery, "file-snapshot-every", c.File.SnapshotEvery, "log records after which the file repo writes a snapshot")
+
+	fs.DurationVar(&c.Server.ReadHeader, "server-read-header-timeout", c.Server.ReadHeader, "time allowed to read request headers")
+	fs.DurationVar(&c.Server.Read, "server-read-timeout", c.Server.Read, "time allowed to read a request")
+	fs.DurationVar(&c.Server.Write, "server-write-timeout", c.Server.Write, "time allowed to write a response")
+	fs.DurationVar(&c.Server.Idle, "server-idle-timeout", c.Server.Idle, "time idle keep-alive connections are kept")
+	fs.DurationVar(&c.Server.Shutdown, "server-shutdown-timeout", c.Server.Shutdown, "time requests in flight may take to finish on shutdown")
+
+	fs.DurationVar(&c.Operations.Create, "timeout-create", c.Operations.Create, "time allowed to store a new customer, 0 for no limit")
+	fs.DurationVar(&c.Operations.Update, "timeout-update", c.Operations.Update, "time allowed to update a customer, 0 for no limit")
+	fs.DurationVar(&c.Operations.Delete, "timeout-delete", c.Operations.Delete, "time allowed to delete a customer, 0 for no limit")
+	fs.DurationVar(&c.Operations.GetById, "timeout-get-by-id", c.Operations.GetById, "time allowed to read a customer, 0 for no limit")
+	fs.DurationVar(&c.Operations.GetAll, "timeout-get-all", c.Operations.GetAll, "time allowed to list customers, 0 for no limit")
+	fs.DurationVar(&c.Operations.Audit, "timeout-audit", c.Operations.Audit, "time allowed to record an audit entry, 0 for no limit")
+
+	fs.IntVar(&c.Notify.QueueSize, "notify-queue-size", c.Notify.QueueSize, "notifications buffered per websocket subscriber")
+	fs.StringVar(&c.Notify.Overflow, "notify-overflow", c.Notify.Overflow, "policy for full subscriber queues, one of coalesce, dropOldest or disconnect")
+	fs.IntVar(&c.Notify.ChangeLogSize, "change-log-size", c.Notify.ChangeLogSize, "recent change events kept for resuming websocket clients")
+	fs.BoolVar(&c.Notify.ChangeFeed, "change-feed", c.Notify.ChangeFeed, "notify websocket clients of changes made by every instance, through postgres LISTEN/NOTIFY")
+
+	fs.IntVar(&c.Websocket.ReadBufferSize, "websocket-read-buffer", c.Websocket.ReadBufferSize, "websocket read buffer size in bytes")
+	fs.IntVar(&c.Websocket.WriteBufferSize, "websocket-write-buffer", c.Websocket.WriteBufferSize, "websocket write buffer size in bytes")
+	fs.Int64Var(&c.Websocket.MaxMessageSize, "websocket-max-message-size", c.Websocket.MaxMessageSize, "largest message accepted from websocket clients in bytes")
+	fs.DurationVar(&c.Websocket.WriteTimeout, "websocket-write-timeout", c.Websocket.WriteTimeout, "time allowed to send one websocket message")
+
+	fs.DurationVar(&c.Trash.Retention, "trash-retention", c.Trash.Retention, "time deleted customers can be restored before they are purged, 0 for forever")
+	fs.DurationVar(&c.Trash.PurgeInterval, "trash-purge-interval", c.Trash.PurgeInterval, "time between purges of the trash")
+
+	fs.StringVar(&c.Auth.Methods, "auth-methods", c.Auth.Methods, "comma separated authentication methods, of apikey and jwt, empty for none")
+	fs.StringVar(&c.Auth.JWKSFile, "auth-jwks-file", c.Auth.JWKSFile, "JWKS file with the keys JWTs are signed with")
+	fs.StringVar(&c.Auth.JWTIssuer, "auth-jwt-issuer", c.Auth.JWTIssuer, "issuer JWTs must name, empty for any")
+	fs.StringVar(&c.Auth.JWTAudience, "auth-jwt-audience", c.Auth.JWTAudience, "audience JWTs must name, empty for any")
+	fs.StringVar(&c.Auth.JWTRolesClaim, "auth-jwt-roles-claim", c.Auth.JWTRolesClaim, "claim of JWTs listing the roles of the caller, may be a dotted path such as realm_access.roles")
+	fs.StringVar(&c.Auth.JWTTenantClaim, "auth-jwt-tenant-claim", c.Auth.JWTTenantClaim, "claim of JWTs naming the tenant of the caller, may be a dotted path, empty to put every caller in the default tenant")
+	fs.StringVar(&c.Auth.Roles, "auth-roles", c.Auth.Roles, "permissions of each role, as role=permission,...; separated, of read, create, update, delete, restore, purge, audit, metrics or *")
+	fs.StringVar(&c.Auth.WebsocketOrigins, "auth-websocket-origins", c.Auth.WebsocketOrigins, "comma separated origins besides the server's own that may open websockets, * for any")
+
+	fs.StringVar(&c.Audit.TrustedProxies, "audit-trusted-proxies", c.Audit.TrustedProxies, "comma separated proxy addresses or CIDR networks whose X-Forwarded-For is recorded as the source IP")
+
+	return fs
+}
+
+// LoadConfig reads the configuration from, in increasing precedence, the
+// defaults, a YAML file, CUSTOMERS_* variables and flags, and
+// returns the arguments left after the flags.
+func LoadConfig(args []string, lookupEnv func(key string) (string, bool)) (Config, []string, error) {
+	config := defaultConfig()
+	fs := newConfigFlagSet(&config)
+
+	configFile, _ := lookupEnv(envPrefix + "CONFIG")
+	fs.StringVar(&configFile, "config", configFile, configUsage)
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	fromFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		fromFlags[f.Name] = true
+	})
+
+	set := func(name string, value string, source string) error {
+		if fromFlags[name] {
+			return nil
+		}
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", source, name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid %s: %w", source, name, err)
+		}
+		return nil
+	}
+
+	if configFile != "" {
+		settings, err := readConfigFile(configFile)
+		if err != nil {
+			return Config{}, nil, err
+		}
+
+		for _, name := range sortedKeys(settings) {
+			if err := set(name, settings[name], configFile); err != nil {
+				return Config{}, nil, err
+			}
+		}
+	}
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := lookupEnv(envName(f.Name)); ok && envErr == nil && f.Name != "config" {
+			envErr = set(f.Name, value, envName(f.Name))
+		}
+	})
+	if envErr != nil {
+		return Config{}, nil, envErr
+	}
+
+	if err := config.validate(); err != nil {
+		return Config{}, nil, err
+	}
+
+	return config, fs.Args(), nil
+}
+
+// PrintConfigUsage describes every setting and where it can be set.
+func PrintConfigUsage(w io.Writer, program string) {
+	config := defaultConfig()
+	fs := newConfigFlagSet(&config)
+	fs.String("config", "", configUsage)
+	fs.SetOutput(w)
+
+	fmt.Fprintf(w, "usage: %s [flags]\n       %s [flags] migrate up|down|status|redo\n", program, program)
+	fmt.Fprintf(w, "       %s [flags] apikey create [-tenant <tenant>] <subject> [role...] | list | revoke <id>\n\n", program)
+	fmt.Fprintf(w, "Every flag can also be set through the environment, -db-dsn as %s.\n\n", envName("db-dsn"))
+	fs.PrintDefaults()
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func (c Config) validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	check(c.Listen != "", "listen address is required")
+	check(slices.Contains(repoBackends, c.Repo), fmt.Sprintf("unknown repo %q", c.Repo))
+	check(c.Repo != "postgres" || c.DB.DSN != "", "db dsn is required for the postgres repo")
+	check(c.Repo != "sqlite" || c.SQLite.Path != "", "sqlite path is required for the sqlite repo")
+	check(c.Repo != "file" || c.File.Dir != "", "file dir is required for the file repo")
+
+	if _, err := idGeneratorByName(c.IdScheme); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := overflowPolicyByName(c.Notify.Overflow); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := checkPhoneRegion(c.PhoneRegion); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := fsyncPolicyByName(c.File.Fsync); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := parseTrustedProxies(c.Audit.TrustedProxies); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, method := range splitList(c.Auth.Methods) {
+		check(slices.Contains(authMethods, method), fmt.Sprintf("unknown auth method %q", method))
+		check(method != AuthAPIKey || c.Repo == "postgres" || c.Repo == "sqlite", "api keys need the postgres or sqlite repo")
+		check(method != AuthJWT || c.Auth.JWKSFile != "", "jwks file is required for jwt authentication")
+	}
+	if _, err := ParsePolicy(c.Auth.Roles); err != nil {
+		problems = append(problems, err.Error())
+	}
+	check(c.Auth.JWTTenantClaim == "" || c.Repo != "file", "tenants need the postgres, sqlite or memory repo")
+	check(!c.DB.RowLevelSecurity || c.Repo == "postgres", "row level security needs the postgres repo")
+
+	check(c.DB.MaxOpenConns >= 0 && c.DB.MaxIdleConns >= 0, "db pool sizes can't be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "db idle connections can't exceed open connections")
+	check(c.File.SyncInterval > 0, "file sync interval must be positive")
+	check(c.File.SnapshotEvery >= 1, "file snapshot interval must be at least 1 record")
+	check(c.Notify.QueueSize >= 1, "notify queue size must be at least 1")
+	check(c.Notify.ChangeLogSize >= 0, "change log size can't be negative")
+	check(c.Websocket.ReadBufferSize > 0 && c.Websocket.WriteBufferSize > 0, "websocket buffers must be positive")
+	check(c.Websocket.MaxMessageSize > 0, "websocket max message size must be positive")
+	check(c.Trash.Retention >= 0, "trash retention can't be negative")
+	check(c.Trash.PurgeInterval > 0, "trash purge interval must be positive")
+
+	durations := []time.Duration{
+		c.DB.ConnMaxLifetime, c.DB.ConnMaxIdleTime,
+		c.Server.ReadHeader, c.Server.Read, c.Server.Write, c.Server.Idle, c.Server.Shutdown,
+		c.Operations.Create, c.Operations.Update, c.Operations.Delete, c.Operations.GetById, c.Operations.GetAll,
+		c.Operations.Audit,
+		c.Websocket.WriteTimeout,
+	}
+	for _, d := range durations {
+		if d < 0 {
+			problems = append(problems, "timeouts can't be negative")
+			break
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readConfigFile joins nested keys with dashes to name them like flags.
+func readConfigFile(path string) (map[string]string, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(source, &tree)
+	default:
+		return nil, fmt.Errorf("%s: config files must be .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := map[string]string{}
+	if err := flattenSettings(tree, "", settings); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return settings, nil
+}
+
+func flattenSettings(tree map[string]interface{}, prefix string, settings map[string]string) error {
+	for key, value := range tree {
+		name := prefix + strings.ReplaceAll(strings.ToLower(key), "_", "-")
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := flattenSettings(v, name+"-", settings); err != nil {
+				return err
+			}
+		case []interface{}, nil:
+			return fmt.Errorf("%s must be a single value", name)
+		default:
+			settings[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
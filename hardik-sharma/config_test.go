@@ -0,0 +1,271 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEnv looks up variables in a map instead of the process environment.
+func fakeEnv(vars map[string]string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal("failed to write config file:", err)
+	}
+	return path
+}
+
+const testDSN = "postgresql://test@db/customers"
+
+func TestLoadConfig(t *testing.T) {
+	yamlFile := writeConfigFile(t, "customers.yaml", `
+listen: ":9090"
+db:
+  dsn: postgresql://file@db/customers
+  max-open-conns: 40
+notify:
+  queue-size: 64
+websocket:
+  write-timeout: 3s
+`)
+
+	ymlFile := writeConfigFile(t, "customers.yml", `
+listen: ":9191" # after a value
+db:
+  dsn: postgresql://yml@db/customers
+  conn_max_lifetime: 1h
+notify:
+  overflow: 'dropOldest'
+`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		want     func(c *Config)
+		wantArgs []string
+		wantErr  bool
+	}{
+		{
+			name: "defaults",
+			want: func(c *Config) {},
+		},
+		{
+			name: "yaml file",
+			args: []string{"-config", yamlFile},
+			want: func(c *Config) {
+				c.Listen = ":9090"
+				c.DB.DSN = "postgresql://file@db/customers"
+				c.DB.MaxOpenConns = 40
+				c.Notify.QueueSize = 64
+				c.Websocket.WriteTimeout = 3 * time.Second
+			},
+		},
+		{
+			name: "yml file named by the environment",
+			env:  map[string]string{"CUSTOMERS_CONFIG": ymlFile},
+			want: func(c *Config) {
+				c.Listen = ":9191"
+				c.DB.DSN = "postgresql://yml@db/customers"
+				c.DB.ConnMaxLifetime = time.Hour
+				c.Notify.Overflow = "dropOldest"
+			},
+		},
+		{
+			name: "environment overrides the file",
+			args: []string{"-config", yamlFile},
+			env:  map[string]string{"CUSTOMERS_DB_DSN": "postgresql://env@db/customers", "CUSTOMERS_CHANGE_FEED": "false"},
+			want: func(c *Config) {
+				c.Listen = ":9090"
+				c.DB.DSN = "postgresql://env@db/customers"
+				c.DB.MaxOpenConns = 40
+				c.Notify.QueueSize = 64
+				c.Notify.ChangeFeed = false
+				c.Websocket.WriteTimeout = 3 * time.Second
+			},
+		},
+		{
+			name: "flags override the environment and the file",
+			args: []string{"-config", yamlFile, "-db-dsn", "postgresql://flag@db/customers", "migrate", "up"},
+			env:  map[string]string{"CUSTOMERS_DB_DSN": "postgresql://env@db/customers"},
+			want: func(c *Config) {
+				c.Listen = ":9090"
+				c.DB.DSN = "postgresql://flag@db/customers"
+				c.DB.MaxOpenConns = 40
+				c.Notify.QueueSize = 64
+				c.Websocket.WriteTimeout = 3 * time.Second
+			},
+			wantArgs: []string{"migrate", "up"},
+		},
+		{
+			name:    "postgres without dsn",
+			env:     map[string]string{"CUSTOMERS_DB_DSN": ""},
+			wantErr: true,
+		},
+		{
+			name:    "unknown setting in the file",
+			args:    []string{"-config", writeConfigFile(t, "typo.yaml", "db:\n  dns: postgresql://db\n")},
+			wantErr: true,
+		},
+		{
+			name:    "toml file",
+			args:    []string{"-config", writeConfigFile(t, "customers.toml", "listen = \":9191\"\n")},
+			wantErr: true,
+		},
+		{
+			name:    "unparsable environment value",
+			env:     map[string]string{"CUSTOMERS_TIMEOUT_CREATE": "soon"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			args:    []string{"-notify-queue-size", "0"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown repo",
+			args:    []string{"-repo", "mongo"},
+			wantErr: true,
+		},
+		{
+			name: "file repo settings from the environment",
+			env:  map[string]string{"CUSTOMERS_REPO": "file", "CUSTOMERS_FILE_DIR": "/var/lib/customers", "CUSTOMERS_FILE_FSYNC": "interval"},
+			want: func(c *Config) {
+				c.Repo = "file"
+				c.File.Dir = "/var/lib/customers"
+				c.File.Fsync = "interval"
+			},
+		},
+		{
+			name:    "unknown fsync policy",
+			args:    []string{"-file-fsync", "sometimes"},
+			wantErr: true,
+		},
+		{
+			name: "trash kept forever",
+			args: []string{"-trash-retention", "0"},
+			want: func(c *Config) {
+				c.Trash.Retention = 0
+			},
+		},
+		{
+			name:    "negative trash retention",
+			env:     map[string]string{"CUSTOMERS_TRASH_RETENTION": "-1h"},
+			wantErr: true,
+		},
+		{
+			name: "trusted proxies",
+			env:  map[string]string{"CUSTOMERS_AUDIT_TRUSTED_PROXIES": "10.0.0.0/8, 127.0.0.1"},
+			want: func(c *Config) {
+				c.Audit.TrustedProxies = "10.0.0.0/8, 127.0.0.1"
+			},
+		},
+		{
+			name:    "invalid trusted proxy",
+			args:    []string{"-audit-trusted-proxies", "10.0.0.0/40"},
+			wantErr: true,
+		},
+		{
+			name: "jwt authentication",
+			env:  map[string]string{"CUSTOMERS_AUTH_METHODS": "jwt", "CUSTOMERS_AUTH_JWKS_FILE": "/etc/customers/jwks.json"},
+			args: []string{"-auth-jwt-audience", "customers", "-auth-websocket-origins", "http://localhost:9000"},
+			want: func(c *Config) {
+				c.Auth.Methods = "jwt"
+				c.Auth.JWKSFile = "/etc/customers/jwks.json"
+				c.Auth.JWTAudience = "customers"
+				c.Auth.WebsocketOrigins = "http://localhost:9000"
+			},
+		},
+		{
+			name:    "jwt authentication without jwks",
+			args:    []string{"-auth-methods", "jwt"},
+			wantErr: true,
+		},
+		{
+			name:    "api keys in memory",
+			args:    []string{"-repo", "memory", "-auth-methods", "apikey"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown auth method",
+			args:    []string{"-repo", "sqlite", "-auth-methods", "apikey,basic"},
+			wantErr: true,
+		},
+		{
+			name: "custom roles",
+			env:  map[string]string{"CUSTOMERS_AUTH_ROLES": "reader=read; owner=*", "CUSTOMERS_AUTH_JWT_ROLES_CLAIM": "realm_access.roles"},
+			want: func(c *Config) {
+				c.Auth.Roles = "reader=read; owner=*"
+				c.Auth.JWTRolesClaim = "realm_access.roles"
+			},
+		},
+		{
+			name:    "unknown permission",
+			args:    []string{"-auth-roles", "viewer=read,write"},
+			wantErr: true,
+		},
+		{
+			name: "tenants with row level security",
+			env:  map[string]string{"CUSTOMERS_AUTH_JWT_TENANT_CLAIM": "org.tenant"},
+			args: []string{"-db-row-level-security"},
+			want: func(c *Config) {
+				c.Auth.JWTTenantClaim = "org.tenant"
+				c.DB.RowLevelSecurity = true
+			},
+		},
+		{
+			name:    "tenants in the file repo",
+			args:    []string{"-repo", "file", "-auth-jwt-tenant-claim", "tenant"},
+			wantErr: true,
+		},
+		{
+			name:    "row level security in sqlite",
+			args:    []string{"-repo", "sqlite", "-db-row-level-security"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported file type",
+			args:    []string{"-config", writeConfigFile(t, "customers.json", "{}")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// postgres has no default dsn, cases with a config file set one there
+			env := map[string]string{}
+			if _, ok := tt.env["CUSTOMERS_CONFIG"]; !ok && !slices.Contains(tt.args, "-config") {
+				env["CUSTOMERS_DB_DSN"] = testDSN
+			}
+			for key, value := range tt.env {
+				env[key] = value
+			}
+			config, args, err := LoadConfig(tt.args, fakeEnv(env))
+
+			if tt.wantErr {
+				assert.Error(t, err, "expected configuration to be rejected")
+				return
+			}
+
+			want := defaultConfig()
+			want.DB.DSN = env["CUSTOMERS_DB_DSN"]
+			tt.want(&want)
+
+			assert.NoError(t, err, "expected configuration to load")
+			assert.Equal(t, want, config, "expected configuration to be same")
+			assert.Equal(t, strings.Join(tt.wantArgs, " "), strings.Join(args, " "), "expected remaining args to be same")
+		})
+	}
+}
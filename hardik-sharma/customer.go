@@ -1,12 +1,19 @@
 package main
 
+import "time"
+
+// Customer carries the version it was read at. The version starts at 1 and
+// grows with every update, writes may require it to be unchanged. DeletedAt
+// is only set on customers in the trash.
 type Customer struct {
 	Id              string          `json:"id"`
 	CustomerDetails CustomerDetails `json:"customerDetails" bun:"embed:customerdetails_"`
+	Version         int64           `json:"version"`
+	DeletedAt       *time.Time      `json:"deletedAt,omitempty" bun:"deleted_at"`
 }
 
 type CustomerDetails struct {
-	Name      string `json:"name"`
-	Address   string `json:"address"`
-	ContactNo int    `json:"contactNo"`
+	Name      string      `json:"name"`
+	Address   string      `json:"address"`
+	ContactNo PhoneNumber `json:"contactNo"`
 }
@@ -0,0 +1,47 @@
+package main
+
+const (
+	EventCustomerCreated = "customer.created"
+	EventCustomerUpdated = "customer.updated"
+	EventCustomerDeleted = "customer.deleted"
+	// EventCustomerRestored brings a deleted customer back from the trash.
+	EventCustomerRestored = "customer.restored"
+	// EventSnapshot carries the complete customer list. Its sequence is the
+	// one of the last change already reflected in the list.
+	EventSnapshot = "snapshot"
+)
+
+// ChangeEvent is what subscribers receive after every mutation. Sequence
+// numbers only ever grow, but when changes come from a shared change feed
+// they may skip values, so clients resume from the last one they saw rather
+// than watching for gaps. Changes are numbered across tenants, so clients
+// also see gaps where other tenants changed their customers. Tenant decides
+// which subscribers receive an event and is not sent to them.
+type ChangeEvent struct {
+	Type      string     `json:"type"`
+	Sequence  uint64     `json:"seq"`
+	Customer  *Customer  `json:"customer,omitempty"`
+	Customers []Customer `json:"customers,omitempty"`
+	Tenant    string     `json:"-"`
+}
+
+// eventsOf keeps the events of tenant.
+func eventsOf(events []ChangeEvent, tenant string) []ChangeEvent {
+	kept := []ChangeEvent{}
+	for _, event := range events {
+		if event.Tenant == tenant {
+			kept = append(kept, event)
+		}
+	}
+	return kept
+}
+
+// newSnapshotEvent wraps the current customer list. An empty list is left
+// out of the JSON encoding like any other empty field.
+func newSnapshotEvent(sequence uint64, customers []Customer) ChangeEvent {
+	return ChangeEvent{
+		Type:      EventSnapshot,
+		Sequence:  sequence,
+		Customers: customers,
+	}
+}
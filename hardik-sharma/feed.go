@@ -0,0 +1,13 @@
+package main
+
+import "context"
+
+// ChangeFeed delivers the change events committed by every instance that
+// shares the same storage, this one included. With a feed in place the
+// Service no longer numbers changes itself, so all instances agree on the
+// sequence of events.
+type ChangeFeed interface {
+	// listen blocks until ctx is done. publish receives events in sequence
+	// order, lost is called whenever events may have been missed.
+	listen(ctx context.Context, publish func(ChangeEvent), lost func())
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeFeed publishes whatever is sent on events and reports a lost
+// connection for every value sent on lose. Sends return once the previous
+// one has been fully handled.
+type fakeFeed struct {
+	events chan ChangeEvent
+	lose   chan struct{}
+}
+
+func newFakeFeed() *fakeFeed {
+	return &fakeFeed{
+		events: make(chan ChangeEvent),
+		lose:   make(chan struct{}),
+	}
+}
+
+func (f *fakeFeed) listen(ctx context.Context, publish func(ChangeEvent), lost func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-f.events:
+			publish(event)
+		case <-f.lose:
+			lost()
+		}
+	}
+}
+
+func TestService_changeFeed(t *testing.T) {
+	feed := newFakeFeed()
+	service := NewService(NewInMemoryRepo(), WithIdGenerator(fixedId("hs")), WithChangeFeed(feed))
+
+	subscriber := newMockSubscriber("1")
+	if err := service.subscribeWithSnapshot(context.Background(), subscriber); err != nil {
+		t.Fatalf("subscribe failed :%v", err)
+	}
+
+	created, err := service.addCustomer(context.Background(), Customer{CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}})
+	if err != nil {
+		t.Fatalf("add failed :%v", err)
+	}
+
+	remote := Customer{Id: "vs", CustomerDetails: CustomerDetails{Name: "varshil", Address: "udr", ContactNo: "+918888888888"}}
+	feed.events <- ChangeEvent{Type: EventCustomerCreated, Sequence: 40, Customer: &created}
+	feed.events <- ChangeEvent{Type: EventCustomerCreated, Sequence: 43, Customer: &remote}
+
+	resumed := newMockSubscriber("2")
+	if err := service.resumeSubscription(context.Background(), resumed, 40); err != nil {
+		t.Fatalf("resume failed :%v", err)
+	}
+	service.Close()
+
+	wantEvents := []ChangeEvent{
+		{Type: EventSnapshot, Sequence: 0, Customers: []Customer{}},
+		{Type: EventCustomerCreated, Sequence: 40, Tenant: DefaultTenant, Customer: &created},
+		{Type: EventCustomerCreated, Sequence: 43, Tenant: DefaultTenant, Customer: &remote},
+	}
+	assert.Equal(t, wantEvents, subscriber.events, "expected only the events from the feed")
+	assert.Equal(t, wantEvents[2:], resumed.events, "expected resume to follow the feed's numbering")
+}
+
+func TestService_changeFeedSnapshotResume(t *testing.T) {
+	feed := newFakeFeed()
+	service := NewService(NewInMemoryRepo(), WithChangeLog(DefaultChangeLogSize, 1700000000000000), WithChangeFeed(feed))
+
+	customer := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}}
+	feed.events <- ChangeEvent{Type: EventCustomerCreated, Sequence: 1, Customer: &customer}
+
+	first := newBlockedSubscriber("1")
+	close(first.release)
+	if err := service.subscribeWithSnapshot(context.Background(), first); err != nil {
+		t.Fatalf("subscribe failed :%v", err)
+	}
+
+	feed.events <- ChangeEvent{Type: EventCustomerUpdated, Sequence: 2, Customer: &customer}
+
+	resumed := newBlockedSubscriber("2")
+	close(resumed.release)
+	if err := service.resumeSubscription(context.Background(), resumed, 1); err != nil {
+		t.Fatalf("resume failed :%v", err)
+	}
+	service.Close()
+
+	assert.Equal(t, []string{"snapshot#1", "customer.updated#2"}, first.received, "expected snapshot to follow the feed's numbering")
+	assert.Equal(t, []string{"customer.updated#2"}, resumed.received, "expected resume from the snapshot to replay later events")
+}
+
+func TestService_changeFeedLost(t *testing.T) {
+	feed := newFakeFeed()
+	service := NewService(NewInMemoryRepo(), WithChangeFeed(feed))
+
+	customer := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}}
+	feed.events <- ChangeEvent{Type: EventCustomerCreated, Sequence: 5, Customer: &customer}
+
+	dropped := newBlockedSubscriber("1")
+	close(dropped.release)
+	service.subscribe(context.Background(), dropped)
+
+	feed.lose <- struct{}{}
+	feed.events <- ChangeEvent{Type: EventCustomerUpdated, Sequence: 9, Customer: &customer}
+
+	stale := newBlockedSubscriber("2")
+	close(stale.release)
+	if err := service.resumeSubscription(context.Background(), stale, 5); err != nil {
+		t.Fatalf("resume failed :%v", err)
+	}
+
+	current := newBlockedSubscriber("3")
+	close(current.release)
+	if err := service.resumeSubscription(context.Background(), current, 8); err != nil {
+		t.Fatalf("resume failed :%v", err)
+	}
+	service.Close()
+
+	assert.True(t, dropped.disconnected, "expected subscriber to be disconnected when the feed was lost")
+	assert.Empty(t, dropped.received, "expected nothing delivered after the disconnect")
+	assert.Equal(t, []string{"snapshot#9"}, stale.received, "expected a snapshot for events that may have been missed")
+	assert.Equal(t, []string{"customer.updated#9"}, current.received, "expected events after the loss to be replayed")
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrCorruptLog = errors.New("corrupt write-ahead log")
+
+const (
+	walFileName      = "customers.wal"
+	snapshotFileName = "customers.snapshot"
+
+	DefaultSnapshotEvery = 1000
+	DefaultSyncInterval  = time.Second
+)
+
+// FsyncPolicy decides when writes to the log are forced to disk.
+type FsyncPolicy int
+
+const (
+	FsyncAlways FsyncPolicy = iota
+	FsyncInterval
+	FsyncNever
+)
+
+var fsyncPolicies = map[string]FsyncPolicy{
+	"always":   FsyncAlways,
+	"interval": FsyncInterval,
+	"never":    FsyncNever,
+}
+
+func fsyncPolicyByName(name string) (FsyncPolicy, error) {
+	policy, ok := fsyncPolicies[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown fsync policy %q", name)
+	}
+
+	return policy, nil
+}
+
+// walRecord logs customers whole and revisions only once, so that replaying
+// it twice is harmless.
+type walRecord struct {
+	Op       string    `json:"op"`
+	Id       string    `json:"id"`
+	Customer *Customer `json:"customer,omitempty"`
+	Revision *Revision `json:"revision,omitempty"`
+}
+
+// walDelete purges a customer, logs from before the trash use it for
+// deletes as well.
+const (
+	walCreate  = "create"
+	walUpdate  = "update"
+	walTrash   = "trash"
+	walRestore = "restore"
+	walDelete  = "delete"
+)
+
+type fileSnapshot struct {
+	Customers []Customer `json:"customers"`
+	Revisions []Revision `json:"revisions,omitempty"`
+}
+
+type FileRepoOption func(*FileRepo)
+
+// WithFsyncPolicy sets when the log is synced.
+func WithFsyncPolicy(policy FsyncPolicy, interval time.Duration) FileRepoOption {
+	return func(f *FileRepo) {
+		f.fsync = policy
+		if interval > 0 {
+			f.syncInterval = interval
+		}
+	}
+}
+
+// WithSnapshotEvery compacts the log into a snapshot after n records.
+func WithSnapshotEvery(n int) FileRepoOption {
+	return func(f *FileRepo) {
+		if n > 0 {
+			f.snapshotEvery = n
+		}
+	}
+}
+
+// FileRepo keeps customers in memory and logs every change to its directory
+// before acknowledging it.
+type FileRepo struct {
+	dir           string
+	fsync         FsyncPolicy
+	syncInterval  time.Duration
+	snapshotEvery int
+
+	// mu keeps the log in the order changes were applied in
+	mu      sync.Mutex
+	memory  *InMemoryRepo
+	wal     *os.File
+	size    int64
+	records int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func OpenFileRepo(dir string, opts ...FileRepoOption) (*FileRepo, error) {
+	f := &FileRepo{
+		dir:           dir,
+		fsync:         FsyncAlways,
+		syncInterval:  DefaultSyncInterval,
+		snapshotEvery: DefaultSnapshotEvery,
+		memory:        NewInMemoryRepo(),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	if err := f.recover(); err != nil {
+		return nil, err
+	}
+
+	f.memory.backfillHistory(time.Now().UTC().Truncate(time.Microsecond))
+
+	if f.fsync == FsyncInterval {
+		f.stop = make(chan struct{})
+		f.done = make(chan struct{})
+		go f.syncPeriodically()
+	}
+
+	return f, nil
+}
+
+func (f *FileRepo) loadSnapshot() error {
+	raw, err := os.ReadFile(filepath.Join(f.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot fileSnapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return fmt.Errorf("%s: %w", snapshotFileName, err)
+	}
+
+	for _, customer := range snapshot.Customers {
+		f.memory.put(customer)
+	}
+
+	for _, revision := range snapshot.Revisions {
+		f.memory.addRevision(revision)
+	}
+
+	return nil
+}
+
+// recover truncates a torn last record, it was never acknowledged, but
+// fails with ErrCorruptLog on a damaged record followed by others.
+func (f *FileRepo) recover() error {
+	wal, err := os.OpenFile(filepath.Join(f.dir, walFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(wal)
+	var good int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			break
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			wal.Close()
+			return err
+		}
+
+		record, decodeErr := decodeWALRecord(line)
+		if decodeErr != nil {
+			if _, peekErr := reader.Peek(1); peekErr == nil {
+				wal.Close()
+				return fmt.Errorf("%w: record at offset %d: %v", ErrCorruptLog, good, decodeErr)
+			}
+
+			log.Printf("truncating incomplete record at offset %d of %s", good, walFileName)
+			if err := wal.Truncate(good); err != nil {
+				wal.Close()
+				return err
+			}
+			break
+		}
+
+		f.apply(record)
+		good += int64(len(line))
+		f.records++
+	}
+
+	f.wal = wal
+	f.size = good
+	return nil
+}
+
+func encodeWALRecord(record walRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	line := fmt.Appendf(make([]byte, 0, len(payload)+10), "%08x ", crc32.ChecksumIEEE(payload))
+	line = append(line, payload...)
+	return append(line, '\n'), nil
+}
+
+func decodeWALRecord(line []byte) (walRecord, error) {
+	var record walRecord
+
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		return record, errors.New("record is incomplete")
+	}
+
+	checksum, payload, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok || len(checksum) != 8 {
+		return record, errors.New("record has no checksum")
+	}
+
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE(payload)) != string(checksum) {
+		return record, errors.New("checksum mismatch")
+	}
+
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, err
+	}
+
+	return record, nil
+}
+
+func (f *FileRepo) apply(record walRecord) {
+	switch record.Op {
+	case walCreate, walUpdate, walTrash, walRestore:
+		if record.Customer != nil {
+			f.memory.put(*record.Customer)
+		}
+		if record.Revision != nil {
+			f.memory.addRevision(*record.Revision)
+		}
+	case walDelete:
+		f.memory.remove(record.Id)
+	}
+}
+
+// append cuts off a failed write so that the next record doesn't follow a
+// torn one.
+func (f *FileRepo) append(record walRecord) error {
+	line, err := encodeWALRecord(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.wal.Write(line); err != nil {
+		f.wal.Truncate(f.size)
+		return err
+	}
+
+	if f.fsync == FsyncAlways {
+		if err := f.wal.Sync(); err != nil {
+			f.wal.Truncate(f.size)
+			return err
+		}
+	}
+
+	f.size += int64(len(line))
+	f.records++
+	f.apply(record)
+
+	if f.records >= f.snapshotEvery {
+		if err := f.snapshot(); err != nil {
+			// the change is logged, compaction is retried on the next write
+			log.Println("failed to write snapshot:", err)
+		}
+	}
+
+	return nil
+}
+
+// snapshot only empties the log once the new snapshot is in place, a crash
+// in between replays the log over it.
+func (f *FileRepo) snapshot() error {
+	customers, err := f.memory.getAll(context.Background())
+	if err != nil {
+		return err
+	}
+
+	trashed, err := f.memory.trash(context.Background())
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(fileSnapshot{Customers: append(customers, trashed...), Revisions: f.memory.allRevisions()})
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(f.dir, snapshotFileName)
+	if err := writeFileSynced(path+".tmp", raw); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	if err := syncDir(f.dir); err != nil {
+		return err
+	}
+
+	if err := f.wal.Truncate(0); err != nil {
+		return err
+	}
+
+	if err := f.wal.Sync(); err != nil {
+		return err
+	}
+
+	f.size = 0
+	f.records = 0
+	return nil
+}
+
+func writeFileSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func (f *FileRepo) syncPeriodically() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.mu.Lock()
+			if err := f.wal.Sync(); err != nil {
+				log.Println("failed to sync write-ahead log:", err)
+			}
+			f.mu.Unlock()
+		}
+	}
+}
+
+// Close compacts the log so that the next start has nothing to replay.
+func (f *FileRepo) Close() error {
+	if f.stop != nil {
+		close(f.stop)
+		<-f.done
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.snapshot(); err != nil {
+		f.wal.Close()
+		return err
+	}
+
+	return f.wal.Close()
+}
+
+func (f *FileRepo) create(ctx context.Context, customer Customer, change Change) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.memory.has(customer.Id) {
+		return ErrConflict
+	}
+
+	revision := newRevision(RevisionCreated, customer, nil, &customer.CustomerDetails, change)
+	return f.append(walRecord{Op: walCreate, Id: customer.Id, Customer: &customer, Revision: &revision})
+}
+
+func (f *FileRepo) getAll(ctx context.Context) ([]Customer, error) {
+	return f.memory.getAll(ctx)
+}
+
+func (f *FileRepo) list(ctx context.Context, opts ListOptions) (CustomerPage, error) {
+	return f.memory.list(ctx, opts)
+}
+
+func (f *FileRepo) search(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	return f.memory.search(ctx, opts)
+}
+
+func (f *FileRepo) getById(ctx context.Context, id string) (Customer, error) {
+	return f.memory.getById(ctx, id)
+}
+
+func (f *FileRepo) update(ctx context.Context, id string, updateCustomer Customer, ifVersion int64, change Change) (Customer, error) {
+	return f.rewrite(ctx, id, ifVersion, change, func(existing Customer) Customer {
+		updateCustomer.Version = existing.Version + 1
+		return updateCustomer
+	})
+}
+
+func (f *FileRepo) patch(ctx context.Context, id string, p CustomerPatch, ifVersion int64, change Change) (Customer, error) {
+	return f.rewrite(ctx, id, ifVersion, change, func(existing Customer) Customer {
+		patched := p.apply(existing)
+		patched.Version++
+		return patched
+	})
+}
+
+func (f *FileRepo) rewrite(ctx context.Context, id string, ifVersion int64, change Change, next func(existing Customer) Customer) (Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return Customer{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, err := f.memory.getById(ctx, id)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	if ifVersion != 0 && existing.Version != ifVersion {
+		return Customer{}, ErrVersionConflict
+	}
+
+	changed := next(existing)
+	revision := newRevision(RevisionUpdated, changed, &existing.CustomerDetails, &changed.CustomerDetails, change)
+	if err := f.append(walRecord{Op: walUpdate, Id: id, Customer: &changed, Revision: &revision}); err != nil {
+		return Customer{}, err
+	}
+
+	return changed, nil
+}
+
+func (f *FileRepo) delete(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return Customer{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, err := f.memory.getById(ctx, id)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	if ifVersion != 0 && existing.Version != ifVersion {
+		return Customer{}, ErrVersionConflict
+	}
+
+	deletedAt := change.At
+	existing.DeletedAt = &deletedAt
+	revision := newRevision(RevisionDeleted, existing, &existing.CustomerDetails, nil, change)
+	if err := f.append(walRecord{Op: walTrash, Id: id, Customer: &existing, Revision: &revision}); err != nil {
+		return Customer{}, err
+	}
+
+	return existing, nil
+}
+
+func (f *FileRepo) trash(ctx context.Context) ([]Customer, error) {
+	return f.memory.trash(ctx)
+}
+
+func (f *FileRepo) restore(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return Customer{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	trashed, err := f.memory.trash(ctx)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	i := customerIndex(trashed, id)
+	if i < 0 {
+		return Customer{}, ErrNotFound
+	}
+
+	restored := trashed[i]
+	if ifVersion != 0 && restored.Version != ifVersion {
+		return Customer{}, ErrVersionConflict
+	}
+
+	restored.DeletedAt = nil
+	revision := newRevision(RevisionRestored, restored, nil, &restored.CustomerDetails, change)
+	if err := f.append(walRecord{Op: walRestore, Id: id, Customer: &restored, Revision: &revision}); err != nil {
+		return Customer{}, err
+	}
+
+	return restored, nil
+}
+
+func (f *FileRepo) purge(ctx context.Context, before time.Time) ([]purgedCustomer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	trashed, err := f.memory.trash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []purgedCustomer
+	for _, customer := range trashed {
+		if !customer.DeletedAt.Before(before) {
+			continue
+		}
+
+		if err := f.append(walRecord{Op: walDelete, Id: customer.Id}); err != nil {
+			return purged, err
+		}
+		purged = append(purged, purgedCustomer{Id: customer.Id})
+	}
+
+	return purged, nil
+}
+
+func (f *FileRepo) history(ctx context.Context, id string) ([]Revision, error) {
+	return f.memory.history(ctx, id)
+}
+
+func (f *FileRepo) asOf(ctx context.Context, at time.Time) ([]Customer, error) {
+	return f.memory.asOf(ctx, at)
+}
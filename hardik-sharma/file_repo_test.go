@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestFileRepo(t *testing.T, dir string, opts ...FileRepoOption) *FileRepo {
+	repo, err := OpenFileRepo(dir, opts...)
+	if err != nil {
+		t.Fatal("failed to open file repo:", err)
+	}
+
+	return repo
+}
+
+func seedFileRepo(t *testing.T, repo *FileRepo, customers []Customer) {
+	for _, customer := range customers {
+		if err := repo.create(context.Background(), customer, conformanceChange); err != nil {
+			t.Fatal("failed to add customer:", err)
+		}
+	}
+}
+
+func TestFileRepo(t *testing.T) {
+	RunRepoConformance(t, func(t *testing.T, existing []Customer) Repo {
+		repo := openTestFileRepo(t, t.TempDir(), WithSnapshotEvery(7))
+		t.Cleanup(func() { repo.Close() })
+		seedFileRepo(t, repo, existing)
+		return repo
+	})
+}
+
+func TestFileRepo_recovery(t *testing.T) {
+	customers := []Customer{
+		{Id: "a", Version: 1},
+		{Id: "b", Version: 1},
+		{Id: "c", Version: 1},
+	}
+
+	tests := []struct {
+		name          string
+		damage        func(t *testing.T, wal string)
+		wantCustomers []Customer
+		wantErr       error
+	}{
+		{
+			name:          "intact log",
+			damage:        func(t *testing.T, wal string) {},
+			wantCustomers: customers,
+		},
+		{
+			name: "last record torn by a crash",
+			damage: func(t *testing.T, wal string) {
+				info, err := os.Stat(wal)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(wal, info.Size()-5); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCustomers: customers[:2],
+		},
+		{
+			name: "damaged record followed by others",
+			damage: func(t *testing.T, wal string) {
+				raw, err := os.ReadFile(wal)
+				if err != nil {
+					t.Fatal(err)
+				}
+				raw[12] ^= 0xff
+				if err := os.WriteFile(wal, raw, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: ErrCorruptLog,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			repo := openTestFileRepo(t, dir)
+			seedFileRepo(t, repo, customers)
+			repo.wal.Close()
+
+			tt.damage(t, filepath.Join(dir, walFileName))
+
+			reopened, err := OpenFileRepo(dir)
+			assert.ErrorIs(t, err, tt.wantErr, "expect error to be same")
+			if err != nil {
+				return
+			}
+			defer reopened.Close()
+
+			gotCustomers, _ := reopened.getAll(context.Background())
+			assert.Equal(t, tt.wantCustomers, gotCustomers, "expect recovered customers to be same")
+
+			// writes after a truncated record must be readable again
+			seedFileRepo(t, reopened, []Customer{{Id: "d", Version: 1}})
+			reopened.wal.Close()
+
+			again := openTestFileRepo(t, dir)
+			defer again.Close()
+			gotCustomers, _ = again.getAll(context.Background())
+			assert.Equal(t, append(tt.wantCustomers, Customer{Id: "d", Version: 1}), gotCustomers, "expect later writes to survive")
+		})
+	}
+}
+
+func TestFileRepo_snapshot(t *testing.T) {
+	dir := t.TempDir()
+	repo := openTestFileRepo(t, dir, WithSnapshotEvery(2))
+	seedFileRepo(t, repo, []Customer{{Id: "a", Version: 1}, {Id: "b", Version: 1}, {Id: "c", Version: 1}})
+
+	assert.Equal(t, 1, repo.records, "expect the log to restart after a snapshot")
+	assert.FileExists(t, filepath.Join(dir, snapshotFileName), "expect a snapshot to be written")
+
+	if _, err := repo.delete(context.Background(), "a", 0, changeAt(time.Now())); err != nil {
+		t.Fatal("failed to delete customer:", err)
+	}
+	repo.wal.Close()
+
+	reopened := openTestFileRepo(t, dir)
+	gotCustomers, _ := reopened.getAll(context.Background())
+	assert.Equal(t, []Customer{{Id: "b", Version: 1}, {Id: "c", Version: 1}}, gotCustomers, "expect snapshot and log to be combined")
+
+	if err := reopened.Close(); err != nil {
+		t.Fatal("failed to close file repo:", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Zero(t, info.Size(), "expect close to compact the log")
+}
+
+func TestFileRepo_trashSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	repo := openTestFileRepo(t, dir)
+	seedFileRepo(t, repo, []Customer{{Id: "a", Version: 1}, {Id: "b", Version: 1}, {Id: "c", Version: 1}})
+	trashCustomers(t, repo, "a", "b")
+	if _, err := repo.purge(context.Background(), conformanceDeletedAt.Add(time.Minute)); err != nil {
+		t.Fatal("failed to purge:", err)
+	}
+	repo.wal.Close()
+
+	wantTrash := []Customer{trashed(Customer{Id: "b", Version: 1}, conformanceDeletedAt.Add(time.Hour))}
+
+	// first from the log, then from the snapshot written on close
+	for _, reopen := range []string{"log", "snapshot"} {
+		reopened := openTestFileRepo(t, dir)
+
+		assert.Equal(t, wantTrash, trashedCustomers(t, reopened), "expect trash to be recovered from the %s", reopen)
+		assert.Equal(t, []Customer{{Id: "c", Version: 1}}, storedCustomers(t, reopened), "expect customers to be recovered from the %s", reopen)
+
+		if err := reopened.Close(); err != nil {
+			t.Fatal("failed to close file repo:", err)
+		}
+	}
+}
+
+func TestFileRepo_historySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	repo := openTestFileRepo(t, dir, WithSnapshotEvery(3))
+	seedFileRepo(t, repo, []Customer{{Id: "a", Version: 1}, {Id: "b", Version: 1}})
+	trashCustomers(t, repo, "a")
+	if _, err := repo.restore(context.Background(), "a", 0, changeAt(conformanceDeletedAt.Add(time.Hour))); err != nil {
+		t.Fatal("failed to restore:", err)
+	}
+	repo.wal.Close()
+
+	wantHistory := customerHistory(t, repo, "a")
+	assert.Len(t, wantHistory, 3, "expect create, delete and restore to be recorded")
+
+	// part from the snapshot written after three records and part from
+	// the log, then all from the snapshot written on close
+	for _, reopen := range []string{"snapshot and log", "snapshot"} {
+		reopened := openTestFileRepo(t, dir)
+
+		assert.Equal(t, wantHistory, customerHistory(t, reopened, "a"), "expect history to be recovered from the %s", reopen)
+
+		if err := reopened.Close(); err != nil {
+			t.Fatal("failed to close file repo:", err)
+		}
+	}
+}
+
+func TestFileRepo_backfillsHistory(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := `{"customers": [{"id": "a", "customerDetails": {"name": "hardik"}, "version": 2}]}`
+	if err := os.WriteFile(filepath.Join(dir, snapshotFileName), []byte(snapshot), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := openTestFileRepo(t, dir)
+	defer repo.Close()
+
+	gotHistory := customerHistory(t, repo, "a")
+	if assert.Len(t, gotHistory, 1, "expect a revision for the customer stored without history") {
+		assert.Equal(t, RevisionCreated, gotHistory[0].Type, "expect customer to start out created")
+		assert.Equal(t, systemActor, gotHistory[0].Actor, "expect revision to be made by the system")
+		assert.Equal(t, &CustomerDetails{Name: "hardik"}, gotHistory[0].NewDetails, "expect current details")
+		assert.Equal(t, int64(2), gotHistory[0].Version, "expect current version")
+	}
+}
+
+func TestFileRepo_concurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	repo := openTestFileRepo(t, dir, WithFsyncPolicy(FsyncInterval, time.Millisecond), WithSnapshotEvery(7))
+	seedFileRepo(t, repo, []Customer{{Id: "hs", Version: 1}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			address := "jaipur"
+			if _, err := repo.patch(context.Background(), "hs", CustomerPatch{Address: &address}, 0, conformanceChange); err != nil {
+				t.Error("failed to patch customer:", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := repo.Close(); err != nil {
+		t.Fatal("failed to close file repo:", err)
+	}
+
+	reopened := openTestFileRepo(t, dir)
+	defer reopened.Close()
+
+	got, err := reopened.getById(context.Background(), "hs")
+	assert.NoError(t, err, "expect customer to be found")
+	assert.Equal(t, int64(21), got.Version, "expect every patch to be kept")
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+var ErrInvalidAsOf = errors.New("invalid asOf")
+
+const (
+	RevisionCreated  = "created"
+	RevisionUpdated  = "updated"
+	RevisionDeleted  = "deleted"
+	RevisionRestored = "restored"
+)
+
+// Change tells who makes a write and when.
+type Change struct {
+	Actor string
+	At    time.Time
+}
+
+// Revision records one write of a customer, OldDetails is nil for creates
+// and restores and NewDetails for deletes.
+type Revision struct {
+	CustomerId string           `json:"customerId" bun:"customer_id"`
+	Version    int64            `json:"version" bun:"version"`
+	Type       string           `json:"type" bun:"type"`
+	Actor      string           `json:"actor" bun:"actor"`
+	At         time.Time        `json:"at" bun:"changed_at"`
+	OldDetails *CustomerDetails `json:"oldDetails,omitempty" bun:"old_details,type:jsonb,nullzero"`
+	NewDetails *CustomerDetails `json:"newDetails,omitempty" bun:"new_details,type:jsonb,nullzero"`
+}
+
+func newRevision(revisionType string, customer Customer, oldDetails, newDetails *CustomerDetails, change Change) Revision {
+	return Revision{
+		CustomerId: customer.Id,
+		Version:    customer.Version,
+		Type:       revisionType,
+		Actor:      change.Actor,
+		At:         change.At,
+		OldDetails: oldDetails,
+		NewDetails: newDetails,
+	}
+}
+
+func (r Revision) customer() (Customer, bool) {
+	if r.NewDetails == nil {
+		return Customer{}, false
+	}
+
+	return Customer{Id: r.CustomerId, CustomerDetails: *r.NewDetails, Version: r.Version}, true
+}
+
+func customerAsOf(revisions []Revision, at time.Time) (Customer, bool) {
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if !revisions[i].At.After(at) {
+			return revisions[i].customer()
+		}
+	}
+
+	return Customer{}, false
+}
+
+// FieldChange is a field a revision changed.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// HistoryEntry is a revision along with the fields it changed.
+type HistoryEntry struct {
+	Revision
+	Changes []FieldChange `json:"changes"`
+}
+
+type CustomerHistory struct {
+	Revisions []HistoryEntry `json:"revisions"`
+}
+
+func newCustomerHistory(revisions []Revision) CustomerHistory {
+	history := CustomerHistory{Revisions: make([]HistoryEntry, len(revisions))}
+	for i, revision := range revisions {
+		history.Revisions[i] = HistoryEntry{Revision: revision, Changes: diffRevision(revision)}
+	}
+
+	return history
+}
+
+func diffRevision(r Revision) []FieldChange {
+	var before, after CustomerDetails
+	if r.OldDetails != nil {
+		before = *r.OldDetails
+	}
+	if r.NewDetails != nil {
+		after = *r.NewDetails
+	}
+
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"customerDetails.name", before.Name, after.Name},
+		{"customerDetails.address", before.Address, after.Address},
+		{"customerDetails.contactNo", string(before.ContactNo), string(after.ContactNo)},
+	}
+
+	changes := []FieldChange{}
+	for _, field := range fields {
+		if field.old != field.new {
+			changes = append(changes, FieldChange{Field: field.name, Old: field.old, New: field.new})
+		}
+	}
+
+	return changes
+}
+
+type revisionRow struct {
+	bun.BaseModel `bun:"table:customer_revisions,alias:revision"`
+
+	Seq    int64  `bun:"seq,pk,autoincrement"`
+	Tenant string `bun:"tenant_id"`
+	Revision
+}
+
+func insertRevision(ctx context.Context, db bun.IDB, revision Revision) error {
+	_, err := db.NewInsert().Model(&revisionRow{Tenant: tenantFromContext(ctx), Revision: revision}).Exec(ctx)
+	return err
+}
+
+func selectHistory(ctx context.Context, db bun.IDB, id string) ([]Revision, error) {
+	rows := []revisionRow{}
+	err := db.NewSelect().
+		Model(&rows).
+		Where("tenant_id = ?", tenantFromContext(ctx)).
+		Where("customer_id = ?", id).
+		Order("seq").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return revisionsOf(rows), nil
+}
+
+func selectAsOf(ctx context.Context, db bun.IDB, at time.Time) ([]Customer, error) {
+	latest := db.NewSelect().
+		Model((*revisionRow)(nil)).
+		ColumnExpr("max(seq)").
+		Where("tenant_id = ?", tenantFromContext(ctx)).
+		Where("changed_at <= ?", at).
+		Group("customer_id")
+
+	rows := []revisionRow{}
+	if err := db.NewSelect().Model(&rows).Where("seq IN (?)", latest).Scan(ctx); err != nil {
+		return nil, err
+	}
+
+	customers := []Customer{}
+	for _, revision := range revisionsOf(rows) {
+		if customer, ok := revision.customer(); ok {
+			customers = append(customers, customer)
+		}
+	}
+
+	return customers, nil
+}
+
+func revisionsOf(rows []revisionRow) []Revision {
+	revisions := make([]Revision, len(rows))
+	for i, row := range rows {
+		revisions[i] = row.Revision
+	}
+
+	return revisions
+}
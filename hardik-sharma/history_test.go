@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_diffRevision(t *testing.T) {
+	before := CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919649127559"}
+	after := CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+917777777777"}
+
+	tests := []struct {
+		name        string
+		revision    Revision
+		wantChanges []FieldChange
+	}{
+		{
+			name:     "created customer has only new values",
+			revision: Revision{Type: RevisionCreated, NewDetails: &before},
+			wantChanges: []FieldChange{
+				{Field: "customerDetails.name", New: "hardik"},
+				{Field: "customerDetails.address", New: "udaipur"},
+				{Field: "customerDetails.contactNo", New: "+919649127559"},
+			},
+		},
+		{
+			name:     "update lists only changed fields",
+			revision: Revision{Type: RevisionUpdated, OldDetails: &before, NewDetails: &after},
+			wantChanges: []FieldChange{
+				{Field: "customerDetails.address", Old: "udaipur", New: "jaipur"},
+				{Field: "customerDetails.contactNo", Old: "+919649127559", New: "+917777777777"},
+			},
+		},
+		{
+			name:        "update that changed nothing",
+			revision:    Revision{Type: RevisionUpdated, OldDetails: &before, NewDetails: &before},
+			wantChanges: []FieldChange{},
+		},
+		{
+			name:     "deleted customer has only old values",
+			revision: Revision{Type: RevisionDeleted, OldDetails: &after},
+			wantChanges: []FieldChange{
+				{Field: "customerDetails.name", Old: "hardik"},
+				{Field: "customerDetails.address", Old: "jaipur"},
+				{Field: "customerDetails.contactNo", Old: "+917777777777"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantChanges, diffRevision(tt.revision), "expect changes to be same")
+		})
+	}
+}
+
+func Test_customerAsOf(t *testing.T) {
+	at := func(hours int) time.Time { return conformanceDeletedAt.Add(time.Duration(hours) * time.Hour) }
+	created := CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919649127559"}
+	updated := CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+919649127559"}
+
+	revisions := []Revision{
+		{CustomerId: "hs", Version: 1, Type: RevisionCreated, At: at(0), NewDetails: &created},
+		{CustomerId: "hs", Version: 2, Type: RevisionUpdated, At: at(1), OldDetails: &created, NewDetails: &updated},
+		{CustomerId: "hs", Version: 2, Type: RevisionDeleted, At: at(2), OldDetails: &updated},
+		{CustomerId: "hs", Version: 2, Type: RevisionRestored, At: at(3), NewDetails: &updated},
+	}
+
+	tests := []struct {
+		name         string
+		at           time.Time
+		wantCustomer Customer
+		wantFound    bool
+	}{
+		{
+			name: "before it was created",
+			at:   at(-1),
+		},
+		{
+			name:         "when it was created",
+			at:           at(0),
+			wantCustomer: Customer{Id: "hs", CustomerDetails: created, Version: 1},
+			wantFound:    true,
+		},
+		{
+			name:         "after an update",
+			at:           at(1).Add(time.Minute),
+			wantCustomer: Customer{Id: "hs", CustomerDetails: updated, Version: 2},
+			wantFound:    true,
+		},
+		{
+			name: "while it was in the trash",
+			at:   at(2),
+		},
+		{
+			name:         "after it was restored",
+			at:           at(4),
+			wantCustomer: Customer{Id: "hs", CustomerDetails: updated, Version: 2},
+			wantFound:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCustomer, gotFound := customerAsOf(revisions, tt.at)
+
+			assert.Equal(t, tt.wantFound, gotFound, "expect found to be same")
+			assert.Equal(t, tt.wantCustomer, gotCustomer, "expect customer to be same")
+		})
+	}
+}
@@ -2,69 +2,138 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
 type CustomerHandler struct {
-	service CustomerService
+	service        CustomerService
+	websocket      WebsocketLimits
+	upgrader       websocket.Upgrader
+	trustedProxies []*net.IPNet
+	authenticators []Authenticator
+	policy         *Policy
+	origins        []string
 }
 
 type Subscriber interface {
 	getSubscriberId() string
-	update(customers []Customer)
+	update(event ChangeEvent)
 }
 
-func NewCustomerHandler(service CustomerService) *CustomerHandler {
-	return &CustomerHandler{service: service}
+type HandlerOption func(*CustomerHandler)
+
+// WithWebsocketLimits bounds the resources each websocket client may use.
+func WithWebsocketLimits(limits WebsocketLimits) HandlerOption {
+	return func(h *CustomerHandler) {
+		h.websocket = limits
+	}
 }
 
-func handleResponseErr(w http.ResponseWriter, statusCode int, errMsg string, err error) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
+// WithTrustedProxies sets the proxies whose X-Forwarded-For header names
+// the source IP recorded in the audit log.
+func WithTrustedProxies(networks []*net.IPNet) HandlerOption {
+	return func(h *CustomerHandler) {
+		h.trustedProxies = networks
+	}
+}
 
-	log.Printf("failed due to error :%q", err)
+// WithAuthenticators makes every request, websocket upgrades included,
+// authenticate with one of authenticators.
+func WithAuthenticators(authenticators ...Authenticator) HandlerOption {
+	return func(h *CustomerHandler) {
+		h.authenticators = authenticators
+	}
+}
 
-	if err := json.NewEncoder(w).Encode(errMsg); err != nil {
-		log.Printf("failed to send response :%q", err)
-		return
+// WithPolicy makes every route require the permission of its operation
+// from the roles of the authenticated principal, see Policy.
+func WithPolicy(policy *Policy) HandlerOption {
+	return func(h *CustomerHandler) {
+		h.policy = policy
 	}
 }
 
+// WithWebsocketOrigins sets the origins besides the server's own whose
+// pages may open websockets, see checkOrigin.
+func WithWebsocketOrigins(origins []string) HandlerOption {
+	return func(h *CustomerHandler) {
+		h.origins = origins
+	}
+}
+
+func NewCustomerHandler(service CustomerService, opts ...HandlerOption) *CustomerHandler {
+	h := &CustomerHandler{service: service, websocket: DefaultWebsocketLimits}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.upgrader = websocket.Upgrader{
+		ReadBufferSize:  h.websocket.ReadBufferSize,
+		WriteBufferSize: h.websocket.WriteBufferSize,
+		CheckOrigin:     checkOrigin(h.origins),
+	}
+
+	return h
+}
+
+// etag is the strong entity tag of a customer version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch returns the version a write is conditional on, or 0 when the
+// request sets no If-Match or "*". Anything but a single tag of the form
+// etag produces can never match, which is reported as ErrVersionConflict.
+func parseIfMatch(r *http.Request) (int64, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+
+	if len(ifMatch) < 2 || ifMatch[0] != '"' || ifMatch[len(ifMatch)-1] != '"' {
+		return 0, ErrVersionConflict
+	}
+
+	version, err := strconv.ParseInt(ifMatch[1:len(ifMatch)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, ErrVersionConflict
+	}
+
+	return version, nil
+}
+
 func (h *CustomerHandler) createCustomer(w http.ResponseWriter, r *http.Request) {
 	var customer Customer
 	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		handleResponseErr(w, http.StatusBadRequest, "invalid json body", err)
+		writeProblem(w, r, fmt.Errorf("%w: %v", ErrInvalidBody, err))
 		return
 	}
 
-	if err := h.service.addCustomer(customer); err != nil {
-		if errors.Is(err, ErrInvalidId) {
-			handleResponseErr(w, http.StatusBadRequest, "invalid id", err)
-			return
-		}
-
-		if errors.Is(err, ErrInvalidContactNo) {
-			handleResponseErr(w, http.StatusBadRequest, "invalid contact number", err)
-			return
-		}
-
-		if errors.Is(err, ErrConflict) {
-			handleResponseErr(w, http.StatusConflict, "customer exists", err)
-			return
-		}
-		handleResponseErr(w, http.StatusInternalServerError, "internal server error", err)
+	created, err := h.service.addCustomer(r.Context(), customer)
+	if err != nil {
+		writeProblem(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/customers/"+created.Id)
+	w.Header().Set("ETag", etag(created.Version))
 	w.WriteHeader(http.StatusCreated)
 
-	if err := json.NewEncoder(w).Encode("customer registered"); err != nil {
+	if err := json.NewEncoder(w).Encode(created); err != nil {
 		log.Printf("failed to send response :%q", err)
 		return
 	}
@@ -74,70 +143,198 @@ func (h *CustomerHandler) updateCustomer(w http.ResponseWriter, r *http.Request)
 	var customer Customer
 
 	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		handleResponseErr(w, http.StatusBadRequest, "invalid json body", err)
+		writeProblem(w, r, fmt.Errorf("%w: %v", ErrInvalidBody, err))
 		return
 	}
 
-	if err := h.service.updateCustomer(customer); err != nil {
-		if errors.Is(err, ErrInvalidId) {
-			handleResponseErr(w, http.StatusBadRequest, "invalid id", err)
-			return
-		}
-
-		if errors.Is(err, ErrInvalidContactNo) {
-			handleResponseErr(w, http.StatusBadRequest, "invalid contact number", err)
-			return
-		}
-
-		if errors.Is(err, ErrNotFound) {
-			handleResponseErr(w, http.StatusNotFound, "customer not found", err)
-			return
-		}
+	ifVersion, err := parseIfMatch(r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
-		handleResponseErr(w, http.StatusInternalServerError, "internal server error", err)
+	updated, err := h.service.updateCustomer(r.Context(), customer, ifVersion)
+	if err != nil {
+		writeProblem(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updated.Version))
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode("customer details updated"); err != nil {
 		log.Printf("failed to write response msg due to error :%q", err)
 	}
 }
 
-func (h *CustomerHandler) getAllCustomer(w http.ResponseWriter, r *http.Request) {
-	customers, err := h.service.getAllCustomer()
+const maxPatchSize = 1 << 20
+
+// patchCustomer applies a JSON Merge Patch or JSON Patch, told apart by the
+// content type, and responds with the updated customer.
+func (h *CustomerHandler) patchCustomer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	parse, err := patchParserByContentType(contentType)
+	if err != nil {
+		w.Header().Set("Accept-Patch", MergePatchContentType+", "+JSONPatchContentType)
+		writeProblem(w, r, err)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		writeProblem(w, r, fmt.Errorf("%w: %v", ErrInvalidPatch, err))
+		return
+	}
+
+	patch, err := parse(body)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	ifVersion, err := parseIfMatch(r)
 	if err != nil {
-		handleResponseErr(w, http.StatusInternalServerError, "internal server error", err)
+		writeProblem(w, r, err)
 		return
 	}
 
+	updated, err := h.service.patchCustomer(r.Context(), id, patch, ifVersion)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updated.Version))
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(customers); err != nil {
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
 		log.Printf("failed to send response :%q", err)
-		return
 	}
 }
 
-func (h *CustomerHandler) getCustomerById(w http.ResponseWriter, r *http.Request) {
-	id := mux.Vars(r)["id"]
+// parseAsOf reads the RFC 3339 time a read should go back to, zero when the
+// request has no asOf.
+func parseAsOf(r *http.Request) (time.Time, error) {
+	asOf := r.URL.Query().Get("asOf")
+	if asOf == "" {
+		return time.Time{}, nil
+	}
 
-	customer, err := h.service.getCustomerById(id)
+	at, err := time.Parse(time.RFC3339Nano, asOf)
 	if err != nil {
-		if errors.Is(err, ErrInvalidId) {
-			handleResponseErr(w, http.StatusBadRequest, "invalid id", err)
-			return
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidAsOf, err)
+	}
+
+	return at.UTC(), nil
+}
+
+// parseListOptions reads paging, filter and sort parameters of a listing
+// request. A leading "-" on sort selects descending order.
+func parseListOptions(r *http.Request) (ListOptions, error) {
+	query := r.URL.Query()
+	opts := ListOptions{
+		Cursor:    query.Get("cursor"),
+		Name:      query.Get("name"),
+		Address:   query.Get("address"),
+		ContactNo: PhoneNumber(query.Get("contactNo")),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return opts, ErrInvalidListOptions
 		}
+		opts.Limit = n
+	}
+
+	if sortBy := query.Get("sort"); sortBy != "" {
+		opts.Descending = strings.HasPrefix(sortBy, "-")
+		opts.SortBy = strings.TrimPrefix(sortBy, "-")
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		return opts, err
+	}
+	opts.AsOf = asOf
+
+	return opts, nil
+}
+
+func (h *CustomerHandler) listCustomers(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	page, err := h.service.listCustomers(r.Context(), opts)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
-		if errors.Is(err, ErrNotFound) {
-			handleResponseErr(w, http.StatusNotFound, "customer not found", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("failed to send response :%q", err)
+		return
+	}
+}
+
+func (h *CustomerHandler) searchCustomers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := SearchOptions{Query: query.Get("q")}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			writeProblem(w, r, fmt.Errorf("%w: limit must be a number", ErrInvalidSearch))
 			return
 		}
+		opts.Limit = n
+	}
+
+	hits, err := h.service.searchCustomers(r.Context(), opts)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(SearchResults{Hits: hits}); err != nil {
+		log.Printf("failed to send response :%q", err)
+		return
+	}
+}
+
+// getCustomerById responds with the customer as it is, or as it was at the
+// time given as asOf.
+func (h *CustomerHandler) getCustomerById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
-		handleResponseErr(w, http.StatusInternalServerError, "internal server error", err)
+	var customer Customer
+	if asOf.IsZero() {
+		customer, err = h.service.getCustomerById(r.Context(), id)
+	} else {
+		customer, err = h.service.getCustomerAsOf(r.Context(), id, asOf)
+	}
+	if err != nil {
+		writeProblem(w, r, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(customer.Version))
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(customer.CustomerDetails); err != nil {
 		log.Printf("failed to write response due to error :%q", err)
@@ -146,43 +343,236 @@ func (h *CustomerHandler) getCustomerById(w http.ResponseWriter, r *http.Request
 
 func (h *CustomerHandler) deleteCustomer(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
-	if err := h.service.deleteCustomer(id); err != nil {
-		if errors.Is(err, ErrInvalidId) {
-			handleResponseErr(w, http.StatusBadRequest, "invalid id", err)
-			return
+
+	ifVersion, err := parseIfMatch(r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	if err := h.service.deleteCustomer(r.Context(), id, ifVersion); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode("customer deleted"); err != nil {
+		log.Printf("failed to send response :%q", err)
+		return
+	}
+}
+
+func (h *CustomerHandler) listTrash(w http.ResponseWriter, r *http.Request) {
+	trashed, err := h.service.listTrash(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(CustomerPage{Customers: trashed}); err != nil {
+		log.Printf("failed to send response :%q", err)
+		return
+	}
+}
+
+// restoreCustomer takes a customer out of the trash and responds with it,
+// If-Match works as for deletes.
+func (h *CustomerHandler) restoreCustomer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	ifVersion, err := parseIfMatch(r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	restored, err := h.service.restoreCustomer(r.Context(), id, ifVersion)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(restored.Version))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(restored); err != nil {
+		log.Printf("failed to send response :%q", err)
+	}
+}
+
+func (h *CustomerHandler) purgeTrash(w http.ResponseWriter, r *http.Request) {
+	purged, err := h.service.purgeTrash(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]int{"purged": purged}); err != nil {
+		log.Printf("failed to send response :%q", err)
+	}
+}
+
+// customerHistory responds with every revision of a customer, oldest
+// first, along with the fields each one changed.
+func (h *CustomerHandler) customerHistory(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	revisions, err := h.service.customerHistory(r.Context(), id)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(newCustomerHistory(revisions)); err != nil {
+		log.Printf("failed to send response :%q", err)
+	}
+}
+
+// parseAuditFilter reads the filters of an audit log query, since and
+// until are RFC 3339 times and cursor is the next of an earlier page.
+func parseAuditFilter(r *http.Request) (AuditFilter, error) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Actor:     query.Get("actor"),
+		Operation: query.Get("operation"),
+		TargetId:  query.Get("targetId"),
+		Outcome:   query.Get("outcome"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return filter, fmt.Errorf("%w: limit must be a number", ErrInvalidAuditFilter)
 		}
+		filter.Limit = n
+	}
 
-		if errors.Is(err, ErrNotFound) {
-			handleResponseErr(w, http.StatusNotFound, "customer not found", err)
-			return
+	var err error
+	if filter.Since, err = parseAuditTime(query.Get("since"), "since"); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = parseAuditTime(query.Get("until"), "until"); err != nil {
+		return filter, err
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		seq, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || seq <= 0 {
+			return filter, fmt.Errorf("%w: invalid cursor", ErrInvalidAuditFilter)
 		}
+		filter.Before = seq
+	}
+
+	return filter, nil
+}
+
+func parseAuditTime(value string, name string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s must be an RFC 3339 time", ErrInvalidAuditFilter, name)
+	}
+
+	return at, nil
+}
+
+// queryAudit responds with a page of audit entries, newest first.
+func (h *CustomerHandler) queryAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
-		handleResponseErr(w, http.StatusInternalServerError, "internal server error", err)
+	page, err := h.service.queryAudit(r.Context(), filter)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode("customer deleted"); err != nil {
+	if err := json.NewEncoder(w).Encode(page); err != nil {
 		log.Printf("failed to send response :%q", err)
+	}
+}
+
+// verifyAudit responds with whether the hash chain of the audit log is
+// intact. A broken chain is still a successful check.
+func (h *CustomerHandler) verifyAudit(w http.ResponseWriter, r *http.Request) {
+	result, err := h.service.verifyAudit(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("failed to send response :%q", err)
+	}
+}
+
+// debugVars responds with the notification counters in the format of
+// expvar, which would also publish the command line and with it -db-dsn.
+func (h *CustomerHandler) debugVars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]any{"notifications": h.service.NotificationStats()}); err != nil {
+		log.Printf("failed to send response :%q", err)
+	}
 }
 
 // websocket implementation
-var upgrader = websocket.Upgrader{
+
+// WebsocketLimits bounds the buffers of a websocket connection, the size of
+// messages read from clients and how long sending one message may take.
+type WebsocketLimits struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+	MaxMessageSize  int64
+	WriteTimeout    time.Duration
+}
+
+var DefaultWebsocketLimits = WebsocketLimits{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	MaxMessageSize:  4096,
+	WriteTimeout:    10 * time.Second,
 }
 
+// websocketClient serializes writes, a websocket connection supports only
+// one concurrent writer while notifications may arrive from many requests.
 type websocketClient struct {
-	clientId string
-	client   *websocket.Conn
+	clientId     string
+	writeMu      sync.Mutex
+	client       *websocket.Conn
+	writeTimeout time.Duration
+	failed       bool
 }
 
-func NewWebsocketClient(clientId string, client *websocket.Conn) *websocketClient {
+func NewWebsocketClient(clientId string, client *websocket.Conn, writeTimeout time.Duration) *websocketClient {
 	return &websocketClient{
-		clientId: clientId,
-		client:   client,
+		clientId:     clientId,
+		client:       client,
+		writeTimeout: writeTimeout,
+	}
+}
+
+// disconnect closes the underlying connection, which also ends the read
+// loop in websocketEndpoint.
+func (w *websocketClient) disconnect() {
+	if err := w.client.Close(); err != nil {
+		log.Printf("failed to close connection :%q", err)
 	}
 }
 
@@ -190,30 +580,75 @@ func (w *websocketClient) getSubscriberId() string {
 	return w.clientId
 }
 
-func (w *websocketClient) update(customers []Customer) {
-	customerList, err := json.Marshal(customers)
+func (w *websocketClient) update(event ChangeEvent) {
+	message, err := json.Marshal(event)
 	if err != nil {
 		log.Printf("encoding to json failed :%q\n", err)
 		return
 	}
 
-	if err := w.client.WriteMessage(websocket.TextMessage, customerList); err != nil {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	if w.failed {
+		return
+	}
+
+	// a peer that can't be written to won't hear of anything else, closing
+	// the connection ends websocketEndpoint, which unsubscribes it
+	if err := w.client.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+		log.Printf("failed to set write deadline :%q", err)
+		w.failed = true
+		w.disconnect()
+		return
+	}
+
+	if err := w.client.WriteMessage(websocket.TextMessage, message); err != nil {
 		log.Printf("failed to write message :%q", err)
+		w.failed = true
+		w.disconnect()
 		return
 	}
 }
 
 func (h *CustomerHandler) websocketEndpoint(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
+	var since uint64
+	resume := r.URL.Query().Has("since")
+	if resume {
+		var err error
+		if since, err = strconv.ParseUint(r.URL.Query().Get("since"), 10, 64); err != nil {
+			writeProblem(w, r, fmt.Errorf("%w: %v", ErrInvalidSince, err))
+			return
+		}
+	}
+
+	ws, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("failed to upgrade request :%q", err)
 		return
 	}
 	defer ws.Close()
+	ws.SetReadLimit(h.websocket.MaxMessageSize)
 
 	clientId := ws.RemoteAddr().String()
-	client := NewWebsocketClient(clientId, ws)
-	h.service.subscribe(client)
+	client := NewWebsocketClient(clientId, ws, h.websocket.WriteTimeout)
+
+	// clients that ask for a snapshot get the current list before any
+	// change events, resuming clients first get what they missed, others
+	// only hear about later changes
+	switch {
+	case resume:
+		err = h.service.resumeSubscription(r.Context(), client, since)
+	case r.URL.Query().Get("snapshot") == "true":
+		err = h.service.subscribeWithSnapshot(r.Context(), client)
+	default:
+		h.service.subscribe(r.Context(), client)
+	}
+
+	if err != nil {
+		log.Printf("failed to subscribe :%q", err)
+		return
+	}
 	defer h.service.unSubscribe(client)
 
 	for {
@@ -226,13 +661,27 @@ func (h *CustomerHandler) websocketEndpoint(w http.ResponseWriter, r *http.Reque
 
 func registerRoutes(h *CustomerHandler) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(withRequestId, withActor, withSourceIP(h.trustedProxies), withAuthentication(h.authenticators))
+
+	allow := func(permission Permission, handler http.HandlerFunc) http.HandlerFunc {
+		return withPermission(h.policy, permission, handler)
+	}
 
-	router.Methods("POST").Path("/api/customers").HandlerFunc(h.createCustomer)
-	router.Methods("PUT").Path("/api/customers").HandlerFunc(h.updateCustomer)
-	router.Methods("GET").Path("/api/customers/{id}").HandlerFunc(h.getCustomerById)
-	router.Methods("GET").Path("/api/customers").HandlerFunc(h.getAllCustomer)
-	router.Methods("DELETE").Path("/api/customers/{id}").HandlerFunc(h.deleteCustomer)
-	router.HandleFunc("/ws", h.websocketEndpoint)
+	router.Methods("POST").Path("/api/customers").HandlerFunc(allow(PermCreate, h.createCustomer))
+	router.Methods("PUT").Path("/api/customers").HandlerFunc(allow(PermUpdate, h.updateCustomer))
+	router.Methods("PATCH").Path("/api/customers/{id}").HandlerFunc(allow(PermUpdate, h.patchCustomer))
+	router.Methods("GET").Path("/api/customers/search").HandlerFunc(allow(PermRead, h.searchCustomers))
+	router.Methods("GET").Path("/api/customers/trash").HandlerFunc(allow(PermRead, h.listTrash))
+	router.Methods("POST").Path("/api/customers/trash/purge").HandlerFunc(allow(PermPurge, h.purgeTrash))
+	router.Methods("POST").Path("/api/customers/trash/{id}/restore").HandlerFunc(allow(PermRestore, h.restoreCustomer))
+	router.Methods("GET").Path("/api/customers/{id}/history").HandlerFunc(allow(PermRead, h.customerHistory))
+	router.Methods("GET").Path("/api/customers/{id}").HandlerFunc(allow(PermRead, h.getCustomerById))
+	router.Methods("GET").Path("/api/customers").HandlerFunc(allow(PermRead, h.listCustomers))
+	router.Methods("DELETE").Path("/api/customers/{id}").HandlerFunc(allow(PermDelete, h.deleteCustomer))
+	router.Methods("GET").Path("/api/audit").HandlerFunc(allow(PermAudit, h.queryAudit))
+	router.Methods("GET").Path("/api/audit/verify").HandlerFunc(allow(PermAudit, h.verifyAudit))
+	router.HandleFunc("/ws", allow(PermRead, h.websocketEndpoint))
+	router.Methods("GET").Path("/debug/vars").HandlerFunc(allow(PermMetrics, h.debugVars))
 
 	return router
 }
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"log"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
@@ -17,50 +18,155 @@ func TestCustomerHandler_createCustomer(t *testing.T) {
 	}
 
 	tests := []struct {
-		name       string
-		fields     fields
-		reqbody    string
-		wantStatus int
-		wantBody   string
+		name         string
+		fields       fields
+		generatedId  string
+		reqbody      string
+		wantStatus   int
+		wantBody     string
+		wantLocation string
+		wantETag     string
 	}{
 		{
-			name: "invalid id",
+			name: "client supplied id is ignored",
 			fields: fields{
 				customers: []Customer{},
 			},
+			generatedId: "0192a5e4-1f3c-7b2a-9d4e-5f6a7b8c9d0e",
 			reqbody: `
 			{
 				"id": "h",
 				"customerDetails": {
 					"name": "hdik",
 					"address": "hsghd",
+					"contactNo": "+919649127584"
+				}
+			}
+			`,
+			wantStatus: http.StatusCreated,
+			wantBody: `
+			{
+				"id": "0192a5e4-1f3c-7b2a-9d4e-5f6a7b8c9d0e",
+				"customerDetails": {
+					"name": "hdik",
+					"address": "hsghd",
+					"contactNo": "+919649127584"
+				},
+				"version": 1
+			}
+			`,
+			wantLocation: "/api/customers/0192a5e4-1f3c-7b2a-9d4e-5f6a7b8c9d0e",
+			wantETag:     `"1"`,
+		},
+		{
+			name: "numeric contact number of older clients",
+			fields: fields{
+				customers: []Customer{},
+			},
+			generatedId: "vs",
+			reqbody: `
+			{
+				"customerDetails": {
+					"name": "varshil",
+					"address": "udaipur",
 					"contactNo": 9649127584
 				}
 			}
 			`,
+			wantStatus: http.StatusCreated,
+			wantBody: `
+			{
+				"id": "vs",
+				"customerDetails": {
+					"name": "varshil",
+					"address": "udaipur",
+					"contactNo": "+919649127584"
+				},
+				"version": 1
+			}
+			`,
+			wantLocation: "/api/customers/vs",
+			wantETag:     `"1"`,
+		},
+		{
+			name: "national contact number is normalized",
+			fields: fields{
+				customers: []Customer{},
+			},
+			generatedId: "vs",
+			reqbody: `
+			{
+				"customerDetails": {
+					"name": "varshil",
+					"address": "udaipur",
+					"contactNo": "096491 27584"
+				}
+			}
+			`,
+			wantStatus: http.StatusCreated,
+			wantBody: `
+			{
+				"id": "vs",
+				"customerDetails": {
+					"name": "varshil",
+					"address": "udaipur",
+					"contactNo": "+919649127584"
+				},
+				"version": 1
+			}
+			`,
+			wantLocation: "/api/customers/vs",
+			wantETag:     `"1"`,
+		},
+		{
+			name: "every invalid field is reported",
+			fields: fields{
+				customers: []Customer{},
+			},
+			generatedId: "vs",
+			reqbody: `
+			{
+				"customerDetails": {
+					"name": "",
+					"address": "jaipur",
+					"contactNo": 96491
+				}
+			}
+			`,
 			wantStatus: http.StatusBadRequest,
-			wantBody:   `"invalid id"`,
+			wantBody: `{
+				"type": "/problems/validation_failed",
+				"title": "invalid customer",
+				"status": 400,
+				"code": "validation_failed",
+				"detail": "validation failed: customerDetails.name is required, customerDetails.contactNo invalid contact number",
+				"errors": [
+					{"field": "customerDetails.name", "reason": "is required"},
+					{"field": "customerDetails.contactNo", "reason": "invalid contact number"}
+				]
+			}`,
 		},
 		{
 			name: "invalid json body",
 			fields: fields{
 				customers: []Customer{},
 			},
+			generatedId: "vs",
 			reqbody: `
 			{
 				"id": "h,
 				"customerDetails": {
 					"name": "hdik",
 					"address": "hsghd",
-					"contactNo": 9649127584
+					"contactNo": "+919649127584"
 				}
 			}
 			`,
 			wantStatus: http.StatusBadRequest,
-			wantBody:   `"invalid json body"`,
+			wantBody:   `{"type": "/problems/invalid_body", "title": "invalid json body", "status": 400, "code": "invalid_body", "detail": "invalid json body: invalid character '\\n' in string"}`,
 		},
 		{
-			name: "existing customer",
+			name: "generated id collides with existing customer",
 			fields: fields{
 				customers: []Customer{
 					{
@@ -68,23 +174,23 @@ func TestCustomerHandler_createCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "uadipur",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 				},
 			},
+			generatedId: "hs",
 			reqbody: `
 			{
-				"id": "hs",
 				"customerDetails": {
 					"name": "hdik",
 					"address": "hsghd",
-					"contactNo": 9649127584
+					"contactNo": "+919649127584"
 				}
 			}
 			`,
 			wantStatus: http.StatusConflict,
-			wantBody:   `"customer exists"`,
+			wantBody:   `{"type": "/problems/conflict", "title": "customer exists", "status": 409, "code": "conflict", "detail": "customer already exists"}`,
 		},
 		{
 			name: "invalid contact number",
@@ -95,14 +201,14 @@ func TestCustomerHandler_createCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "uadipur",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 				},
 			},
+			generatedId: "vs",
 			reqbody: `
 			{
-				"id": "vs",
 				"customerDetails": {
 					"name": "varshil",
 					"address": "udr",
@@ -111,7 +217,7 @@ func TestCustomerHandler_createCustomer(t *testing.T) {
 			}
 			`,
 			wantStatus: http.StatusBadRequest,
-			wantBody:   `"invalid contact number"`,
+			wantBody:   `{"type": "/problems/validation_failed", "title": "invalid customer", "status": 400, "code": "validation_failed", "detail": "validation failed: customerDetails.contactNo invalid contact number", "errors": [{"field": "customerDetails.contactNo", "reason": "invalid contact number"}]}`,
 		},
 		{
 			name: "non existing valid customer",
@@ -122,23 +228,35 @@ func TestCustomerHandler_createCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "uadipur",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 				},
 			},
+			generatedId: "01HF3T7ZQK9V5X2M8N4P6R0W1Y",
 			reqbody: `
 			{
-				"id": "vs",
 				"customerDetails": {
 					"name": "varshil",
 					"address": "udr",
-					"contactNo": 8888888888
+					"contactNo": "+918888888888"
 				}
 			}
 			`,
 			wantStatus: http.StatusCreated,
-			wantBody:   `"customer registered"`,
+			wantBody: `
+			{
+				"id": "01HF3T7ZQK9V5X2M8N4P6R0W1Y",
+				"customerDetails": {
+					"name": "varshil",
+					"address": "udr",
+					"contactNo": "+918888888888"
+				},
+				"version": 1
+			}
+			`,
+			wantLocation: "/api/customers/01HF3T7ZQK9V5X2M8N4P6R0W1Y",
+			wantETag:     `"1"`,
 		},
 	}
 
@@ -146,7 +264,7 @@ func TestCustomerHandler_createCustomer(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := NewInMemoryRepo()
 			repo.customers = tt.fields.customers
-			service := NewService(repo)
+			service := NewService(repo, WithIdGenerator(fixedId(tt.generatedId)))
 			transport := NewCustomerHandler(service)
 
 			body := strings.NewReader(tt.reqbody)
@@ -155,7 +273,11 @@ func TestCustomerHandler_createCustomer(t *testing.T) {
 
 			transport.createCustomer(w, r)
 
-			assert.JSONEq(t, tt.wantBody, w.Body.String(), "expect body to be same")
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
+
+			assert.Equal(t, tt.wantLocation, w.Header().Get("Location"), "expect location to be same")
+
+			assert.Equal(t, tt.wantETag, w.Header().Get("ETag"), "expect etag to be same")
 
 			if w.Code != tt.wantStatus {
 				t.Errorf("want status :%d got status %d", tt.wantStatus, w.Code)
@@ -173,8 +295,10 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 		name     string
 		fields   fields
 		reqBody  string
+		ifMatch  string
 		wantBody string
 		wantCode int
+		wantETag string
 	}{
 		{
 			name: "invalid json body",
@@ -190,7 +314,7 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 				"contactNo":9649127059
 			}
 		}`,
-			wantBody: `"invalid json body"`,
+			wantBody: `{"type": "/problems/invalid_body", "title": "invalid json body", "status": 400, "code": "invalid_body", "detail": "invalid json body: invalid character '\\n' in string"}`,
 			wantCode: http.StatusBadRequest,
 		},
 		{
@@ -207,7 +331,7 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 				"contactNo":9649127059
 			}
 		}`,
-			wantBody: `"invalid id"`,
+			wantBody: `{"type": "/problems/validation_failed", "title": "invalid customer", "status": 400, "code": "validation_failed", "detail": "validation failed: id invalid id", "errors": [{"field": "id", "reason": "invalid id"}]}`,
 			wantCode: http.StatusBadRequest,
 		},
 		{
@@ -224,7 +348,7 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 				"contactNo":96491270
 			}
 		}`,
-			wantBody: `"invalid contact number"`,
+			wantBody: `{"type": "/problems/validation_failed", "title": "invalid customer", "status": 400, "code": "validation_failed", "detail": "validation failed: customerDetails.contactNo invalid contact number", "errors": [{"field": "customerDetails.contactNo", "reason": "invalid contact number"}]}`,
 			wantCode: http.StatusBadRequest,
 		},
 		{
@@ -236,7 +360,7 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 7777777777,
+							ContactNo: "+917777777777",
 						},
 					},
 					{
@@ -244,8 +368,36 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "vastghj",
 							Address:   "udaipur",
-							ContactNo: 9876655547,
+							ContactNo: "+919876655547",
+						},
+					},
+				},
+			},
+			reqBody: `{
+			"id":"hs",
+			"customerDetails":
+			{
+				"name":"hdik",
+				"address":"hsghd",
+				"contactNo":9649127559
+			}
+		}`,
+			wantBody: `"customer details updated"`,
+			wantCode: http.StatusOK,
+			wantETag: `"1"`,
+		},
+		{
+			name: "matching if-match",
+			fields: fields{
+				customers: []Customer{
+					{
+						Id: "hs",
+						CustomerDetails: CustomerDetails{
+							Name:      "hardik",
+							Address:   "udaipur",
+							ContactNo: "+917777777777",
 						},
+						Version: 4,
 					},
 				},
 			},
@@ -258,8 +410,66 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 				"contactNo":9649127559
 			}
 		}`,
+			ifMatch:  `"4"`,
 			wantBody: `"customer details updated"`,
 			wantCode: http.StatusOK,
+			wantETag: `"5"`,
+		},
+		{
+			name: "stale if-match",
+			fields: fields{
+				customers: []Customer{
+					{
+						Id: "hs",
+						CustomerDetails: CustomerDetails{
+							Name:      "hardik",
+							Address:   "udaipur",
+							ContactNo: "+917777777777",
+						},
+						Version: 4,
+					},
+				},
+			},
+			reqBody: `{
+			"id":"hs",
+			"customerDetails":
+			{
+				"name":"hdik",
+				"address":"hsghd",
+				"contactNo":9649127559
+			}
+		}`,
+			ifMatch:  `"3"`,
+			wantBody: `{"type": "/problems/version_conflict", "title": "customer was modified", "status": 412, "code": "version_conflict", "detail": "customer version does not match"}`,
+			wantCode: http.StatusPreconditionFailed,
+		},
+		{
+			name: "weak if-match never matches",
+			fields: fields{
+				customers: []Customer{
+					{
+						Id: "hs",
+						CustomerDetails: CustomerDetails{
+							Name:      "hardik",
+							Address:   "udaipur",
+							ContactNo: "+917777777777",
+						},
+						Version: 4,
+					},
+				},
+			},
+			reqBody: `{
+			"id":"hs",
+			"customerDetails":
+			{
+				"name":"hdik",
+				"address":"hsghd",
+				"contactNo":9649127559
+			}
+		}`,
+			ifMatch:  `W/"4"`,
+			wantBody: `{"type": "/problems/version_conflict", "title": "customer was modified", "status": 412, "code": "version_conflict", "detail": "customer version does not match"}`,
+			wantCode: http.StatusPreconditionFailed,
 		},
 		{
 			name: "updating non existing customer",
@@ -270,7 +480,7 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 7777777777,
+							ContactNo: "+917777777777",
 						},
 					},
 					{
@@ -278,7 +488,7 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "vastghj",
 							Address:   "udaipur",
-							ContactNo: 9876655547,
+							ContactNo: "+919876655547",
 						},
 					},
 				},
@@ -292,14 +502,14 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 				"contactNo":9649127559
 			}
 		}`,
-			wantBody: `"customer not found"`,
+			wantBody: `{"type": "/problems/not_found", "title": "customer not found", "status": 404, "code": "not_found"}`,
 			wantCode: http.StatusNotFound,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := &InMemoryRepo{tt.fields.customers}
+			repo := &InMemoryRepo{customers: tt.fields.customers}
 			service := NewService(repo)
 			transport := NewCustomerHandler(service)
 			handle := registerRoutes(transport)
@@ -307,18 +517,167 @@ func TestCustomerHandler_updateCustomer(t *testing.T) {
 			body := strings.NewReader(tt.reqBody)
 
 			r := httptest.NewRequest("PUT", "/api/customers", body)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
 			w := httptest.NewRecorder()
 
 			handle.ServeHTTP(w, r)
 
-			assert.JSONEq(t, tt.wantBody, w.Body.String(), "expect body to be same")
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
+
+			assert.Equal(t, tt.wantCode, w.Code, "expect status code to be same")
+
+			assert.Equal(t, tt.wantETag, w.Header().Get("ETag"), "expect etag to be same")
+		})
+	}
+}
+
+func TestCustomerHandler_patchCustomer(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		ifMatch     string
+		reqBody     string
+		wantBody    string
+		wantCode    int
+		wantETag    string
+	}{
+		{
+			name:        "merge patch",
+			path:        "/api/customers/hs",
+			contentType: "application/merge-patch+json",
+			reqBody:     `{"customerDetails": {"address": "jaipur"}}`,
+			wantBody:    `{"id": "hs", "customerDetails": {"name": "hardik", "address": "jaipur", "contactNo": "+917777777777"}, "version": 2}`,
+			wantCode:    http.StatusOK,
+			wantETag:    `"2"`,
+		},
+		{
+			name:        "json patch with charset",
+			path:        "/api/customers/hs",
+			contentType: "application/json-patch+json; charset=utf-8",
+			ifMatch:     `"1"`,
+			reqBody:     `[{"op": "replace", "path": "/customerDetails/name", "value": "varshil"}]`,
+			wantBody:    `{"id": "hs", "customerDetails": {"name": "varshil", "address": "udaipur", "contactNo": "+917777777777"}, "version": 2}`,
+			wantCode:    http.StatusOK,
+			wantETag:    `"2"`,
+		},
+		{
+			name:        "plain json",
+			path:        "/api/customers/hs",
+			contentType: "application/json",
+			reqBody:     `{"customerDetails": {"address": "jaipur"}}`,
+			wantBody:    `{"type": "/problems/unsupported_patch_format", "title": "unsupported patch format", "status": 415, "code": "unsupported_patch_format", "detail": "unsupported patch format \"application/json\""}`,
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "malformed patch",
+			path:        "/api/customers/hs",
+			contentType: "application/merge-patch+json",
+			reqBody:     `{"customerDetails": `,
+			wantBody:    `{"type": "/problems/invalid_patch", "title": "invalid patch", "status": 400, "code": "invalid_patch"}`,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "removing a field",
+			path:        "/api/customers/hs",
+			contentType: "application/merge-patch+json",
+			reqBody:     `{"customerDetails": {"name": null}}`,
+			wantBody:    `{"type": "/problems/invalid_patch", "title": "invalid patch", "status": 400, "code": "invalid_patch"}`,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "invalid contact number",
+			path:        "/api/customers/hs",
+			contentType: "application/merge-patch+json",
+			reqBody:     `{"customerDetails": {"contactNo": 123}}`,
+			wantBody:    `{"type": "/problems/validation_failed", "title": "invalid customer", "status": 400, "code": "validation_failed", "detail": "validation failed: customerDetails.contactNo invalid contact number", "errors": [{"field": "customerDetails.contactNo", "reason": "invalid contact number"}]}`,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "failing test operation",
+			path:        "/api/customers/hs",
+			contentType: "application/json-patch+json",
+			reqBody:     `[{"op": "test", "path": "/customerDetails/name", "value": "varshil"}]`,
+			wantBody:    `{"type": "/problems/patch_test_failed", "title": "patch test failed", "status": 409, "code": "patch_test_failed"}`,
+			wantCode:    http.StatusConflict,
+		},
+		{
+			name:        "stale if-match",
+			path:        "/api/customers/hs",
+			contentType: "application/merge-patch+json",
+			ifMatch:     `"4"`,
+			reqBody:     `{"customerDetails": {"address": "jaipur"}}`,
+			wantBody:    `{"type": "/problems/version_conflict", "title": "customer was modified", "status": 412, "code": "version_conflict", "detail": "customer version does not match"}`,
+			wantCode:    http.StatusPreconditionFailed,
+		},
+		{
+			name:        "non existing customer",
+			path:        "/api/customers/vs",
+			contentType: "application/merge-patch+json",
+			reqBody:     `{"customerDetails": {"address": "jaipur"}}`,
+			wantBody:    `{"type": "/problems/not_found", "title": "customer not found", "status": 404, "code": "not_found"}`,
+			wantCode:    http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &InMemoryRepo{customers: []Customer{
+				{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}, Version: 1},
+			}}
+			handler := registerRoutes(NewCustomerHandler(NewService(repo)))
+
+			r := httptest.NewRequest("PATCH", tt.path, strings.NewReader(tt.reqBody))
+			r.Header.Set("Content-Type", tt.contentType)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
 
 			assert.Equal(t, tt.wantCode, w.Code, "expect status code to be same")
+
+			assert.Equal(t, tt.wantETag, w.Header().Get("ETag"), "expect etag to be same")
 		})
 	}
 }
 
-func TestCustomerHandler_getAllCustomer(t *testing.T) {
+var listFixture = []Customer{
+	{
+		Id: "hs",
+		CustomerDetails: CustomerDetails{
+			Name:      "hardik",
+			Address:   "udaipur",
+			ContactNo: "+917777777777",
+		},
+		Version: 1,
+	},
+	{
+		Id: "vs",
+		CustomerDetails: CustomerDetails{
+			Name:      "varshil",
+			Address:   "udaipur",
+			ContactNo: "+916666666666",
+		},
+		Version: 1,
+	},
+	{
+		Id: "ps",
+		CustomerDetails: CustomerDetails{
+			Name:      "paramveer",
+			Address:   "jaipur",
+			ContactNo: "+915555555555",
+		},
+		Version: 1,
+	},
+}
+
+func TestCustomerHandler_listCustomers(t *testing.T) {
 	type fields struct {
 		customers []Customer
 	}
@@ -326,6 +685,7 @@ func TestCustomerHandler_getAllCustomer(t *testing.T) {
 	tests := []struct {
 		name     string
 		fields   fields
+		path     string
 		wantBody string
 		wantCode int
 	}{
@@ -338,53 +698,60 @@ func TestCustomerHandler_getAllCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 7777777777,
+							ContactNo: "+917777777777",
 						},
+						Version: 1,
 					},
 					{
 						Id: "vs",
 						CustomerDetails: CustomerDetails{
 							Name:      "varshil",
 							Address:   "udaipur",
-							ContactNo: 6666666666,
+							ContactNo: "+916666666666",
 						},
+						Version: 1,
 					},
 					{
 						Id: "ps",
 						CustomerDetails: CustomerDetails{
 							Name:      "paramveer",
 							Address:   "udaipur",
-							ContactNo: 5555555555,
+							ContactNo: "+915555555555",
 						},
+						Version: 1,
 					},
 				},
 			},
-			wantBody: `[
+			path: "/api/customers",
+			wantBody: `{"customers": [
 				{
 					"id": "hs",
 					"customerDetails": {
 						"name": "hardik",
 						"address": "udaipur",
-						"contactNo": 7777777777
-					}
+						"contactNo": "+917777777777"
+					},
+					"version": 1
 				},
 				{
-					"id": "vs",
+					"id": "ps",
 					"customerDetails": {
-						"name": "varshil",
+						"name": "paramveer",
 						"address": "udaipur",
-						"contactNo": 6666666666
-					}
+						"contactNo": "+915555555555"
+					},
+					"version": 1
 				},
 				{
-					"id": "ps",
+					"id": "vs",
 					"customerDetails": {
-						"name": "paramveer",
+						"name": "varshil",
 						"address": "udaipur",
-						"contactNo": 5555555555
-					}
+						"contactNo": "+916666666666"
+					},
+					"version": 1
 				}
-			]`,
+			]}`,
 			wantCode: http.StatusOK,
 		},
 		{
@@ -392,9 +759,87 @@ func TestCustomerHandler_getAllCustomer(t *testing.T) {
 			fields: fields{
 				customers: []Customer{},
 			},
-			wantBody: `[]`,
+			path:     "/api/customers",
+			wantBody: `{"customers": []}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "first page sorted by name descending",
+			fields: fields{
+				customers: listFixture,
+			},
+			path: "/api/customers?limit=2&sort=-name",
+			wantBody: `{
+				"customers": [
+					{"id": "vs", "customerDetails": {"name": "varshil", "address": "udaipur", "contactNo": "+916666666666"}, "version": 1},
+					{"id": "ps", "customerDetails": {"name": "paramveer", "address": "jaipur", "contactNo": "+915555555555"}, "version": 1}
+				],
+				"next": "` + encodeCursor(ListOptions{SortBy: "name", Descending: true}, listFixture[2]) + `"
+			}`,
 			wantCode: http.StatusOK,
 		},
+		{
+			name: "second page sorted by name descending",
+			fields: fields{
+				customers: listFixture,
+			},
+			path: "/api/customers?limit=2&sort=-name&cursor=" + encodeCursor(ListOptions{SortBy: "name", Descending: true}, listFixture[2]),
+			wantBody: `{
+				"customers": [
+					{"id": "hs", "customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}, "version": 1}
+				]
+			}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "filtered by address and contact number",
+			fields: fields{
+				customers: listFixture,
+			},
+			path: "/api/customers?address=UDAI&contactNo=7777777777",
+			wantBody: `{
+				"customers": [
+					{"id": "hs", "customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}, "version": 1}
+				]
+			}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "cursor issued for another sort",
+			fields: fields{
+				customers: listFixture,
+			},
+			path:     "/api/customers?sort=address&cursor=" + encodeCursor(ListOptions{SortBy: "name"}, listFixture[0]),
+			wantBody: `{"type": "/problems/invalid_cursor", "title": "invalid cursor", "status": 400, "code": "invalid_cursor", "errors": [{"field": "cursor", "reason": "invalid cursor"}]}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "unknown sort field",
+			fields: fields{
+				customers: listFixture,
+			},
+			path:     "/api/customers?sort=city",
+			wantBody: `{"type": "/problems/invalid_query", "title": "invalid query parameters", "status": 400, "code": "invalid_query", "detail": "invalid list options"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "limit too large",
+			fields: fields{
+				customers: listFixture,
+			},
+			path:     "/api/customers?limit=1000",
+			wantBody: `{"type": "/problems/invalid_query", "title": "invalid query parameters", "status": 400, "code": "invalid_query", "detail": "invalid list options"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "invalid contact number filter",
+			fields: fields{
+				customers: listFixture,
+			},
+			path:     "/api/customers?contactNo=call-me",
+			wantBody: `{"type": "/problems/invalid_query", "title": "invalid query parameters", "status": 400, "code": "invalid_query", "detail": "invalid list options"}`,
+			wantCode: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -406,34 +851,96 @@ func TestCustomerHandler_getAllCustomer(t *testing.T) {
 
 			w := httptest.NewRecorder()
 
-			handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/customers", nil))
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
 
-			assert.JSONEq(t, tt.wantBody, w.Body.String(), "expect body to be same")
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
 
 			assert.Equal(t, tt.wantCode, w.Code, "expect status code to be same")
 		})
 	}
 }
 
-func TestCustomerHandler_getCustomerById(t *testing.T) {
-	type fields struct {
-		customers []Customer
+func TestCustomerHandler_searchCustomers(t *testing.T) {
+	customers := []Customer{
+		{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}, Version: 1},
+		{Id: "vs", CustomerDetails: CustomerDetails{Name: "varshil", Address: "jaipur", ContactNo: "+916666666666"}, Version: 1},
 	}
 
 	tests := []struct {
 		name     string
-		fields   fields
 		path     string
 		wantBody string
 		wantCode int
 	}{
 		{
-			name: "invalid id",
-			fields: fields{
-				customers: []Customer{},
-			},
-			path:     "/api/customers/hss",
-			wantBody: `"invalid id"`,
+			name: "matching customers",
+			path: "/api/customers/search?q=hard",
+			wantBody: `{"hits": [
+				{
+					"customer": {"id": "hs", "customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}, "version": 1},
+					"score": 0.8,
+					"highlights": {"customerDetails.name": [{"text": "hard", "match": true}, {"text": "ik"}]}
+				}
+			]}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "nothing found",
+			path:     "/api/customers/search?q=mumbai",
+			wantBody: `{"hits": []}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "missing query",
+			path:     "/api/customers/search",
+			wantBody: `{"type": "/problems/invalid_query", "title": "invalid query parameters", "status": 400, "code": "invalid_query", "detail": "invalid search: q is required"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid limit",
+			path:     "/api/customers/search?q=hard&limit=many",
+			wantBody: `{"type": "/problems/invalid_query", "title": "invalid query parameters", "status": 400, "code": "invalid_query", "detail": "invalid search: limit must be a number"}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &InMemoryRepo{customers: customers}
+			service := NewService(repo)
+			handler := registerRoutes(NewCustomerHandler(service))
+
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
+
+			assert.Equal(t, tt.wantCode, w.Code, "expect status code to be same")
+		})
+	}
+}
+
+func TestCustomerHandler_getCustomerById(t *testing.T) {
+	type fields struct {
+		customers []Customer
+	}
+
+	tests := []struct {
+		name     string
+		fields   fields
+		path     string
+		wantBody string
+		wantCode int
+		wantETag string
+	}{
+		{
+			name: "invalid id",
+			fields: fields{
+				customers: []Customer{},
+			},
+			path:     "/api/customers/hss",
+			wantBody: `{"type": "/problems/invalid_id", "title": "invalid id", "status": 400, "code": "invalid_id", "errors": [{"field": "id", "reason": "invalid id"}]}`,
 			wantCode: http.StatusBadRequest,
 		},
 		{
@@ -445,7 +952,7 @@ func TestCustomerHandler_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hshsj",
 							Address:   "udr",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 					{
@@ -453,13 +960,13 @@ func TestCustomerHandler_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hshsnjmj",
 							Address:   "jaiop",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 				},
 			},
 			path:     "/api/customers/hs",
-			wantBody: `"customer not found"`,
+			wantBody: `{"type": "/problems/not_found", "title": "customer not found", "status": 404, "code": "not_found"}`,
 			wantCode: http.StatusNotFound,
 		},
 		{
@@ -471,7 +978,7 @@ func TestCustomerHandler_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hshsj",
 							Address:   "udr",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 					{
@@ -479,8 +986,9 @@ func TestCustomerHandler_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "vashil",
 							Address:   "udaipur",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
+						Version: 7,
 					},
 				},
 			},
@@ -489,16 +997,17 @@ func TestCustomerHandler_getCustomerById(t *testing.T) {
 			{
 				"name": "vashil",
 				"address": "udaipur",
-				"contactNo": 9999999999
+				"contactNo": "+919999999999"
 			}
 			`,
 			wantCode: http.StatusOK,
+			wantETag: `"7"`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := &InMemoryRepo{tt.fields.customers}
+			repo := &InMemoryRepo{customers: tt.fields.customers}
 			service := NewService(repo)
 			transport := NewCustomerHandler(service)
 			handler := registerRoutes(transport)
@@ -507,9 +1016,11 @@ func TestCustomerHandler_getCustomerById(t *testing.T) {
 
 			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
 
-			assert.JSONEq(t, tt.wantBody, w.Body.String(), "expect body to be same")
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
 
 			assert.Equal(t, tt.wantCode, w.Code, "want status code to be same")
+
+			assert.Equal(t, tt.wantETag, w.Header().Get("ETag"), "want etag to be same")
 		})
 	}
 }
@@ -523,6 +1034,7 @@ func TestCustomerHandler_deleteCustomer(t *testing.T) {
 		name     string
 		fields   fields
 		path     string
+		ifMatch  string
 		wantBody string
 		wantCode int
 	}{
@@ -532,7 +1044,7 @@ func TestCustomerHandler_deleteCustomer(t *testing.T) {
 				customers: []Customer{},
 			},
 			path:     "/api/customers/hsss",
-			wantBody: `"invalid id"`,
+			wantBody: `{"type": "/problems/invalid_id", "title": "invalid id", "status": 400, "code": "invalid_id", "errors": [{"field": "id", "reason": "invalid id"}]}`,
 			wantCode: http.StatusBadRequest,
 		},
 		{
@@ -544,13 +1056,13 @@ func TestCustomerHandler_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "uadipur",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 				},
 			},
 			path:     "/api/customers/js",
-			wantBody: `"customer not found"`,
+			wantBody: `{"type": "/problems/not_found", "title": "customer not found", "status": 404, "code": "not_found"}`,
 			wantCode: http.StatusNotFound,
 		},
 		{
@@ -562,7 +1074,7 @@ func TestCustomerHandler_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "uadipur",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 					{
@@ -570,7 +1082,7 @@ func TestCustomerHandler_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "jdskik",
 							Address:   "uadopksipur",
-							ContactNo: 9888889999,
+							ContactNo: "+919888889999",
 						},
 					},
 				},
@@ -579,113 +1091,592 @@ func TestCustomerHandler_deleteCustomer(t *testing.T) {
 			wantBody: `"customer deleted"`,
 			wantCode: http.StatusOK,
 		},
+		{
+			name: "matching if-match",
+			fields: fields{
+				customers: []Customer{
+					{
+						Id: "hs",
+						CustomerDetails: CustomerDetails{
+							Name:      "hardik",
+							Address:   "uadipur",
+							ContactNo: "+919999999999",
+						},
+						Version: 2,
+					},
+				},
+			},
+			path:     "/api/customers/hs",
+			ifMatch:  `"2"`,
+			wantBody: `"customer deleted"`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "stale if-match",
+			fields: fields{
+				customers: []Customer{
+					{
+						Id: "hs",
+						CustomerDetails: CustomerDetails{
+							Name:      "hardik",
+							Address:   "uadipur",
+							ContactNo: "+919999999999",
+						},
+						Version: 2,
+					},
+				},
+			},
+			path:     "/api/customers/hs",
+			ifMatch:  `"1"`,
+			wantBody: `{"type": "/problems/version_conflict", "title": "customer was modified", "status": 412, "code": "version_conflict", "detail": "customer version does not match"}`,
+			wantCode: http.StatusPreconditionFailed,
+		},
 		{
 			name: "empty databasde",
 			fields: fields{
 				customers: []Customer{},
 			},
 			path:     "/api/customers/hs",
-			wantBody: `"customer not found"`,
+			wantBody: `{"type": "/problems/not_found", "title": "customer not found", "status": 404, "code": "not_found"}`,
 			wantCode: http.StatusNotFound,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := &InMemoryRepo{tt.fields.customers}
+			repo := &InMemoryRepo{customers: tt.fields.customers}
 			service := NewService(repo)
 			transport := NewCustomerHandler(service)
 			handler := registerRoutes(transport)
 
 			w := httptest.NewRecorder()
 
-			handler.ServeHTTP(w, httptest.NewRequest("DELETE", tt.path, nil))
+			r := httptest.NewRequest("DELETE", tt.path, nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
 
-			assert.JSONEq(t, tt.wantBody, w.Body.String(), "expect body to be same")
+			handler.ServeHTTP(w, r)
+
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
 
 			assert.Equal(t, tt.wantCode, w.Code, "expect status code to be same")
 		})
 	}
 }
 
-func TestCustomerHandler_WSCreateCustomer(t *testing.T) {
+func TestCustomerHandler_listTrash(t *testing.T) {
 	repo := &InMemoryRepo{
 		customers: []Customer{
-			{
-				Id: "hs",
-				CustomerDetails: CustomerDetails{
-					Name:      "hardik",
-					Address:   "udr",
-					ContactNo: 8888888888,
+			{Id: "vs", CustomerDetails: CustomerDetails{Name: "varshil", Address: "jaipur", ContactNo: "+916666666666"}, Version: 1},
+		},
+		trashed: []Customer{
+			trashed(Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}, Version: 2}, conformanceDeletedAt),
+		},
+	}
+	handler := registerRoutes(NewCustomerHandler(NewService(repo)))
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/customers/trash", nil))
+
+	assert.JSONEq(t, `{"customers": [
+		{"id": "hs", "customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}, "version": 2, "deletedAt": "2023-11-20T10:00:00Z"}
+	]}`, w.Body.String(), "expect body to be same")
+
+	assert.Equal(t, http.StatusOK, w.Code, "expect status code to be same")
+}
+
+func TestCustomerHandler_purgeTrash(t *testing.T) {
+	repo := &InMemoryRepo{
+		trashed: []Customer{
+			trashed(Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}, Version: 2}, conformanceDeletedAt),
+			trashed(Customer{Id: "vs", CustomerDetails: CustomerDetails{Name: "varshil", Address: "jaipur", ContactNo: "+916666666666"}, Version: 1}, conformanceDeletedAt.Add(48*time.Hour)),
+		},
+	}
+	service := NewService(repo, WithClock(func() time.Time { return conformanceDeletedAt.Add(72 * time.Hour) }), WithTrashPurge(36*time.Hour, 0))
+	handler := registerRoutes(NewCustomerHandler(service))
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/customers/trash/purge", nil))
+
+	assert.JSONEq(t, `{"purged": 1}`, w.Body.String(), "expect only customers deleted before the retention to be purged")
+	assert.Equal(t, http.StatusOK, w.Code, "expect status code to be same")
+	if remaining := trashedCustomers(t, repo); assert.Len(t, remaining, 1, "expect one customer to stay in the trash") {
+		assert.Equal(t, "vs", remaining[0].Id, "expect the later customer to stay in the trash")
+	}
+}
+
+func TestCustomerHandler_restoreCustomer(t *testing.T) {
+	customer := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}, Version: 2}
+
+	tests := []struct {
+		name     string
+		path     string
+		ifMatch  string
+		wantBody string
+		wantCode int
+		wantETag string
+	}{
+		{
+			name:     "restoring deleted customer",
+			path:     "/api/customers/trash/hs/restore",
+			wantBody: `{"id": "hs", "customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}, "version": 2}`,
+			wantCode: http.StatusOK,
+			wantETag: `"2"`,
+		},
+		{
+			name:     "restoring a stale version",
+			path:     "/api/customers/trash/hs/restore",
+			ifMatch:  `"1"`,
+			wantBody: `{"type": "/problems/version_conflict", "title": "customer was modified", "status": 412, "code": "version_conflict", "detail": "customer version does not match"}`,
+			wantCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:     "restoring customer that isn't deleted",
+			path:     "/api/customers/trash/vs/restore",
+			wantBody: `{"type": "/problems/not_found", "title": "customer not found", "status": 404, "code": "not_found"}`,
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &InMemoryRepo{
+				customers: []Customer{{Id: "vs", Version: 1}},
+				trashed:   []Customer{trashed(customer, conformanceDeletedAt)},
+			}
+			handler := registerRoutes(NewCustomerHandler(NewService(repo)))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", tt.path, nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			handler.ServeHTTP(w, r)
+
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
+			assert.Equal(t, tt.wantCode, w.Code, "expect status code to be same")
+			assert.Equal(t, tt.wantETag, w.Header().Get("ETag"), "expect etag to be same")
+		})
+	}
+}
+
+// newHistoryHandler serves a customer "hs" that hardik created at
+// conformanceDeletedAt and varshil moved to jaipur an hour later.
+func newHistoryHandler(t *testing.T) http.Handler {
+	service := NewService(NewInMemoryRepo(), WithIdGenerator(fixedId("hs")), WithClock(steppingClock()))
+	t.Cleanup(service.Close)
+	handler := registerRoutes(NewCustomerHandler(service))
+
+	requests := []struct {
+		method, path, actor, body string
+	}{
+		{"POST", "/api/customers", "hardik", `{"customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}}`},
+		{"PUT", "/api/customers", "varshil", `{"id": "hs", "customerDetails": {"name": "hardik", "address": "jaipur", "contactNo": "+917777777777"}}`},
+	}
+	for _, request := range requests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(request.method, request.path, strings.NewReader(request.body))
+		r.Header.Set("X-Actor", request.actor)
+		handler.ServeHTTP(w, r)
+
+		if w.Code >= http.StatusBadRequest {
+			t.Fatalf("%s %s failed: %s", request.method, request.path, w.Body.String())
+		}
+	}
+
+	return handler
+}
+
+func TestCustomerHandler_customerHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantBody string
+		wantCode int
+	}{
+		{
+			name: "revisions with changed fields",
+			path: "/api/customers/hs/history",
+			wantBody: `{"revisions": [
+				{
+					"customerId": "hs", "version": 1, "type": "created", "actor": "hardik", "at": "2023-11-20T10:00:00Z",
+					"newDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"},
+					"changes": [
+						{"field": "customerDetails.name", "new": "hardik"},
+						{"field": "customerDetails.address", "new": "udaipur"},
+						{"field": "customerDetails.contactNo", "new": "+917777777777"}
+					]
 				},
-			},
+				{
+					"customerId": "hs", "version": 2, "type": "updated", "actor": "varshil", "at": "2023-11-20T11:00:00Z",
+					"oldDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"},
+					"newDetails": {"name": "hardik", "address": "jaipur", "contactNo": "+917777777777"},
+					"changes": [{"field": "customerDetails.address", "old": "udaipur", "new": "jaipur"}]
+				}
+			]}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "unknown customer",
+			path:     "/api/customers/vs/history",
+			wantBody: `{"type": "/problems/not_found", "title": "customer not found", "status": 404, "code": "not_found"}`,
+			wantCode: http.StatusNotFound,
 		},
 	}
-	service := NewService(repo)
-	transport := NewCustomerHandler(service)
-	handler := registerRoutes(transport)
 
-	// creating backend server
-	go func() {
-		if err := http.ListenAndServe(":7070", handler); err != nil {
-			t.Logf("connection failed with server: %v", err)
+	handler := newHistoryHandler(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
+			assert.Equal(t, tt.wantCode, w.Code, "expect status code to be same")
+		})
+	}
+}
+
+func TestCustomerHandler_asOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantBody string
+		wantCode int
+		wantETag string
+	}{
+		{
+			name:     "customer as created",
+			path:     "/api/customers/hs?asOf=2023-11-20T10:30:00Z",
+			wantBody: `{"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}`,
+			wantCode: http.StatusOK,
+			wantETag: `"1"`,
+		},
+		{
+			name:     "customer as updated, in another zone",
+			path:     "/api/customers/hs?asOf=2023-11-20T16:30:00%2B05:30",
+			wantBody: `{"name": "hardik", "address": "jaipur", "contactNo": "+917777777777"}`,
+			wantCode: http.StatusOK,
+			wantETag: `"2"`,
+		},
+		{
+			name:     "customer before it was created",
+			path:     "/api/customers/hs?asOf=2023-11-20T09:00:00Z",
+			wantBody: `{"type": "/problems/not_found", "title": "customer not found", "status": 404, "code": "not_found"}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "listing as created",
+			path:     "/api/customers?asOf=2023-11-20T10:00:00Z",
+			wantBody: `{"customers": [{"id": "hs", "customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}, "version": 1}]}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "listing before anything was created",
+			path:     "/api/customers?asOf=2023-11-19T10:00:00Z",
+			wantBody: `{"customers": []}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "invalid time",
+			path:     "/api/customers/hs?asOf=yesterday",
+			wantBody: `{"type": "/problems/invalid_as_of", "title": "invalid asOf", "status": 400, "code": "invalid_as_of", "detail": "invalid asOf: parsing time \"yesterday\" as \"2006-01-02T15:04:05.999999999Z07:00\": cannot parse \"yesterday\" as \"2006\"", "errors": [{"field": "asOf", "reason": "invalid asOf"}]}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	handler := newHistoryHandler(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
+			assert.Equal(t, tt.wantCode, w.Code, "expect status code to be same")
+			assert.Equal(t, tt.wantETag, w.Header().Get("ETag"), "expect etag to be same")
+		})
+	}
+}
+
+// newAuditHandler serves an audit log of hardik creating "hs" through a
+// trusted proxy, varshil failing to update it and then deleting it, an
+// hour apart starting at conformanceDeletedAt.
+func newAuditHandler(t *testing.T) http.Handler {
+	service := NewService(NewInMemoryRepo(), WithIdGenerator(fixedId("hs")), WithClock(steppingClock()))
+	t.Cleanup(service.Close)
+
+	proxies, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := registerRoutes(NewCustomerHandler(service, WithTrustedProxies(proxies)))
+
+	requests := []struct {
+		method, path, actor, requestId, remoteAddr, forwarded, ifMatch, body string
+	}{
+		{"POST", "/api/customers", "hardik", "r1", "10.1.1.1:4000", "198.51.100.4", "", `{"customerDetails": {"name": "hardik", "address": "udaipur", "contactNo": "+917777777777"}}`},
+		{"PUT", "/api/customers", "varshil", "r2", "203.0.113.9:4000", "198.51.100.4", `"7"`, `{"id": "hs", "customerDetails": {"name": "hardik", "address": "jaipur", "contactNo": "+917777777777"}}`},
+		{"DELETE", "/api/customers/hs", "varshil", "r3", "203.0.113.9:4000", "", "", ""},
+	}
+	for _, request := range requests {
+		r := httptest.NewRequest(request.method, request.path, strings.NewReader(request.body))
+		r.RemoteAddr = request.remoteAddr
+		r.Header.Set("X-Actor", request.actor)
+		r.Header.Set("X-Request-Id", request.requestId)
+		if request.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", request.forwarded)
 		}
-	}()
+		if request.ifMatch != "" {
+			r.Header.Set("If-Match", request.ifMatch)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	return handler
+}
+
+func TestCustomerHandler_queryAudit(t *testing.T) {
+	at := func(hours int) time.Time { return conformanceDeletedAt.Add(time.Duration(hours) * time.Hour) }
+	created := AuditEntry{Seq: 1, Tenant: DefaultTenant, At: at(0), Actor: "hardik", SourceIP: "198.51.100.4", RequestId: "r1", Operation: AuditCreate, TargetId: "hs", Outcome: AuditSuccess}
+	conflict := AuditEntry{Seq: 2, Tenant: DefaultTenant, At: at(1), Actor: "varshil", SourceIP: "203.0.113.9", RequestId: "r2", Operation: AuditUpdate, TargetId: "hs", Outcome: AuditFailure, Reason: "version_conflict"}
+	deleted := AuditEntry{Seq: 3, Tenant: DefaultTenant, At: at(2), Actor: "varshil", SourceIP: "203.0.113.9", RequestId: "r3", Operation: AuditDelete, TargetId: "hs", Outcome: AuditSuccess}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantPage AuditPage
+	}{
+		{
+			name:     "everything, newest first",
+			path:     "/api/audit",
+			wantPage: AuditPage{Entries: []AuditEntry{deleted, conflict, created}},
+		},
+		{
+			name:     "by actor and outcome",
+			path:     "/api/audit?actor=varshil&outcome=failure",
+			wantPage: AuditPage{Entries: []AuditEntry{conflict}},
+		},
+		{
+			name:     "by operation and target",
+			path:     "/api/audit?operation=create&targetId=hs",
+			wantPage: AuditPage{Entries: []AuditEntry{created}},
+		},
+		{
+			name:     "by time",
+			path:     "/api/audit?since=2023-11-20T16:30:00%2B05:30&until=2023-11-20T12:00:00Z",
+			wantPage: AuditPage{Entries: []AuditEntry{deleted, conflict}},
+		},
+		{
+			name:     "first page",
+			path:     "/api/audit?limit=2",
+			wantPage: AuditPage{Entries: []AuditEntry{deleted, conflict}, Next: "2"},
+		},
+		{
+			name:     "next page",
+			path:     "/api/audit?limit=2&cursor=2",
+			wantPage: AuditPage{Entries: []AuditEntry{created}},
+		},
+	}
+
+	handler := newAuditHandler(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			var gotPage AuditPage
+			if err := json.Unmarshal(w.Body.Bytes(), &gotPage); err != nil {
+				t.Fatal("invalid response:", err)
+			}
+			for i := range gotPage.Entries {
+				assert.NotEmpty(t, gotPage.Entries[i].Hash, "expect entries to be hashed")
+				gotPage.Entries[i].PrevHash, gotPage.Entries[i].Hash = "", ""
+			}
+
+			assert.Equal(t, tt.wantPage, gotPage, "expect page to be same")
+			assert.Equal(t, http.StatusOK, w.Code, "expect status code to be same")
+		})
+	}
+}
+
+func TestCustomerHandler_queryAudit_invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantBody string
+	}{
+		{
+			name:     "unknown operation",
+			path:     "/api/audit?operation=archive",
+			wantBody: `{"type": "/problems/invalid_query", "title": "invalid query parameters", "status": 400, "code": "invalid_query", "detail": "invalid audit filter: unknown operation \"archive\""}`,
+		},
+		{
+			name:     "invalid time",
+			path:     "/api/audit?since=yesterday",
+			wantBody: `{"type": "/problems/invalid_query", "title": "invalid query parameters", "status": 400, "code": "invalid_query", "detail": "invalid audit filter: since must be an RFC 3339 time"}`,
+		},
+		{
+			name:     "invalid cursor",
+			path:     "/api/audit?cursor=abc",
+			wantBody: `{"type": "/problems/invalid_query", "title": "invalid query parameters", "status": 400, "code": "invalid_query", "detail": "invalid audit filter: invalid cursor"}`,
+		},
+	}
+
+	handler := newAuditHandler(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			assert.JSONEq(t, tt.wantBody, withoutRequestId(t, w.Body.String()), "expect body to be same")
+			assert.Equal(t, http.StatusBadRequest, w.Code, "expect status code to be same")
+		})
+	}
+}
+
+func TestCustomerHandler_verifyAudit(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	newAuditHandler(t).ServeHTTP(w, httptest.NewRequest("GET", "/api/audit/verify", nil))
+
+	assert.JSONEq(t, `{"valid": true, "entries": 3}`, w.Body.String(), "expect body to be same")
+	assert.Equal(t, http.StatusOK, w.Code, "expect status code to be same")
+}
+
+func TestCustomerHandler_debugVars(t *testing.T) {
+	handler := registerRoutes(NewCustomerHandler(NewService(NewInMemoryRepo())))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	assert.JSONEq(t, `{"notifications": {"delivered": 0, "dropped": 0, "coalesced": 0, "disconnected": 0}}`, w.Body.String(), "expect only the notification counters, not the command line")
+	assert.Equal(t, http.StatusOK, w.Code, "expect status code to be same")
+}
+
+func TestCustomerHandler_timeout(t *testing.T) {
+	service := NewService(&blockingRepo{}, WithOperationTimeouts(OperationTimeouts{
+		GetAll: 10 * time.Millisecond,
+	}))
+	handler := registerRoutes(NewCustomerHandler(service))
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/customers", nil))
+
+	assert.JSONEq(t, `{"type": "/problems/timeout", "title": "request timed out", "status": 504, "code": "timeout"}`, withoutRequestId(t, w.Body.String()), "expect body to be same")
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code, "expect status code to be same")
+}
+
+// withoutRequestId drops the request id from a problem response, it is
+// generated per request and can't be pinned in expectations.
+func withoutRequestId(t *testing.T, body string) string {
+	var problem map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &problem); err != nil {
+		return body
+	}
+
+	delete(problem, "requestId")
+	stripped, err := json.Marshal(problem)
+	if err != nil {
+		t.Fatalf("failed to encode body :%q", err)
+	}
+
+	return string(stripped)
+}
+
+// startWebsocketServer serves the routes on a random local port and opens a
+// websocket connection to it.
+func startWebsocketServer(t *testing.T, service *Service, query string) (*httptest.Server, *websocket.Conn) {
+	server := httptest.NewServer(registerRoutes(NewCustomerHandler(service)))
+	t.Cleanup(server.Close)
 
 	//establishing websocket connection
-	conn, wsRes, err := websocket.DefaultDialer.Dial("ws://localhost:7070/ws", nil)
+	conn, wsRes, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws"+query, nil)
 	if err != nil {
 		t.Fatalf("failed to establish websocket connection: %v", err)
 	}
+	t.Cleanup(func() { conn.Close() })
 
 	assert.Equal(t, http.StatusSwitchingProtocols, wsRes.StatusCode, "expected status code to be same")
 
+	return server, conn
+}
+
+func readWebsocketMessage(t *testing.T, conn *websocket.Conn) string {
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set read deadline :%v", err)
+	}
+
+	mt, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read messages :%v", err)
+	}
+
+	assert.Equal(t, websocket.TextMessage, mt, "expected message type to be same")
+
+	return string(message)
+}
+
+func TestCustomerHandler_WSCreateCustomer(t *testing.T) {
+	repo := &InMemoryRepo{
+		customers: []Customer{
+			{
+				Id: "hs",
+				CustomerDetails: CustomerDetails{
+					Name:      "hardik",
+					Address:   "udr",
+					ContactNo: "+918888888888",
+				},
+			},
+		},
+	}
+	service := NewService(repo, WithIdGenerator(fixedId("vs")))
+	server, conn := startWebsocketServer(t, service, "?snapshot=true")
+
+	// the snapshot arriving proves the subscription is in place
+	readWebsocketMessage(t, conn)
+
 	//making http req
 	body := strings.NewReader(`
    {
-	   "id": "vs",
 	   "customerDetails": {
 		   "name": "varshil",
 		   "address": "udr",
-		   "contactNo": 8888888888
+		   "contactNo": "+918888888888"
 	   }
    }
    `)
 
-	resp, err := http.Post("http://localhost:7070/api/customers", "application/json", body)
+	resp, err := http.Post(server.URL+"/api/customers", "application/json", body)
 	if err != nil {
 		t.Fatalf("http request failed :%v", err)
 	}
 
 	assert.Equal(t, http.StatusCreated, resp.StatusCode, "expected status code to be same")
 
-	mt, message, err := conn.ReadMessage()
-	if err != nil {
-		t.Fatalf("failed to read messages :%v", err)
-	}
-
-	assert.Equal(t, websocket.TextMessage, mt, "expected message type to be same")
-
-	wantNotification := `[
-		{
-			"id": "hs",
-			"customerDetails": {
-				"name": "hardik",
-				"address": "udr",
-				"contactNo": 8888888888
-			}
-		},
-		{
+	wantEvent := `{
+		"type": "customer.created",
+		"seq": 1,
+		"customer": {
 			"id": "vs",
 			"customerDetails": {
 				"name": "varshil",
 				"address": "udr",
-				"contactNo": 8888888888
-			}		
+				"contactNo": "+918888888888"
+			},
+			"version": 1
 		}
-		]`
+	}`
 
-	assert.JSONEq(t, wantNotification, string(message), "expecting customer list to be same")
+	assert.JSONEq(t, wantEvent, readWebsocketMessage(t, conn), "expecting event to be same")
 }
 
 func TestCustomerHandler_WSUpdateCustomer(t *testing.T) {
@@ -696,7 +1687,7 @@ func TestCustomerHandler_WSUpdateCustomer(t *testing.T) {
 				CustomerDetails: CustomerDetails{
 					Name:      "hardik",
 					Address:   "udr",
-					ContactNo: 8888888888,
+					ContactNo: "+918888888888",
 				},
 			},
 			{
@@ -704,42 +1695,31 @@ func TestCustomerHandler_WSUpdateCustomer(t *testing.T) {
 				CustomerDetails: CustomerDetails{
 					Name:      "hd",
 					Address:   "udr",
-					ContactNo: 8888888888,
+					ContactNo: "+918888888888",
 				},
 			},
 		},
 	}
 	service := NewService(repo)
-	transport := NewCustomerHandler(service)
-	handler := registerRoutes(transport)
+	server, conn := startWebsocketServer(t, service, "?snapshot=true")
 
-	go func() {
-		if err := http.ListenAndServe(":6060", handler); err != nil {
-			log.Fatalf("server connection failed :%v", err)
-		}
-	}()
+	// the snapshot arriving proves the subscription is in place
+	readWebsocketMessage(t, conn)
 
 	client := &http.Client{}
 
-	conn, wsRes, err := websocket.DefaultDialer.Dial("ws://localhost:6060/ws", nil)
-	if err != nil {
-		t.Fatalf("failed to establish websocket connection :%v", err)
-	}
-
-	assert.Equal(t, http.StatusSwitchingProtocols, wsRes.StatusCode, "expected status code to be same")
-
 	body := strings.NewReader(`
    {
 	   "id": "vs",
 	   "customerDetails": {
 		   "name": "varshil",
 		   "address": "udr",
-		   "contactNo": 8888888888
+		   "contactNo": "+918888888888"
 	   }
    }
    `)
 
-	req, err := http.NewRequest("PUT", "http://localhost:6060/api/customers", body)
+	req, err := http.NewRequest("PUT", server.URL+"/api/customers", body)
 	if err != nil {
 		t.Fatalf("http request failed :%v", err)
 	}
@@ -751,33 +1731,21 @@ func TestCustomerHandler_WSUpdateCustomer(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, resp.StatusCode, "expected status code to be same")
 
-	mt, message, err := conn.ReadMessage()
-	if err != nil {
-		t.Fatalf("failed to read message :%v", err)
-	}
-
-	assert.Equal(t, websocket.TextMessage, mt, "expected message type to be same")
-
-	wantCustomerList := `[
-		{
+	wantEvent := `{
+		"type": "customer.updated",
+		"seq": 1,
+		"customer": {
 			"id": "vs",
 			"customerDetails": {
 				"name": "varshil",
 				"address": "udr",
-				"contactNo": 8888888888
-			}
-		},
-		{
-			"id": "hs",
-			"customerDetails": {
-				"name": "hd",
-				"address": "udr",
-				"contactNo": 8888888888
-			}		
+				"contactNo": "+918888888888"
+			},
+			"version": 1
 		}
-		]`
+	}`
 
-	assert.JSONEq(t, wantCustomerList, string(message), "expected customer list to be same")
+	assert.JSONEq(t, wantEvent, readWebsocketMessage(t, conn), "expected event to be same")
 }
 
 func TestCustomerHandler_WSDeleteCustomer(t *testing.T) {
@@ -788,39 +1756,30 @@ func TestCustomerHandler_WSDeleteCustomer(t *testing.T) {
 				CustomerDetails: CustomerDetails{
 					Name:      "varshil",
 					Address:   "udr",
-					ContactNo: 8888888888,
+					ContactNo: "+918888888888",
 				},
+				Version: 1,
 			},
 			{
 				Id: "hs",
 				CustomerDetails: CustomerDetails{
 					Name:      "hardik",
 					Address:   "udr",
-					ContactNo: 8888888888,
+					ContactNo: "+918888888888",
 				},
+				Version: 1,
 			},
 		},
 	}
-	service := NewService(repo)
-	transport := NewCustomerHandler(service)
-	handler := registerRoutes(transport)
+	service := NewService(repo, WithClock(func() time.Time { return conformanceDeletedAt }))
+	server, conn := startWebsocketServer(t, service, "?snapshot=true")
 
-	go func() {
-		if err := http.ListenAndServe(":5050", handler); err != nil {
-			t.Logf("server connection failed :%v", err)
-		}
-	}()
+	// the snapshot arriving proves the subscription is in place
+	readWebsocketMessage(t, conn)
 
 	client := http.Client{}
 
-	conn, wsRes, err := websocket.DefaultDialer.Dial("ws://localhost:5050/ws", nil)
-	if err != nil {
-		t.Fatalf("failed to establish websocket connection :%v", err)
-	}
-
-	assert.Equal(t, http.StatusSwitchingProtocols, wsRes.StatusCode, "expected status code to be same")
-
-	req, err := http.NewRequest("DELETE", "http://localhost:5050/api/customers/vs", nil)
+	req, err := http.NewRequest("DELETE", server.URL+"/api/customers/vs", nil)
 	if err != nil {
 		t.Fatalf("http request failed :%v", err)
 	}
@@ -832,23 +1791,133 @@ func TestCustomerHandler_WSDeleteCustomer(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, resp.StatusCode, "expected status code to be same")
 
-	mt, message, err := conn.ReadMessage()
-	if err != nil {
-		t.Fatalf("failed to read message :%v", err)
-	}
+	wantEvent := `{
+		"type": "customer.deleted",
+		"seq": 1,
+		"customer": {
+			"id": "vs",
+			"customerDetails": {
+				"name": "varshil",
+				"address": "udr",
+				"contactNo": "+918888888888"
+			},
+			"version": 1,
+			"deletedAt": "2023-11-20T10:00:00Z"
+		}
+	}`
 
-	assert.Equal(t, websocket.TextMessage, mt, "expected message type to be same")
+	assert.JSONEq(t, wantEvent, readWebsocketMessage(t, conn), "expected event to be same")
+}
+
+func TestCustomerHandler_WSSnapshot(t *testing.T) {
+	repo := &InMemoryRepo{
+		customers: []Customer{
+			{
+				Id: "hs",
+				CustomerDetails: CustomerDetails{
+					Name:      "hardik",
+					Address:   "udr",
+					ContactNo: "+918888888888",
+				},
+				Version: 1,
+			},
+		},
+	}
+	service := NewService(repo)
+	_, conn := startWebsocketServer(t, service, "?snapshot=true")
 
-	wantCustomerList := `[
-		    {
+	wantSnapshot := `{
+		"type": "snapshot",
+		"seq": 0,
+		"customers": [
+			{
 				"id": "hs",
 				"customerDetails": {
 					"name": "hardik",
 					"address": "udr",
-					"contactNo":8888888888
-				}
+					"contactNo": "+918888888888"
+				},
+				"version": 1
 			}
-		]`
+		]
+	}`
+
+	assert.JSONEq(t, wantSnapshot, readWebsocketMessage(t, conn), "expected snapshot to be same")
+}
+
+func TestCustomerHandler_WSResume(t *testing.T) {
+	service := NewService(NewInMemoryRepo(), WithIdGenerator(fixedId("vs")), WithClock(func() time.Time { return conformanceDeletedAt }))
+	server, conn := startWebsocketServer(t, service, "?snapshot=true")
+	readWebsocketMessage(t, conn)
+
+	body := `{"customerDetails": {"name": "varshil", "address": "udr", "contactNo": "+918888888888"}}`
+	if _, err := http.Post(server.URL+"/api/customers", "application/json", strings.NewReader(body)); err != nil {
+		t.Fatalf("http request failed :%v", err)
+	}
+	readWebsocketMessage(t, conn)
+	conn.Close()
+
+	req, _ := http.NewRequest("DELETE", server.URL+"/api/customers/vs", nil)
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("http request failed :%v", err)
+	}
+
+	resumed, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?since=1", nil)
+	if err != nil {
+		t.Fatalf("failed to resume websocket connection: %v", err)
+	}
+	defer resumed.Close()
+
+	wantEvent := `{
+		"type": "customer.deleted",
+		"seq": 2,
+		"customer": {
+			"id": "vs",
+			"customerDetails": {
+				"name": "varshil",
+				"address": "udr",
+				"contactNo": "+918888888888"
+			},
+			"version": 1,
+			"deletedAt": "2023-11-20T10:00:00Z"
+		}
+	}`
+
+	assert.JSONEq(t, wantEvent, readWebsocketMessage(t, resumed), "expected missed event to be replayed")
+}
+
+func TestCustomerHandler_WSWriteFailure(t *testing.T) {
+	service := NewService(NewInMemoryRepo())
+	defer service.Close()
+
+	limits := DefaultWebsocketLimits
+	limits.WriteTimeout = time.Nanosecond
+	server := httptest.NewServer(registerRoutes(NewCustomerHandler(service, WithWebsocketLimits(limits))))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?snapshot=true", nil)
+	if err != nil {
+		t.Fatalf("failed to establish websocket connection: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set read deadline :%v", err)
+	}
+	_, _, err = conn.ReadMessage()
+	assert.Error(t, err, "expected connection to be closed after the failed write")
+
+	assert.Eventually(t, func() bool { return len(service.subscribers()) == 0 }, 5*time.Second, 10*time.Millisecond, "expected client to be unsubscribed")
+}
+
+func TestCustomerHandler_WSInvalidSince(t *testing.T) {
+	handler := registerRoutes(NewCustomerHandler(NewService(NewInMemoryRepo())))
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/ws?since=latest", nil))
+
+	assert.JSONEq(t, `{"type": "/problems/invalid_since", "title": "invalid since", "status": 400, "code": "invalid_since", "detail": "invalid since: strconv.ParseUint: parsing \"latest\": invalid syntax", "errors": [{"field": "since", "reason": "invalid since"}]}`, withoutRequestId(t, w.Body.String()), "expect body to be same")
 
-	assert.JSONEq(t, wantCustomerList, string(message), "expected customer list to be same")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "expect status code to be same")
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// IdGenerator produces a new, globally unique customer id.
+type IdGenerator func() (string, error)
+
+// idGenerators lists the id schemes that can be selected by name.
+var idGenerators = map[string]IdGenerator{
+	"uuidv7": NewUUIDv7,
+	"ulid":   NewULID,
+}
+
+func idGeneratorByName(name string) (IdGenerator, error) {
+	generator, ok := idGenerators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown id scheme %q", name)
+	}
+
+	return generator, nil
+}
+
+// timestampedId returns 16 bytes whose first 48 bits are the current unix
+// time in milliseconds and whose remaining bits are random.
+func timestampedId() ([16]byte, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[6:]); err != nil {
+		return id, err
+	}
+
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(id[:6], ms[2:])
+
+	return id, nil
+}
+
+// NewUUIDv7 generates a time ordered UUID as described in RFC 9562.
+func NewUUIDv7() (string, error) {
+	id, err := timestampedId()
+	if err != nil {
+		return "", err
+	}
+
+	id[6] = (id[6] & 0x0f) | 0x70
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+
+	return string(buf), nil
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID generates a lexicographically sortable identifier encoded in
+// Crockford's base32.
+func NewULID() (string, error) {
+	id, err := timestampedId()
+	if err != nil {
+		return "", err
+	}
+
+	// 128 bits are written as 26 characters of 5 bits, the first character
+	// only carrying the top 3 bits.
+	buf := make([]byte, 26)
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(buf), nil
+}
+
+func isLegacyId(id string) bool {
+	return len(id) == 2
+}
+
+func isUUID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+
+	for i, c := range id {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdef", c) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func isULID(id string) bool {
+	if len(id) != 26 || id[0] > '7' {
+		return false
+	}
+
+	for _, c := range id {
+		if !strings.ContainsRune(crockfordAlphabet, c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUUIDv7(t *testing.T) {
+	before := time.Now().UnixMilli()
+	id, err := NewUUIDv7()
+	if err != nil {
+		t.Fatalf("failed to generate id :%v", err)
+	}
+
+	assert.True(t, isUUID(id), "expected %q to be a uuid", id)
+	assert.Equal(t, byte('7'), id[14], "expected version 7")
+	assert.Contains(t, "89ab", string(id[19]), "expected RFC 9562 variant")
+
+	var ms int64
+	for _, c := range id[:8] + id[9:13] {
+		ms = ms<<4 | int64(hexValue(byte(c)))
+	}
+	assert.GreaterOrEqual(t, ms, before, "expected id to carry the generation time")
+
+	other, _ := NewUUIDv7()
+	assert.NotEqual(t, id, other, "expected ids to be unique")
+}
+
+func hexValue(c byte) byte {
+	if c >= 'a' {
+		return c - 'a' + 10
+	}
+	return c - '0'
+}
+
+func TestNewULID(t *testing.T) {
+	first, err := NewULID()
+	if err != nil {
+		t.Fatalf("failed to generate id :%v", err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	second, _ := NewULID()
+
+	assert.True(t, isULID(first), "expected %q to be a ulid", first)
+	assert.True(t, isULID(second), "expected %q to be a ulid", second)
+	assert.Less(t, first, second, "expected ulids to sort by creation time")
+}
+
+func Test_idGeneratorByName(t *testing.T) {
+	for _, name := range []string{"uuidv7", "ulid"} {
+		generator, err := idGeneratorByName(name)
+		assert.NoError(t, err, "expected %s to be known", name)
+
+		id, err := generator()
+		assert.NoError(t, err, "expected %s generator to work", name)
+		assert.NoError(t, validateId(id), "expected generated id to be accepted")
+	}
+
+	_, err := idGeneratorByName("serial")
+	assert.Error(t, err, "expected unknown scheme to be rejected")
+}
+
+func Test_validateId(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+	}{
+		{name: "legacy two character id", id: "hs", wantErr: nil},
+		{name: "uuid", id: "0192a5e4-1f3c-7b2a-9d4e-5f6a7b8c9d0e", wantErr: nil},
+		{name: "ulid", id: "01HF3T7ZQK9V5X2M8N4P6R0W1Y", wantErr: nil},
+		{name: "three characters", id: "hss", wantErr: ErrInvalidId},
+		{name: "uppercase uuid", id: "0192A5E4-1F3C-7B2A-9D4E-5F6A7B8C9D0E", wantErr: ErrInvalidId},
+		{name: "ulid overflowing 128 bits", id: "81HF3T7ZQK9V5X2M8N4P6R0W1Y", wantErr: ErrInvalidId},
+		{name: "ulid with excluded letter", id: "01HF3T7ZQK9V5X2M8N4P6R0W1U", wantErr: ErrInvalidId},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, validateId(tt.id), tt.wantErr, "expected error to be same")
+		})
+	}
+}
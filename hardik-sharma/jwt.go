@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is how far the clocks of token issuers may drift.
+const jwtLeeway = 30 * time.Second
+
+// jwk is one key of a JWKS file, RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type verificationKey struct {
+	kid    string
+	alg    string
+	secret []byte
+	public *rsa.PublicKey
+}
+
+// loadJWKS fails on keys it can't use so that a typo doesn't silently lock
+// everyone out.
+func loadJWKS(path string) ([]verificationKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var keys []verificationKey
+	for i, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		parsed, err := parseJWK(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %d: %w", path, i, err)
+		}
+		keys = append(keys, parsed)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no signing keys", path)
+	}
+
+	return keys, nil
+}
+
+func parseJWK(key jwk) (verificationKey, error) {
+	parsed := verificationKey{kid: key.Kid, alg: key.Alg}
+
+	switch key.Kty {
+	case "oct":
+		if parsed.alg == "" {
+			parsed.alg = "HS256"
+		}
+		secret, err := base64.RawURLEncoding.DecodeString(key.K)
+		if err != nil || len(secret) < sha256.Size {
+			return parsed, errors.New("HS256 keys need at least 32 bytes in k")
+		}
+		parsed.secret = secret
+	case "RSA":
+		if parsed.alg == "" {
+			parsed.alg = "RS256"
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return parsed, errors.New("invalid RSA modulus or exponent")
+		}
+		parsed.public = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if parsed.public.N.BitLen() < 2048 {
+			return parsed, errors.New("RSA keys need at least 2048 bits")
+		}
+	default:
+		return parsed, fmt.Errorf("unsupported key type %q", key.Kty)
+	}
+
+	if (parsed.alg == "HS256") != (key.Kty == "oct") || (parsed.alg == "RS256") != (key.Kty == "RSA") {
+		return parsed, fmt.Errorf("algorithm %q doesn't fit key type %q", parsed.alg, key.Kty)
+	}
+
+	return parsed, nil
+}
+
+func (k verificationKey) verify(signed []byte, signature []byte) bool {
+	switch k.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS256":
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k.public, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+	all       map[string]any
+}
+
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(raw []byte) error {
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		*a = jwtAudience{one}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return errors.New("aud must be a string or a list of strings")
+	}
+	*a = many
+	return nil
+}
+
+// JWTAuthenticator accepts HS256 and RS256 bearer tokens signed by a key of
+// a local JWKS file.
+type JWTAuthenticator struct {
+	keys        []verificationKey
+	issuer      string
+	audience    string
+	rolesClaim  string
+	tenantClaim string
+	now         func() time.Time
+}
+
+func NewJWTAuthenticator(config AuthConfig) (*JWTAuthenticator, error) {
+	keys, err := loadJWKS(config.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWTAuthenticator{
+		keys:        keys,
+		issuer:      config.JWTIssuer,
+		audience:    config.JWTAudience,
+		rolesClaim:  config.JWTRolesClaim,
+		tenantClaim: config.JWTTenantClaim,
+		now:         time.Now,
+	}, nil
+}
+
+func (a *JWTAuthenticator) challenge() string {
+	return `Bearer realm="customers"`
+}
+
+func (a *JWTAuthenticator) authenticate(r *http.Request) (Principal, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return Principal{}, errNoCredentials
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	roles, err := jwtRoles(claims.all, a.rolesClaim)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	tenant, err := jwtTenant(claims.all, a.tenantClaim)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	return Principal{Subject: claims.Subject, Method: AuthJWT, Roles: roles, Tenant: tenant}, nil
+}
+
+// jwtClaim follows dotted paths such as realm_access.roles.
+func jwtClaim(claims map[string]any, name string) (any, bool) {
+	var value any = claims
+	for _, key := range strings.Split(name, ".") {
+		nested, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = nested[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func jwtRoles(claims map[string]any, name string) ([]string, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	value, ok := jwtClaim(claims, name)
+	if !ok {
+		return nil, nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v), nil
+	case []any:
+		roles := make([]string, 0, len(v))
+		for _, item := range v {
+			role, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim %s must be a list of strings", name)
+			}
+			roles = append(roles, role)
+		}
+		return roles, nil
+	}
+	return nil, fmt.Errorf("claim %s must be a string or a list of strings", name)
+}
+
+// jwtTenant requires the claim once it is configured, so that a token
+// missing it doesn't see the customers of the default tenant.
+func jwtTenant(claims map[string]any, name string) (string, error) {
+	if name == "" {
+		return DefaultTenant, nil
+	}
+
+	value, ok := jwtClaim(claims, name)
+	if !ok {
+		return "", fmt.Errorf("token has no claim %s", name)
+	}
+
+	tenant, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("claim %s must be a string", name)
+	}
+	return tenant, nil
+}
+
+// verify requires the algorithm of the key, so a token can't pick HS256 to
+// be checked with a public RSA key as secret.
+func (a *JWTAuthenticator) verify(token string) (jwtClaims, error) {
+	var claims jwtClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return claims, errors.New("malformed token header")
+	}
+
+	key, ok := a.key(header.Alg, header.Kid)
+	if !ok {
+		return claims, fmt.Errorf("no key for algorithm %q and key id %q", header.Alg, header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return claims, errors.New("invalid signature")
+	}
+
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return claims, errors.New("malformed token claims")
+	}
+	if err := decodeJWTPart(parts[1], &claims.all); err != nil {
+		return claims, errors.New("malformed token claims")
+	}
+
+	return claims, a.check(claims)
+}
+
+// key only accepts tokens without a key id while there is a single key
+// for their algorithm.
+func (a *JWTAuthenticator) key(alg string, kid string) (verificationKey, bool) {
+	var found []verificationKey
+	for _, key := range a.keys {
+		if key.alg == alg && (kid == "" || key.kid == kid) {
+			found = append(found, key)
+		}
+	}
+
+	if len(found) != 1 {
+		return verificationKey{}, false
+	}
+	return found[0], true
+}
+
+func (a *JWTAuthenticator) check(claims jwtClaims) error {
+	now := a.now()
+	at := func(seconds float64) time.Time {
+		return time.Unix(0, int64(seconds*float64(time.Second)))
+	}
+
+	switch {
+	case claims.Subject == "":
+		return errors.New("token has no subject")
+	case claims.ExpiresAt == nil:
+		return errors.New("token doesn't expire")
+	case now.After(at(*claims.ExpiresAt).Add(jwtLeeway)):
+		return errors.New("token expired")
+	case claims.NotBefore != nil && now.Before(at(*claims.NotBefore).Add(-jwtLeeway)):
+		return errors.New("token not valid yet")
+	case a.issuer != "" && claims.Issuer != a.issuer:
+		return errors.New("token from another issuer")
+	case a.audience != "" && !slices.Contains(claims.Audience, a.audience):
+		return errors.New("token meant for another audience")
+	}
+
+	return nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testHMACSecret and testRSAKey sign the tokens of tests, testJWKS holds
+// both under the key ids "hs" and "rs".
+var testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+
+var testRSAKey = sync.OnceValue(func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+func testJWKS() map[string]any {
+	public := testRSAKey().PublicKey
+	return map[string]any{"keys": []map[string]any{
+		{"kty": "oct", "kid": "hs", "alg": "HS256", "k": base64.RawURLEncoding.EncodeToString(testHMACSecret)},
+		{
+			"kty": "RSA", "kid": "rs", "alg": "RS256", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		},
+	}}
+}
+
+func writeJWKS(t *testing.T, jwks any) string {
+	raw, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// signJWT makes a token of claims signed with alg, HS256 with
+// testHMACSecret and RS256 with testRSAKey.
+func signJWT(t *testing.T, alg string, kid string, claims map[string]any) string {
+	header := map[string]any{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+
+	encode := func(v any) string {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signed := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, testHMACSecret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, testRSAKey(), crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testJWTNow is the time tokens of tests are checked at.
+var testJWTNow = conformanceDeletedAt
+
+func validClaims(overrides map[string]any) map[string]any {
+	claims := map[string]any{
+		"sub": "hardik",
+		"iss": "https://auth.example.com",
+		"aud": "customers",
+		"exp": testJWTNow.Add(time.Hour).Unix(),
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	return claims
+}
+
+func newTestJWTAuthenticator(t *testing.T) *JWTAuthenticator {
+	authenticator, err := NewJWTAuthenticator(AuthConfig{
+		JWKSFile:      writeJWKS(t, testJWKS()),
+		JWTIssuer:     "https://auth.example.com",
+		JWTAudience:   "customers",
+		JWTRolesClaim: "roles",
+	})
+	if err != nil {
+		t.Fatal("failed to load jwks:", err)
+	}
+	authenticator.now = func() time.Time { return testJWTNow }
+	return authenticator
+}
+
+func TestJWTAuthenticator_authenticate(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		wantPrincipal Principal
+		wantErr       error
+	}{
+		{
+			name:          "HS256",
+			token:         signJWT(t, "HS256", "hs", validClaims(nil)),
+			wantPrincipal: Principal{Subject: "hardik", Method: AuthJWT, Tenant: DefaultTenant},
+		},
+		{
+			name:          "RS256",
+			token:         signJWT(t, "RS256", "rs", validClaims(nil)),
+			wantPrincipal: Principal{Subject: "hardik", Method: AuthJWT, Tenant: DefaultTenant},
+		},
+		{
+			name:          "without key id",
+			token:         signJWT(t, "RS256", "", validClaims(nil)),
+			wantPrincipal: Principal{Subject: "hardik", Method: AuthJWT, Tenant: DefaultTenant},
+		},
+		{
+			name:          "with roles",
+			token:         signJWT(t, "HS256", "hs", validClaims(map[string]any{"roles": []string{"viewer", "editor"}})),
+			wantPrincipal: Principal{Subject: "hardik", Method: AuthJWT, Roles: []string{"viewer", "editor"}, Tenant: DefaultTenant},
+		},
+		{
+			name:    "roles not strings",
+			token:   signJWT(t, "HS256", "hs", validClaims(map[string]any{"roles": []int{1}})),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:          "audience in a list",
+			token:         signJWT(t, "HS256", "hs", validClaims(map[string]any{"aud": []string{"billing", "customers"}})),
+			wantPrincipal: Principal{Subject: "hardik", Method: AuthJWT, Tenant: DefaultTenant},
+		},
+		{
+			name:          "expired within the leeway",
+			token:         signJWT(t, "HS256", "hs", validClaims(map[string]any{"exp": testJWTNow.Add(-10 * time.Second).Unix()})),
+			wantPrincipal: Principal{Subject: "hardik", Method: AuthJWT, Tenant: DefaultTenant},
+		},
+		{
+			name:    "no token",
+			wantErr: errNoCredentials,
+		},
+		{
+			name:    "expired",
+			token:   signJWT(t, "HS256", "hs", validClaims(map[string]any{"exp": testJWTNow.Add(-time.Minute).Unix()})),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "not valid yet",
+			token:   signJWT(t, "HS256", "hs", validClaims(map[string]any{"nbf": testJWTNow.Add(time.Minute).Unix()})),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "never expires",
+			token:   signJWT(t, "HS256", "hs", validClaims(map[string]any{"exp": nil})),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "no subject",
+			token:   signJWT(t, "HS256", "hs", validClaims(map[string]any{"sub": nil})),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "other issuer",
+			token:   signJWT(t, "HS256", "hs", validClaims(map[string]any{"iss": "https://evil.example.com"})),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "other audience",
+			token:   signJWT(t, "HS256", "hs", validClaims(map[string]any{"aud": "billing"})),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "unknown key id",
+			token:   signJWT(t, "HS256", "other", validClaims(nil)),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "algorithm of another key",
+			token:   signJWT(t, "HS256", "rs", validClaims(nil)),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "unsigned",
+			token:   strings.TrimRight(signJWT(t, "none", "", validClaims(nil)), ".") + ".",
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name: "changed claims",
+			token: func() string {
+				parts := strings.Split(signJWT(t, "HS256", "hs", validClaims(nil)), ".")
+				other := strings.Split(signJWT(t, "HS256", "hs", validClaims(map[string]any{"sub": "admin"})), ".")
+				return parts[0] + "." + other[1] + "." + parts[2]
+			}(),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "malformed",
+			token:   "not.a.token",
+			wantErr: ErrUnauthenticated,
+		},
+	}
+
+	authenticator := newTestJWTAuthenticator(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/customers", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			gotPrincipal, gotErr := authenticator.authenticate(r)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expect error to be same")
+			assert.Equal(t, tt.wantPrincipal, gotPrincipal, "expect principal to be same")
+		})
+	}
+}
+
+func Test_jwtRoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		claims    string
+		claim     string
+		wantRoles []string
+		wantErr   bool
+	}{
+		{name: "list", claims: `{"roles": ["viewer", "admin"]}`, claim: "roles", wantRoles: []string{"viewer", "admin"}},
+		{name: "space separated", claims: `{"scope": "viewer  admin"}`, claim: "scope", wantRoles: []string{"viewer", "admin"}},
+		{name: "nested", claims: `{"realm_access": {"roles": ["editor"]}}`, claim: "realm_access.roles", wantRoles: []string{"editor"}},
+		{name: "missing", claims: `{"sub": "hardik"}`, claim: "roles"},
+		{name: "missing parent", claims: `{"realm_access": "editor"}`, claim: "realm_access.roles"},
+		{name: "no claim configured", claims: `{"roles": ["viewer"]}`},
+		{name: "object", claims: `{"roles": {"viewer": true}}`, claim: "roles", wantErr: true},
+		{name: "list of numbers", claims: `{"roles": [1, 2]}`, claim: "roles", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims map[string]any
+			if err := json.Unmarshal([]byte(tt.claims), &claims); err != nil {
+				t.Fatal(err)
+			}
+
+			gotRoles, err := jwtRoles(claims, tt.claim)
+
+			assert.Equal(t, tt.wantErr, err != nil, "expect error to be %v, got %v", tt.wantErr, err)
+			assert.Equal(t, tt.wantRoles, gotRoles, "expect roles to be same")
+		})
+	}
+}
+
+func Test_jwtTenant(t *testing.T) {
+	tests := []struct {
+		name       string
+		claims     string
+		claim      string
+		wantTenant string
+		wantErr    bool
+	}{
+		{name: "claimed", claims: `{"tenant": "acme"}`, claim: "tenant", wantTenant: "acme"},
+		{name: "nested", claims: `{"org": {"tenant": "acme"}}`, claim: "org.tenant", wantTenant: "acme"},
+		{name: "no claim configured", claims: `{"tenant": "acme"}`, wantTenant: DefaultTenant},
+		{name: "missing", claims: `{"sub": "hardik"}`, claim: "tenant", wantErr: true},
+		{name: "not a string", claims: `{"tenant": ["acme"]}`, claim: "tenant", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims map[string]any
+			if err := json.Unmarshal([]byte(tt.claims), &claims); err != nil {
+				t.Fatal(err)
+			}
+
+			gotTenant, err := jwtTenant(claims, tt.claim)
+
+			assert.Equal(t, tt.wantErr, err != nil, "expect error to be %v, got %v", tt.wantErr, err)
+			assert.Equal(t, tt.wantTenant, gotTenant, "expect tenant to be same")
+		})
+	}
+}
+
+func Test_loadJWKS(t *testing.T) {
+	tests := []struct {
+		name     string
+		jwks     any
+		wantKids []string
+		wantErr  bool
+	}{
+		{
+			name:     "symmetric and RSA keys",
+			jwks:     testJWKS(),
+			wantKids: []string{"hs", "rs"},
+		},
+		{
+			name: "encryption keys skipped",
+			jwks: map[string]any{"keys": []map[string]any{
+				{"kty": "oct", "kid": "enc", "use": "enc", "k": "c2hvcnQ"},
+				{"kty": "oct", "kid": "hs", "k": base64.RawURLEncoding.EncodeToString(testHMACSecret)},
+			}},
+			wantKids: []string{"hs"},
+		},
+		{
+			name:    "short secret",
+			jwks:    map[string]any{"keys": []map[string]any{{"kty": "oct", "k": "c2hvcnQ"}}},
+			wantErr: true,
+		},
+		{
+			name:    "algorithm not fitting the key",
+			jwks:    map[string]any{"keys": []map[string]any{{"kty": "oct", "alg": "RS256", "k": base64.RawURLEncoding.EncodeToString(testHMACSecret)}}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported key type",
+			jwks:    map[string]any{"keys": []map[string]any{{"kty": "EC", "crv": "P-256"}}},
+			wantErr: true,
+		},
+		{
+			name:    "no keys",
+			jwks:    map[string]any{"keys": []map[string]any{}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := loadJWKS(writeJWKS(t, tt.jwks))
+
+			if tt.wantErr {
+				assert.Error(t, err, "expect jwks to be rejected")
+				return
+			}
+
+			gotKids := []string{}
+			for _, key := range keys {
+				gotKids = append(gotKids, key.kid)
+			}
+			assert.NoError(t, err, "expect jwks to load")
+			assert.Equal(t, tt.wantKids, gotKids, "expect keys to be same")
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// sortColumns maps the sort keys accepted by the API to database columns.
+var sortColumns = map[string]string{
+	"id":        "id",
+	"name":      "customerdetails_name",
+	"address":   "customerdetails_address",
+	"contactNo": "customerdetails_contact_no",
+}
+
+// ListOptions describes one page of a filtered, sorted customer listing.
+// Name and Address match case-insensitive substrings, ContactNo matches
+// exactly when set. A non-zero AsOf lists the customers as they were then.
+type ListOptions struct {
+	Limit      int
+	Cursor     string
+	Name       string
+	Address    string
+	ContactNo  PhoneNumber
+	SortBy     string
+	Descending bool
+	AsOf       time.Time
+}
+
+type CustomerPage struct {
+	Customers []Customer `json:"customers"`
+	Next      string     `json:"next,omitempty"`
+}
+
+// pageCursor is the decoded form of ListOptions.Cursor. It remembers the
+// sort it was issued for so it can't be replayed against a different order.
+type pageCursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	Key        string `json:"k"`
+	Id         string `json:"i"`
+}
+
+func (o ListOptions) normalize() (ListOptions, error) {
+	if o.SortBy == "" {
+		o.SortBy = "id"
+	}
+
+	if _, ok := sortColumns[o.SortBy]; !ok {
+		return o, ErrInvalidListOptions
+	}
+
+	if o.Limit < 0 || o.Limit > MaxPageLimit {
+		return o, ErrInvalidListOptions
+	}
+
+	if o.Limit == 0 {
+		o.Limit = DefaultPageLimit
+	}
+
+	return o, nil
+}
+
+func sortKey(sortBy string, customer Customer) string {
+	switch sortBy {
+	case "name":
+		return customer.CustomerDetails.Name
+	case "address":
+		return customer.CustomerDetails.Address
+	case "contactNo":
+		return string(customer.CustomerDetails.ContactNo)
+	default:
+		return customer.Id
+	}
+}
+
+// compareToCursor orders a customer against the (key, id) position of a
+// cursor, honoring the sort direction.
+func compareToCursor(opts ListOptions, customer Customer, key string, id string) int {
+	cmp := strings.Compare(sortKey(opts.SortBy, customer), key)
+	if cmp == 0 {
+		cmp = strings.Compare(customer.Id, id)
+	}
+
+	if opts.Descending {
+		return -cmp
+	}
+
+	return cmp
+}
+
+func encodeCursor(opts ListOptions, last Customer) string {
+	cursor := pageCursor{
+		SortBy:     opts.SortBy,
+		Descending: opts.Descending,
+		Key:        sortKey(opts.SortBy, last),
+		Id:         last.Id,
+	}
+
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(opts ListOptions) (pageCursor, error) {
+	var cursor pageCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	if cursor.SortBy != opts.SortBy || cursor.Descending != opts.Descending {
+		return cursor, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+func matchesFilters(opts ListOptions, customer Customer) bool {
+	details := customer.CustomerDetails
+
+	if opts.Name != "" && !strings.Contains(strings.ToLower(details.Name), strings.ToLower(opts.Name)) {
+		return false
+	}
+
+	if opts.Address != "" && !strings.Contains(strings.ToLower(details.Address), strings.ToLower(opts.Address)) {
+		return false
+	}
+
+	if opts.ContactNo != "" && details.ContactNo != opts.ContactNo {
+		return false
+	}
+
+	return true
+}
+
+// paginate applies filters, sort order and cursor position to an unordered
+// set of customers. It is used by repos that can't push the query down.
+func paginate(customers []Customer, opts ListOptions) (CustomerPage, error) {
+	var after *pageCursor
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts)
+		if err != nil {
+			return CustomerPage{}, err
+		}
+		after = &cursor
+	}
+
+	matched := []Customer{}
+	for _, customer := range customers {
+		if !matchesFilters(opts, customer) {
+			continue
+		}
+
+		if after != nil && compareToCursor(opts, customer, after.Key, after.Id) <= 0 {
+			continue
+		}
+
+		matched = append(matched, customer)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return compareToCursor(opts, matched[i], sortKey(opts.SortBy, matched[j]), matched[j].Id) < 0
+	})
+
+	return newCustomerPage(matched, opts), nil
+}
+
+// newCustomerPage trims a result fetched with one extra row and derives the
+// next cursor from it.
+func newCustomerPage(customers []Customer, opts ListOptions) CustomerPage {
+	page := CustomerPage{Customers: customers}
+
+	if len(customers) > opts.Limit {
+		page.Customers = customers[:opts.Limit]
+		page.Next = encodeCursor(opts, page.Customers[opts.Limit-1])
+	}
+
+	return page
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+var ErrNoMigration = errors.New("no migration to roll back")
+
+// migrationLockKey serializes migration runs of every instance sharing a
+// database.
+const migrationLockKey = "customers_schema_migrations"
+
+// Migration is one goose-style SQL migration.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      []string
+	Down    []string
+	// NoTransaction marks migrations with statements that can't run in a
+	// transaction, such as CREATE INDEX CONCURRENTLY
+	NoTransaction bool
+}
+
+// MigrationStatus reports whether a migration is applied.
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+}
+
+func (s MigrationStatus) applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		version, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		number, err := strconv.ParseInt(version, 10, 64)
+		if !ok || err != nil || number < 1 {
+			return nil, fmt.Errorf("migration %s: name must start with a version", entry.Name())
+		}
+
+		source, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, err := parseMigration(string(source))
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		migration.Version = number
+		migration.Name = name
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("migration version %d is used twice", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// parseMigration ends statements at a semicolon ending a line, or at a
+// StatementEnd annotation.
+func parseMigration(source string) (Migration, error) {
+	var migration Migration
+	var section *[]string
+	var statement strings.Builder
+	inBlock := false
+
+	scanner := bufio.NewScanner(strings.NewReader(source))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if annotation, ok := strings.CutPrefix(strings.TrimSpace(line), "-- +goose "); ok {
+			annotation = strings.TrimSpace(annotation)
+			switch {
+			case (annotation == "Up" || annotation == "Down") && inBlock:
+				return Migration{}, fmt.Errorf("%s annotation inside a statement block", annotation)
+			case (annotation == "StatementBegin" || annotation == "StatementEnd") && section == nil:
+				return Migration{}, errors.New("statement outside of Up and Down sections")
+			case annotation == "StatementBegin" && inBlock:
+				return Migration{}, errors.New("StatementBegin inside a statement block")
+			case annotation == "StatementEnd" && !inBlock:
+				return Migration{}, errors.New("StatementEnd without StatementBegin")
+			}
+
+			switch annotation {
+			case "Up":
+				section = &migration.Up
+			case "Down":
+				section = &migration.Down
+			case "StatementBegin":
+				inBlock = true
+			case "StatementEnd":
+				inBlock = false
+				*section = append(*section, strings.TrimSpace(statement.String()))
+				statement.Reset()
+			case "NO TRANSACTION":
+				migration.NoTransaction = true
+			default:
+				return Migration{}, fmt.Errorf("unknown annotation %q", annotation)
+			}
+			continue
+		}
+
+		if section == nil {
+			if strings.TrimSpace(line) != "" && !strings.HasPrefix(strings.TrimSpace(line), "--") {
+				return Migration{}, errors.New("statement outside of Up and Down sections")
+			}
+			continue
+		}
+
+		statement.WriteString(line)
+		statement.WriteString("\n")
+
+		if !inBlock && strings.HasSuffix(strings.TrimSpace(line), ";") {
+			*section = append(*section, strings.TrimSpace(statement.String()))
+			statement.Reset()
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return Migration{}, err
+	}
+
+	if inBlock {
+		return Migration{}, errors.New("StatementBegin without StatementEnd")
+	}
+
+	if strings.TrimSpace(statement.String()) != "" && !onlyComments(statement.String()) {
+		return Migration{}, errors.New("statement without terminating semicolon")
+	}
+
+	if migration.Up == nil {
+		return Migration{}, errors.New("missing Up section")
+	}
+
+	return migration, nil
+}
+
+func onlyComments(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+// Migrator applies migrations, recording them in schema_migrations.
+type Migrator struct {
+	db         *bun.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *bun.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// NewEmbeddedMigrator applies the migrations built into the binary.
+func NewEmbeddedMigrator(db *bun.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(embeddedMigrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMigrator(db, migrations), nil
+}
+
+// Status lists every known migration in version order.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn bun.Conn) error {
+		var err error
+		statuses, err = m.status(ctx, conn)
+		return err
+	})
+	return statuses, err
+}
+
+// Pending lists the migrations that are not applied yet.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if !status.applied() {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration and returns those it applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn bun.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			if status.applied() {
+				continue
+			}
+
+			if err := m.run(ctx, conn, status.Migration, true); err != nil {
+				return err
+			}
+			applied = append(applied, status.Migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the latest applied migration and returns it.
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	var rolledBack Migration
+	err := m.withLock(ctx, func(conn bun.Conn) error {
+		var err error
+		rolledBack, err = m.down(ctx, conn)
+		return err
+	})
+	return rolledBack, err
+}
+
+// Redo rolls back the latest applied migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) (Migration, error) {
+	var redone Migration
+	err := m.withLock(ctx, func(conn bun.Conn) error {
+		var err error
+		if redone, err = m.down(ctx, conn); err != nil {
+			return err
+		}
+		return m.run(ctx, conn, redone, true)
+	})
+	return redone, err
+}
+
+func (m *Migrator) down(ctx context.Context, conn bun.Conn) (Migration, error) {
+	statuses, err := m.status(ctx, conn)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if statuses[i].applied() {
+			return statuses[i].Migration, m.run(ctx, conn, statuses[i].Migration, false)
+		}
+	}
+
+	return Migration{}, ErrNoMigration
+}
+
+func (m *Migrator) run(ctx context.Context, conn bun.Conn, migration Migration, up bool) error {
+	statements := migration.Down
+	record := func(db bun.IConn) error {
+		_, err := db.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+		return err
+	}
+	if up {
+		statements = migration.Up
+		record = func(db bun.IConn) error {
+			_, err := db.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name)
+			return err
+		}
+	}
+
+	apply := func(db bun.IConn) error {
+		for _, statement := range statements {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return record(db)
+	}
+
+	if migration.NoTransaction {
+		return apply(conn)
+	}
+
+	return conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		return apply(tx)
+	})
+}
+
+func (m *Migrator) status(ctx context.Context, conn bun.Conn) ([]MigrationStatus, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedAt := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = MigrationStatus{Migration: migration, AppliedAt: appliedAt[migration.Version]}
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) withLock(ctx context.Context, f func(conn bun.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext(?))", migrationLockKey); err != nil {
+		return err
+	}
+	defer func() {
+		// the lock also ends with the session, should this fail
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(hashtext(?))", migrationLockKey); err != nil {
+			log.Printf("failed to release migration lock :%q", err)
+		}
+	}()
+
+	if err := m.createSchemaTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+// createSchemaTable carries over the history of the goose CLI.
+func (m *Migrator) createSchemaTable(ctx context.Context, conn bun.Conn) error {
+	return conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, `CREATE TABLE schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+			return err
+		}
+
+		var fromGoose bool
+		if err := tx.QueryRowContext(ctx, "SELECT to_regclass('goose_db_version') IS NOT NULL").Scan(&fromGoose); err != nil {
+			return err
+		}
+		if !fromGoose {
+			return nil
+		}
+
+		// goose appends a row for every up and down, the latest one per
+		// version tells whether it is applied
+		_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at)
+			SELECT version_id, tstamp FROM (
+				SELECT DISTINCT ON (version_id) version_id, is_applied, tstamp
+				FROM goose_db_version
+				WHERE version_id > 0
+				ORDER BY version_id, id DESC
+			) latest
+			WHERE is_applied`)
+		return err
+	})
+}
+
+func runMigrateCommand(ctx context.Context, migrator *Migrator, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status|redo")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Fprintf(out, "applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "schema is up to date")
+		}
+		return err
+	case "down":
+		migration, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "rolled back %d_%s\n", migration.Version, migration.Name)
+	case "redo":
+		migration, err := migrator.Redo(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "redid %d_%s\n", migration.Version, migration.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, status := range statuses {
+			state := "pending"
+			if status.applied() {
+				state = "applied " + status.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, state)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	return nil
+}
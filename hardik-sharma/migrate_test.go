@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseMigration(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    Migration
+		wantErr bool
+	}{
+		{
+			name: "statements split at semicolons",
+			source: `-- +goose Up
+-- a comment before the first statement
+CREATE TABLE a (id TEXT);
+ALTER TABLE a
+    ADD COLUMN b TEXT;
+
+-- +goose Down
+DROP TABLE a;
+`,
+			want: Migration{
+				Up: []string{
+					"-- a comment before the first statement\nCREATE TABLE a (id TEXT);",
+					"ALTER TABLE a\n    ADD COLUMN b TEXT;",
+				},
+				Down: []string{"DROP TABLE a;"},
+			},
+		},
+		{
+			name: "statement block keeps inner semicolons",
+			source: `-- +goose Up
+-- +goose StatementBegin
+CREATE FUNCTION f() RETURNS int AS $$
+BEGIN
+    RETURN 1;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+`,
+			want: Migration{
+				Up: []string{"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n    RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;"},
+			},
+		},
+		{
+			name: "no transaction",
+			source: `-- +goose NO TRANSACTION
+-- +goose Up
+CREATE INDEX CONCURRENTLY a_idx ON a (id);
+`,
+			want: Migration{
+				Up:            []string{"CREATE INDEX CONCURRENTLY a_idx ON a (id);"},
+				NoTransaction: true,
+			},
+		},
+		{
+			name:    "missing up section",
+			source:  "-- +goose Down\nDROP TABLE a;\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated statement",
+			source:  "-- +goose Up\nCREATE TABLE a (id TEXT)\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated block",
+			source:  "-- +goose Up\n-- +goose StatementBegin\nSELECT 1;\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown annotation",
+			source:  "-- +goose Sideways\n",
+			wantErr: true,
+		},
+		{
+			name:    "block before any section",
+			source:  "-- +goose StatementBegin\nSELECT 1;\n-- +goose StatementEnd\n-- +goose Up\nSELECT 2;\n",
+			wantErr: true,
+		},
+		{
+			name:    "block end before any section",
+			source:  "-- +goose StatementEnd\n-- +goose Up\nSELECT 1;\n",
+			wantErr: true,
+		},
+		{
+			name:    "block end without begin",
+			source:  "-- +goose Up\nSELECT 1;\n-- +goose StatementEnd\n",
+			wantErr: true,
+		},
+		{
+			name:    "nested block",
+			source:  "-- +goose Up\n-- +goose StatementBegin\n-- +goose StatementBegin\nSELECT 1;\n-- +goose StatementEnd\n",
+			wantErr: true,
+		},
+		{
+			name:    "section inside block",
+			source:  "-- +goose Up\n-- +goose StatementBegin\nSELECT 1;\n-- +goose Down\nSELECT 2;\n-- +goose StatementEnd\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated block at end of down section",
+			source:  "-- +goose Up\nSELECT 1;\n-- +goose Down\n-- +goose StatementBegin\nSELECT 2;\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMigration(tt.source)
+
+			assert.Equal(t, tt.wantErr, err != nil, "expected error to be reported")
+
+			assert.Equal(t, tt.want, got, "expected migration to be same")
+		})
+	}
+}
+
+func Test_loadMigrations(t *testing.T) {
+	migration := &fstest.MapFile{Data: []byte("-- +goose Up\nSELECT 1;\n")}
+
+	tests := []struct {
+		name         string
+		files        fstest.MapFS
+		wantVersions []int64
+		wantErr      bool
+	}{
+		{
+			name: "ordered by version",
+			files: fstest.MapFS{
+				"migrations/20_second.sql": migration,
+				"migrations/3_first.sql":   migration,
+				"migrations/README.md":     &fstest.MapFile{},
+			},
+			wantVersions: []int64{3, 20},
+		},
+		{
+			name: "name without version",
+			files: fstest.MapFS{
+				"migrations/first.sql": migration,
+			},
+			wantErr: true,
+		},
+		{
+			name: "version used twice",
+			files: fstest.MapFS{
+				"migrations/1_first.sql":  migration,
+				"migrations/01_again.sql": migration,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files, "migrations")
+
+			assert.Equal(t, tt.wantErr, err != nil, "expected error to be reported")
+
+			var versions []int64
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			assert.Equal(t, tt.wantVersions, versions, "expected versions to be same")
+		})
+	}
+}
+
+func Test_embeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(embeddedMigrations, "migrations")
+	if err != nil {
+		t.Fatal("failed to load embedded migrations:", err)
+	}
+
+	assert.NotEmpty(t, migrations, "expected migrations to be embedded")
+
+	for _, migration := range migrations {
+		assert.NotEmpty(t, migration.Down, "expected %d_%s to be reversible", migration.Version, migration.Name)
+	}
+}
+
+func Test_postgresMigrator(t *testing.T) {
+	db := setupDB(t, nil)
+	ctx := context.Background()
+
+	migrator, err := NewEmbeddedMigrator(db)
+	if err != nil {
+		t.Fatal("failed to load migrations:", err)
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, runMigrateCommand(ctx, migrator, []string{"redo"}, &out), "expected redo to succeed")
+
+	pending, err := migrator.Pending(ctx)
+	assert.NoError(t, err, "expected status to be read")
+	assert.Empty(t, pending, "expected every migration to be applied")
+
+	statuses, err := migrator.Status(ctx)
+	assert.NoError(t, err, "expected status to be read")
+	latest := statuses[len(statuses)-1]
+	assert.Equal(t, "redid "+strconv.FormatInt(latest.Version, 10)+"_"+latest.Name+"\n", out.String(), "expected output to be same")
+}
@@ -0,0 +1,10 @@
+-- +goose Up
+
+CREATE INDEX customers_name_id_idx ON customers (customerdetails_name, id);
+CREATE INDEX customers_address_id_idx ON customers (customerdetails_address, id);
+CREATE INDEX customers_contact_no_id_idx ON customers (customerdetails_contact_no, id);
+
+-- +goose Down
+DROP INDEX customers_contact_no_id_idx;
+DROP INDEX customers_address_id_idx;
+DROP INDEX customers_name_id_idx;
@@ -0,0 +1,84 @@
+-- +goose Up
+
+ALTER TABLE customers ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_customer_change() RETURNS trigger AS $$
+DECLARE
+    changed customers;
+    event_type TEXT;
+BEGIN
+    -- writers queue up here until the holder commits, so sequence numbers
+    -- are handed out and delivered in commit order
+    PERFORM pg_advisory_xact_lock(hashtext('customer_changes'));
+
+    IF TG_OP = 'DELETE' THEN
+        changed := OLD;
+        event_type := 'customer.deleted';
+    ELSIF TG_OP = 'UPDATE' THEN
+        changed := NEW;
+        event_type := 'customer.updated';
+    ELSE
+        changed := NEW;
+        event_type := 'customer.created';
+    END IF;
+
+    PERFORM pg_notify('customer_changes', json_build_object(
+        'type', event_type,
+        'seq', nextval('customer_change_seq'),
+        'customer', json_build_object(
+            'id', changed.id,
+            'customerDetails', json_build_object(
+                'name', changed.customerdetails_name,
+                'address', changed.customerdetails_address,
+                'contactNo', changed.customerdetails_contact_no
+            ),
+            'version', changed.version
+        )
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_customer_change() RETURNS trigger AS $$
+DECLARE
+    changed customers;
+    event_type TEXT;
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('customer_changes'));
+
+    IF TG_OP = 'DELETE' THEN
+        changed := OLD;
+        event_type := 'customer.deleted';
+    ELSIF TG_OP = 'UPDATE' THEN
+        changed := NEW;
+        event_type := 'customer.updated';
+    ELSE
+        changed := NEW;
+        event_type := 'customer.created';
+    END IF;
+
+    PERFORM pg_notify('customer_changes', json_build_object(
+        'type', event_type,
+        'seq', nextval('customer_change_seq'),
+        'customer', json_build_object(
+            'id', changed.id,
+            'customerDetails', json_build_object(
+                'name', changed.customerdetails_name,
+                'address', changed.customerdetails_address,
+                'contactNo', changed.customerdetails_contact_no
+            )
+        )
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+ALTER TABLE customers DROP COLUMN version;
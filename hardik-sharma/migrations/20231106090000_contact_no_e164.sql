@@ -0,0 +1,15 @@
+-- +goose Up
+
+-- contact numbers used to be validated as ten digit Indian numbers, which
+-- all get the +91 country code
+ALTER TABLE customers
+    ALTER COLUMN customerdetails_contact_no TYPE TEXT
+    USING '+91' || customerdetails_contact_no::TEXT;
+
+-- +goose Down
+
+-- numbers outside India keep their country code, there is no better place
+-- for it in a BIGINT
+ALTER TABLE customers
+    ALTER COLUMN customerdetails_contact_no TYPE BIGINT
+    USING regexp_replace(customerdetails_contact_no, '^\+91|\D', '', 'g')::BIGINT;
@@ -0,0 +1,17 @@
+-- +goose Up
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- the expression is repeated as searchDocument in postgres_repo.go
+CREATE INDEX customers_search_idx ON customers USING GIN ((
+    setweight(to_tsvector('simple', coalesce(customerdetails_name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(customerdetails_address, '')), 'B')
+));
+CREATE INDEX customers_name_trgm_idx ON customers USING GIN (customerdetails_name gin_trgm_ops);
+CREATE INDEX customers_address_trgm_idx ON customers USING GIN (customerdetails_address gin_trgm_ops);
+
+-- +goose Down
+DROP INDEX customers_address_trgm_idx;
+DROP INDEX customers_name_trgm_idx;
+DROP INDEX customers_search_idx;
+-- pg_trgm is left installed, other schemas may rely on it
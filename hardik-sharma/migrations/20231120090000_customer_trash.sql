@@ -0,0 +1,109 @@
+-- +goose Up
+
+ALTER TABLE customers ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- the trash listing and purge only look at deleted customers
+CREATE INDEX customers_deleted_at_idx ON customers (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_customer_change() RETURNS trigger AS $$
+DECLARE
+    changed customers;
+    event_type TEXT;
+BEGIN
+    -- moving a customer to the trash is what clients know as a delete,
+    -- changes inside the trash and purging it are of no concern to them
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        changed := OLD;
+        event_type := 'customer.deleted';
+    ELSIF TG_OP = 'UPDATE' THEN
+        changed := NEW;
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NULL THEN
+            event_type := 'customer.updated';
+        ELSIF OLD.deleted_at IS NULL THEN
+            event_type := 'customer.deleted';
+        ELSIF NEW.deleted_at IS NULL THEN
+            event_type := 'customer.restored';
+        ELSE
+            RETURN NULL;
+        END IF;
+    ELSE
+        changed := NEW;
+        event_type := 'customer.created';
+    END IF;
+
+    -- writers queue up here until the holder commits, so sequence numbers
+    -- are handed out and delivered in commit order
+    PERFORM pg_advisory_xact_lock(hashtext('customer_changes'));
+
+    PERFORM pg_notify('customer_changes', json_build_object(
+        'type', event_type,
+        'seq', nextval('customer_change_seq'),
+        'customer', json_build_object(
+            'id', changed.id,
+            'customerDetails', json_build_object(
+                'name', changed.customerdetails_name,
+                'address', changed.customerdetails_address,
+                'contactNo', changed.customerdetails_contact_no
+            ),
+            'version', changed.version,
+            'deletedAt', changed.deleted_at
+        )
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- there is nowhere left to keep the trash, so it is emptied while the
+-- trigger still ignores it
+DELETE FROM customers WHERE deleted_at IS NOT NULL;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_customer_change() RETURNS trigger AS $$
+DECLARE
+    changed customers;
+    event_type TEXT;
+BEGIN
+    -- writers queue up here until the holder commits, so sequence numbers
+    -- are handed out and delivered in commit order
+    PERFORM pg_advisory_xact_lock(hashtext('customer_changes'));
+
+    IF TG_OP = 'DELETE' THEN
+        changed := OLD;
+        event_type := 'customer.deleted';
+    ELSIF TG_OP = 'UPDATE' THEN
+        changed := NEW;
+        event_type := 'customer.updated';
+    ELSE
+        changed := NEW;
+        event_type := 'customer.created';
+    END IF;
+
+    PERFORM pg_notify('customer_changes', json_build_object(
+        'type', event_type,
+        'seq', nextval('customer_change_seq'),
+        'customer', json_build_object(
+            'id', changed.id,
+            'customerDetails', json_build_object(
+                'name', changed.customerdetails_name,
+                'address', changed.customerdetails_address,
+                'contactNo', changed.customerdetails_contact_no
+            ),
+            'version', changed.version
+        )
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP INDEX customers_deleted_at_idx;
+ALTER TABLE customers DROP COLUMN deleted_at;
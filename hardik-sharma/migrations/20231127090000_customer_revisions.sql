@@ -0,0 +1,39 @@
+-- +goose Up
+
+-- every write of a customer adds a row here in the same transaction, see
+-- history.go, purging a customer removes its history
+CREATE TABLE customer_revisions(
+    seq BIGSERIAL PRIMARY KEY,
+    customer_id TEXT NOT NULL REFERENCES customers (id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    type TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL,
+    old_details JSONB,
+    new_details JSONB
+);
+
+CREATE INDEX customer_revisions_customer_id_idx ON customer_revisions (customer_id, seq);
+CREATE INDEX customer_revisions_changed_at_idx ON customer_revisions (changed_at);
+
+-- customers stored until now start their history here
+INSERT INTO customer_revisions (customer_id, version, type, actor, changed_at, old_details, new_details)
+SELECT id, version,
+    CASE WHEN deleted_at IS NULL THEN 'created' ELSE 'deleted' END,
+    'system',
+    coalesce(deleted_at, now()),
+    CASE WHEN deleted_at IS NOT NULL THEN jsonb_build_object(
+        'name', customerdetails_name,
+        'address', customerdetails_address,
+        'contactNo', customerdetails_contact_no
+    ) END,
+    CASE WHEN deleted_at IS NULL THEN jsonb_build_object(
+        'name', customerdetails_name,
+        'address', customerdetails_address,
+        'contactNo', customerdetails_contact_no
+    ) END
+FROM customers
+ORDER BY id;
+
+-- +goose Down
+DROP TABLE customer_revisions;
@@ -0,0 +1,14 @@
+-- +goose Up
+
+-- keys of the apikey authentication method, see apikey.go, only the hash
+-- of each secret is stored
+CREATE TABLE api_keys(
+    id TEXT PRIMARY KEY,
+    subject TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+-- +goose Down
+DROP TABLE api_keys;
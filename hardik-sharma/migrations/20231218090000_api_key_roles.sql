@@ -0,0 +1,8 @@
+-- +goose Up
+
+-- roles of the principal each api key authenticates, see authz.go, keys
+-- made before roles existed have none until they are replaced
+ALTER TABLE api_keys ADD COLUMN roles JSONB NOT NULL DEFAULT '[]';
+
+-- +goose Down
+ALTER TABLE api_keys DROP COLUMN roles;
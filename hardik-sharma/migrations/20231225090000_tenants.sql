@@ -0,0 +1,189 @@
+-- +goose Up
+
+-- customers belong to a tenant, see tenant.go, everything stored until now
+-- to the default one. Ids only have to be unique within a tenant.
+ALTER TABLE customers ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE customer_revisions ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE api_keys ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+
+ALTER TABLE customer_revisions DROP CONSTRAINT customer_revisions_customer_id_fkey;
+ALTER TABLE customers DROP CONSTRAINT customers_pkey;
+ALTER TABLE customers ADD PRIMARY KEY (tenant_id, id);
+ALTER TABLE customer_revisions ADD CONSTRAINT customer_revisions_customer_id_fkey
+    FOREIGN KEY (tenant_id, customer_id) REFERENCES customers (tenant_id, id) ON DELETE CASCADE;
+
+-- every query is within one tenant, so the listing indexes lead with it
+DROP INDEX customers_name_id_idx;
+DROP INDEX customers_address_id_idx;
+DROP INDEX customers_contact_no_id_idx;
+CREATE INDEX customers_name_id_idx ON customers (tenant_id, customerdetails_name, id);
+CREATE INDEX customers_address_id_idx ON customers (tenant_id, customerdetails_address, id);
+CREATE INDEX customers_contact_no_id_idx ON customers (tenant_id, customerdetails_contact_no, id);
+
+DROP INDEX customer_revisions_customer_id_idx;
+CREATE INDEX customer_revisions_customer_id_idx ON customer_revisions (tenant_id, customer_id, seq);
+CREATE INDEX IF NOT EXISTS audit_log_tenant_id_idx ON audit_log (tenant_id, seq);
+
+-- the queries of the server name the tenant, these policies back them up
+-- once row level security is enabled on the tables, which is left to the
+-- operator along with -db-row-level-security, see the README. The purge
+-- job goes through every tenant with the setting '*'.
+CREATE POLICY customers_tenant ON customers
+    USING (tenant_id = current_setting('customers.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('customers.tenant_id', true));
+CREATE POLICY customers_purge_select ON customers FOR SELECT
+    USING (current_setting('customers.tenant_id', true) = '*');
+CREATE POLICY customers_purge_delete ON customers FOR DELETE
+    USING (current_setting('customers.tenant_id', true) = '*');
+CREATE POLICY customer_revisions_tenant ON customer_revisions
+    USING (tenant_id = current_setting('customers.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('customers.tenant_id', true));
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_customer_change() RETURNS trigger AS $$
+DECLARE
+    changed customers;
+    event_type TEXT;
+BEGIN
+    -- moving a customer to the trash is what clients know as a delete,
+    -- changes inside the trash and purging it are of no concern to them
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        changed := OLD;
+        event_type := 'customer.deleted';
+    ELSIF TG_OP = 'UPDATE' THEN
+        changed := NEW;
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NULL THEN
+            event_type := 'customer.updated';
+        ELSIF OLD.deleted_at IS NULL THEN
+            event_type := 'customer.deleted';
+        ELSIF NEW.deleted_at IS NULL THEN
+            event_type := 'customer.restored';
+        ELSE
+            RETURN NULL;
+        END IF;
+    ELSE
+        changed := NEW;
+        event_type := 'customer.created';
+    END IF;
+
+    -- writers queue up here until the holder commits, so sequence numbers
+    -- are handed out and delivered in commit order
+    PERFORM pg_advisory_xact_lock(hashtext('customer_changes'));
+
+    -- the tenant decides which websocket clients hear of the change
+    PERFORM pg_notify('customer_changes', json_build_object(
+        'type', event_type,
+        'seq', nextval('customer_change_seq'),
+        'tenant', changed.tenant_id,
+        'customer', json_build_object(
+            'id', changed.id,
+            'customerDetails', json_build_object(
+                'name', changed.customerdetails_name,
+                'address', changed.customerdetails_address,
+                'contactNo', changed.customerdetails_contact_no
+            ),
+            'version', changed.version,
+            'deletedAt', changed.deleted_at
+        )
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- only the customers of the default tenant fit in a table without tenants
+ALTER TABLE customers DISABLE ROW LEVEL SECURITY;
+ALTER TABLE customer_revisions DISABLE ROW LEVEL SECURITY;
+DROP POLICY customer_revisions_tenant ON customer_revisions;
+DROP POLICY customers_purge_delete ON customers;
+DROP POLICY customers_purge_select ON customers;
+DROP POLICY customers_tenant ON customers;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_customer_change() RETURNS trigger AS $$
+DECLARE
+    changed customers;
+    event_type TEXT;
+BEGIN
+    -- moving a customer to the trash is what clients know as a delete,
+    -- changes inside the trash and purging it are of no concern to them
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        changed := OLD;
+        event_type := 'customer.deleted';
+    ELSIF TG_OP = 'UPDATE' THEN
+        changed := NEW;
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NULL THEN
+            event_type := 'customer.updated';
+        ELSIF OLD.deleted_at IS NULL THEN
+            event_type := 'customer.deleted';
+        ELSIF NEW.deleted_at IS NULL THEN
+            event_type := 'customer.restored';
+        ELSE
+            RETURN NULL;
+        END IF;
+    ELSE
+        changed := NEW;
+        event_type := 'customer.created';
+    END IF;
+
+    -- writers queue up here until the holder commits, so sequence numbers
+    -- are handed out and delivered in commit order
+    PERFORM pg_advisory_xact_lock(hashtext('customer_changes'));
+
+    PERFORM pg_notify('customer_changes', json_build_object(
+        'type', event_type,
+        'seq', nextval('customer_change_seq'),
+        'customer', json_build_object(
+            'id', changed.id,
+            'customerDetails', json_build_object(
+                'name', changed.customerdetails_name,
+                'address', changed.customerdetails_address,
+                'contactNo', changed.customerdetails_contact_no
+            ),
+            'version', changed.version,
+            'deletedAt', changed.deleted_at
+        )
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- the customers of other tenants go along with their history
+DELETE FROM customers WHERE tenant_id <> 'default';
+
+DROP INDEX customer_revisions_customer_id_idx;
+CREATE INDEX customer_revisions_customer_id_idx ON customer_revisions (customer_id, seq);
+
+DROP INDEX customers_contact_no_id_idx;
+DROP INDEX customers_address_id_idx;
+DROP INDEX customers_name_id_idx;
+CREATE INDEX customers_name_id_idx ON customers (customerdetails_name, id);
+CREATE INDEX customers_address_id_idx ON customers (customerdetails_address, id);
+CREATE INDEX customers_contact_no_id_idx ON customers (customerdetails_contact_no, id);
+
+ALTER TABLE customer_revisions DROP CONSTRAINT customer_revisions_customer_id_fkey;
+ALTER TABLE customers DROP CONSTRAINT customers_pkey;
+ALTER TABLE customers ADD PRIMARY KEY (id);
+ALTER TABLE customer_revisions ADD CONSTRAINT customer_revisions_customer_id_fkey
+    FOREIGN KEY (customer_id) REFERENCES customers (id) ON DELETE CASCADE;
+
+-- keys of other tenants would otherwise grant access to the default one
+DELETE FROM api_keys WHERE tenant_id <> 'default';
+
+-- audit_log keeps its tenant_id, entries can't be changed and those of
+-- other tenants wouldn't verify without it
+ALTER TABLE api_keys DROP COLUMN tenant_id;
+ALTER TABLE customer_revisions DROP COLUMN tenant_id;
+ALTER TABLE customers DROP COLUMN tenant_id;
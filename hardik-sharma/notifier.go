@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what happens when a subscriber falls so far behind
+// that its queue is full.
+type OverflowPolicy int
+
+const (
+	// CoalesceLatest replaces everything still queued with a snapshot of
+	// the current state, a slow client skips intermediate changes. The
+	// snapshot is read by the subscriber's goroutine, not the writer's.
+	CoalesceLatest OverflowPolicy = iota
+	// DropOldest discards the oldest queued notification.
+	DropOldest
+	// DisconnectSlow drops the subscriber altogether.
+	DisconnectSlow
+)
+
+var overflowPolicies = map[string]OverflowPolicy{
+	"coalesce":   CoalesceLatest,
+	"dropOldest": DropOldest,
+	"disconnect": DisconnectSlow,
+}
+
+func overflowPolicyByName(name string) (OverflowPolicy, error) {
+	policy, ok := overflowPolicies[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown overflow policy %q", name)
+	}
+
+	return policy, nil
+}
+
+const DefaultNotifyQueueSize = 16
+
+// disconnecter is implemented by subscribers that can be forcibly dropped
+// when the DisconnectSlow policy kicks in.
+type disconnecter interface {
+	disconnect()
+}
+
+type NotificationStats struct {
+	Delivered    uint64 `json:"delivered"`
+	Dropped      uint64 `json:"dropped"`
+	Coalesced    uint64 `json:"coalesced"`
+	Disconnected uint64 `json:"disconnected"`
+}
+
+type notificationCounters struct {
+	delivered    atomic.Uint64
+	dropped      atomic.Uint64
+	coalesced    atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+func (c *notificationCounters) snapshot() NotificationStats {
+	return NotificationStats{
+		Delivered:    c.delivered.Load(),
+		Dropped:      c.dropped.Load(),
+		Coalesced:    c.coalesced.Load(),
+		Disconnected: c.disconnected.Load(),
+	}
+}
+
+// subscriberQueue buffers notifications for one subscriber and delivers
+// them from its own goroutine, so a slow subscriber only delays itself.
+// Only the changes of tenant are queued. snapshot reads the customers a
+// coalesced queue catches up with.
+type subscriberQueue struct {
+	subscriber Subscriber
+	tenant     string
+	size       int
+	policy     OverflowPolicy
+	counters   *notificationCounters
+	snapshot   func() ([]Customer, error)
+
+	mu              sync.Mutex
+	pending         []ChangeEvent
+	snapshotPending bool
+	latest          uint64
+	stopped         bool
+
+	wake chan struct{}
+	done chan struct{}
+	exit chan struct{}
+}
+
+func newSubscriberQueue(subscriber Subscriber, size int, policy OverflowPolicy, counters *notificationCounters, snapshot func() ([]Customer, error)) *subscriberQueue {
+	q := &subscriberQueue{
+		subscriber: subscriber,
+		size:       size,
+		policy:     policy,
+		counters:   counters,
+		snapshot:   snapshot,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		exit:       make(chan struct{}),
+	}
+
+	go q.run()
+	return q
+}
+
+// enqueue never blocks. It returns false when the subscriber was
+// disconnected and should be forgotten.
+func (q *subscriberQueue) enqueue(event ChangeEvent) bool {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return false
+	}
+	q.latest = event.Sequence
+
+	if len(q.pending) >= q.size {
+		switch q.policy {
+		case CoalesceLatest:
+			// the snapshot read later includes event, it takes its place,
+			// and that of the event it was already standing in for
+			coalesced := uint64(len(q.pending))
+			if q.snapshotPending {
+				coalesced++
+			}
+			q.counters.coalesced.Add(coalesced)
+			q.pending = q.pending[:0]
+			q.snapshotPending = true
+			q.mu.Unlock()
+			q.wakeUp()
+			return true
+		case DropOldest:
+			q.pending = q.pending[1:]
+			q.counters.dropped.Add(1)
+		case DisconnectSlow:
+			q.drop()
+			return false
+		}
+	}
+
+	q.pending = append(q.pending, event)
+	q.mu.Unlock()
+	q.wakeUp()
+
+	return true
+}
+
+func (q *subscriberQueue) wakeUp() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drop disconnects the subscriber, it must be called with mu held and
+// releases it.
+func (q *subscriberQueue) drop() {
+	q.pending = nil
+	q.snapshotPending = false
+	stopped := q.stopped
+	q.stopped = true
+	q.mu.Unlock()
+
+	q.counters.disconnected.Add(1)
+	if !stopped {
+		close(q.done)
+	}
+	if d, ok := q.subscriber.(disconnecter); ok {
+		d.disconnect()
+	}
+}
+
+func (q *subscriberQueue) pop() (ChangeEvent, bool) {
+	q.mu.Lock()
+	if q.snapshotPending {
+		q.snapshotPending = false
+		sequence := q.latest
+		q.mu.Unlock()
+		return q.catchUp(sequence)
+	}
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return ChangeEvent{}, false
+	}
+
+	event := q.pending[0]
+	q.pending = q.pending[1:]
+	return event, true
+}
+
+// catchUp reads the snapshot of a coalesced queue. Every change up to
+// sequence was stored before it was queued, so the snapshot holds them and
+// the events still queued for them are dropped.
+func (q *subscriberQueue) catchUp(sequence uint64) (ChangeEvent, bool) {
+	customers, err := q.snapshot()
+
+	q.mu.Lock()
+	if err != nil {
+		// without a snapshot the client can't catch up, it is better off
+		// reconnecting
+		log.Printf("cant fetch data :%q", err)
+		q.drop()
+		return ChangeEvent{}, false
+	}
+
+	kept := q.pending[:0]
+	for _, event := range q.pending {
+		if event.Sequence > sequence {
+			kept = append(kept, event)
+			continue
+		}
+		q.counters.coalesced.Add(1)
+	}
+	q.pending = kept
+	q.mu.Unlock()
+
+	return newSnapshotEvent(sequence, customers), true
+}
+
+func (q *subscriberQueue) deliverPending() {
+	for {
+		event, ok := q.pop()
+		if !ok {
+			return
+		}
+
+		q.subscriber.update(event)
+		q.counters.delivered.Add(1)
+	}
+}
+
+func (q *subscriberQueue) run() {
+	defer close(q.exit)
+
+	for {
+		select {
+		case <-q.wake:
+			q.deliverPending()
+		case <-q.done:
+			q.deliverPending()
+			return
+		}
+	}
+}
+
+// stop ends the delivery goroutine once it has flushed what is queued, and
+// waits for it to finish. Later notifications are refused.
+func (q *subscriberQueue) stop() {
+	q.mu.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.done)
+	}
+	q.mu.Unlock()
+
+	<-q.exit
+}
+
+// discard stops the queue without delivering anything still pending.
+func (q *subscriberQueue) discard() {
+	q.mu.Lock()
+	q.pending = nil
+	q.snapshotPending = false
+	q.mu.Unlock()
+
+	q.stop()
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockedSubscriber holds every delivery until it is released, recording
+// the type and sequence of each event it was handed.
+type blockedSubscriber struct {
+	id           string
+	started      chan struct{}
+	release      chan struct{}
+	received     []string
+	disconnected bool
+}
+
+func newBlockedSubscriber(id string) *blockedSubscriber {
+	return &blockedSubscriber{
+		id:      id,
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (b *blockedSubscriber) update(event ChangeEvent) {
+	b.started <- struct{}{}
+	<-b.release
+	b.received = append(b.received, fmt.Sprintf("%s#%d", event.Type, event.Sequence))
+}
+
+func (b *blockedSubscriber) getSubscriberId() string {
+	return b.id
+}
+
+func (b *blockedSubscriber) disconnect() {
+	b.disconnected = true
+}
+
+func Test_subscriberQueue_overflow(t *testing.T) {
+	tests := []struct {
+		name             string
+		policy           OverflowPolicy
+		size             int
+		events           uint64
+		noSnapshot       bool
+		wantAccepted     []bool
+		wantReceived     []string
+		wantStats        NotificationStats
+		wantDisconnected bool
+	}{
+		{
+			name:         "drop oldest",
+			policy:       DropOldest,
+			size:         2,
+			wantAccepted: []bool{true, true, true, true},
+			wantReceived: []string{"customer.updated#1", "customer.updated#3", "customer.updated#4"},
+			wantStats:    NotificationStats{Delivered: 3, Dropped: 1},
+		},
+		{
+			name:         "coalesce to latest snapshot",
+			policy:       CoalesceLatest,
+			size:         2,
+			wantAccepted: []bool{true, true, true, true},
+			wantReceived: []string{"customer.updated#1", "snapshot#4"},
+			wantStats:    NotificationStats{Delivered: 2, Coalesced: 2},
+		},
+		{
+			name:         "coalesce twice before the snapshot is read",
+			policy:       CoalesceLatest,
+			size:         2,
+			events:       7,
+			wantAccepted: []bool{true, true, true, true, true, true, true},
+			wantReceived: []string{"customer.updated#1", "snapshot#7"},
+			wantStats:    NotificationStats{Delivered: 2, Coalesced: 5},
+		},
+		{
+			name:             "coalesce without snapshot disconnects",
+			policy:           CoalesceLatest,
+			size:             2,
+			noSnapshot:       true,
+			wantAccepted:     []bool{true, true, true, true},
+			wantReceived:     []string{"customer.updated#1"},
+			wantStats:        NotificationStats{Delivered: 1, Coalesced: 2, Disconnected: 1},
+			wantDisconnected: true,
+		},
+		{
+			name:             "disconnect slow subscriber",
+			policy:           DisconnectSlow,
+			size:             1,
+			wantAccepted:     []bool{true, true, false, false},
+			wantReceived:     []string{"customer.updated#1"},
+			wantStats:        NotificationStats{Delivered: 1, Disconnected: 1},
+			wantDisconnected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscriber := newBlockedSubscriber("1")
+			counters := &notificationCounters{}
+			queue := newSubscriberQueue(subscriber, tt.size, tt.policy, counters, func() ([]Customer, error) {
+				if tt.noSnapshot {
+					return nil, errors.New("repo unavailable")
+				}
+				return nil, nil
+			})
+
+			events := tt.events
+			if events == 0 {
+				events = 4
+			}
+
+			gotAccepted := []bool{}
+			for seq := uint64(1); seq <= events; seq++ {
+				event := ChangeEvent{Type: EventCustomerUpdated, Sequence: seq}
+				gotAccepted = append(gotAccepted, queue.enqueue(event))
+
+				// wait for the first event to be in flight so the rest
+				// pile up in the queue
+				if seq == 1 {
+					<-subscriber.started
+				}
+			}
+
+			close(subscriber.release)
+			queue.stop()
+
+			assert.Equal(t, tt.wantAccepted, gotAccepted, "expected accepted events to be same")
+			assert.Equal(t, tt.wantReceived, subscriber.received, "expected delivered events to be same")
+			assert.Equal(t, tt.wantStats, counters.snapshot(), "expected stats to be same")
+			assert.Equal(t, tt.wantDisconnected, subscriber.disconnected, "expected disconnect state to be same")
+		})
+	}
+}
+
+func TestService_slowSubscriberDoesNotBlockWrites(t *testing.T) {
+	service := NewService(NewInMemoryRepo(), WithNotifyQueue(2, CoalesceLatest))
+	slow := newBlockedSubscriber("slow")
+	fast := newBlockedSubscriber("fast")
+	close(fast.release)
+	service.subscribe(context.Background(), slow)
+	service.subscribe(context.Background(), fast)
+
+	addCustomer := func() {
+		customer := Customer{CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}}
+		if _, err := service.addCustomer(context.Background(), customer); err != nil {
+			t.Errorf("add failed :%v", err)
+		}
+	}
+
+	addCustomer()
+	<-slow.started
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			addCustomer()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writes were blocked by a slow subscriber")
+	}
+
+	close(slow.release)
+	service.Close()
+
+	assert.Equal(t, []string{"customer.created#1", "snapshot#6"}, slow.received, "expected slow subscriber to skip to the latest snapshot")
+	assert.Contains(t, []string{"customer.created#6", "snapshot#6"}, fast.received[len(fast.received)-1], "expected fast subscriber to end on the latest change")
+
+	stats := service.NotificationStats()
+	assert.Equal(t, uint64(2*6), stats.Delivered+stats.Coalesced, "expected every event to be delivered or coalesced")
+}
+
+// blockedGetAllRepo holds every getAll until release is closed.
+type blockedGetAllRepo struct {
+	Repo
+	release chan struct{}
+}
+
+func (r *blockedGetAllRepo) getAll(ctx context.Context) ([]Customer, error) {
+	<-r.release
+	return r.Repo.getAll(ctx)
+}
+
+func TestService_coalescingDoesNotReadOnWrites(t *testing.T) {
+	repo := &blockedGetAllRepo{Repo: NewInMemoryRepo(), release: make(chan struct{})}
+	service := NewService(repo, WithNotifyQueue(1, CoalesceLatest))
+	slow := newBlockedSubscriber("slow")
+	service.subscribe(context.Background(), slow)
+
+	addCustomer := func() {
+		customer := Customer{CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}}
+		if _, err := service.addCustomer(context.Background(), customer); err != nil {
+			t.Errorf("add failed :%v", err)
+		}
+	}
+
+	addCustomer()
+	<-slow.started
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			addCustomer()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writes waited for the snapshot of a slow subscriber")
+	}
+
+	close(slow.release)
+	close(repo.release)
+	service.Close()
+
+	assert.Equal(t, []string{"customer.created#1", "snapshot#6"}, slow.received, "expected slow subscriber to catch up with a snapshot")
+}
+
+func TestService_subscribeWithSnapshot(t *testing.T) {
+	repo := &InMemoryRepo{customers: []Customer{
+		{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}},
+	}}
+	service := NewService(repo, WithIdGenerator(fixedId("vs")))
+
+	if err := service.deleteCustomer(context.Background(), "hs", 0); err != nil {
+		t.Fatalf("delete failed :%v", err)
+	}
+
+	subscriber := newMockSubscriber("1")
+	if err := service.subscribeWithSnapshot(context.Background(), subscriber); err != nil {
+		t.Fatalf("subscribe failed :%v", err)
+	}
+
+	created, err := service.addCustomer(context.Background(), Customer{CustomerDetails: CustomerDetails{Name: "varshil", Address: "udr", ContactNo: "+918888888888"}})
+	if err != nil {
+		t.Fatalf("add failed :%v", err)
+	}
+	service.Close()
+
+	wantEvents := []ChangeEvent{
+		{Type: EventSnapshot, Sequence: 1, Customers: []Customer{}},
+		{Type: EventCustomerCreated, Sequence: 2, Tenant: DefaultTenant, Customer: &created},
+	}
+	assert.Equal(t, wantEvents, subscriber.events, "expected snapshot followed by changes")
+}
+
+func Test_overflowPolicyByName(t *testing.T) {
+	policy, err := overflowPolicyByName("dropOldest")
+	assert.NoError(t, err, "expected known policy")
+	assert.Equal(t, DropOldest, policy, "expected policy to be same")
+
+	_, err = overflowPolicyByName("block")
+	assert.Error(t, err, "expected unknown policy to be rejected")
+}
+
+func TestService_resumeSubscription(t *testing.T) {
+	newCustomer := Customer{CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}}
+
+	tests := []struct {
+		name         string
+		changeLog    int
+		queueSize    int
+		since        uint64
+		wantReceived []string
+	}{
+		{
+			name:         "replays missed events",
+			changeLog:    10,
+			queueSize:    10,
+			since:        102,
+			wantReceived: []string{"customer.created#103", "customer.created#104", "customer.created#105"},
+		},
+		{
+			name:         "nothing missed",
+			changeLog:    10,
+			queueSize:    10,
+			since:        104,
+			wantReceived: []string{"customer.created#105"},
+		},
+		{
+			name:         "gap older than the change log",
+			changeLog:    2,
+			queueSize:    10,
+			since:        101,
+			wantReceived: []string{"snapshot#104", "customer.created#105"},
+		},
+		{
+			name:         "missed more than the queue holds",
+			changeLog:    10,
+			queueSize:    2,
+			since:        101,
+			wantReceived: []string{"snapshot#104", "customer.created#105"},
+		},
+		{
+			name:         "sequence from another run",
+			changeLog:    10,
+			queueSize:    10,
+			since:        7,
+			wantReceived: []string{"snapshot#104", "customer.created#105"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(NewInMemoryRepo(),
+				WithNotifyQueue(tt.queueSize, CoalesceLatest),
+				WithChangeLog(tt.changeLog, 100),
+			)
+
+			for i := 0; i < 4; i++ {
+				if _, err := service.addCustomer(context.Background(), newCustomer); err != nil {
+					t.Fatalf("add failed :%v", err)
+				}
+			}
+
+			subscriber := newBlockedSubscriber("1")
+			close(subscriber.release)
+			if err := service.resumeSubscription(context.Background(), subscriber, tt.since); err != nil {
+				t.Fatalf("resume failed :%v", err)
+			}
+
+			if _, err := service.addCustomer(context.Background(), newCustomer); err != nil {
+				t.Fatalf("add failed :%v", err)
+			}
+			service.Close()
+
+			assert.Equal(t, tt.wantReceived, subscriber.received, "expected received events to be same")
+		})
+	}
+}
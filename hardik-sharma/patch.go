@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPatch = errors.New("invalid patch")
+var ErrPatchTestFailed = errors.New("patch test failed")
+var ErrUnsupportedPatchFormat = errors.New("unsupported patch format")
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// DocumentPatch rewrites the JSON representation of a customer, decoded
+// into maps, slices and scalars the way encoding/json does for interface{}.
+type DocumentPatch func(doc interface{}) (interface{}, error)
+
+// patchParsers lists the patch formats accepted by content type.
+var patchParsers = map[string]func(body []byte) (DocumentPatch, error){
+	MergePatchContentType: ParseMergePatch,
+	JSONPatchContentType:  ParseJSONPatch,
+}
+
+func patchParserByContentType(contentType string) (func(body []byte) (DocumentPatch, error), error) {
+	parser, ok := patchParsers[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedPatchFormat, contentType)
+	}
+
+	return parser, nil
+}
+
+// CustomerPatch holds the fields a partial update changes, nil fields are
+// left alone.
+type CustomerPatch struct {
+	Name      *string
+	Address   *string
+	ContactNo *PhoneNumber
+}
+
+func (p CustomerPatch) isEmpty() bool {
+	return p.Name == nil && p.Address == nil && p.ContactNo == nil
+}
+
+func (p CustomerPatch) apply(customer Customer) Customer {
+	if p.Name != nil {
+		customer.CustomerDetails.Name = *p.Name
+	}
+
+	if p.Address != nil {
+		customer.CustomerDetails.Address = *p.Address
+	}
+
+	if p.ContactNo != nil {
+		customer.CustomerDetails.ContactNo = *p.ContactNo
+	}
+
+	return customer
+}
+
+// diffCustomers lists the fields in which after differs from before.
+func diffCustomers(before Customer, after Customer) CustomerPatch {
+	var p CustomerPatch
+	if after.CustomerDetails.Name != before.CustomerDetails.Name {
+		p.Name = &after.CustomerDetails.Name
+	}
+
+	if after.CustomerDetails.Address != before.CustomerDetails.Address {
+		p.Address = &after.CustomerDetails.Address
+	}
+
+	if after.CustomerDetails.ContactNo != before.CustomerDetails.ContactNo {
+		p.ContactNo = &after.CustomerDetails.ContactNo
+	}
+
+	return p
+}
+
+// patchedCustomer decodes a patched document, every member has to survive
+// the patch.
+type patchedCustomer struct {
+	Id              *string `json:"id"`
+	CustomerDetails *struct {
+		Name      *string      `json:"name"`
+		Address   *string      `json:"address"`
+		ContactNo *PhoneNumber `json:"contactNo"`
+	} `json:"customerDetails"`
+	Version *int64 `json:"version"`
+}
+
+// applyPatch runs patch against the JSON form of customer. The patch may
+// only change customer details, its id and version stay as they are.
+func applyPatch(customer Customer, patch DocumentPatch) (Customer, error) {
+	raw, err := json.Marshal(customer)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return Customer{}, err
+	}
+
+	doc, err = patch(doc)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	if raw, err = json.Marshal(doc); err != nil {
+		return Customer{}, ErrInvalidPatch
+	}
+
+	var patched patchedCustomer
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return Customer{}, ErrInvalidPatch
+	}
+
+	details := patched.CustomerDetails
+	if patched.Id == nil || patched.Version == nil || details == nil ||
+		details.Name == nil || details.Address == nil || details.ContactNo == nil {
+		return Customer{}, ErrInvalidPatch
+	}
+
+	if *patched.Id != customer.Id || *patched.Version != customer.Version {
+		return Customer{}, ErrInvalidPatch
+	}
+
+	customer.CustomerDetails = CustomerDetails{
+		Name:      *details.Name,
+		Address:   *details.Address,
+		ContactNo: *details.ContactNo,
+	}
+	return customer, nil
+}
+
+// ParseMergePatch reads a JSON Merge Patch as described in RFC 7396.
+func ParseMergePatch(body []byte) (DocumentPatch, error) {
+	var patch interface{}
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	return func(doc interface{}) (interface{}, error) {
+		return mergePatch(doc, patch), nil
+	}, nil
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+
+	return targetObject
+}
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ParseJSONPatch reads a JSON Patch as described in RFC 6902. Operations
+// apply in order and the whole patch fails when one of them does.
+func ParseJSONPatch(body []byte) (DocumentPatch, error) {
+	var operations []patchOperation
+	if err := json.Unmarshal(body, &operations); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	for _, operation := range operations {
+		if _, err := parsePointer(operation.Path); err != nil {
+			return nil, err
+		}
+
+		switch operation.Op {
+		case "add", "replace", "test":
+			if operation.Value == nil {
+				return nil, ErrInvalidPatch
+			}
+		case "move", "copy":
+			if _, err := parsePointer(operation.From); err != nil {
+				return nil, err
+			}
+		case "remove":
+		default:
+			return nil, ErrInvalidPatch
+		}
+	}
+
+	return func(doc interface{}) (interface{}, error) {
+		for _, operation := range operations {
+			var err error
+			if doc, err = operation.apply(doc); err != nil {
+				return nil, err
+			}
+		}
+		return doc, nil
+	}, nil
+}
+
+func (o patchOperation) apply(doc interface{}) (interface{}, error) {
+	path, _ := parsePointer(o.Path)
+
+	var value interface{}
+	if o.Value != nil {
+		if err := json.Unmarshal(o.Value, &value); err != nil {
+			return nil, ErrInvalidPatch
+		}
+	}
+
+	switch o.Op {
+	case "add":
+		return addValue(doc, path, value)
+	case "remove":
+		return removeValue(doc, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+
+		doc, err := removeValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "test":
+		current, err := lookup(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrPatchTestFailed
+		}
+		return doc, nil
+	}
+
+	// move and copy
+	from, _ := parsePointer(o.From)
+	value, err := lookup(doc, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Op == "copy" {
+		return addValue(doc, path, deepCopy(value))
+	}
+
+	if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+		// a value can't be moved into one of its own children
+		return nil, ErrInvalidPatch
+	}
+
+	if doc, err = removeValue(doc, from); err != nil {
+		return nil, err
+	}
+	return addValue(doc, path, value)
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped
+// reference tokens. The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrInvalidPatch
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrInvalidPatch
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index >= length {
+		return 0, ErrInvalidPatch
+	}
+
+	return index, nil
+}
+
+func lookup(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, ErrInvalidPatch
+			}
+			doc = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			doc = node[index]
+		default:
+			return nil, ErrInvalidPatch
+		}
+	}
+
+	return doc, nil
+}
+
+// modify rebuilds doc with change applied to the container holding the last
+// token of path, which is never empty.
+func modify(doc interface{}, path []string, change func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return change(doc, path[0])
+	}
+
+	child, err := lookup(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = modify(child, path[1:], change)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		index, _ := arrayIndex(path[0], len(node))
+		node[index] = child
+	}
+
+	return doc, nil
+}
+
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return modify(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			if token == "-" {
+				return append(node, value), nil
+			}
+
+			index, err := arrayIndex(token, len(node)+1)
+			if err != nil {
+				return nil, err
+			}
+
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+
+		return nil, ErrInvalidPatch
+	})
+}
+
+func removeValue(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, ErrInvalidPatch
+	}
+
+	return modify(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, ErrInvalidPatch
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:index:index], node[index+1:]...), nil
+		}
+
+		return nil, ErrInvalidPatch
+	})
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(node))
+		for name, child := range node {
+			copied[name] = deepCopy(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(node))
+		for i, child := range node {
+			copied[i] = deepCopy(child)
+		}
+		return copied
+	}
+
+	return value
+}
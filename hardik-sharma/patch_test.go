@@ -0,0 +1,239 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var patchFixture = Customer{
+	Id: "hs",
+	CustomerDetails: CustomerDetails{
+		Name:      "hardik",
+		Address:   "udaipur",
+		ContactNo: "+919999999999",
+	},
+	Version: 3,
+}
+
+func Test_applyPatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		patch        string
+		wantCustomer Customer
+		wantErr      error
+	}{
+		{
+			name:        "merge patch changes one field",
+			contentType: MergePatchContentType,
+			patch:       `{"customerDetails": {"address": "jaipur"}}`,
+			wantCustomer: Customer{
+				Id:              "hs",
+				CustomerDetails: CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+919999999999"},
+				Version:         3,
+			},
+		},
+		{
+			name:        "merge patch repeating the id",
+			contentType: MergePatchContentType,
+			patch:       `{"id": "hs", "customerDetails": {"contactNo": "+918888888888"}}`,
+			wantCustomer: Customer{
+				Id:              "hs",
+				CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+918888888888"},
+				Version:         3,
+			},
+		},
+		{
+			name:        "merge patch removing a field",
+			contentType: MergePatchContentType,
+			patch:       `{"customerDetails": {"name": null}}`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "merge patch changing the id",
+			contentType: MergePatchContentType,
+			patch:       `{"id": "vs"}`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "merge patch changing the version",
+			contentType: MergePatchContentType,
+			patch:       `{"version": 9}`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "merge patch with unknown field",
+			contentType: MergePatchContentType,
+			patch:       `{"customerDetails": {"email": "h@example.com"}}`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "merge patch with wrong type",
+			contentType: MergePatchContentType,
+			patch:       `{"customerDetails": {"contactNo": true}}`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "merge patch replacing the document",
+			contentType: MergePatchContentType,
+			patch:       `[]`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "json patch replace after test",
+			contentType: JSONPatchContentType,
+			patch: `[
+				{"op": "test", "path": "/customerDetails/address", "value": "udaipur"},
+				{"op": "replace", "path": "/customerDetails/address", "value": "jaipur"}
+			]`,
+			wantCustomer: Customer{
+				Id:              "hs",
+				CustomerDetails: CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+919999999999"},
+				Version:         3,
+			},
+		},
+		{
+			name:        "json patch copy",
+			contentType: JSONPatchContentType,
+			patch:       `[{"op": "copy", "from": "/customerDetails/name", "path": "/customerDetails/address"}]`,
+			wantCustomer: Customer{
+				Id:              "hs",
+				CustomerDetails: CustomerDetails{Name: "hardik", Address: "hardik", ContactNo: "+919999999999"},
+				Version:         3,
+			},
+		},
+		{
+			name:        "json patch move and add back",
+			contentType: JSONPatchContentType,
+			patch: `[
+				{"op": "move", "from": "/customerDetails/name", "path": "/customerDetails/address"},
+				{"op": "add", "path": "/customerDetails/name", "value": "varshil"}
+			]`,
+			wantCustomer: Customer{
+				Id:              "hs",
+				CustomerDetails: CustomerDetails{Name: "varshil", Address: "hardik", ContactNo: "+919999999999"},
+				Version:         3,
+			},
+		},
+		{
+			name:        "json patch adding an unknown member",
+			contentType: JSONPatchContentType,
+			patch:       `[{"op": "add", "path": "/customerDetails/a~1b", "value": 1}]`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "json patch failing test",
+			contentType: JSONPatchContentType,
+			patch:       `[{"op": "test", "path": "/version", "value": 2}]`,
+			wantErr:     ErrPatchTestFailed,
+		},
+		{
+			name:        "json patch removing a field",
+			contentType: JSONPatchContentType,
+			patch:       `[{"op": "remove", "path": "/customerDetails/contactNo"}]`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "json patch replacing a missing member",
+			contentType: JSONPatchContentType,
+			patch:       `[{"op": "replace", "path": "/customerDetails/email", "value": "h@example.com"}]`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "json patch with relative pointer",
+			contentType: JSONPatchContentType,
+			patch:       `[{"op": "replace", "path": "customerDetails/name", "value": "varshil"}]`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "json patch with unknown operation",
+			contentType: JSONPatchContentType,
+			patch:       `[{"op": "merge", "path": "/customerDetails", "value": {}}]`,
+			wantErr:     ErrInvalidPatch,
+		},
+		{
+			name:        "json patch without value",
+			contentType: JSONPatchContentType,
+			patch:       `[{"op": "add", "path": "/customerDetails/name"}]`,
+			wantErr:     ErrInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parse, err := patchParserByContentType(tt.contentType)
+			if err != nil {
+				t.Fatalf("no parser :%v", err)
+			}
+
+			var gotCustomer Customer
+			patch, gotErr := parse([]byte(tt.patch))
+			if gotErr == nil {
+				gotCustomer, gotErr = applyPatch(patchFixture, patch)
+			}
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
+
+			assert.Equal(t, tt.wantCustomer, gotCustomer, "expected patched customer to be same")
+		})
+	}
+}
+
+func Test_jsonPatchArrays(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		wantDoc interface{}
+		wantErr error
+	}{
+		{
+			name:    "insert before index",
+			patch:   `[{"op": "add", "path": "/1", "value": "x"}]`,
+			wantDoc: []interface{}{"a", "x", "b"},
+		},
+		{
+			name:    "append",
+			patch:   `[{"op": "add", "path": "/-", "value": "x"}]`,
+			wantDoc: []interface{}{"a", "b", "x"},
+		},
+		{
+			name:    "remove element",
+			patch:   `[{"op": "remove", "path": "/0"}]`,
+			wantDoc: []interface{}{"b"},
+		},
+		{
+			name:    "index out of range",
+			patch:   `[{"op": "replace", "path": "/2", "value": "x"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "index with leading zero",
+			patch:   `[{"op": "remove", "path": "/01"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := ParseJSONPatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("parsing failed :%v", err)
+			}
+
+			gotDoc, gotErr := patch([]interface{}{"a", "b"})
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
+
+			if tt.wantErr == nil {
+				assert.Equal(t, tt.wantDoc, gotDoc, "expected document to be same")
+			}
+		})
+	}
+}
+
+func Test_patchParserByContentType(t *testing.T) {
+	_, err := patchParserByContentType("application/json")
+
+	assert.ErrorIs(t, err, ErrUnsupportedPatchFormat, "expected plain json to be rejected")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const DefaultPhoneRegion = "IN"
+
+// PhoneNumber is a contact number in E.164 form, such as +919876543210.
+// Older clients send contact numbers as JSON numbers, which are still
+// accepted and normalized like any number written without country code.
+type PhoneNumber string
+
+func (p *PhoneNumber) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '"' && string(data) != "null" {
+		if _, err := strconv.ParseUint(string(data), 10, 64); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidContactNo, data)
+		}
+		*p = PhoneNumber(data)
+		return nil
+	}
+
+	return json.Unmarshal(data, (*string)(p))
+}
+
+// phoneRegion describes the numbering plan of a region, as far as needed to
+// tell whether a number is plausible.
+type phoneRegion struct {
+	callingCode string
+	// trunkPrefix is dialled before national numbers and not part of the
+	// international form
+	trunkPrefix string
+	minLength   int
+	maxLength   int
+}
+
+// phoneRegions lists regions by ISO 3166 code. Numbers from other regions
+// are accepted in international form with only the E.164 length checked.
+var phoneRegions = map[string]phoneRegion{
+	"IN": {callingCode: "91", trunkPrefix: "0", minLength: 10, maxLength: 10},
+	"US": {callingCode: "1", minLength: 10, maxLength: 10},
+	"CA": {callingCode: "1", minLength: 10, maxLength: 10},
+	"GB": {callingCode: "44", trunkPrefix: "0", minLength: 9, maxLength: 10},
+	"DE": {callingCode: "49", trunkPrefix: "0", minLength: 6, maxLength: 13},
+	"FR": {callingCode: "33", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"AU": {callingCode: "61", trunkPrefix: "0", minLength: 9, maxLength: 9},
+	"SG": {callingCode: "65", minLength: 8, maxLength: 8},
+	"AE": {callingCode: "971", trunkPrefix: "0", minLength: 8, maxLength: 9},
+}
+
+const (
+	minE164Digits = 8
+	maxE164Digits = 15
+)
+
+func checkPhoneRegion(region string) error {
+	if _, ok := phoneRegions[region]; !ok {
+		return fmt.Errorf("unknown phone region %q", region)
+	}
+
+	return nil
+}
+
+// NormalizePhoneNumber returns number in E.164 form. Numbers starting with
+// + or 00 carry their country code, any other number is read as a national
+// number of defaultRegion. Spaces, dots, dashes and parentheses are ignored.
+func NormalizePhoneNumber(number string, defaultRegion string) (PhoneNumber, error) {
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(number))
+
+	international := strings.HasPrefix(digits, "+")
+	if international {
+		digits = digits[1:]
+	} else if strings.HasPrefix(digits, "00") {
+		international = true
+		digits = digits[2:]
+	}
+
+	if digits == "" || strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return "", ErrInvalidContactNo
+	}
+
+	if !international {
+		region, ok := phoneRegions[defaultRegion]
+		if !ok {
+			return "", ErrInvalidContactNo
+		}
+
+		if region.trunkPrefix != "" && strings.HasPrefix(digits, region.trunkPrefix) {
+			digits = digits[len(region.trunkPrefix):]
+		}
+		digits = region.callingCode + digits
+	}
+
+	if !plausibleNumber(digits) {
+		return "", ErrInvalidContactNo
+	}
+
+	return PhoneNumber("+" + digits), nil
+}
+
+// plausibleNumber checks the digits of an international number against the
+// numbering plan of its calling code, when that is known.
+func plausibleNumber(digits string) bool {
+	if len(digits) < minE164Digits || len(digits) > maxE164Digits || digits[0] == '0' {
+		return false
+	}
+
+	for _, region := range phoneRegions {
+		if national, ok := strings.CutPrefix(digits, region.callingCode); ok {
+			return len(national) >= region.minLength && len(national) <= region.maxLength &&
+				!strings.HasPrefix(national, "0")
+		}
+	}
+
+	return true
+}
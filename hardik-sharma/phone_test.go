@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		number  string
+		region  string
+		want    PhoneNumber
+		wantErr error
+	}{
+		{
+			name:   "national number of the default region",
+			number: "9649127550",
+			region: "IN",
+			want:   "+919649127550",
+		},
+		{
+			name:   "trunk prefix is dropped",
+			number: "09649127550",
+			region: "IN",
+			want:   "+919649127550",
+		},
+		{
+			name:   "formatting is ignored",
+			number: "(415) 555-0132",
+			region: "US",
+			want:   "+14155550132",
+		},
+		{
+			name:   "international number ignores the default region",
+			number: "+44 20 7946 0958",
+			region: "IN",
+			want:   "+442079460958",
+		},
+		{
+			name:   "00 international prefix",
+			number: "0044 20 7946 0958",
+			region: "IN",
+			want:   "+442079460958",
+		},
+		{
+			name:   "unlisted country code",
+			number: "+81 3 1234 5678",
+			region: "IN",
+			want:   "+81312345678",
+		},
+		{
+			name:    "wrong length for its country",
+			number:  "+91 96491",
+			region:  "IN",
+			wantErr: ErrInvalidContactNo,
+		},
+		{
+			name:    "letters",
+			number:  "96491-CALL",
+			region:  "IN",
+			wantErr: ErrInvalidContactNo,
+		},
+		{
+			name:    "too long for E.164",
+			number:  "+1234567890123456",
+			region:  "IN",
+			wantErr: ErrInvalidContactNo,
+		},
+		{
+			name:    "national number without region",
+			number:  "9649127550",
+			region:  "",
+			wantErr: ErrInvalidContactNo,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhoneNumber(tt.number, tt.region)
+
+			assert.ErrorIs(t, err, tt.wantErr, "expected error to be same")
+
+			assert.Equal(t, tt.want, got, "expected number to be same")
+		})
+	}
+}
+
+func TestPhoneNumber_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    PhoneNumber
+		wantErr bool
+	}{
+		{
+			name: "string",
+			data: `"+919649127550"`,
+			want: "+919649127550",
+		},
+		{
+			name: "legacy number",
+			data: `9649127550`,
+			want: "9649127550",
+		},
+		{
+			name:    "fraction",
+			data:    `96491.5`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got PhoneNumber
+			err := json.Unmarshal([]byte(tt.data), &got)
+
+			assert.Equal(t, tt.wantErr, err != nil, "expected error to be reported")
+
+			assert.Equal(t, tt.want, got, "expected number to be same")
+		})
+	}
+}
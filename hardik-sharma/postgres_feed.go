@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// changeChannel is the channel the customers table trigger notifies on.
+const changeChannel = "customer_changes"
+
+const (
+	changeFeedPingInterval = 15 * time.Second
+	changeFeedRetryDelay   = time.Second
+)
+
+// postgresChangeFeed receives the notifications sent by the trigger on the
+// customers table. The trigger numbers changes from a database sequence in
+// commit order, which keeps the numbering consistent across instances.
+type postgresChangeFeed struct {
+	db *bun.DB
+}
+
+func NewPostgresChangeFeed(db *bun.DB) *postgresChangeFeed {
+	return &postgresChangeFeed{
+		db: db,
+	}
+}
+
+func (f *postgresChangeFeed) listen(ctx context.Context, publish func(ChangeEvent), lost func()) {
+	for {
+		err := f.receive(ctx, publish)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// notifications sent while no connection was listening are gone
+		log.Println("change feed interrupted:", err)
+		lost()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(changeFeedRetryDelay):
+		}
+	}
+}
+
+// receive listens on a fresh connection until it fails. A connection that
+// stays quiet is pinged, and given up on when not even the ping arrives.
+func (f *postgresChangeFeed) receive(ctx context.Context, publish func(ChangeEvent)) error {
+	ln := pgdriver.NewListener(f.db)
+	defer ln.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		ln.Close()
+	})
+	defer stop()
+
+	if err := ln.Listen(ctx, changeChannel); err != nil {
+		return err
+	}
+
+	pinged := false
+	for {
+		_, payload, err := ln.ReceiveTimeout(ctx, changeFeedPingInterval)
+		if err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() || pinged {
+				return err
+			}
+
+			if err := pgdriver.Notify(ctx, f.db, changeChannel, ""); err != nil {
+				return err
+			}
+			pinged = true
+			continue
+		}
+		pinged = false
+
+		if payload == "" {
+			continue
+		}
+
+		// the tenant is part of the payload, but never sent on to clients
+		var notification struct {
+			ChangeEvent
+			Tenant string `json:"tenant"`
+		}
+		if err := json.Unmarshal([]byte(payload), &notification); err != nil {
+			return fmt.Errorf("decoding change notification: %w", err)
+		}
+
+		event := notification.ChangeEvent
+		event.Tenant = notification.Tenant
+		publish(event)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+// listenForChanges runs a postgres change feed until the test ends. The
+// listener connects in the background, so a probe customer is written until
+// its changes come through.
+func listenForChanges(t *testing.T, db *bun.DB) <-chan ChangeEvent {
+	events := make(chan ChangeEvent, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewPostgresChangeFeed(db).listen(ctx, func(event ChangeEvent) {
+			events <- event
+		}, func() {})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	repo := NewPostgresRepo(db)
+	probe := Customer{Id: "pr", CustomerDetails: CustomerDetails{Name: "probe", Address: "probe", ContactNo: "+911111111111"}}
+	deadline := time.After(5 * time.Second)
+	for ready := false; !ready; {
+		if err := repo.create(ctx, probe, conformanceChange); err != nil {
+			t.Fatal("failed to add probe:", err)
+		}
+		if _, err := repo.delete(ctx, probe.Id, 0, conformanceChange); err != nil {
+			t.Fatal("failed to delete probe:", err)
+		}
+		if _, err := repo.purge(ctx, conformanceDeletedAt.Add(time.Minute)); err != nil {
+			t.Fatal("failed to purge probe:", err)
+		}
+
+		select {
+		case <-events:
+			ready = true
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("change feed never started listening")
+		}
+	}
+
+	for {
+		select {
+		case <-events:
+		case <-time.After(200 * time.Millisecond):
+			return events
+		}
+	}
+}
+
+func Test_postgresChangeFeed(t *testing.T) {
+	db := setupDB(t, nil)
+	events := listenForChanges(t, db)
+	repo := NewPostgresRepo(db)
+
+	customer := Customer{Id: "ht", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}, Version: 1}
+	updated := Customer{Id: "ht", CustomerDetails: CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+919999999999"}, Version: 2}
+
+	if err := repo.create(context.Background(), customer, conformanceChange); err != nil {
+		t.Fatal("create failed:", err)
+	}
+	if _, err := repo.update(context.Background(), updated.Id, updated, 0, conformanceChange); err != nil {
+		t.Fatal("update failed:", err)
+	}
+	if _, err := repo.delete(context.Background(), updated.Id, 0, conformanceChange); err != nil {
+		t.Fatal("delete failed:", err)
+	}
+	if _, err := repo.restore(context.Background(), updated.Id, 0, conformanceChange); err != nil {
+		t.Fatal("restore failed:", err)
+	}
+	if _, err := repo.delete(context.Background(), updated.Id, 0, conformanceChange); err != nil {
+		t.Fatal("delete failed:", err)
+	}
+	// purging is not announced, the purged customer was deleted already
+	if _, err := repo.purge(context.Background(), conformanceDeletedAt.Add(time.Minute)); err != nil {
+		t.Fatal("purge failed:", err)
+	}
+	if err := repo.create(context.Background(), customer, conformanceChange); err != nil {
+		t.Fatal("create failed:", err)
+	}
+
+	deleted := trashed(updated, conformanceDeletedAt)
+	wantEvents := []ChangeEvent{
+		{Type: EventCustomerCreated, Tenant: DefaultTenant, Customer: &customer},
+		{Type: EventCustomerUpdated, Tenant: DefaultTenant, Customer: &updated},
+		{Type: EventCustomerDeleted, Tenant: DefaultTenant, Customer: &deleted},
+		{Type: EventCustomerRestored, Tenant: DefaultTenant, Customer: &updated},
+		{Type: EventCustomerDeleted, Tenant: DefaultTenant, Customer: &deleted},
+		{Type: EventCustomerCreated, Tenant: DefaultTenant, Customer: &customer},
+	}
+
+	var lastSeq uint64
+	for _, want := range wantEvents {
+		select {
+		case got := <-events:
+			assert.Greater(t, got.Sequence, lastSeq, "expected sequence numbers to grow")
+			lastSeq = got.Sequence
+
+			got.Sequence = 0
+			if got.Customer != nil {
+				*got.Customer = inUTC(*got.Customer)
+			}
+			assert.Equal(t, want, got, "expected change event to be same")
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for", want.Type)
+		}
+	}
+}
@@ -4,23 +4,75 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/driver/pgdriver"
 )
 
+// postgresRepo writes return ?Columns rather than *, Customer has no tenant
+// to read it into.
 type postgresRepo struct {
-	db *bun.DB
+	db               *bun.DB
+	rowLevelSecurity bool
 }
 
-func NewPostgresRepo(db *bun.DB) *postgresRepo {
-	return &postgresRepo{
+type PostgresRepoOption func(*postgresRepo)
+
+// WithRowLevelSecurity sets customers.tenant_id in every transaction for
+// the row level security policies to check.
+func WithRowLevelSecurity() PostgresRepoOption {
+	return func(repo *postgresRepo) {
+		repo.rowLevelSecurity = true
+	}
+}
+
+func NewPostgresRepo(db *bun.DB, opts ...PostgresRepoOption) *postgresRepo {
+	repo := &postgresRepo{
 		db: db,
 	}
+	for _, opt := range opts {
+		opt(repo)
+	}
+	return repo
+}
+
+// inTx runs f in a transaction, which tells the row level security
+// policies the tenant of ctx when they are enforced.
+func (repo *postgresRepo) inTx(ctx context.Context, f func(ctx context.Context, tx bun.Tx) error) error {
+	return repo.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if repo.rowLevelSecurity {
+			if _, err := tx.ExecContext(ctx, "SELECT set_config('customers.tenant_id', ?, true)", tenantFromContext(ctx)); err != nil {
+				return err
+			}
+		}
+		return f(ctx, tx)
+	})
+}
+
+// run runs the queries of f right on the database, or in a transaction of
+// inTx when row level security is enforced.
+func (repo *postgresRepo) run(ctx context.Context, f func(ctx context.Context, db bun.IDB) error) error {
+	if !repo.rowLevelSecurity {
+		return f(ctx, repo.db)
+	}
+
+	return repo.inTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		return f(ctx, tx)
+	})
 }
 
-func (repo *postgresRepo) create(customer Customer) error {
-	if _, err := repo.db.NewInsert().Model(&customer).Exec(context.Background()); err != nil {
+func (repo *postgresRepo) create(ctx context.Context, customer Customer, change Change) error {
+	err := repo.inTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewInsert().Model(&customer).Value("tenant_id", "?", tenantFromContext(ctx)).Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionCreated, customer, nil, &customer.CustomerDetails, change))
+	})
+	if err != nil {
 		var pgdriverErr pgdriver.Error
 		if errors.As(err, &pgdriverErr) && pgdriverErr.IntegrityViolation() {
 			return ErrConflict
@@ -32,18 +84,142 @@ func (repo *postgresRepo) create(customer Customer) error {
 	return nil
 }
 
-func (repo *postgresRepo) getAll() ([]Customer, error) {
+func (repo *postgresRepo) getAll(ctx context.Context) ([]Customer, error) {
 	customers := []Customer{}
-	if err := repo.db.NewSelect().Model(&customers).Scan(context.Background()); err != nil {
+	err := repo.run(ctx, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(&customers).
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("deleted_at IS NULL").
+			Scan(ctx)
+	})
+	if err != nil {
 		return customers, err
 	}
 
 	return customers, nil
 }
 
-func (repo *postgresRepo) getById(id string) (Customer, error) {
+func (repo *postgresRepo) list(ctx context.Context, opts ListOptions) (CustomerPage, error) {
+	column := sortColumns[opts.SortBy]
+	direction, comparison := "ASC", ">"
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	customers := []Customer{}
+	err := repo.run(ctx, func(ctx context.Context, db bun.IDB) error {
+		query := db.NewSelect().
+			Model(&customers).
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("deleted_at IS NULL")
+
+		if opts.Name != "" {
+			query = query.Where("customerdetails_name ILIKE ?", likePattern(opts.Name))
+		}
+
+		if opts.Address != "" {
+			query = query.Where("customerdetails_address ILIKE ?", likePattern(opts.Address))
+		}
+
+		if opts.ContactNo != "" {
+			query = query.Where("customerdetails_contact_no = ?", opts.ContactNo)
+		}
+
+		if opts.Cursor != "" {
+			cursor, err := decodeCursor(opts)
+			if err != nil {
+				return err
+			}
+
+			query = query.Where("(?, id) "+comparison+" (?, ?)", bun.Ident(column), cursor.Key, cursor.Id)
+		}
+
+		return query.
+			OrderExpr("? "+direction, bun.Ident(column)).
+			OrderExpr("id " + direction).
+			Limit(opts.Limit + 1).
+			Scan(ctx)
+	})
+	if err != nil {
+		return CustomerPage{}, err
+	}
+
+	return newCustomerPage(customers, opts), nil
+}
+
+// likePattern builds a substring ILIKE pattern, escaping wildcards in the
+// user supplied term.
+func likePattern(term string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term)
+	return "%" + escaped + "%"
+}
+
+// searchDocument is the expression indexed by customers_search_idx, it has
+// to be repeated verbatim for the index to be used.
+const searchDocument = "setweight(to_tsvector('simple', coalesce(customerdetails_name, '')), 'A') || " +
+	"setweight(to_tsvector('simple', coalesce(customerdetails_address, '')), 'B')"
+
+type rankedCustomer struct {
+	Customer `bun:",extend"`
+	Rank     float64 `bun:"rank"`
+}
+
+// search narrows customers down through the full text and trigram indexes
+// and ranks them in Go.
+func (repo *postgresRepo) search(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	prefixes := make([]string, len(opts.Terms))
+	for i, term := range opts.Terms {
+		prefixes[i] = term + ":*"
+	}
+	tsquery := strings.Join(prefixes, " & ")
+	text := strings.Join(opts.Terms, " ")
+
+	rows := []rankedCustomer{}
+	err := repo.run(ctx, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(&rows).
+			ColumnExpr("?TableColumns").
+			ColumnExpr("ts_rank("+searchDocument+", to_tsquery('simple', ?)) + "+
+				"greatest(word_similarity(?, customerdetails_name), word_similarity(?, customerdetails_address)) AS rank",
+				tsquery, text, text).
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("deleted_at IS NULL").
+			WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.
+					Where("("+searchDocument+") @@ to_tsquery('simple', ?)", tsquery).
+					WhereOr("? <% customerdetails_name", text).
+					WhereOr("? <% customerdetails_address", text)
+			}).
+			OrderExpr("rank DESC").
+			OrderExpr("id").
+			Limit(opts.Limit).
+			Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]SearchHit, len(rows))
+	for i, row := range rows {
+		_, _, highlights := scoreCustomer(row.Customer, opts.Terms)
+		hits[i] = SearchHit{Customer: row.Customer, Score: row.Rank, Highlights: highlights}
+	}
+
+	return hits, nil
+}
+
+func (repo *postgresRepo) getById(ctx context.Context, id string) (Customer, error) {
 	var customer Customer
-	if err := repo.db.NewSelect().Model(&customer).Where("id = ?", id).Scan(context.Background()); err != nil {
+	err := repo.run(ctx, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(&customer).
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("id = ?", id).
+			Where("deleted_at IS NULL").
+			Scan(ctx)
+	})
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Customer{}, ErrNotFound
 		}
@@ -54,38 +230,217 @@ func (repo *postgresRepo) getById(id string) (Customer, error) {
 	return customer, nil
 }
 
-func (repo *postgresRepo) update(id string, customer Customer) error {
-	res, err := repo.db.NewUpdate().Model(&customer).Where("id = ?", id).Exec(context.Background())
+func (repo *postgresRepo) update(ctx context.Context, id string, customer Customer, ifVersion int64, change Change) (Customer, error) {
+	err := repo.inTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		existing, err := repo.lock(ctx, tx, id, false, ifVersion)
+		if err != nil {
+			return err
+		}
+
+		details := customer.CustomerDetails
+		_, err = tx.NewUpdate().
+			Model(&customer).
+			Set("customerdetails_name = ?", details.Name).
+			Set("customerdetails_address = ?", details.Address).
+			Set("customerdetails_contact_no = ?", details.ContactNo).
+			Set("version = version + 1").
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("id = ?", id).
+			Returning("?Columns").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionUpdated, customer, &existing.CustomerDetails, &customer.CustomerDetails, change))
+	})
 	if err != nil {
-		return err
+		return Customer{}, err
 	}
 
-	rowsAffectCount, err := res.RowsAffected()
+	return customer, nil
+}
+
+// patch only sets the columns of fields present in p.
+func (repo *postgresRepo) patch(ctx context.Context, id string, p CustomerPatch, ifVersion int64, change Change) (Customer, error) {
+	var customer Customer
+	err := repo.inTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		existing, err := repo.lock(ctx, tx, id, false, ifVersion)
+		if err != nil {
+			return err
+		}
+
+		query := tx.NewUpdate().
+			Model(&customer).
+			Set("version = version + 1").
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("id = ?", id).
+			Returning("?Columns")
+
+		if p.Name != nil {
+			query = query.Set("customerdetails_name = ?", *p.Name)
+		}
+
+		if p.Address != nil {
+			query = query.Set("customerdetails_address = ?", *p.Address)
+		}
+
+		if p.ContactNo != nil {
+			query = query.Set("customerdetails_contact_no = ?", *p.ContactNo)
+		}
+
+		if _, err := query.Exec(ctx); err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionUpdated, customer, &existing.CustomerDetails, &customer.CustomerDetails, change))
+	})
 	if err != nil {
-		return err
+		return Customer{}, err
 	}
 
-	if rowsAffectCount == 0 {
-		return ErrNotFound
+	return customer, nil
+}
+
+func (repo *postgresRepo) delete(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	var customer Customer
+	err := repo.inTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := repo.lock(ctx, tx, id, false, ifVersion); err != nil {
+			return err
+		}
+
+		_, err := tx.NewUpdate().
+			Model(&customer).
+			Set("deleted_at = ?", change.At).
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("id = ?", id).
+			Returning("?Columns").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionDeleted, customer, &customer.CustomerDetails, nil, change))
+	})
+	if err != nil {
+		return Customer{}, err
 	}
 
-	return nil
+	return customer, nil
 }
 
-func (repo *postgresRepo) delete(id string) error {
-	res, err := repo.db.NewDelete().Model((*Customer)(nil)).Where("id = ?", id).Exec(context.Background())
+func (repo *postgresRepo) trash(ctx context.Context) ([]Customer, error) {
+	customers := []Customer{}
+	err := repo.run(ctx, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(&customers).
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("deleted_at IS NOT NULL").
+			OrderExpr("deleted_at DESC").
+			OrderExpr("id").
+			Scan(ctx)
+	})
 	if err != nil {
-		return err
+		return customers, err
 	}
 
-	rowAffectCount, err := res.RowsAffected()
+	return customers, nil
+}
+
+func (repo *postgresRepo) restore(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	var customer Customer
+	err := repo.inTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := repo.lock(ctx, tx, id, true, ifVersion); err != nil {
+			return err
+		}
+
+		_, err := tx.NewUpdate().
+			Model(&customer).
+			Set("deleted_at = NULL").
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("id = ?", id).
+			Returning("?Columns").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionRestored, customer, nil, &customer.CustomerDetails, change))
+	})
 	if err != nil {
+		return Customer{}, err
+	}
+
+	return customer, nil
+}
+
+// purge leaves removing the history of purged customers to the foreign
+// key of customer_revisions.
+func (repo *postgresRepo) purge(ctx context.Context, before time.Time) ([]purgedCustomer, error) {
+	var purged []purgedCustomer
+	err := repo.run(ctx, func(ctx context.Context, db bun.IDB) error {
+		query := db.NewDelete().
+			Model((*Customer)(nil)).
+			Where("deleted_at < ?", before).
+			Returning("tenant_id, id")
+		if tenant := tenantFromContext(ctx); tenant != allTenants {
+			query = query.Where("tenant_id = ?", tenant)
+		}
+
+		return query.Scan(ctx, &purged)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, nil
+}
+
+func (repo *postgresRepo) history(ctx context.Context, id string) ([]Revision, error) {
+	var revisions []Revision
+	err := repo.run(ctx, func(ctx context.Context, db bun.IDB) error {
+		var err error
+		revisions, err = selectHistory(ctx, db, id)
 		return err
+	})
+	return revisions, err
+}
+
+func (repo *postgresRepo) asOf(ctx context.Context, at time.Time) ([]Customer, error) {
+	var customers []Customer
+	err := repo.run(ctx, func(ctx context.Context, db bun.IDB) error {
+		var err error
+		customers, err = selectAsOf(ctx, db, at)
+		return err
+	})
+	return customers, err
+}
+
+// lock keeps other writers away from the customer until tx ends.
+func (repo *postgresRepo) lock(ctx context.Context, tx bun.Tx, id string, trashed bool, ifVersion int64) (Customer, error) {
+	var customer Customer
+	query := tx.NewSelect().
+		Model(&customer).
+		Where("tenant_id = ?", tenantFromContext(ctx)).
+		Where("id = ?", id).
+		For("UPDATE")
+	if trashed {
+		query = query.Where("deleted_at IS NOT NULL")
+	} else {
+		query = query.Where("deleted_at IS NULL")
 	}
 
-	if rowAffectCount == 0 {
-		return ErrNotFound
+	if err := query.Scan(ctx); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Customer{}, ErrNotFound
+		}
+
+		return Customer{}, err
 	}
 
-	return nil
+	if ifVersion != 0 && customer.Version != ifVersion {
+		return Customer{}, ErrVersionConflict
+	}
+
+	return customer, nil
 }
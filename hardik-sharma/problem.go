@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+var ErrInvalidBody = errors.New("invalid json body")
+var ErrInvalidSince = errors.New("invalid since")
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is the RFC 7807 body of every error response. Code is stable
+// and meant for clients to branch on, Title is for people.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail,omitempty"`
+	RequestId string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError points at the part of a request that was rejected, using
+// JSON field paths for bodies and parameter names otherwise.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// fieldErrorer is implemented by errors that know which fields of a
+// request caused them.
+type fieldErrorer interface {
+	fieldErrors() []FieldError
+}
+
+type problemType struct {
+	status int
+	code   string
+	title  string
+	// field is reported for errors that are always caused by one field
+	field string
+}
+
+// problemTypes maps service errors to responses. The first entry matching
+// with errors.Is wins, anything unmatched is an internal error.
+var problemTypes = []struct {
+	err error
+	problemType
+}{
+	{ErrValidation, problemType{http.StatusBadRequest, "validation_failed", "invalid customer", ""}},
+	{ErrInvalidBody, problemType{http.StatusBadRequest, "invalid_body", "invalid json body", ""}},
+	{ErrInvalidId, problemType{http.StatusBadRequest, "invalid_id", "invalid id", "id"}},
+	{ErrInvalidListOptions, problemType{http.StatusBadRequest, "invalid_query", "invalid query parameters", ""}},
+	{ErrInvalidSearch, problemType{http.StatusBadRequest, "invalid_query", "invalid query parameters", ""}},
+	{ErrInvalidCursor, problemType{http.StatusBadRequest, "invalid_cursor", "invalid cursor", "cursor"}},
+	{ErrInvalidSince, problemType{http.StatusBadRequest, "invalid_since", "invalid since", "since"}},
+	{ErrInvalidAsOf, problemType{http.StatusBadRequest, "invalid_as_of", "invalid asOf", "asOf"}},
+	{ErrInvalidAuditFilter, problemType{http.StatusBadRequest, "invalid_query", "invalid query parameters", ""}},
+	{ErrInvalidPatch, problemType{http.StatusBadRequest, "invalid_patch", "invalid patch", ""}},
+	{ErrUnsupportedPatchFormat, problemType{http.StatusUnsupportedMediaType, "unsupported_patch_format", "unsupported patch format", ""}},
+	{ErrUnauthenticated, problemType{http.StatusUnauthorized, "unauthenticated", "authentication required", ""}},
+	{ErrForbidden, problemType{http.StatusForbidden, "forbidden", "permission denied", ""}},
+	{ErrNotFound, problemType{http.StatusNotFound, "not_found", "customer not found", ""}},
+	{ErrConflict, problemType{http.StatusConflict, "conflict", "customer exists", ""}},
+	{ErrPatchTestFailed, problemType{http.StatusConflict, "patch_test_failed", "patch test failed", ""}},
+	{ErrVersionConflict, problemType{http.StatusPreconditionFailed, "version_conflict", "customer was modified", ""}},
+	{context.DeadlineExceeded, problemType{http.StatusGatewayTimeout, "timeout", "request timed out", ""}},
+}
+
+var internalProblem = problemType{http.StatusInternalServerError, "internal", "internal server error", ""}
+
+// problemTypeOf is the first entry of problemTypes matching err.
+func problemTypeOf(err error) problemType {
+	for _, candidate := range problemTypes {
+		if errors.Is(err, candidate.err) {
+			return candidate.problemType
+		}
+	}
+	return internalProblem
+}
+
+// newProblem describes err for a client. Internal errors carry no detail so
+// nothing about the failure leaks.
+func newProblem(err error, requestId string) Problem {
+	kind := problemTypeOf(err)
+
+	problem := Problem{
+		Type:      "/problems/" + kind.code,
+		Title:     kind.title,
+		Status:    kind.status,
+		Code:      kind.code,
+		RequestId: requestId,
+	}
+
+	if kind.status < http.StatusInternalServerError && err.Error() != kind.title {
+		problem.Detail = err.Error()
+	}
+
+	var fields fieldErrorer
+	if errors.As(err, &fields) {
+		problem.Errors = fields.fieldErrors()
+	} else if kind.field != "" {
+		problem.Errors = []FieldError{{Field: kind.field, Reason: kind.title}}
+	}
+
+	return problem
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	requestId := requestIdFromContext(r.Context())
+	problem := newProblem(err, requestId)
+
+	log.Printf("request %s failed due to error :%q", requestId, err)
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.Printf("failed to send response :%q", err)
+	}
+}
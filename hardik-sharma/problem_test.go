@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newProblem(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Problem
+	}{
+		{
+			name: "sentinel error",
+			err:  ErrNotFound,
+			want: Problem{
+				Type:      "/problems/not_found",
+				Title:     "customer not found",
+				Status:    http.StatusNotFound,
+				Code:      "not_found",
+				RequestId: "req-1",
+			},
+		},
+		{
+			name: "wrapped error keeps its message as detail",
+			err:  fmt.Errorf("%w: unexpected EOF", ErrInvalidBody),
+			want: Problem{
+				Type:      "/problems/invalid_body",
+				Title:     "invalid json body",
+				Status:    http.StatusBadRequest,
+				Code:      "invalid_body",
+				Detail:    "invalid json body: unexpected EOF",
+				RequestId: "req-1",
+			},
+		},
+		{
+			name: "field error",
+			err:  ErrInvalidId,
+			want: Problem{
+				Type:      "/problems/invalid_id",
+				Title:     "invalid id",
+				Status:    http.StatusBadRequest,
+				Code:      "invalid_id",
+				RequestId: "req-1",
+				Errors:    []FieldError{{Field: "id", Reason: "invalid id"}},
+			},
+		},
+		{
+			name: "validation error lists its fields",
+			err:  &ValidationError{Violations: []Violation{{Field: "customerDetails.name", Err: ErrRequired}}},
+			want: Problem{
+				Type:      "/problems/validation_failed",
+				Title:     "invalid customer",
+				Status:    http.StatusBadRequest,
+				Code:      "validation_failed",
+				Detail:    "validation failed: customerDetails.name is required",
+				RequestId: "req-1",
+				Errors:    []FieldError{{Field: "customerDetails.name", Reason: "is required"}},
+			},
+		},
+		{
+			name: "timeout",
+			err:  fmt.Errorf("listing customers: %w", context.DeadlineExceeded),
+			want: Problem{
+				Type:      "/problems/timeout",
+				Title:     "request timed out",
+				Status:    http.StatusGatewayTimeout,
+				Code:      "timeout",
+				RequestId: "req-1",
+			},
+		},
+		{
+			name: "unknown error hides its message",
+			err:  errors.New("connection refused"),
+			want: Problem{
+				Type:      "/problems/internal",
+				Title:     "internal server error",
+				Status:    http.StatusInternalServerError,
+				Code:      "internal",
+				RequestId: "req-1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, newProblem(tt.err, "req-1"), "expect problem to be same")
+		})
+	}
+}
+
+func Test_writeProblem(t *testing.T) {
+	handler := withRequestId(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, ErrConflict)
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/customers", nil)
+	r.Header.Set("X-Request-Id", "req-1")
+
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusConflict, w.Code, "expect status code to be same")
+
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"), "expect content type to be same")
+
+	assert.JSONEq(t, `{
+		"type": "/problems/conflict",
+		"title": "customer exists",
+		"status": 409,
+		"code": "conflict",
+		"detail": "customer already exists",
+		"requestId": "req-1"
+	}`, w.Body.String(), "expect body to be same")
+}
@@ -1,42 +1,142 @@
 package main
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
 
 var ErrConflict = errors.New("customer already exists")
 var ErrNotFound = errors.New("customer not found")
+var ErrVersionConflict = errors.New("customer version does not match")
 
+// purgedCustomer names a customer removed by purge. Tenant is left empty by
+// repos that hold a single tenant.
+type purgedCustomer struct {
+	Tenant string `bun:"tenant_id"`
+	Id     string `bun:"id"`
+}
+
+// Repo stores the customers of the tenant of ctx. Every method but trash,
+// restore and purge ignores those in the trash.
 type Repo interface {
-	create(c Customer) error
-	getAll() ([]Customer, error)
-	getById(id string) (Customer, error)
-	update(id string, updateCustomer Customer) error
-	delete(id string) error
+	create(ctx context.Context, c Customer, change Change) error
+	getAll(ctx context.Context) ([]Customer, error)
+	list(ctx context.Context, opts ListOptions) (CustomerPage, error)
+	// search returns the best matches first, opts is normalized
+	search(ctx context.Context, opts SearchOptions) ([]SearchHit, error)
+	getById(ctx context.Context, id string) (Customer, error)
+	// update stores the customer under the next version and returns it. A
+	// non-zero ifVersion must equal the stored version, otherwise the update
+	// fails with ErrVersionConflict.
+	update(ctx context.Context, id string, updateCustomer Customer, ifVersion int64, change Change) (Customer, error)
+	// patch changes only the fields set in p, the version is handled as
+	// for update
+	patch(ctx context.Context, id string, p CustomerPatch, ifVersion int64, change Change) (Customer, error)
+	// delete moves the customer to the trash as deleted at change.At and
+	// returns it from there, ifVersion is checked like for update
+	delete(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error)
+	// trash returns the deleted customers, most recently deleted first
+	trash(ctx context.Context) ([]Customer, error)
+	// restore takes a customer out of the trash, ifVersion is checked like
+	// for update
+	restore(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error)
+	// purge removes the customers deleted before the given time for good,
+	// history included, and returns which they were, those removed before
+	// an error too. It goes through the trash of every tenant when ctx is
+	// for allTenants.
+	purge(ctx context.Context, before time.Time) ([]purgedCustomer, error)
+	// history returns the revisions of a customer in the order they were
+	// made, none for customers never stored
+	history(ctx context.Context, id string) ([]Revision, error)
+	// asOf returns the customers as they were at the given time, without
+	// those in the trash then
+	asOf(ctx context.Context, at time.Time) ([]Customer, error)
 }
 
+// InMemoryRepo is safe for concurrent use. Writers replace or mutate
+// customers under the write lock and readers only ever see copies. It
+// holds a single tenant, whichever ctx names.
 type InMemoryRepo struct {
+	mu        sync.RWMutex
 	customers []Customer
+	trashed   []Customer
+	revisions map[string][]Revision
 }
 
 func NewInMemoryRepo() *InMemoryRepo {
 	return &InMemoryRepo{customers: []Customer{}}
 }
 
-func (m *InMemoryRepo) create(newCustomer Customer) error {
-	for _, existingCustomer := range m.customers {
-		if newCustomer.Id == existingCustomer.Id {
-			return ErrConflict
-		}
+func (m *InMemoryRepo) create(ctx context.Context, newCustomer Customer, change Change) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if customerIndex(m.customers, newCustomer.Id) >= 0 || customerIndex(m.trashed, newCustomer.Id) >= 0 {
+		return ErrConflict
 	}
 
 	m.customers = append(m.customers, newCustomer)
+	m.record(newRevision(RevisionCreated, newCustomer, nil, &newCustomer.CustomerDetails, change))
 	return nil
 }
 
-func (m *InMemoryRepo) getAll() ([]Customer, error) {
-	return m.customers, nil
+func (m *InMemoryRepo) has(id string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return customerIndex(m.customers, id) >= 0 || customerIndex(m.trashed, id) >= 0
+}
+
+func (m *InMemoryRepo) getAll(ctx context.Context) ([]Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	customers := make([]Customer, len(m.customers))
+	copy(customers, m.customers)
+	return customers, nil
 }
 
-func (m *InMemoryRepo) getById(id string) (Customer, error) {
+func (m *InMemoryRepo) list(ctx context.Context, opts ListOptions) (CustomerPage, error) {
+	if err := ctx.Err(); err != nil {
+		return CustomerPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return paginate(m.customers, opts)
+}
+
+func (m *InMemoryRepo) search(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return rankCustomers(m.customers, opts), nil
+}
+
+func (m *InMemoryRepo) getById(ctx context.Context, id string) (Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return Customer{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	for _, existingCustomer := range m.customers {
 		if id == existingCustomer.Id {
 			return existingCustomer, nil
@@ -46,22 +146,282 @@ func (m *InMemoryRepo) getById(id string) (Customer, error) {
 	return Customer{}, ErrNotFound
 }
 
-func (m *InMemoryRepo) update(id string, updateCustomer Customer) error {
+func (m *InMemoryRepo) update(ctx context.Context, id string, updateCustomer Customer, ifVersion int64, change Change) (Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return Customer{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	for i, existingCustomer := range m.customers {
 		if existingCustomer.Id == id {
+			if ifVersion != 0 && existingCustomer.Version != ifVersion {
+				return Customer{}, ErrVersionConflict
+			}
+
+			updateCustomer.Version = existingCustomer.Version + 1
 			m.customers[i] = updateCustomer
-			return nil
+			m.record(newRevision(RevisionUpdated, updateCustomer, &existingCustomer.CustomerDetails, &updateCustomer.CustomerDetails, change))
+			return updateCustomer, nil
 		}
 	}
-	return ErrNotFound
+	return Customer{}, ErrNotFound
 }
 
-func (m *InMemoryRepo) delete(id string) error {
+func (m *InMemoryRepo) patch(ctx context.Context, id string, p CustomerPatch, ifVersion int64, change Change) (Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return Customer{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	for i, existingCustomer := range m.customers {
 		if existingCustomer.Id == id {
-			m.customers = append(m.customers[:i], m.customers[i+1:]...)
-			return nil
+			if ifVersion != 0 && existingCustomer.Version != ifVersion {
+				return Customer{}, ErrVersionConflict
+			}
+
+			patched := p.apply(existingCustomer)
+			patched.Version++
+			m.customers[i] = patched
+			m.record(newRevision(RevisionUpdated, patched, &existingCustomer.CustomerDetails, &patched.CustomerDetails, change))
+			return patched, nil
 		}
 	}
-	return ErrNotFound
+	return Customer{}, ErrNotFound
+}
+
+func (m *InMemoryRepo) delete(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return Customer{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := customerIndex(m.customers, id)
+	if i < 0 {
+		return Customer{}, ErrNotFound
+	}
+
+	trashed := m.customers[i]
+	if ifVersion != 0 && trashed.Version != ifVersion {
+		return Customer{}, ErrVersionConflict
+	}
+
+	deletedAt := change.At
+	trashed.DeletedAt = &deletedAt
+	m.customers = append(m.customers[:i], m.customers[i+1:]...)
+	m.trashed = append(m.trashed, trashed)
+	m.record(newRevision(RevisionDeleted, trashed, &trashed.CustomerDetails, nil, change))
+	return trashed, nil
+}
+
+func (m *InMemoryRepo) trash(ctx context.Context) ([]Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	trashed := make([]Customer, len(m.trashed))
+	copy(trashed, m.trashed)
+	sortTrash(trashed)
+	return trashed, nil
+}
+
+func (m *InMemoryRepo) restore(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return Customer{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := customerIndex(m.trashed, id)
+	if i < 0 {
+		return Customer{}, ErrNotFound
+	}
+
+	restored := m.trashed[i]
+	if ifVersion != 0 && restored.Version != ifVersion {
+		return Customer{}, ErrVersionConflict
+	}
+
+	restored.DeletedAt = nil
+	m.trashed = append(m.trashed[:i], m.trashed[i+1:]...)
+	m.customers = append(m.customers, restored)
+	m.record(newRevision(RevisionRestored, restored, nil, &restored.CustomerDetails, change))
+	return restored, nil
+}
+
+func (m *InMemoryRepo) purge(ctx context.Context, before time.Time) ([]purgedCustomer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged []purgedCustomer
+	kept := make([]Customer, 0, len(m.trashed))
+	for _, trashed := range m.trashed {
+		if !trashed.DeletedAt.Before(before) {
+			kept = append(kept, trashed)
+			continue
+		}
+		delete(m.revisions, trashed.Id)
+		purged = append(purged, purgedCustomer{Id: trashed.Id})
+	}
+
+	m.trashed = kept
+	return purged, nil
+}
+
+func (m *InMemoryRepo) history(ctx context.Context, id string) ([]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revisions := make([]Revision, len(m.revisions[id]))
+	copy(revisions, m.revisions[id])
+	return revisions, nil
+}
+
+func (m *InMemoryRepo) asOf(ctx context.Context, at time.Time) ([]Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	customers := []Customer{}
+	for _, revisions := range m.revisions {
+		if customer, ok := customerAsOf(revisions, at); ok {
+			customers = append(customers, customer)
+		}
+	}
+	return customers, nil
+}
+
+// record adds a revision, it must be called with the write lock held.
+func (m *InMemoryRepo) record(revision Revision) {
+	if m.revisions == nil {
+		m.revisions = map[string][]Revision{}
+	}
+	m.revisions[revision.CustomerId] = append(m.revisions[revision.CustomerId], revision)
+}
+
+// addRevision adds a revision unless the history already has it, so that
+// replaying it twice adds it once.
+func (m *InMemoryRepo) addRevision(revision Revision) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.revisions[revision.CustomerId] {
+		if existing.Version == revision.Version && existing.Type == revision.Type && existing.At.Equal(revision.At) {
+			return
+		}
+	}
+	m.record(revision)
+}
+
+// allRevisions returns every revision, those of each customer oldest
+// first.
+func (m *InMemoryRepo) allRevisions() []Revision {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.revisions))
+	for id := range m.revisions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	revisions := []Revision{}
+	for _, id := range ids {
+		revisions = append(revisions, m.revisions[id]...)
+	}
+	return revisions
+}
+
+// backfillHistory starts the history of customers stored without one.
+func (m *InMemoryRepo) backfillHistory(at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, customer := range m.customers {
+		if len(m.revisions[customer.Id]) == 0 {
+			details := customer.CustomerDetails
+			m.record(newRevision(RevisionCreated, customer, nil, &details, Change{Actor: systemActor, At: at}))
+		}
+	}
+
+	for _, customer := range m.trashed {
+		if len(m.revisions[customer.Id]) == 0 {
+			details := customer.CustomerDetails
+			m.record(newRevision(RevisionDeleted, customer, &details, nil, Change{Actor: systemActor, At: *customer.DeletedAt}))
+		}
+	}
+}
+
+func (m *InMemoryRepo) put(customer Customer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := &m.customers
+	if customer.DeletedAt != nil {
+		list = &m.trashed
+	}
+
+	if i := customerIndex(*list, customer.Id); i >= 0 {
+		(*list)[i] = customer
+		return
+	}
+
+	m.customers = removeCustomer(m.customers, customer.Id)
+	m.trashed = removeCustomer(m.trashed, customer.Id)
+	*list = append(*list, customer)
+}
+
+func (m *InMemoryRepo) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.customers = removeCustomer(m.customers, id)
+	m.trashed = removeCustomer(m.trashed, id)
+	delete(m.revisions, id)
+}
+
+func customerIndex(customers []Customer, id string) int {
+	for i, customer := range customers {
+		if customer.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeCustomer(customers []Customer, id string) []Customer {
+	if i := customerIndex(customers, id); i >= 0 {
+		return append(customers[:i], customers[i+1:]...)
+	}
+	return customers
+}
+
+func sortTrash(customers []Customer) {
+	sort.Slice(customers, func(i, j int) bool {
+		a, b := customers[i].DeletedAt, customers[j].DeletedAt
+		if !a.Equal(*b) {
+			return a.After(*b)
+		}
+		return customers[i].Id < customers[j].Id
+	})
 }
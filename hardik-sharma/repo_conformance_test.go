@@ -0,0 +1,1125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RepoFactory returns a repo holding exactly existing.
+type RepoFactory func(t *testing.T, existing []Customer) Repo
+
+// RunRepoConformance checks the contract every Repo shares.
+func RunRepoConformance(t *testing.T, newRepo RepoFactory) {
+	t.Run("create", func(t *testing.T) { testRepoCreate(t, newRepo) })
+	t.Run("getAll", func(t *testing.T) { testRepoGetAll(t, newRepo) })
+	t.Run("getById", func(t *testing.T) { testRepoGetById(t, newRepo) })
+	t.Run("update", func(t *testing.T) { testRepoUpdate(t, newRepo) })
+	t.Run("patch", func(t *testing.T) { testRepoPatch(t, newRepo) })
+	t.Run("delete", func(t *testing.T) { testRepoDelete(t, newRepo) })
+	t.Run("trash", func(t *testing.T) { testRepoTrash(t, newRepo) })
+	t.Run("restore", func(t *testing.T) { testRepoRestore(t, newRepo) })
+	t.Run("purge", func(t *testing.T) { testRepoPurge(t, newRepo) })
+	t.Run("history", func(t *testing.T) { testRepoHistory(t, newRepo) })
+	t.Run("asOf", func(t *testing.T) { testRepoAsOf(t, newRepo) })
+	t.Run("list", func(t *testing.T) { testRepoList(t, newRepo) })
+	t.Run("search", func(t *testing.T) { testRepoSearch(t, newRepo) })
+	t.Run("canceled context", func(t *testing.T) { testRepoCanceledContext(t, newRepo) })
+	t.Run("concurrent access", func(t *testing.T) { testRepoConcurrentAccess(t, newRepo) })
+	t.Run("concurrent patches", func(t *testing.T) { testRepoConcurrentPatches(t, newRepo) })
+}
+
+var conformanceCustomers = []Customer{
+	{Id: "hm", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919649127559"}, Version: 1},
+	{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919649127559"}, Version: 2},
+}
+
+// conformanceDeletedAt is when customers are deleted in tests, in whole
+// seconds so that every backend stores it exactly.
+var conformanceDeletedAt = time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+
+// conformanceChange makes the writes of tests whose history doesn't
+// matter, deletes happen at conformanceDeletedAt.
+var conformanceChange = changeAt(conformanceDeletedAt)
+
+func changeAt(at time.Time) Change {
+	return Change{Actor: "tester", At: at}
+}
+
+// trashed returns customer as deleted at deletedAt.
+func trashed(customer Customer, deletedAt time.Time) Customer {
+	customer.DeletedAt = &deletedAt
+	return customer
+}
+
+// inUTC moves DeletedAt to UTC, backends may read it back in another
+// location.
+func inUTC(customer Customer) Customer {
+	if customer.DeletedAt != nil {
+		deletedAt := customer.DeletedAt.UTC()
+		customer.DeletedAt = &deletedAt
+	}
+	return customer
+}
+
+// trashCustomers deletes ids in order, an hour apart starting at
+// conformanceDeletedAt.
+func purgedIds(purged []purgedCustomer) []string {
+	ids := make([]string, 0, len(purged))
+	for _, customer := range purged {
+		ids = append(ids, customer.Id)
+	}
+	return ids
+}
+
+func trashCustomers(t *testing.T, repo Repo, ids ...string) {
+	for i, id := range ids {
+		if _, err := repo.delete(context.Background(), id, 0, changeAt(conformanceDeletedAt.Add(time.Duration(i)*time.Hour))); err != nil {
+			t.Fatal("failed to delete customer:", err)
+		}
+	}
+}
+
+func trashedCustomers(t *testing.T, repo Repo) []Customer {
+	customers, err := repo.trash(context.Background())
+	if err != nil {
+		t.Fatal("failed to fetch trash:", err)
+	}
+
+	for i := range customers {
+		customers[i] = inUTC(customers[i])
+	}
+	return customers
+}
+
+func storedCustomers(t *testing.T, repo Repo) []Customer {
+	customers, err := repo.getAll(context.Background())
+	if err != nil {
+		t.Fatal("failed to fetch customers:", err)
+	}
+
+	sort.Slice(customers, func(i, j int) bool { return customers[i].Id < customers[j].Id })
+	return customers
+}
+
+func testRepoCreate(t *testing.T, newRepo RepoFactory) {
+	added := Customer{Id: "hx", CustomerDetails: CustomerDetails{Name: "varshil", Address: "jaipur", ContactNo: "+917777777777"}, Version: 1}
+
+	tests := []struct {
+		name          string
+		existing      []Customer
+		customer      Customer
+		wantCustomers []Customer
+		wantErr       error
+	}{
+		{
+			name:          "adding new customer",
+			existing:      conformanceCustomers,
+			customer:      added,
+			wantCustomers: append(conformanceCustomers[:2:2], added),
+		},
+		{
+			name:          "adding to empty repo",
+			existing:      []Customer{},
+			customer:      added,
+			wantCustomers: []Customer{added},
+		},
+		{
+			name:          "adding existing customer",
+			existing:      conformanceCustomers,
+			customer:      Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "h", Address: "u", ContactNo: "+917777777777"}, Version: 1},
+			wantCustomers: conformanceCustomers,
+			wantErr:       ErrConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, tt.existing)
+
+			gotErr := repo.create(context.Background(), tt.customer, conformanceChange)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expect error to be same")
+			assert.Equal(t, tt.wantCustomers, storedCustomers(t, repo), "expect customers to be same")
+		})
+	}
+}
+
+func testRepoGetAll(t *testing.T, newRepo RepoFactory) {
+	tests := []struct {
+		name          string
+		existing      []Customer
+		wantCustomers []Customer
+	}{
+		{
+			name:          "customers present",
+			existing:      conformanceCustomers,
+			wantCustomers: conformanceCustomers,
+		},
+		{
+			name:          "empty repo",
+			existing:      []Customer{},
+			wantCustomers: []Customer{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, tt.existing)
+
+			gotCustomers, gotErr := repo.getAll(context.Background())
+			sort.Slice(gotCustomers, func(i, j int) bool { return gotCustomers[i].Id < gotCustomers[j].Id })
+
+			assert.NoError(t, gotErr, "expect no error")
+			assert.Equal(t, tt.wantCustomers, gotCustomers, "expect customers to be same")
+		})
+	}
+}
+
+func testRepoGetById(t *testing.T, newRepo RepoFactory) {
+	tests := []struct {
+		name         string
+		id           string
+		wantCustomer Customer
+		wantErr      error
+	}{
+		{
+			name:         "getting existing customer",
+			id:           "hs",
+			wantCustomer: conformanceCustomers[1],
+		},
+		{
+			name:    "getting customer that does not exist",
+			id:      "hx",
+			wantErr: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, conformanceCustomers)
+
+			gotCustomer, gotErr := repo.getById(context.Background(), tt.id)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expect error to be same")
+			assert.Equal(t, tt.wantCustomer, gotCustomer, "expect customer to be same")
+		})
+	}
+}
+
+func testRepoUpdate(t *testing.T, newRepo RepoFactory) {
+	details := CustomerDetails{Name: "parmavrr", Address: "jaipur", ContactNo: "+917777777777"}
+	updated := Customer{Id: "hs", CustomerDetails: details, Version: 3}
+
+	tests := []struct {
+		name          string
+		existing      []Customer
+		id            string
+		ifVersion     int64
+		wantCustomer  Customer
+		wantCustomers []Customer
+		wantErr       error
+	}{
+		{
+			name:          "updating existing customer",
+			existing:      conformanceCustomers,
+			id:            "hs",
+			wantCustomer:  updated,
+			wantCustomers: []Customer{conformanceCustomers[0], updated},
+		},
+		{
+			name:          "updating at the expected version",
+			existing:      conformanceCustomers,
+			id:            "hs",
+			ifVersion:     2,
+			wantCustomer:  updated,
+			wantCustomers: []Customer{conformanceCustomers[0], updated},
+		},
+		{
+			name:          "updating a stale version",
+			existing:      conformanceCustomers,
+			id:            "hs",
+			ifVersion:     1,
+			wantCustomers: conformanceCustomers,
+			wantErr:       ErrVersionConflict,
+		},
+		{
+			name:          "updating non existing customer",
+			existing:      conformanceCustomers,
+			id:            "hx",
+			wantCustomers: conformanceCustomers,
+			wantErr:       ErrNotFound,
+		},
+		{
+			name:          "updating in empty repo",
+			existing:      []Customer{},
+			id:            "hs",
+			wantCustomers: []Customer{},
+			wantErr:       ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, tt.existing)
+
+			gotCustomer, gotErr := repo.update(context.Background(), tt.id, Customer{Id: tt.id, CustomerDetails: details}, tt.ifVersion, conformanceChange)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expect error to be same")
+			assert.Equal(t, tt.wantCustomer, gotCustomer, "expect updated customer to be same")
+			assert.Equal(t, tt.wantCustomers, storedCustomers(t, repo), "expect customers to be same")
+		})
+	}
+}
+
+func testRepoPatch(t *testing.T, newRepo RepoFactory) {
+	address := "jaipur"
+	patched := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+919649127559"}, Version: 3}
+
+	tests := []struct {
+		name          string
+		id            string
+		ifVersion     int64
+		wantCustomer  Customer
+		wantCustomers []Customer
+		wantErr       error
+	}{
+		{
+			name:          "patching one field",
+			id:            "hs",
+			wantCustomer:  patched,
+			wantCustomers: []Customer{conformanceCustomers[0], patched},
+		},
+		{
+			name:          "patching at the expected version",
+			id:            "hs",
+			ifVersion:     2,
+			wantCustomer:  patched,
+			wantCustomers: []Customer{conformanceCustomers[0], patched},
+		},
+		{
+			name:          "patching a stale version",
+			id:            "hs",
+			ifVersion:     1,
+			wantCustomers: conformanceCustomers,
+			wantErr:       ErrVersionConflict,
+		},
+		{
+			name:          "patching non existing customer",
+			id:            "hx",
+			wantCustomers: conformanceCustomers,
+			wantErr:       ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, conformanceCustomers)
+
+			gotCustomer, gotErr := repo.patch(context.Background(), tt.id, CustomerPatch{Address: &address}, tt.ifVersion, conformanceChange)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expect error to be same")
+			assert.Equal(t, tt.wantCustomer, gotCustomer, "expect patched customer to be same")
+			assert.Equal(t, tt.wantCustomers, storedCustomers(t, repo), "expect customers to be same")
+		})
+	}
+}
+
+func testRepoDelete(t *testing.T, newRepo RepoFactory) {
+	tests := []struct {
+		name          string
+		existing      []Customer
+		trashed       []string
+		id            string
+		ifVersion     int64
+		wantDeleted   Customer
+		wantCustomers []Customer
+		wantTrash     []Customer
+		wantErr       error
+	}{
+		{
+			name:          "deleting existing customer",
+			existing:      conformanceCustomers,
+			id:            "hs",
+			wantDeleted:   trashed(conformanceCustomers[1], conformanceDeletedAt),
+			wantCustomers: conformanceCustomers[:1],
+			wantTrash:     []Customer{trashed(conformanceCustomers[1], conformanceDeletedAt)},
+		},
+		{
+			name:          "deleting at the expected version",
+			existing:      conformanceCustomers,
+			id:            "hs",
+			ifVersion:     2,
+			wantDeleted:   trashed(conformanceCustomers[1], conformanceDeletedAt),
+			wantCustomers: conformanceCustomers[:1],
+			wantTrash:     []Customer{trashed(conformanceCustomers[1], conformanceDeletedAt)},
+		},
+		{
+			name:          "deleting a stale version",
+			existing:      conformanceCustomers,
+			id:            "hs",
+			ifVersion:     1,
+			wantCustomers: conformanceCustomers,
+			wantTrash:     []Customer{},
+			wantErr:       ErrVersionConflict,
+		},
+		{
+			name:          "deleting customer in the trash",
+			existing:      conformanceCustomers,
+			trashed:       []string{"hs"},
+			id:            "hs",
+			wantCustomers: conformanceCustomers[:1],
+			wantTrash:     []Customer{trashed(conformanceCustomers[1], conformanceDeletedAt)},
+			wantErr:       ErrNotFound,
+		},
+		{
+			name:          "deleting non existing customer",
+			existing:      conformanceCustomers,
+			id:            "hx",
+			wantCustomers: conformanceCustomers,
+			wantTrash:     []Customer{},
+			wantErr:       ErrNotFound,
+		},
+		{
+			name:          "deleting from empty repo",
+			existing:      []Customer{},
+			id:            "hs",
+			wantCustomers: []Customer{},
+			wantTrash:     []Customer{},
+			wantErr:       ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, tt.existing)
+			trashCustomers(t, repo, tt.trashed...)
+
+			gotDeleted, gotErr := repo.delete(context.Background(), tt.id, tt.ifVersion, conformanceChange)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expect error to be same")
+			assert.Equal(t, tt.wantDeleted, inUTC(gotDeleted), "expect deleted customer to be same")
+			assert.Equal(t, tt.wantCustomers, storedCustomers(t, repo), "expect customers to be same")
+			assert.Equal(t, tt.wantTrash, trashedCustomers(t, repo), "expect trash to be same")
+		})
+	}
+}
+
+// testRepoTrash checks that customers in the trash are left out of every
+// read and can't be written to.
+func testRepoTrash(t *testing.T, newRepo RepoFactory) {
+	repo := newRepo(t, pagingFixture)
+	trashCustomers(t, repo, "hs", "ab")
+	ctx := context.Background()
+
+	assert.Equal(t, []Customer{
+		trashed(pagingFixture[3], conformanceDeletedAt.Add(time.Hour)),
+		trashed(pagingFixture[0], conformanceDeletedAt),
+	}, trashedCustomers(t, repo), "expect most recently deleted first")
+
+	gotIds := []string{}
+	for _, customer := range storedCustomers(t, repo) {
+		gotIds = append(gotIds, customer.Id)
+	}
+	assert.Equal(t, []string{"ps", "vs", "zz"}, gotIds, "expect getAll to leave out the trash")
+
+	page, err := repo.list(ctx, ListOptions{Limit: 10, SortBy: "name", Name: "hardik"})
+	assert.NoError(t, err, "expect list to succeed")
+	assert.Empty(t, page.Customers, "expect list to leave out the trash")
+
+	opts, _ := SearchOptions{Query: "hardik"}.normalize()
+	hits, err := repo.search(ctx, opts)
+	assert.NoError(t, err, "expect search to succeed")
+	assert.Empty(t, hits, "expect search to leave out the trash")
+
+	_, err = repo.getById(ctx, "hs")
+	assert.ErrorIs(t, err, ErrNotFound, "expect getById to leave out the trash")
+
+	_, err = repo.update(ctx, "hs", Customer{Id: "hs", CustomerDetails: pagingFixture[0].CustomerDetails}, 0, conformanceChange)
+	assert.ErrorIs(t, err, ErrNotFound, "expect update to leave out the trash")
+
+	address := "jaipur"
+	_, err = repo.patch(ctx, "hs", CustomerPatch{Address: &address}, 0, conformanceChange)
+	assert.ErrorIs(t, err, ErrNotFound, "expect patch to leave out the trash")
+
+	err = repo.create(ctx, pagingFixture[0], conformanceChange)
+	assert.ErrorIs(t, err, ErrConflict, "expect ids in the trash to stay taken")
+}
+
+func testRepoRestore(t *testing.T, newRepo RepoFactory) {
+	tests := []struct {
+		name          string
+		id            string
+		ifVersion     int64
+		wantRestored  Customer
+		wantCustomers []Customer
+		wantTrash     []Customer
+		wantErr       error
+	}{
+		{
+			name:          "restoring deleted customer",
+			id:            "hs",
+			wantRestored:  conformanceCustomers[1],
+			wantCustomers: conformanceCustomers,
+			wantTrash:     []Customer{},
+		},
+		{
+			name:          "restoring at the expected version",
+			id:            "hs",
+			ifVersion:     2,
+			wantRestored:  conformanceCustomers[1],
+			wantCustomers: conformanceCustomers,
+			wantTrash:     []Customer{},
+		},
+		{
+			name:          "restoring a stale version",
+			id:            "hs",
+			ifVersion:     1,
+			wantCustomers: conformanceCustomers[:1],
+			wantTrash:     []Customer{trashed(conformanceCustomers[1], conformanceDeletedAt)},
+			wantErr:       ErrVersionConflict,
+		},
+		{
+			name:          "restoring customer that isn't deleted",
+			id:            "hm",
+			wantCustomers: conformanceCustomers[:1],
+			wantTrash:     []Customer{trashed(conformanceCustomers[1], conformanceDeletedAt)},
+			wantErr:       ErrNotFound,
+		},
+		{
+			name:          "restoring non existing customer",
+			id:            "hx",
+			wantCustomers: conformanceCustomers[:1],
+			wantTrash:     []Customer{trashed(conformanceCustomers[1], conformanceDeletedAt)},
+			wantErr:       ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, conformanceCustomers)
+			trashCustomers(t, repo, "hs")
+
+			gotRestored, gotErr := repo.restore(context.Background(), tt.id, tt.ifVersion, conformanceChange)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expect error to be same")
+			assert.Equal(t, tt.wantRestored, gotRestored, "expect restored customer to be same")
+			assert.Equal(t, tt.wantCustomers, storedCustomers(t, repo), "expect customers to be same")
+			assert.Equal(t, tt.wantTrash, trashedCustomers(t, repo), "expect trash to be same")
+		})
+	}
+}
+
+func testRepoPurge(t *testing.T, newRepo RepoFactory) {
+	tests := []struct {
+		name       string
+		before     time.Time
+		wantPurged []string
+		wantTrash  []Customer
+	}{
+		{
+			name:       "nothing deleted long enough ago",
+			before:     conformanceDeletedAt,
+			wantPurged: []string{},
+			wantTrash: []Customer{
+				trashed(conformanceCustomers[1], conformanceDeletedAt.Add(time.Hour)),
+				trashed(conformanceCustomers[0], conformanceDeletedAt),
+			},
+		},
+		{
+			name:       "some deleted long enough ago",
+			before:     conformanceDeletedAt.Add(time.Minute),
+			wantPurged: []string{"hm"},
+			wantTrash:  []Customer{trashed(conformanceCustomers[1], conformanceDeletedAt.Add(time.Hour))},
+		},
+		{
+			name:       "all deleted long enough ago",
+			before:     conformanceDeletedAt.Add(2 * time.Hour),
+			wantPurged: []string{"hm", "hs"},
+			wantTrash:  []Customer{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, append(conformanceCustomers[:2:2], Customer{Id: "hx", Version: 1}))
+			trashCustomers(t, repo, "hm", "hs")
+
+			gotPurged, gotErr := repo.purge(context.Background(), tt.before)
+
+			assert.NoError(t, gotErr, "expect no error")
+			assert.ElementsMatch(t, tt.wantPurged, purgedIds(gotPurged), "expect purged customers to be same")
+			assert.Equal(t, tt.wantTrash, trashedCustomers(t, repo), "expect trash to be same")
+			assert.Equal(t, []Customer{{Id: "hx", Version: 1}}, storedCustomers(t, repo), "expect customers outside the trash to be kept")
+		})
+	}
+
+	t.Run("purged ids can be used again", func(t *testing.T) {
+		repo := newRepo(t, conformanceCustomers)
+		trashCustomers(t, repo, "hs")
+
+		if _, err := repo.purge(context.Background(), conformanceDeletedAt.Add(time.Minute)); err != nil {
+			t.Fatal("failed to purge:", err)
+		}
+
+		assert.NoError(t, repo.create(context.Background(), conformanceCustomers[1], conformanceChange), "expect id to be free")
+	})
+}
+
+// customerHistory returns the revisions of id with their times in UTC.
+func customerHistory(t *testing.T, repo Repo, id string) []Revision {
+	revisions, err := repo.history(context.Background(), id)
+	if err != nil {
+		t.Fatal("failed to fetch history:", err)
+	}
+
+	for i := range revisions {
+		revisions[i].At = revisions[i].At.UTC()
+	}
+	return revisions
+}
+
+func testRepoHistory(t *testing.T, newRepo RepoFactory) {
+	ctx := context.Background()
+	at := func(hours int) time.Time { return conformanceDeletedAt.Add(time.Duration(hours) * time.Hour) }
+
+	created := CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919649127559"}
+	updated := CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+919649127559"}
+	patched := CustomerDetails{Name: "hardik sharma", Address: "jaipur", ContactNo: "+919649127559"}
+
+	repo := newRepo(t, []Customer{})
+	writes := []func() error{
+		func() error {
+			return repo.create(ctx, Customer{Id: "hx", CustomerDetails: created, Version: 1}, Change{Actor: "hardik", At: at(0)})
+		},
+		func() error {
+			_, err := repo.update(ctx, "hx", Customer{Id: "hx", CustomerDetails: updated}, 1, Change{Actor: "varshil", At: at(1)})
+			return err
+		},
+		func() error {
+			name := patched.Name
+			_, err := repo.patch(ctx, "hx", CustomerPatch{Name: &name}, 0, Change{Actor: "hardik", At: at(2)})
+			return err
+		},
+		func() error {
+			_, err := repo.delete(ctx, "hx", 3, Change{Actor: "varshil", At: at(3)})
+			return err
+		},
+		func() error {
+			_, err := repo.restore(ctx, "hx", 3, Change{Actor: "hardik", At: at(4)})
+			return err
+		},
+	}
+	for i, write := range writes {
+		if err := write(); err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+	}
+
+	_, err := repo.update(ctx, "hx", Customer{Id: "hx", CustomerDetails: created}, 1, Change{Actor: "paramveer", At: at(5)})
+	assert.ErrorIs(t, err, ErrVersionConflict, "expect stale update to fail")
+
+	wantRevisions := []Revision{
+		{CustomerId: "hx", Version: 1, Type: RevisionCreated, Actor: "hardik", At: at(0), NewDetails: &created},
+		{CustomerId: "hx", Version: 2, Type: RevisionUpdated, Actor: "varshil", At: at(1), OldDetails: &created, NewDetails: &updated},
+		{CustomerId: "hx", Version: 3, Type: RevisionUpdated, Actor: "hardik", At: at(2), OldDetails: &updated, NewDetails: &patched},
+		{CustomerId: "hx", Version: 3, Type: RevisionDeleted, Actor: "varshil", At: at(3), OldDetails: &patched},
+		{CustomerId: "hx", Version: 3, Type: RevisionRestored, Actor: "hardik", At: at(4), NewDetails: &patched},
+	}
+	assert.Equal(t, wantRevisions, customerHistory(t, repo, "hx"), "expect every successful write to be recorded in order")
+	assert.Empty(t, customerHistory(t, repo, "hy"), "expect unknown customer to have no history")
+
+	t.Run("purging removes the history", func(t *testing.T) {
+		if _, err := repo.delete(ctx, "hx", 0, changeAt(at(6))); err != nil {
+			t.Fatal("failed to delete:", err)
+		}
+		if _, err := repo.purge(ctx, at(7)); err != nil {
+			t.Fatal("failed to purge:", err)
+		}
+
+		assert.Empty(t, customerHistory(t, repo, "hx"), "expect purged customer to have no history")
+	})
+}
+
+func testRepoAsOf(t *testing.T, newRepo RepoFactory) {
+	ctx := context.Background()
+	at := func(hours int) time.Time { return conformanceDeletedAt.Add(time.Duration(hours) * time.Hour) }
+
+	hx := Customer{Id: "hx", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919649127559"}, Version: 1}
+	hy := Customer{Id: "hy", CustomerDetails: CustomerDetails{Name: "varshil", Address: "jaipur", ContactNo: "+917777777777"}, Version: 1}
+	hxUpdated := Customer{Id: "hx", CustomerDetails: CustomerDetails{Name: "hardik", Address: "ajmer", ContactNo: "+919649127559"}, Version: 2}
+
+	repo := newRepo(t, []Customer{})
+	if err := repo.create(ctx, hx, changeAt(at(0))); err != nil {
+		t.Fatal("failed to create:", err)
+	}
+	if err := repo.create(ctx, hy, changeAt(at(1))); err != nil {
+		t.Fatal("failed to create:", err)
+	}
+	if _, err := repo.update(ctx, "hx", hxUpdated, 0, changeAt(at(2))); err != nil {
+		t.Fatal("failed to update:", err)
+	}
+	if _, err := repo.delete(ctx, "hy", 0, changeAt(at(3))); err != nil {
+		t.Fatal("failed to delete:", err)
+	}
+
+	tests := []struct {
+		name          string
+		at            time.Time
+		wantCustomers []Customer
+	}{
+		{
+			name:          "before the first write",
+			at:            at(-1),
+			wantCustomers: []Customer{},
+		},
+		{
+			name:          "at the time of a write",
+			at:            at(0),
+			wantCustomers: []Customer{hx},
+		},
+		{
+			name:          "between writes",
+			at:            at(1).Add(30 * time.Minute),
+			wantCustomers: []Customer{hx, hy},
+		},
+		{
+			name:          "after an update",
+			at:            at(2),
+			wantCustomers: []Customer{hxUpdated, hy},
+		},
+		{
+			name:          "after a delete",
+			at:            at(4),
+			wantCustomers: []Customer{hxUpdated},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCustomers, gotErr := repo.asOf(ctx, tt.at)
+			sort.Slice(gotCustomers, func(i, j int) bool { return gotCustomers[i].Id < gotCustomers[j].Id })
+
+			assert.NoError(t, gotErr, "expect no error")
+			assert.Equal(t, tt.wantCustomers, gotCustomers, "expect customers to be same")
+		})
+	}
+}
+
+var pagingFixture = []Customer{
+	{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}},
+	{Id: "vs", CustomerDetails: CustomerDetails{Name: "varshil", Address: "udaipur", ContactNo: "+916666666666"}},
+	{Id: "ps", CustomerDetails: CustomerDetails{Name: "paramveer", Address: "jaipur", ContactNo: "+915555555555"}},
+	{Id: "ab", CustomerDetails: CustomerDetails{Name: "hardik", Address: "ajmer", ContactNo: "+919999999999"}},
+	{Id: "zz", CustomerDetails: CustomerDetails{Name: "zoya", Address: "100% udaipur", ContactNo: "+918888888888"}},
+}
+
+var pagingTests = []struct {
+	name    string
+	opts    ListOptions
+	wantIds []string
+}{
+	{
+		name:    "default sort by id",
+		opts:    ListOptions{Limit: 2, SortBy: "id"},
+		wantIds: []string{"ab", "hs", "ps", "vs", "zz"},
+	},
+	{
+		name:    "sort by name breaks ties on id",
+		opts:    ListOptions{Limit: 2, SortBy: "name"},
+		wantIds: []string{"ab", "hs", "ps", "vs", "zz"},
+	},
+	{
+		name:    "sort by contact number descending",
+		opts:    ListOptions{Limit: 3, SortBy: "contactNo", Descending: true},
+		wantIds: []string{"ab", "zz", "hs", "vs", "ps"},
+	},
+	{
+		name:    "filter by address substring",
+		opts:    ListOptions{Limit: 1, SortBy: "id", Address: "UDAIPUR"},
+		wantIds: []string{"hs", "vs", "zz"},
+	},
+	{
+		name:    "filter by address with wildcard character",
+		opts:    ListOptions{Limit: 1, SortBy: "id", Address: "100%"},
+		wantIds: []string{"zz"},
+	},
+	{
+		name:    "filter by name and contact number",
+		opts:    ListOptions{Limit: 5, SortBy: "id", Name: "hard", ContactNo: "+919999999999"},
+		wantIds: []string{"ab"},
+	},
+}
+
+func collectPages(t *testing.T, repo Repo, opts ListOptions) []string {
+	ids := []string{}
+	for {
+		page, err := repo.list(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("listing failed :%v", err)
+		}
+
+		if len(page.Customers) > opts.Limit {
+			t.Fatalf("page holds %d customers, limit is %d", len(page.Customers), opts.Limit)
+		}
+
+		for _, customer := range page.Customers {
+			ids = append(ids, customer.Id)
+		}
+
+		if page.Next == "" {
+			return ids
+		}
+		opts.Cursor = page.Next
+	}
+}
+
+func testRepoList(t *testing.T, newRepo RepoFactory) {
+	for _, tt := range pagingTests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, pagingFixture)
+
+			gotIds := collectPages(t, repo, tt.opts)
+
+			assert.Equal(t, tt.wantIds, gotIds, "expect listed ids to be same")
+		})
+	}
+
+	t.Run("malformed cursor", func(t *testing.T) {
+		repo := newRepo(t, pagingFixture)
+
+		_, gotErr := repo.list(context.Background(), ListOptions{Limit: 2, SortBy: "id", Cursor: "not-a-cursor"})
+
+		assert.ErrorIs(t, gotErr, ErrInvalidCursor, "expect cursor to be rejected")
+	})
+
+	t.Run("cursor of another sort", func(t *testing.T) {
+		repo := newRepo(t, pagingFixture)
+
+		page, err := repo.list(context.Background(), ListOptions{Limit: 2, SortBy: "id"})
+		if err != nil {
+			t.Fatal("listing failed:", err)
+		}
+
+		_, gotErr := repo.list(context.Background(), ListOptions{Limit: 2, SortBy: "name", Cursor: page.Next})
+
+		assert.ErrorIs(t, gotErr, ErrInvalidCursor, "expect cursor to be rejected")
+	})
+}
+
+// testRepoSearch only expects what every backend agrees on, which hits are
+// found and how they are highlighted, not how they are scored.
+func testRepoSearch(t *testing.T, newRepo RepoFactory) {
+	tests := []struct {
+		name     string
+		opts     SearchOptions
+		wantIds  []string
+		wantHits int
+	}{
+		{
+			name:     "prefix of a name",
+			opts:     SearchOptions{Query: "hard"},
+			wantIds:  []string{"ab", "hs"},
+			wantHits: 2,
+		},
+		{
+			name:     "address in another case",
+			opts:     SearchOptions{Query: "UDAIPUR"},
+			wantIds:  []string{"hs", "vs", "zz"},
+			wantHits: 3,
+		},
+		{
+			name:     "name with a typo",
+			opts:     SearchOptions{Query: "hardk"},
+			wantIds:  []string{"ab", "hs"},
+			wantHits: 2,
+		},
+		{
+			name:    "no match",
+			opts:    SearchOptions{Query: "mumbai"},
+			wantIds: []string{},
+		},
+		{
+			name:     "limit",
+			opts:     SearchOptions{Query: "udaipur", Limit: 2},
+			wantHits: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t, pagingFixture)
+			opts, err := tt.opts.normalize()
+			if err != nil {
+				t.Fatal("invalid search:", err)
+			}
+
+			hits, err := repo.search(context.Background(), opts)
+			if err != nil {
+				t.Fatal("search failed:", err)
+			}
+
+			gotIds := []string{}
+			for _, hit := range hits {
+				gotIds = append(gotIds, hit.Customer.Id)
+			}
+
+			assert.Len(t, gotIds, tt.wantHits, "expect number of hits to be same")
+			if tt.wantIds != nil {
+				assert.ElementsMatch(t, tt.wantIds, gotIds, "expect hits to be same")
+			}
+		})
+	}
+
+	t.Run("highlights", func(t *testing.T) {
+		repo := newRepo(t, pagingFixture)
+		opts, _ := SearchOptions{Query: "hard udaipur"}.normalize()
+
+		hits, err := repo.search(context.Background(), opts)
+		if err != nil {
+			t.Fatal("search failed:", err)
+		}
+
+		if assert.Len(t, hits, 1, "expect one hit") {
+			assert.Equal(t, "hs", hits[0].Customer.Id, "expect hit to be same")
+			assert.Equal(t, map[string][]HighlightFragment{
+				"customerDetails.name":    {{Text: "hard", Match: true}, {Text: "ik"}},
+				"customerDetails.address": {{Text: "udaipur", Match: true}},
+			}, hits[0].Highlights, "expect highlights to be same")
+		}
+	})
+}
+
+func testRepoCanceledContext(t *testing.T, newRepo RepoFactory) {
+	repo := newRepo(t, conformanceCustomers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, gotErr := repo.getById(ctx, "hs")
+	assert.ErrorIs(t, gotErr, context.Canceled, "expect read to be abandoned")
+
+	gotErr = repo.create(ctx, Customer{Id: "hx", Version: 1}, conformanceChange)
+	assert.ErrorIs(t, gotErr, context.Canceled, "expect write to be abandoned")
+
+	assert.Equal(t, conformanceCustomers, storedCustomers(t, repo), "expect customers to be unchanged")
+}
+
+func testRepoConcurrentAccess(t *testing.T, newRepo RepoFactory) {
+	const writers = 10
+	const perWriter = 10
+
+	repo := newRepo(t, []Customer{})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				id := fmt.Sprintf("%02d-%02d", w, i)
+				customer := Customer{Id: id, CustomerDetails: CustomerDetails{Name: id, ContactNo: "+919999999999"}, Version: 1}
+
+				if err := repo.create(ctx, customer, conformanceChange); err != nil {
+					t.Errorf("create %s failed :%v", id, err)
+				}
+
+				customer.CustomerDetails.Address = "updated"
+				if _, err := repo.update(ctx, id, customer, 1, conformanceChange); err != nil {
+					t.Errorf("update %s failed :%v", id, err)
+				}
+
+				// every odd customer is removed again
+				if i%2 == 1 {
+					if _, err := repo.delete(ctx, id, 2, conformanceChange); err != nil {
+						t.Errorf("delete %s failed :%v", id, err)
+					}
+				}
+			}
+		}(w)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if _, err := repo.getAll(ctx); err != nil {
+					t.Errorf("getAll failed :%v", err)
+				}
+
+				if _, err := repo.list(ctx, ListOptions{Limit: 10, SortBy: "name"}); err != nil {
+					t.Errorf("list failed :%v", err)
+				}
+
+				_, _ = repo.getById(ctx, "00-00")
+			}
+		}()
+	}
+	wg.Wait()
+
+	customers := storedCustomers(t, repo)
+	assert.Len(t, customers, writers*perWriter/2, "expect every even customer to be left")
+
+	for _, customer := range customers {
+		if customer.CustomerDetails.Address != "updated" || customer.CustomerDetails.Name != customer.Id || customer.Version != 2 {
+			t.Errorf("customer %s was not stored consistently: %+v", customer.Id, customer)
+		}
+	}
+}
+
+func testRepoConcurrentPatches(t *testing.T, newRepo RepoFactory) {
+	const patches = 20
+
+	repo := newRepo(t, conformanceCustomers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < patches; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			address := "jaipur"
+			if _, err := repo.patch(context.Background(), "hs", CustomerPatch{Address: &address}, 0, conformanceChange); err != nil {
+				t.Error("patch failed:", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := repo.getById(context.Background(), "hs")
+	assert.NoError(t, err, "expect customer to be found")
+	assert.Equal(t, conformanceCustomers[1].Version+patches, got.Version, "expect every patch to count")
+}
+
+// RunTenantConformance checks that a repo keeping customers of several
+// tenants shows each tenant only its own, whatever backend isolates them.
+// Customers made by newRepo belong to DefaultTenant.
+func RunTenantConformance(t *testing.T, newRepo RepoFactory) {
+	t.Run("ids unique per tenant", func(t *testing.T) { testTenantIds(t, newRepo) })
+	t.Run("reads", func(t *testing.T) { testTenantReads(t, newRepo) })
+	t.Run("writes", func(t *testing.T) { testTenantWrites(t, newRepo) })
+	t.Run("trash", func(t *testing.T) { testTenantTrash(t, newRepo) })
+	t.Run("history", func(t *testing.T) { testTenantHistory(t, newRepo) })
+}
+
+// acmeCustomer is conformanceCustomers[1] as another tenant keeps it.
+var acmeCustomer = Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "paramveer", Address: "jaipur", ContactNo: "+917777777777"}, Version: 1}
+
+func testTenantIds(t *testing.T, newRepo RepoFactory) {
+	repo := newRepo(t, conformanceCustomers)
+	acme := contextWithTenant(context.Background(), "acme")
+
+	assert.NoError(t, repo.create(acme, acmeCustomer, conformanceChange), "expect id of another tenant to be free")
+	assert.ErrorIs(t, repo.create(acme, acmeCustomer, conformanceChange), ErrConflict, "expect id to be taken within the tenant")
+
+	gotCustomer, err := repo.getById(acme, "hs")
+	assert.NoError(t, err, "expect no error")
+	assert.Equal(t, acmeCustomer, gotCustomer, "expect customer of the tenant")
+
+	gotCustomer, err = repo.getById(context.Background(), "hs")
+	assert.NoError(t, err, "expect no error")
+	assert.Equal(t, conformanceCustomers[1], gotCustomer, "expect customer of the default tenant to be untouched")
+}
+
+func testTenantReads(t *testing.T, newRepo RepoFactory) {
+	repo := newRepo(t, conformanceCustomers)
+	acme := contextWithTenant(context.Background(), "acme")
+	if err := repo.create(acme, acmeCustomer, changeAt(conformanceDeletedAt)); err != nil {
+		t.Fatal("failed to create:", err)
+	}
+
+	gotCustomers, err := repo.getAll(acme)
+	assert.NoError(t, err, "expect no error")
+	assert.Equal(t, []Customer{acmeCustomer}, gotCustomers, "expect only customers of the tenant")
+	assert.Equal(t, conformanceCustomers, storedCustomers(t, repo), "expect only customers of the default tenant")
+
+	_, err = repo.getById(acme, "hm")
+	assert.ErrorIs(t, err, ErrNotFound, "expect customer of another tenant to be hidden")
+
+	page, err := repo.list(acme, ListOptions{Limit: 10, SortBy: "id"})
+	assert.NoError(t, err, "expect no error")
+	assert.Equal(t, []Customer{acmeCustomer}, page.Customers, "expect listing of the tenant")
+
+	opts, _ := SearchOptions{Query: "hardik"}.normalize()
+	hits, err := repo.search(acme, opts)
+	assert.NoError(t, err, "expect no error")
+	assert.Empty(t, hits, "expect customers of another tenant not to be found")
+
+	gotCustomers, err = repo.asOf(acme, conformanceDeletedAt.Add(time.Minute))
+	assert.NoError(t, err, "expect no error")
+	assert.Equal(t, []Customer{acmeCustomer}, gotCustomers, "expect past of the tenant")
+}
+
+func testTenantWrites(t *testing.T, newRepo RepoFactory) {
+	repo := newRepo(t, conformanceCustomers)
+	acme := contextWithTenant(context.Background(), "acme")
+	name := "varshil"
+
+	_, err := repo.update(acme, "hm", Customer{Id: "hm", CustomerDetails: acmeCustomer.CustomerDetails}, 0, conformanceChange)
+	assert.ErrorIs(t, err, ErrNotFound, "expect update of another tenant's customer to fail")
+
+	_, err = repo.patch(acme, "hm", CustomerPatch{Name: &name}, 0, conformanceChange)
+	assert.ErrorIs(t, err, ErrNotFound, "expect patch of another tenant's customer to fail")
+
+	_, err = repo.delete(acme, "hm", 0, conformanceChange)
+	assert.ErrorIs(t, err, ErrNotFound, "expect delete of another tenant's customer to fail")
+
+	assert.Equal(t, conformanceCustomers, storedCustomers(t, repo), "expect customers of the default tenant to be untouched")
+}
+
+func testTenantTrash(t *testing.T, newRepo RepoFactory) {
+	repo := newRepo(t, conformanceCustomers)
+	acme := contextWithTenant(context.Background(), "acme")
+	if err := repo.create(acme, acmeCustomer, conformanceChange); err != nil {
+		t.Fatal("failed to create:", err)
+	}
+	trashCustomers(t, repo, "hm")
+	if _, err := repo.delete(acme, "hs", 0, conformanceChange); err != nil {
+		t.Fatal("failed to delete:", err)
+	}
+
+	assert.Equal(t, []Customer{trashed(conformanceCustomers[0], conformanceDeletedAt)}, trashedCustomers(t, repo), "expect only the trash of the default tenant")
+
+	_, err := repo.restore(context.Background(), "hs", 0, conformanceChange)
+	assert.ErrorIs(t, err, ErrNotFound, "expect trash of another tenant to be out of reach")
+
+	purged, err := repo.purge(acme, conformanceDeletedAt.Add(time.Minute))
+	assert.NoError(t, err, "expect no error")
+	assert.Equal(t, []string{"hs"}, purgedIds(purged), "expect only the trash of the tenant to be purged")
+	assert.Len(t, trashedCustomers(t, repo), 1, "expect trash of the default tenant to be kept")
+
+	purged, err = repo.purge(contextWithTenant(context.Background(), allTenants), conformanceDeletedAt.Add(time.Minute))
+	assert.NoError(t, err, "expect no error")
+	assert.Equal(t, []purgedCustomer{{Tenant: DefaultTenant, Id: "hm"}}, purged, "expect the trash of every tenant to be purged under its tenant")
+	assert.Empty(t, trashedCustomers(t, repo), "expect trash to be empty")
+}
+
+func testTenantHistory(t *testing.T, newRepo RepoFactory) {
+	repo := newRepo(t, []Customer{})
+	acme := contextWithTenant(context.Background(), "acme")
+	if err := repo.create(context.Background(), conformanceCustomers[0], conformanceChange); err != nil {
+		t.Fatal("failed to create:", err)
+	}
+	if err := repo.create(acme, Customer{Id: "hm", CustomerDetails: acmeCustomer.CustomerDetails, Version: 1}, conformanceChange); err != nil {
+		t.Fatal("failed to create:", err)
+	}
+
+	revisions, err := repo.history(acme, "hm")
+	assert.NoError(t, err, "expect no error")
+	for i := range revisions {
+		revisions[i].At = revisions[i].At.UTC()
+	}
+	assert.Equal(t, []Revision{{
+		CustomerId: "hm",
+		Version:    1,
+		Type:       RevisionCreated,
+		Actor:      conformanceChange.Actor,
+		At:         conformanceDeletedAt,
+		NewDetails: &acmeCustomer.CustomerDetails,
+	}}, revisions, "expect only revisions of the tenant")
+	assert.Len(t, customerHistory(t, repo, "hm"), 1, "expect revisions of the default tenant to be kept apart")
+}
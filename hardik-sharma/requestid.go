@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+// maxRequestIdLength bounds ids taken over from clients.
+const maxRequestIdLength = 128
+
+type requestIdKey struct{}
+
+// withRequestId tags every request with an id, taken from the X-Request-Id
+// header when the client sent a usable one, and echoes it in the response.
+func withRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId(id) {
+			var err error
+			if id, err = NewUUIDv7(); err != nil {
+				log.Printf("failed to generate request id :%q", err)
+			}
+		}
+
+		w.Header().Set(requestIdHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id)))
+	})
+}
+
+// validRequestId accepts short ids of printable ASCII, so they can be
+// logged and echoed safely.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func requestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_withRequestId(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantKept bool
+	}{
+		{
+			name:     "client id is kept",
+			header:   "3f2a-checkout",
+			wantKept: true,
+		},
+		{
+			name:     "missing id is generated",
+			header:   "",
+			wantKept: false,
+		},
+		{
+			name:     "id with spaces is replaced",
+			header:   "a b",
+			wantKept: false,
+		},
+		{
+			name:     "overlong id is replaced",
+			header:   strings.Repeat("a", maxRequestIdLength+1),
+			wantKept: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := withRequestId(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestIdFromContext(r.Context())
+			}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/api/customers", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Request-Id", tt.header)
+			}
+
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, seen, w.Header().Get("X-Request-Id"), "expect response to echo the request id")
+
+			if tt.wantKept {
+				assert.Equal(t, tt.header, seen, "expect client id to be kept")
+			} else {
+				assert.NotEqual(t, tt.header, seen, "expect a new id")
+				assert.True(t, validRequestId(seen), "expect generated id to be valid")
+			}
+		})
+	}
+}
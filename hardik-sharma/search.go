@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrInvalidSearch = errors.New("invalid search")
+
+const (
+	DefaultSearchLimit   = 20
+	MaxSearchLimit       = 100
+	MaxSearchQueryLength = 200
+	maxSearchTerms       = 8
+)
+
+// SearchOptions describes a search, Terms is filled in by normalize.
+type SearchOptions struct {
+	Query string
+	Limit int
+	Terms []string
+}
+
+// SearchHit is a customer found by a search, scores only compare the hits
+// of one search.
+type SearchHit struct {
+	Customer   Customer                       `json:"customer"`
+	Score      float64                        `json:"score"`
+	Highlights map[string][]HighlightFragment `json:"highlights"`
+}
+
+type HighlightFragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+type SearchResults struct {
+	Hits []SearchHit `json:"hits"`
+}
+
+var searchFields = []struct {
+	path   string
+	weight float64
+	value  func(c Customer) string
+}{
+	{"customerDetails.name", 1, func(c Customer) string { return c.CustomerDetails.Name }},
+	{"customerDetails.address", 0.6, func(c Customer) string { return c.CustomerDetails.Address }},
+}
+
+func (o SearchOptions) normalize() (SearchOptions, error) {
+	query := strings.TrimSpace(o.Query)
+	if query == "" {
+		return o, fmt.Errorf("%w: q is required", ErrInvalidSearch)
+	}
+
+	if utf8.RuneCountInString(query) > MaxSearchQueryLength {
+		return o, fmt.Errorf("%w: q must be at most %d characters", ErrInvalidSearch, MaxSearchQueryLength)
+	}
+
+	if o.Limit < 0 || o.Limit > MaxSearchLimit {
+		return o, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidSearch, MaxSearchLimit)
+	}
+
+	if o.Limit == 0 {
+		o.Limit = DefaultSearchLimit
+	}
+
+	o.Terms = nil
+	for _, word := range splitWords(query) {
+		o.Terms = append(o.Terms, string(word.lower))
+	}
+
+	if len(o.Terms) == 0 {
+		return o, fmt.Errorf("%w: q has no letters or digits", ErrInvalidSearch)
+	}
+
+	if len(o.Terms) > maxSearchTerms {
+		return o, fmt.Errorf("%w: q may have at most %d words", ErrInvalidSearch, maxSearchTerms)
+	}
+
+	return o, nil
+}
+
+// searchWord keeps the byte offset of each rune and of the word's end.
+type searchWord struct {
+	lower   []rune
+	offsets []int
+}
+
+func splitWords(text string) []searchWord {
+	var words []searchWord
+	var current *searchWord
+
+	for i, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if current != nil {
+				current.offsets = append(current.offsets, i)
+				current = nil
+			}
+			continue
+		}
+
+		if current == nil {
+			words = append(words, searchWord{})
+			current = &words[len(words)-1]
+		}
+		current.lower = append(current.lower, unicode.ToLower(r))
+		current.offsets = append(current.offsets, i)
+	}
+
+	if current != nil {
+		current.offsets = append(current.offsets, len(text))
+	}
+
+	return words
+}
+
+// matchTerm ranks whole words above prefixes, substrings and words a few
+// typos away, in that order.
+func matchTerm(term []rune, word []rune) (score float64, start int, end int, ok bool) {
+	if index := runeIndex(word, term); index >= 0 {
+		switch {
+		case len(term) == len(word):
+			return 1, 0, len(word), true
+		case index == 0:
+			return 0.8, 0, len(term), true
+		case len(term) >= 3:
+			return 0.6, index, index + len(term), true
+		}
+	}
+
+	allowed := allowedEdits(len(term))
+	if allowed == 0 {
+		return 0, 0, 0, false
+	}
+
+	// a term may be a mistyped prefix of a longer word, so it is compared
+	// with the prefixes of about its length as well
+	distance := editDistance(term, word)
+	for length := max(len(term)-allowed, 1); length < len(word) && length <= len(term)+allowed; length++ {
+		distance = min(distance, editDistance(term, word[:length]))
+	}
+
+	if distance > allowed {
+		return 0, 0, 0, false
+	}
+
+	return 0.5 * (1 - float64(distance)/float64(len(term)+1)), 0, len(word), true
+}
+
+func allowedEdits(length int) int {
+	switch {
+	case length >= 8:
+		return 2
+	case length >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func runeIndex(word []rune, term []rune) int {
+	for i := 0; i+len(term) <= len(word); i++ {
+		if string(word[i:i+len(term)]) == string(term) {
+			return i
+		}
+	}
+	return -1
+}
+
+// editDistance is the optimal string alignment distance, swapped
+// neighbours count as one edit.
+func editDistance(a []rune, b []rune) int {
+	beforePrevious := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = min(current[j], beforePrevious[j-2]+1)
+			}
+		}
+		beforePrevious, previous, current = previous, current, beforePrevious
+	}
+
+	return previous[len(b)]
+}
+
+func scoreCustomer(customer Customer, terms []string) (score float64, matchesAll bool, highlights map[string][]HighlightFragment) {
+	type span struct{ start, end int }
+	spans := make([][]span, len(searchFields))
+	words := make([][]searchWord, len(searchFields))
+	for i, field := range searchFields {
+		words[i] = splitWords(field.value(customer))
+	}
+
+	matchesAll = true
+	for _, term := range terms {
+		termRunes := []rune(term)
+		best := 0.0
+
+		for i, field := range searchFields {
+			for _, word := range words[i] {
+				wordScore, start, end, ok := matchTerm(termRunes, word.lower)
+				if !ok {
+					continue
+				}
+
+				spans[i] = append(spans[i], span{word.offsets[start], word.offsets[end]})
+				best = max(best, wordScore*field.weight)
+			}
+		}
+
+		if best == 0 {
+			matchesAll = false
+		}
+		score += best
+	}
+
+	highlights = map[string][]HighlightFragment{}
+	for i, field := range searchFields {
+		if len(spans[i]) == 0 {
+			continue
+		}
+
+		sort.Slice(spans[i], func(a, b int) bool { return spans[i][a].start < spans[i][b].start })
+
+		value := field.value(customer)
+		var fragments []HighlightFragment
+		position := 0
+		for _, s := range spans[i] {
+			if s.end <= position {
+				continue
+			}
+			if s.start > position {
+				fragments = append(fragments, HighlightFragment{Text: value[position:s.start]})
+			}
+			fragments = append(fragments, HighlightFragment{Text: value[max(s.start, position):s.end], Match: true})
+			position = s.end
+		}
+		if position < len(value) {
+			fragments = append(fragments, HighlightFragment{Text: value[position:]})
+		}
+
+		highlights[field.path] = fragments
+	}
+
+	return score / float64(len(terms)), matchesAll, highlights
+}
+
+func rankCustomers(customers []Customer, opts SearchOptions) []SearchHit {
+	hits := []SearchHit{}
+	for _, customer := range customers {
+		score, matchesAll, highlights := scoreCustomer(customer, opts.Terms)
+		if !matchesAll {
+			continue
+		}
+
+		hits = append(hits, SearchHit{Customer: customer, Score: score, Highlights: highlights})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Customer.Id < hits[j].Customer.Id
+	})
+
+	if len(hits) > opts.Limit {
+		hits = hits[:opts.Limit]
+	}
+
+	return hits
+}
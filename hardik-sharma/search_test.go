@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchOptions_normalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      SearchOptions
+		wantTerms []string
+		wantLimit int
+		wantErr   bool
+	}{
+		{
+			name:      "words are lower cased and split on punctuation",
+			opts:      SearchOptions{Query: " Hardik,  UDAIPUR-313001 "},
+			wantTerms: []string{"hardik", "udaipur", "313001"},
+			wantLimit: DefaultSearchLimit,
+		},
+		{
+			name:      "limit is kept",
+			opts:      SearchOptions{Query: "hardik", Limit: 5},
+			wantTerms: []string{"hardik"},
+			wantLimit: 5,
+		},
+		{
+			name:    "empty query",
+			opts:    SearchOptions{Query: "   "},
+			wantErr: true,
+		},
+		{
+			name:    "query without words",
+			opts:    SearchOptions{Query: "%%"},
+			wantErr: true,
+		},
+		{
+			name:    "too many words",
+			opts:    SearchOptions{Query: "a b c d e f g h i"},
+			wantErr: true,
+		},
+		{
+			name:    "limit too large",
+			opts:    SearchOptions{Query: "hardik", Limit: MaxSearchLimit + 1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.normalize()
+
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidSearch), "expect invalid search, got %v", err)
+				return
+			}
+
+			assert.NoError(t, err, "expect no error")
+			assert.Equal(t, tt.wantTerms, got.Terms, "expect terms to be same")
+			assert.Equal(t, tt.wantLimit, got.Limit, "expect limit to be same")
+		})
+	}
+}
+
+func Test_editDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"hardik", "hardik", 0},
+		{"hardk", "hardik", 1},
+		{"udaipur", "udiapur", 1},
+		{"sharam", "sharma", 1},
+		{"", "abc", 3},
+		{"jaipur", "jodhpur", 3},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, editDistance([]rune(tt.a), []rune(tt.b)), "distance of %q and %q", tt.a, tt.b)
+	}
+}
+
+func Test_matchTerm(t *testing.T) {
+	tests := []struct {
+		name      string
+		term      string
+		word      string
+		wantScore float64
+		wantSpan  [2]int
+		wantOk    bool
+	}{
+		{name: "whole word", term: "hardik", word: "hardik", wantScore: 1, wantSpan: [2]int{0, 6}, wantOk: true},
+		{name: "prefix", term: "har", word: "hardik", wantScore: 0.8, wantSpan: [2]int{0, 3}, wantOk: true},
+		{name: "substring", term: "dik", word: "hardik", wantScore: 0.6, wantSpan: [2]int{3, 6}, wantOk: true},
+		{name: "short substring", term: "ik", word: "hardik"},
+		{name: "typo", term: "hardk", word: "hardik", wantScore: 0.5 * (1 - 1.0/6), wantSpan: [2]int{0, 6}, wantOk: true},
+		{name: "swapped letters", term: "udiapur", word: "udaipur", wantScore: 0.5 * (1 - 1.0/8), wantSpan: [2]int{0, 7}, wantOk: true},
+		{name: "mistyped prefix", term: "udiap", word: "udaipur", wantScore: 0.5 * (1 - 1.0/6), wantSpan: [2]int{0, 7}, wantOk: true},
+		{name: "too many typos", term: "udyap", word: "udaipur"},
+		{name: "short words must match exactly", term: "hsr", word: "har"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, start, end, ok := matchTerm([]rune(tt.term), []rune(tt.word))
+
+			assert.Equal(t, tt.wantOk, ok, "expect match to be same")
+			if ok {
+				assert.InDelta(t, tt.wantScore, score, 1e-9, "expect score to be same")
+				assert.Equal(t, tt.wantSpan, [2]int{start, end}, "expect matched runes to be same")
+			}
+		})
+	}
+}
+
+func Test_rankCustomers(t *testing.T) {
+	customers := []Customer{
+		{Id: "hs", CustomerDetails: CustomerDetails{Name: "Hardik Sharma", Address: "Udaipur"}},
+		{Id: "ab", CustomerDetails: CustomerDetails{Name: "Hardik", Address: "Ajmer"}},
+		{Id: "vs", CustomerDetails: CustomerDetails{Name: "Varshil", Address: "Hardik Nagar, Udaipur"}},
+		{Id: "ps", CustomerDetails: CustomerDetails{Name: "Paramveer", Address: "Jaipur"}},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		limit          int
+		wantIds        []string
+		wantHighlights map[string][]HighlightFragment
+	}{
+		{
+			name:    "name matches rank above address matches",
+			query:   "hardik",
+			wantIds: []string{"ab", "hs", "vs"},
+			wantHighlights: map[string][]HighlightFragment{
+				"customerDetails.name": {{Text: "Hardik", Match: true}},
+			},
+		},
+		{
+			name:    "every word has to match",
+			query:   "hardik udaipur",
+			wantIds: []string{"hs", "vs"},
+			wantHighlights: map[string][]HighlightFragment{
+				"customerDetails.name":    {{Text: "Hardik", Match: true}, {Text: " Sharma"}},
+				"customerDetails.address": {{Text: "Udaipur", Match: true}},
+			},
+		},
+		{
+			name:    "typos are tolerated",
+			query:   "sharam",
+			wantIds: []string{"hs"},
+			wantHighlights: map[string][]HighlightFragment{
+				"customerDetails.name": {{Text: "Hardik "}, {Text: "Sharma", Match: true}},
+			},
+		},
+		{
+			name:    "substrings match inside words",
+			query:   "pur",
+			wantIds: []string{"hs", "ps", "vs"},
+			wantHighlights: map[string][]HighlightFragment{
+				"customerDetails.address": {{Text: "Udai"}, {Text: "pur", Match: true}},
+			},
+		},
+		{
+			name:    "limit",
+			query:   "hardik",
+			limit:   1,
+			wantIds: []string{"ab"},
+			wantHighlights: map[string][]HighlightFragment{
+				"customerDetails.name": {{Text: "Hardik", Match: true}},
+			},
+		},
+		{
+			name:    "no match",
+			query:   "mumbai",
+			wantIds: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := SearchOptions{Query: tt.query, Limit: tt.limit}.normalize()
+			if err != nil {
+				t.Fatal("invalid search:", err)
+			}
+
+			hits := rankCustomers(customers, opts)
+
+			gotIds := []string{}
+			for _, hit := range hits {
+				gotIds = append(gotIds, hit.Customer.Id)
+			}
+			assert.Equal(t, tt.wantIds, gotIds, "expect hits to be same")
+
+			if len(hits) > 0 {
+				assert.Equal(t, tt.wantHighlights, hits[0].Highlights, "expect highlights of the best hit to be same")
+			}
+		})
+	}
+}
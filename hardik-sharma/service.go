@@ -1,139 +1,845 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"log"
+	"sync"
+	"time"
 )
 
 var ErrInvalidId = errors.New("invalid id")
 var ErrInvalidContactNo = errors.New("invalid contact number")
 
 type CustomerService interface {
-	addCustomer(Customer) error
-	updateCustomer(Customer) error
-	getAllCustomer() ([]Customer, error)
-	getCustomerById(id string) (Customer, error)
-	deleteCustomer(id string) error
-	subscribe(s Subscriber)
+	addCustomer(ctx context.Context, customer Customer) (Customer, error)
+	updateCustomer(ctx context.Context, customer Customer, ifVersion int64) (Customer, error)
+	getAllCustomer(ctx context.Context) ([]Customer, error)
+	patchCustomer(ctx context.Context, id string, patch DocumentPatch, ifVersion int64) (Customer, error)
+	listCustomers(ctx context.Context, opts ListOptions) (CustomerPage, error)
+	searchCustomers(ctx context.Context, opts SearchOptions) ([]SearchHit, error)
+	getCustomerById(ctx context.Context, id string) (Customer, error)
+	getCustomerAsOf(ctx context.Context, id string, at time.Time) (Customer, error)
+	customerHistory(ctx context.Context, id string) ([]Revision, error)
+	deleteCustomer(ctx context.Context, id string, ifVersion int64) error
+	listTrash(ctx context.Context) ([]Customer, error)
+	restoreCustomer(ctx context.Context, id string, ifVersion int64) (Customer, error)
+	queryAudit(ctx context.Context, filter AuditFilter) (AuditPage, error)
+	verifyAudit(ctx context.Context) (AuditVerification, error)
+	purgeTrash(ctx context.Context) (int, error)
+	subscribe(ctx context.Context, s Subscriber)
+	subscribeWithSnapshot(ctx context.Context, s Subscriber) error
+	resumeSubscription(ctx context.Context, s Subscriber, since uint64) error
 	unSubscribe(s Subscriber)
+	NotificationStats() NotificationStats
+}
+
+// OperationTimeouts bounds how long each kind of repository call may run.
+// A zero duration leaves the caller's deadline untouched.
+type OperationTimeouts struct {
+	Create  time.Duration
+	Update  time.Duration
+	Delete  time.Duration
+	GetById time.Duration
+	GetAll  time.Duration
+	Audit   time.Duration
+}
+
+var DefaultOperationTimeouts = OperationTimeouts{
+	Create:  5 * time.Second,
+	Update:  5 * time.Second,
+	Delete:  5 * time.Second,
+	GetById: 3 * time.Second,
+	GetAll:  10 * time.Second,
+	Audit:   5 * time.Second,
 }
 
 type Service struct {
 	customerRepo   Repo
-	subscriberList []Subscriber
+	subscriberMu   sync.RWMutex
+	subscriberList []*subscriberQueue
+	notifyMu       sync.Mutex
+	sequence       uint64
+	changes        *changeLog
+	queueSize      int
+	overflowPolicy OverflowPolicy
+	counters       notificationCounters
+	timeouts       OperationTimeouts
+	newId          IdGenerator
+	feed           ChangeFeed
+	stopFeed       context.CancelFunc
+	feedDone       chan struct{}
+	phoneRegion    string
+	now            func() time.Time
+	trashRetention time.Duration
+	purgeInterval  time.Duration
+	stopPurge      context.CancelFunc
+	purgeDone      chan struct{}
+	auditLog       AuditLog
 }
 
-func NewService(repo Repo) *Service {
-	return &Service{customerRepo: repo}
+type ServiceOption func(*Service)
+
+func WithOperationTimeouts(timeouts OperationTimeouts) ServiceOption {
+	return func(s *Service) {
+		s.timeouts = timeouts
+	}
 }
 
-func (s *Service) subscribe(subs Subscriber) {
-	s.subscriberList = append(s.subscriberList, subs)
+func WithIdGenerator(generator IdGenerator) ServiceOption {
+	return func(s *Service) {
+		s.newId = generator
+	}
 }
 
-func (s *Service) unSubscribe(subs Subscriber) {
-	for i, subscriber := range s.subscriberList {
+// WithPhoneRegion sets the region of contact numbers given without country
+// code.
+func WithPhoneRegion(region string) ServiceOption {
+	return func(s *Service) {
+		s.phoneRegion = region
+	}
+}
+
+// WithNotifyQueue bounds how many notifications may wait for each
+// subscriber and what happens once that bound is reached.
+func WithNotifyQueue(size int, policy OverflowPolicy) ServiceOption {
+	return func(s *Service) {
+		s.queueSize = size
+		s.overflowPolicy = policy
+	}
+}
+
+// WithChangeLog sets how many recent events are kept for subscribers that
+// resume, and the sequence number the first change follows. Seeding the
+// sequence from the clock keeps numbers growing across restarts, so a
+// client resuming from an earlier run is sent a snapshot instead of events
+// that merely share its sequence numbers.
+func WithChangeLog(size int, startSequence uint64) ServiceOption {
+	return func(s *Service) {
+		s.changes = newChangeLog(size)
+		s.changes.start(startSequence)
+		s.sequence = startSequence
+	}
+}
+
+// WithChangeFeed makes subscribers receive the changes published by feed
+// instead of those made through this Service, so they also see writes from
+// other instances.
+func WithChangeFeed(feed ChangeFeed) ServiceOption {
+	return func(s *Service) {
+		s.feed = feed
+	}
+}
+
+// WithTrashPurge removes deleted customers for good after retention, a zero
+// retention keeps them forever.
+func WithTrashPurge(retention time.Duration, interval time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.trashRetention = retention
+		s.purgeInterval = interval
+	}
+}
+
+// WithClock sets where the service reads the time of writes and purges
+// from.
+func WithClock(now func() time.Time) ServiceOption {
+	return func(s *Service) {
+		s.now = now
+	}
+}
+
+// WithAuditLog sets where every attempt to change a customer is recorded.
+// Without it the entries are only kept in memory.
+func WithAuditLog(log AuditLog) ServiceOption {
+	return func(s *Service) {
+		s.auditLog = log
+	}
+}
+
+func NewService(repo Repo, opts ...ServiceOption) *Service {
+	s := &Service{
+		customerRepo:   repo,
+		changes:        newChangeLog(DefaultChangeLogSize),
+		queueSize:      DefaultNotifyQueueSize,
+		overflowPolicy: CoalesceLatest,
+		timeouts:       DefaultOperationTimeouts,
+		newId:          NewUUIDv7,
+		phoneRegion:    DefaultPhoneRegion,
+		now:            time.Now,
+		auditLog:       NewMemoryAuditLog(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
 
-		if subscriber.getSubscriberId() == subs.getSubscriberId() {
-			s.subscriberList = append(s.subscriberList[:i], s.subscriberList[i+1:]...)
+	if s.feed != nil {
+		s.startFeed()
+	}
+
+	if s.trashRetention > 0 && s.purgeInterval > 0 {
+		s.startPurge()
+	}
+
+	return s
+}
+
+// startFeed runs the change feed until Close. Until its first event
+// arrives nothing is known about the feed's numbering, so resuming
+// subscribers get a snapshot.
+func (s *Service) startFeed() {
+	// the feed numbers events itself, snapshots must not carry the seed of
+	// WithChangeLog until its first event arrives
+	s.changes.reset()
+	s.sequence = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopFeed = cancel
+	s.feedDone = make(chan struct{})
+
+	go func() {
+		defer close(s.feedDone)
+		s.feed.listen(ctx, s.publish, s.feedLost)
+	}()
+}
+
+func (s *Service) startPurge() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopPurge = cancel
+	s.purgeDone = make(chan struct{})
+
+	go func() {
+		defer close(s.purgeDone)
+
+		ticker := time.NewTicker(s.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := s.purgeTrash(contextWithActor(contextWithTenant(ctx, allTenants), systemActor))
+			if err != nil && ctx.Err() == nil {
+				log.Println("failed to purge trash:", err)
+			}
+			if purged > 0 {
+				log.Printf("purged %d customers from the trash", purged)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
 		}
+	}()
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// subscribe registers a subscriber to the changes of the tenant of ctx.
+func (s *Service) subscribe(ctx context.Context, subs Subscriber) {
+	queue := s.newQueue(ctx, subs)
+
+	s.subscriberMu.Lock()
+	defer s.subscriberMu.Unlock()
+
+	s.subscriberList = append(s.subscriberList, queue)
+}
+
+// subscribeWithSnapshot registers a subscriber whose first event is a
+// snapshot of all customers. No change can slip in between the snapshot and
+// the registration, so the snapshot plus later events is always complete.
+func (s *Service) subscribeWithSnapshot(ctx context.Context, subs Subscriber) error {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+
+	return s.registerWithSnapshot(ctx, subs)
 }
 
-func (s *Service) notify() {
-	customers, err := s.getAllCustomer()
+// resumeSubscription registers a subscriber that last saw event since. It
+// first replays the events it missed, or sends a snapshot when they are no
+// longer all in the change log or would not fit in its queue.
+func (s *Service) resumeSubscription(ctx context.Context, subs Subscriber, since uint64) error {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+
+	missed, ok := s.changes.since(since, s.sequence)
+	missed = eventsOf(missed, tenantFromContext(ctx))
+	if !ok || len(missed) > s.queueSize {
+		return s.registerWithSnapshot(ctx, subs)
+	}
+
+	s.register(ctx, subs, missed)
+	return nil
+}
+
+// registerWithSnapshot must be called with notifyMu held.
+func (s *Service) registerWithSnapshot(ctx context.Context, subs Subscriber) error {
+	customers, err := s.getAllCustomer(ctx)
 	if err != nil {
-		log.Printf("cant fetch data :%q", err)
+		return err
+	}
+
+	s.register(ctx, subs, []ChangeEvent{newSnapshotEvent(s.sequence, customers)})
+	return nil
+}
+
+// register must be called with notifyMu held.
+func (s *Service) register(ctx context.Context, subs Subscriber, initial []ChangeEvent) {
+	queue := s.newQueue(ctx, subs)
+	for _, event := range initial {
+		queue.enqueue(event)
+	}
+
+	s.subscriberMu.Lock()
+	defer s.subscriberMu.Unlock()
+
+	s.subscriberList = append(s.subscriberList, queue)
+}
+
+// newQueue makes the queue of a subscriber of the tenant of ctx, which
+// reads the customers of that tenant when it has to catch up.
+func (s *Service) newQueue(ctx context.Context, subs Subscriber) *subscriberQueue {
+	tenant := tenantFromContext(ctx)
+	queue := newSubscriberQueue(subs, s.queueSize, s.overflowPolicy, &s.counters, func() ([]Customer, error) {
+		return s.getAllCustomer(contextWithTenant(context.Background(), tenant))
+	})
+	queue.tenant = tenant
+	return queue
+}
+
+func (s *Service) unSubscribe(subs Subscriber) {
+	removed := s.removeSubscribers(func(queue *subscriberQueue) bool {
+		return queue.subscriber.getSubscriberId() == subs.getSubscriberId()
+	})
+
+	for _, queue := range removed {
+		queue.discard()
+	}
+}
+
+// removeSubscribers builds a fresh list rather than shifting elements in
+// place so that copies handed out by subscribers() are never modified.
+func (s *Service) removeSubscribers(match func(*subscriberQueue) bool) []*subscriberQueue {
+	s.subscriberMu.Lock()
+	defer s.subscriberMu.Unlock()
+
+	var removed []*subscriberQueue
+	remaining := make([]*subscriberQueue, 0, len(s.subscriberList))
+	for _, queue := range s.subscriberList {
+		if match(queue) {
+			removed = append(removed, queue)
+			continue
+		}
+		remaining = append(remaining, queue)
+	}
+	s.subscriberList = remaining
+
+	return removed
+}
+
+func (s *Service) subscribers() []*subscriberQueue {
+	s.subscriberMu.RLock()
+	defer s.subscriberMu.RUnlock()
+
+	subscribers := make([]*subscriberQueue, len(s.subscriberList))
+	copy(subscribers, s.subscriberList)
+	return subscribers
+}
+
+// notify serializes numbering and queueing so every subscriber sees events
+// in sequence order. With a change feed the change arrives through the feed
+// instead.
+func (s *Service) notify(ctx context.Context, eventType string, customer Customer) {
+	if s.feed != nil {
 		return
 	}
 
-	for _, subscriber := range s.subscriberList {
-		subscriber.update(customers)
+	// the write already happened, so a caller going away must not
+	// swallow the notification
+	ctx = context.WithoutCancel(ctx)
+
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+
+	s.sequence++
+	s.enqueueAll(ChangeEvent{
+		Type:     eventType,
+		Sequence: s.sequence,
+		Customer: &customer,
+		Tenant:   tenantFromContext(ctx),
+	})
+}
+
+// publish hands on an event already numbered by the change feed. Events
+// without a tenant are of the default one.
+func (s *Service) publish(event ChangeEvent) {
+	if event.Tenant == "" {
+		event.Tenant = DefaultTenant
+	}
+
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+
+	if event.Sequence > s.sequence {
+		s.sequence = event.Sequence
 	}
+	s.enqueueAll(event)
 }
 
-func calculateDigits(num int) int {
-	count := 0
-	for i := 0; num != 0; i++ {
-		num = num / 10
-		count++
+// feedLost disconnects every subscriber that can be, as they may have
+// missed events. They resume once reconnected and are sent a snapshot,
+// since the change log no longer covers their position.
+func (s *Service) feedLost() {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+
+	s.changes.reset()
+
+	removed := s.removeSubscribers(func(queue *subscriberQueue) bool {
+		_, ok := queue.subscriber.(disconnecter)
+		return ok
+	})
+
+	for _, queue := range removed {
+		queue.discard()
+		s.counters.disconnected.Add(1)
+		queue.subscriber.(disconnecter).disconnect()
+	}
+}
+
+// enqueueAll hands event to the subscribers of its tenant, it must be
+// called with notifyMu held.
+func (s *Service) enqueueAll(event ChangeEvent) {
+	s.changes.append(event)
+
+	dropped := map[*subscriberQueue]bool{}
+	for _, queue := range s.subscribers() {
+		if queue.tenant != event.Tenant {
+			continue
+		}
+		if !queue.enqueue(event) {
+			dropped[queue] = true
+		}
+	}
+
+	if len(dropped) > 0 {
+		s.removeSubscribers(func(queue *subscriberQueue) bool {
+			return dropped[queue]
+		})
 	}
-	return count
 }
 
+func (s *Service) NotificationStats() NotificationStats {
+	return s.counters.snapshot()
+}
+
+// Close stops the change feed and the trash purge, delivers whatever is
+// still queued and stops all subscriber goroutines.
+func (s *Service) Close() {
+	if s.stopPurge != nil {
+		s.stopPurge()
+		<-s.purgeDone
+	}
+
+	if s.stopFeed != nil {
+		s.stopFeed()
+		<-s.feedDone
+	}
+
+	removed := s.removeSubscribers(func(*subscriberQueue) bool {
+		return true
+	})
+
+	for _, queue := range removed {
+		queue.stop()
+	}
+}
+
+// validateId accepts server generated UUIDs and ULIDs as well as the two
+// character ids that clients used to choose themselves.
 func validateId(id string) error {
-	if len(id) != 2 {
+	if !isLegacyId(id) && !isUUID(id) && !isULID(id) {
 		return ErrInvalidId
 	}
 	return nil
 }
 
-func validateContactNo(contactNo int) error {
-	if calculateDigits(contactNo) != 10 {
+// validateContactNo accepts plausible numbers in E.164 form.
+func validateContactNo(contactNo string) error {
+	normalized, err := NormalizePhoneNumber(contactNo, "")
+	if err != nil || string(normalized) != contactNo {
 		return ErrInvalidContactNo
 	}
 	return nil
 }
 
-func validateCustomer(customer Customer) error {
-	if err := validateId(customer.Id); err != nil {
-		return err
+// normalizeContactNo rewrites the contact number in E.164 form, reading it
+// as a number of the default region when it has no country code. Numbers
+// that can't be parsed are kept for validation to reject.
+func (s *Service) normalizeContactNo(customer Customer) Customer {
+	if normalized, err := NormalizePhoneNumber(string(customer.CustomerDetails.ContactNo), s.phoneRegion); err == nil {
+		customer.CustomerDetails.ContactNo = normalized
 	}
+	return customer
+}
 
-	if err := validateContactNo(customer.CustomerDetails.ContactNo); err != nil {
-		return err
+// change describes a write made now by the actor of ctx. The time is cut
+// to microseconds, which is what the databases keep.
+func (s *Service) change(ctx context.Context) Change {
+	return Change{Actor: actorFromContext(ctx), At: s.now().UTC().Truncate(time.Microsecond)}
+}
+
+// audit only logs a failure to record the attempt, the change was already
+// made or refused.
+func (s *Service) audit(ctx context.Context, change Change, operation string, id string, err error) {
+	entry := AuditEntry{
+		Tenant:    tenantFromContext(ctx),
+		At:        change.At,
+		Actor:     change.Actor,
+		SourceIP:  sourceIPFromContext(ctx),
+		RequestId: requestIdFromContext(ctx),
+		Operation: operation,
+		TargetId:  id,
+		Outcome:   AuditSuccess,
+	}
+	if err != nil {
+		entry.Outcome = AuditFailure
+		entry.Reason = problemTypeOf(err).code
 	}
 
-	return nil
+	auditCtx, cancel := withTimeout(context.WithoutCancel(ctx), s.timeouts.Audit)
+	defer cancel()
+
+	if _, err := s.auditLog.append(auditCtx, entry); err != nil {
+		log.Printf("failed to record %s of customer %q in the audit log :%q", operation, id, err)
+	}
 }
 
-func (s *Service) addCustomer(customer Customer) error {
+// addCustomer stores a new customer under a freshly generated id, any id
+// supplied by the caller is discarded.
+func (s *Service) addCustomer(ctx context.Context, customer Customer) (_ Customer, err error) {
+	customer.Id = ""
+	change := s.change(ctx)
+	defer func() { s.audit(ctx, change, AuditCreate, customer.Id, err) }()
+
+	id, err := s.newId()
+	if err != nil {
+		return Customer{}, err
+	}
+	customer.Id = id
+	customer.Version = 1
+	customer.DeletedAt = nil
+	customer = s.normalizeContactNo(customer)
+
 	if err := validateCustomer(customer); err != nil {
-		return err
+		return Customer{}, err
 	}
 
-	if err := s.customerRepo.create(customer); err != nil {
-		return err
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.Create)
+	defer cancel()
+
+	if err := s.customerRepo.create(repoCtx, customer, change); err != nil {
+		return Customer{}, err
 	}
 
-	s.notify()
-	return nil
+	s.notify(ctx, EventCustomerCreated, customer)
+	return customer, nil
 }
 
-func (s *Service) updateCustomer(customer Customer) error {
+// updateCustomer replaces the customer's details and returns it under its
+// new version. A non-zero ifVersion guards against overwriting a change the
+// caller has not seen.
+func (s *Service) updateCustomer(ctx context.Context, customer Customer, ifVersion int64) (_ Customer, err error) {
+	change := s.change(ctx)
+	defer func() { s.audit(ctx, change, AuditUpdate, customer.Id, err) }()
+
+	customer.DeletedAt = nil
+	customer = s.normalizeContactNo(customer)
 	if err := validateCustomer(customer); err != nil {
-		return err
+		return Customer{}, err
 	}
 
-	if err := s.customerRepo.update(customer.Id, customer); err != nil {
-		return err
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.Update)
+	defer cancel()
+
+	updated, err := s.customerRepo.update(repoCtx, customer.Id, customer, ifVersion, change)
+	if err != nil {
+		return Customer{}, err
 	}
 
-	s.notify()
-	return nil
+	s.notify(ctx, EventCustomerUpdated, updated)
+	return updated, nil
+}
+
+// maxPatchAttempts bounds how often patchCustomer starts over when another
+// write lands between reading and saving a customer.
+const maxPatchAttempts = 3
+
+// patchCustomer applies patch to the stored customer and saves only the
+// fields it changed. The patched customer must pass the same validation as
+// a full update.
+func (s *Service) patchCustomer(ctx context.Context, id string, patch DocumentPatch, ifVersion int64) (_ Customer, err error) {
+	change := s.change(ctx)
+	defer func() { s.audit(ctx, change, AuditPatch, id, err) }()
+
+	if err := validateId(id); err != nil {
+		return Customer{}, err
+	}
+
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.Update)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		updated, changed, err := s.patchOnce(repoCtx, id, patch, ifVersion, change)
+		// without If-Match the caller didn't see the concurrent write,
+		// the patch is applied again on top of it
+		if errors.Is(err, ErrVersionConflict) && ifVersion == 0 && attempt < maxPatchAttempts {
+			continue
+		}
+		if err != nil {
+			return Customer{}, err
+		}
+
+		if changed {
+			s.notify(ctx, EventCustomerUpdated, updated)
+		}
+		return updated, nil
+	}
+}
+
+// patchOnce reads, patches and saves the customer once, failing with
+// ErrVersionConflict when it was changed meanwhile. changed tells whether
+// anything was saved.
+func (s *Service) patchOnce(ctx context.Context, id string, patch DocumentPatch, ifVersion int64, change Change) (_ Customer, changed bool, _ error) {
+	current, err := s.customerRepo.getById(ctx, id)
+	if err != nil {
+		return Customer{}, false, err
+	}
+
+	if ifVersion != 0 && current.Version != ifVersion {
+		return Customer{}, false, ErrVersionConflict
+	}
+
+	patched, err := applyPatch(current, patch)
+	if err != nil {
+		return Customer{}, false, err
+	}
+	patched = s.normalizeContactNo(patched)
+
+	if err := validateCustomer(patched); err != nil {
+		return Customer{}, false, err
+	}
+
+	changes := diffCustomers(current, patched)
+	if changes.isEmpty() {
+		// nothing to save, but current must still be what is stored
+		latest, err := s.customerRepo.getById(ctx, id)
+		if err != nil {
+			return Customer{}, false, err
+		}
+		if latest.Version != current.Version {
+			return Customer{}, false, ErrVersionConflict
+		}
+		return current, false, nil
+	}
+
+	updated, err := s.customerRepo.patch(ctx, id, changes, current.Version, change)
+	if err != nil {
+		return Customer{}, false, err
+	}
+
+	return updated, true, nil
 }
 
-func (s *Service) getAllCustomer() ([]Customer, error) {
-	return s.customerRepo.getAll()
+func (s *Service) getAllCustomer(ctx context.Context) ([]Customer, error) {
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.GetAll)
+	defer cancel()
+
+	return s.customerRepo.getAll(repoCtx)
 }
 
-func (s *Service) getCustomerById(id string) (Customer, error) {
+func (s *Service) listCustomers(ctx context.Context, opts ListOptions) (CustomerPage, error) {
+	opts, err := opts.normalize()
+	if err != nil {
+		return CustomerPage{}, err
+	}
+
+	if opts.ContactNo != "" {
+		if opts.ContactNo, err = NormalizePhoneNumber(string(opts.ContactNo), s.phoneRegion); err != nil {
+			return CustomerPage{}, ErrInvalidListOptions
+		}
+	}
+
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.GetAll)
+	defer cancel()
+
+	if !opts.AsOf.IsZero() {
+		customers, err := s.customerRepo.asOf(repoCtx, opts.AsOf)
+		if err != nil {
+			return CustomerPage{}, err
+		}
+
+		return paginate(customers, opts)
+	}
+
+	return s.customerRepo.list(repoCtx, opts)
+}
+
+func (s *Service) searchCustomers(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	opts, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.GetAll)
+	defer cancel()
+
+	return s.customerRepo.search(repoCtx, opts)
+}
+
+func (s *Service) getCustomerById(ctx context.Context, id string) (Customer, error) {
 	if err := validateId(id); err != nil {
 		return Customer{}, err
 	}
 
-	return s.customerRepo.getById(id)
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.GetById)
+	defer cancel()
+
+	return s.customerRepo.getById(repoCtx, id)
+}
+
+// getCustomerAsOf returns the customer as it was at the given time, which
+// is ErrNotFound when it didn't exist yet or was in the trash.
+func (s *Service) getCustomerAsOf(ctx context.Context, id string, at time.Time) (Customer, error) {
+	revisions, err := s.customerHistory(ctx, id)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	customer, ok := customerAsOf(revisions, at)
+	if !ok {
+		return Customer{}, ErrNotFound
+	}
+
+	return customer, nil
+}
+
+// customerHistory returns the revisions of a customer, oldest first. It
+// is ErrNotFound for customers that were never stored or have been purged.
+func (s *Service) customerHistory(ctx context.Context, id string) ([]Revision, error) {
+	if err := validateId(id); err != nil {
+		return nil, err
+	}
+
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.GetById)
+	defer cancel()
+
+	revisions, err := s.customerRepo.history(repoCtx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return revisions, nil
 }
 
-func (s *Service) deleteCustomer(id string) error {
+func (s *Service) deleteCustomer(ctx context.Context, id string, ifVersion int64) (err error) {
+	change := s.change(ctx)
+	defer func() { s.audit(ctx, change, AuditDelete, id, err) }()
+
 	if err := validateId(id); err != nil {
 		return err
 	}
 
-	if err := s.customerRepo.delete(id); err != nil {
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.Delete)
+	defer cancel()
+
+	deleted, err := s.customerRepo.delete(repoCtx, id, ifVersion, change)
+	if err != nil {
 		return err
 	}
 
-	s.notify()
+	s.notify(ctx, EventCustomerDeleted, deleted)
 	return nil
 }
+
+func (s *Service) listTrash(ctx context.Context) ([]Customer, error) {
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.GetAll)
+	defer cancel()
+
+	return s.customerRepo.trash(repoCtx)
+}
+
+func (s *Service) restoreCustomer(ctx context.Context, id string, ifVersion int64) (_ Customer, err error) {
+	change := s.change(ctx)
+	defer func() { s.audit(ctx, change, AuditRestore, id, err) }()
+
+	if err := validateId(id); err != nil {
+		return Customer{}, err
+	}
+
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.Update)
+	defer cancel()
+
+	restored, err := s.customerRepo.restore(repoCtx, id, ifVersion, change)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	s.notify(ctx, EventCustomerRestored, restored)
+	return restored, nil
+}
+
+// queryAudit returns the audit entries matching filter, newest first.
+func (s *Service) queryAudit(ctx context.Context, filter AuditFilter) (AuditPage, error) {
+	filter, err := filter.normalize()
+	if err != nil {
+		return AuditPage{}, err
+	}
+	filter.Tenant = tenantFromContext(ctx)
+
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.GetAll)
+	defer cancel()
+
+	return s.auditLog.query(repoCtx, filter)
+}
+
+// verifyAudit reads every entry of the tenant, so it is not bounded by the
+// usual timeouts.
+func (s *Service) verifyAudit(ctx context.Context) (AuditVerification, error) {
+	return verifyAuditLog(ctx, s.auditLog, tenantFromContext(ctx))
+}
+
+// purgeTrash empties the trash without a retention. Subscribers are not
+// told, the customers were gone for them already. Every purged customer is
+// audited under its own tenant, a failure under that of ctx.
+func (s *Service) purgeTrash(ctx context.Context) (int, error) {
+	change := s.change(ctx)
+	repoCtx, cancel := withTimeout(ctx, s.timeouts.Delete)
+	defer cancel()
+
+	purged, err := s.customerRepo.purge(repoCtx, change.At.Add(-s.trashRetention))
+	for _, customer := range purged {
+		tenant := customer.Tenant
+		if tenant == "" {
+			tenant = tenantFromContext(ctx)
+		}
+		if tenant == allTenants {
+			tenant = DefaultTenant
+		}
+		s.audit(contextWithTenant(ctx, tenant), change, AuditPurge, customer.Id, nil)
+	}
+	if err != nil {
+		s.audit(ctx, change, AuditPurge, "", err)
+	}
+
+	return len(purged), err
+}
@@ -1,25 +1,30 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 type mockSubscriber struct {
-	id           string
-	customerList []Customer
+	id     string
+	events []ChangeEvent
 }
 
 func newMockSubscriber(id string) *mockSubscriber {
 	return &mockSubscriber{
-		id:           id,
-		customerList: []Customer{},
+		id:     id,
+		events: []ChangeEvent{},
 	}
 }
 
-func (m *mockSubscriber) update(customers []Customer) {
-	m.customerList = append(m.customerList, customers...)
+func (m *mockSubscriber) update(event ChangeEvent) {
+	m.events = append(m.events, event)
 	return
 }
 
@@ -27,6 +32,35 @@ func (m *mockSubscriber) getSubscriberId() string {
 	return m.id
 }
 
+// fixedId hands out the same id every time, which lets tests predict the
+// stored customer and provoke id collisions.
+func fixedId(id string) IdGenerator {
+	return func() (string, error) {
+		return id, nil
+	}
+}
+
+// blockingRepo never finishes an operation on its own, it only returns once
+// the context handed to it is done.
+type blockingRepo struct {
+	InMemoryRepo
+}
+
+func (b *blockingRepo) getAll(ctx context.Context) ([]Customer, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b *blockingRepo) list(ctx context.Context, opts ListOptions) (CustomerPage, error) {
+	<-ctx.Done()
+	return CustomerPage{}, ctx.Err()
+}
+
+func (b *blockingRepo) create(ctx context.Context, customer Customer, change Change) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
 func TestService_addCustomer(t *testing.T) {
 	type fields struct {
 		customers []Customer
@@ -37,16 +71,16 @@ func TestService_addCustomer(t *testing.T) {
 	}
 
 	tests := []struct {
-		name                  string
-		fields                fields
-		args                  args
-		wantCustomers         []Customer
-		wantErr               error
-		isSubscriber          bool
-		wantNotifiedCustomers []Customer
+		name          string
+		fields        fields
+		args          args
+		wantCustomers []Customer
+		wantErr       error
+		isSubscriber  bool
+		wantEvents    []ChangeEvent
 	}{
 		{
-			name: "invalid id without subscriber",
+			name: "client supplied id replaced without subscriber",
 			fields: fields{
 				customers: []Customer{},
 			},
@@ -56,14 +90,24 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
 				},
 			},
-			wantCustomers:         []Customer{},
-			wantErr:               ErrInvalidId,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantCustomers: []Customer{
+				{
+					Id: "hs",
+					CustomerDetails: CustomerDetails{
+						Name:      "hardik",
+						Address:   "udaipur",
+						ContactNo: "+919999999999",
+					},
+					Version: 1,
+				},
+			},
+			wantErr:      nil,
+			isSubscriber: false,
+			wantEvents:   []ChangeEvent{},
 		},
 		{
 			name: "invalid contact number without subscriber",
@@ -76,14 +120,14 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 999999,
+						ContactNo: "999999",
 					},
 				},
 			},
-			wantCustomers:         []Customer{},
-			wantErr:               ErrInvalidContactNo,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantCustomers: []Customer{},
+			wantErr:       ErrInvalidContactNo,
+			isSubscriber:  false,
+			wantEvents:    []ChangeEvent{},
 		},
 		{
 			name: "valid customer without subscriber",
@@ -96,7 +140,7 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
 				},
 			},
@@ -106,13 +150,14 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
+					Version: 1,
 				},
 			},
-			wantErr:               nil,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantErr:      nil,
+			isSubscriber: false,
+			wantEvents:   []ChangeEvent{},
 		},
 		{
 			name: "adding existing customer without subscriber",
@@ -123,7 +168,7 @@ func TestService_addCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 9649127559,
+							ContactNo: "+919649127559",
 						},
 					},
 				},
@@ -134,7 +179,7 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9649127559,
+						ContactNo: "+919649127559",
 					},
 				},
 			},
@@ -144,13 +189,13 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9649127559,
+						ContactNo: "+919649127559",
 					},
 				},
 			},
-			wantErr:               ErrConflict,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantErr:      ErrConflict,
+			isSubscriber: false,
+			wantEvents:   []ChangeEvent{},
 		},
 		{
 			name: "valid customer with subscriber",
@@ -163,7 +208,7 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
 				},
 			},
@@ -173,19 +218,26 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
+					Version: 1,
 				},
 			},
 			wantErr:      nil,
 			isSubscriber: true,
-			wantNotifiedCustomers: []Customer{
+			wantEvents: []ChangeEvent{
 				{
-					Id: "hs",
-					CustomerDetails: CustomerDetails{
-						Name:      "hardik",
-						Address:   "udaipur",
-						ContactNo: 9999999999,
+					Type:     EventCustomerCreated,
+					Sequence: 1,
+					Tenant:   DefaultTenant,
+					Customer: &Customer{
+						Id: "hs",
+						CustomerDetails: CustomerDetails{
+							Name:      "hardik",
+							Address:   "udaipur",
+							ContactNo: "+919999999999",
+						},
+						Version: 1,
 					},
 				},
 			},
@@ -199,7 +251,7 @@ func TestService_addCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 9649127559,
+							ContactNo: "+919649127559",
 						},
 					},
 				},
@@ -210,7 +262,7 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9649127559,
+						ContactNo: "+919649127559",
 					},
 				},
 			},
@@ -220,33 +272,34 @@ func TestService_addCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9649127559,
+						ContactNo: "+919649127559",
 					},
 				},
 			},
-			wantErr:               ErrConflict,
-			isSubscriber:          true,
-			wantNotifiedCustomers: []Customer{},
+			wantErr:      ErrConflict,
+			isSubscriber: true,
+			wantEvents:   []ChangeEvent{},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := &InMemoryRepo{customers: tt.fields.customers}
-			service := &Service{customerRepo: repo}
+			service := NewService(repo, WithIdGenerator(fixedId("hs")))
 			subscriber1 := newMockSubscriber("1")
 
 			if tt.isSubscriber {
-				service.subscribe(subscriber1)
+				service.subscribe(context.Background(), subscriber1)
 			}
 
-			gotErr := service.addCustomer(tt.args.newCustomer)
+			_, gotErr := service.addCustomer(context.Background(), tt.args.newCustomer)
+			service.Close()
 
-			assert.ErrorIs(t, tt.wantErr, gotErr, "expected error to be same")
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
 
 			assert.Equal(t, tt.wantCustomers, repo.customers, "expect customers to be matched")
 
-			assert.Equal(t, tt.wantNotifiedCustomers, subscriber1.customerList, "expect customer list to be matched")
+			assert.Equal(t, tt.wantEvents, subscriber1.events, "expect events to be matched")
 		})
 	}
 }
@@ -258,16 +311,17 @@ func TestService_updateCustomer(t *testing.T) {
 
 	type args struct {
 		updatedCustomer Customer
+		ifVersion       int64
 	}
 
 	tests := []struct {
-		name                  string
-		fields                fields
-		args                  args
-		wantCustomers         []Customer
-		wantErr               error
-		isSubscriber          bool
-		wantNotifiedCustomers []Customer
+		name          string
+		fields        fields
+		args          args
+		wantCustomers []Customer
+		wantErr       error
+		isSubscriber  bool
+		wantEvents    []ChangeEvent
 	}{
 		{
 			name: "invalid id without subscriber",
@@ -280,14 +334,14 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
 				},
 			},
-			wantCustomers:         []Customer{},
-			wantErr:               ErrInvalidId,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantCustomers: []Customer{},
+			wantErr:       ErrInvalidId,
+			isSubscriber:  false,
+			wantEvents:    []ChangeEvent{},
 		},
 		{
 			name: "invalid contact number without subscriber",
@@ -300,14 +354,14 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 999999,
+						ContactNo: "999999",
 					},
 				},
 			},
-			wantCustomers:         []Customer{},
-			wantErr:               ErrInvalidContactNo,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantCustomers: []Customer{},
+			wantErr:       ErrInvalidContactNo,
+			isSubscriber:  false,
+			wantEvents:    []ChangeEvent{},
 		},
 		{
 			name: "valid updation without subscriber",
@@ -318,7 +372,7 @@ func TestService_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "varshil",
 							Address:   "rj",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 					{
@@ -326,7 +380,7 @@ func TestService_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "param",
 							Address:   "rj",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 				},
@@ -337,7 +391,7 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
 				},
 			},
@@ -347,7 +401,7 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "varshil",
 						Address:   "rj",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
 				},
 				{
@@ -355,13 +409,14 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
+					Version: 1,
 				},
 			},
-			wantErr:               nil,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantErr:      nil,
+			isSubscriber: false,
+			wantEvents:   []ChangeEvent{},
 		},
 		{
 			name: "updating non existing customer without subscriber",
@@ -372,7 +427,7 @@ func TestService_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 9649127559,
+							ContactNo: "+919649127559",
 						},
 					},
 				},
@@ -383,7 +438,7 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "rj",
-						ContactNo: 9649127559,
+						ContactNo: "+919649127559",
 					},
 				},
 			},
@@ -393,13 +448,13 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9649127559,
+						ContactNo: "+919649127559",
 					},
 				},
 			},
-			wantErr:               ErrNotFound,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantErr:      ErrNotFound,
+			isSubscriber: false,
+			wantEvents:   []ChangeEvent{},
 		},
 		{
 			name: "valid updation with subscriber",
@@ -410,7 +465,7 @@ func TestService_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "varshil",
 							Address:   "rj",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 					{
@@ -418,7 +473,7 @@ func TestService_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "param",
 							Address:   "rj",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 				},
@@ -429,7 +484,7 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
 				},
 			},
@@ -439,7 +494,7 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "varshil",
 						Address:   "rj",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
 				},
 				{
@@ -447,33 +502,70 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
+					Version: 1,
 				},
 			},
 			wantErr:      nil,
 			isSubscriber: true,
-			wantNotifiedCustomers: []Customer{
+			wantEvents: []ChangeEvent{
 				{
-					Id: "vs",
+					Type:     EventCustomerUpdated,
+					Sequence: 1,
+					Tenant:   DefaultTenant,
+					Customer: &Customer{
+						Id: "hs",
+						CustomerDetails: CustomerDetails{
+							Name:      "hardik",
+							Address:   "udaipur",
+							ContactNo: "+919999999999",
+						},
+						Version: 1,
+					},
+				},
+			},
+		},
+		{
+			name: "updating non existing customer with subscriber",
+			fields: fields{
+				customers: []Customer{
+					{
+						Id: "hs",
+						CustomerDetails: CustomerDetails{
+							Name:      "hardik",
+							Address:   "udaipur",
+							ContactNo: "+919649127559",
+						},
+					},
+				},
+			},
+			args: args{
+				updatedCustomer: Customer{
+					Id: "hm",
 					CustomerDetails: CustomerDetails{
-						Name:      "varshil",
+						Name:      "hardik",
 						Address:   "rj",
-						ContactNo: 9999999999,
+						ContactNo: "+919649127559",
 					},
 				},
+			},
+			wantCustomers: []Customer{
 				{
 					Id: "hs",
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9999999999,
+						ContactNo: "+919649127559",
 					},
 				},
 			},
+			wantErr:      ErrNotFound,
+			isSubscriber: true,
+			wantEvents:   []ChangeEvent{},
 		},
 		{
-			name: "updating non existing customer with subscriber",
+			name: "updating a stale version with subscriber",
 			fields: fields{
 				customers: []Customer{
 					{
@@ -481,20 +573,22 @@ func TestService_updateCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 9649127559,
+							ContactNo: "+919649127559",
 						},
+						Version: 2,
 					},
 				},
 			},
 			args: args{
 				updatedCustomer: Customer{
-					Id: "hm",
+					Id: "hs",
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "rj",
-						ContactNo: 9649127559,
+						ContactNo: "+919649127559",
 					},
 				},
+				ifVersion: 1,
 			},
 			wantCustomers: []Customer{
 				{
@@ -502,13 +596,14 @@ func TestService_updateCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 9649127559,
+						ContactNo: "+919649127559",
 					},
+					Version: 2,
 				},
 			},
-			wantErr:               ErrNotFound,
-			isSubscriber:          true,
-			wantNotifiedCustomers: []Customer{},
+			wantErr:      ErrVersionConflict,
+			isSubscriber: true,
+			wantEvents:   []ChangeEvent{},
 		},
 	}
 
@@ -519,16 +614,201 @@ func TestService_updateCustomer(t *testing.T) {
 			subscriber1 := newMockSubscriber("1")
 
 			if tt.isSubscriber {
-				service.subscribe(subscriber1)
+				service.subscribe(context.Background(), subscriber1)
 			}
 
-			gotErr := service.updateCustomer(tt.args.updatedCustomer)
+			_, gotErr := service.updateCustomer(context.Background(), tt.args.updatedCustomer, tt.args.ifVersion)
+			service.Close()
 
-			assert.ErrorIs(t, tt.wantErr, gotErr, "expected errors to be same")
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected errors to be same")
 
 			assert.Equal(t, tt.wantCustomers, repo.customers, "expected customer list to be same")
 
-			assert.Equal(t, tt.wantNotifiedCustomers, subscriber1.customerList, "expected customer list to be same")
+			assert.Equal(t, tt.wantEvents, subscriber1.events, "expected events to be same")
+		})
+	}
+}
+
+func TestService_patchCustomer(t *testing.T) {
+	existing := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}, Version: 2}
+
+	tests := []struct {
+		name          string
+		id            string
+		patch         string
+		ifVersion     int64
+		wantCustomers []Customer
+		wantErr       error
+		wantEvents    []ChangeEvent
+	}{
+		{
+			name:  "changing the address",
+			id:    "hs",
+			patch: `{"customerDetails": {"address": "jaipur"}}`,
+			wantCustomers: []Customer{
+				{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+919999999999"}, Version: 3},
+			},
+			wantEvents: []ChangeEvent{
+				{
+					Type:     EventCustomerUpdated,
+					Sequence: 1,
+					Tenant:   DefaultTenant,
+					Customer: &Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+919999999999"}, Version: 3},
+				},
+			},
+		},
+		{
+			name:          "patch without changes",
+			id:            "hs",
+			patch:         `{"customerDetails": {"address": "udaipur"}}`,
+			wantCustomers: []Customer{existing},
+			wantEvents:    []ChangeEvent{},
+		},
+		{
+			name:          "invalid contact number",
+			id:            "hs",
+			patch:         `{"customerDetails": {"contactNo": 12345}}`,
+			wantCustomers: []Customer{existing},
+			wantErr:       ErrInvalidContactNo,
+			wantEvents:    []ChangeEvent{},
+		},
+		{
+			name:          "stale version",
+			id:            "hs",
+			patch:         `{"customerDetails": {"address": "jaipur"}}`,
+			ifVersion:     1,
+			wantCustomers: []Customer{existing},
+			wantErr:       ErrVersionConflict,
+			wantEvents:    []ChangeEvent{},
+		},
+		{
+			name:          "non existing customer",
+			id:            "vs",
+			patch:         `{"customerDetails": {"address": "jaipur"}}`,
+			wantCustomers: []Customer{existing},
+			wantErr:       ErrNotFound,
+			wantEvents:    []ChangeEvent{},
+		},
+		{
+			name:          "invalid id",
+			id:            "hsv",
+			patch:         `{"customerDetails": {"address": "jaipur"}}`,
+			wantCustomers: []Customer{existing},
+			wantErr:       ErrInvalidId,
+			wantEvents:    []ChangeEvent{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &InMemoryRepo{customers: []Customer{existing}}
+			service := NewService(repo)
+			subscriber := newMockSubscriber("1")
+			service.subscribe(context.Background(), subscriber)
+
+			patch, err := ParseMergePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("parsing patch failed :%v", err)
+			}
+
+			_, gotErr := service.patchCustomer(context.Background(), tt.id, patch, tt.ifVersion)
+			service.Close()
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
+
+			assert.Equal(t, tt.wantCustomers, repo.customers, "expected customer list to be same")
+
+			assert.Equal(t, tt.wantEvents, subscriber.events, "expected events to be same")
+		})
+	}
+}
+
+// racingRepo changes a customer with write right after the first getById
+// read it, as if another request got in between.
+type racingRepo struct {
+	Repo
+	write func(repo Repo)
+	raced bool
+}
+
+func (r *racingRepo) getById(ctx context.Context, id string) (Customer, error) {
+	customer, err := r.Repo.getById(ctx, id)
+	if !r.raced {
+		r.raced = true
+		r.write(r.Repo)
+	}
+	return customer, err
+}
+
+func TestService_patchCustomerConcurrentWrite(t *testing.T) {
+	existing := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}, Version: 2}
+	rename := func(repo Repo) {
+		name := "varshil"
+		repo.patch(context.Background(), "hs", CustomerPatch{Name: &name}, 0, conformanceChange)
+	}
+	move := func(repo Repo) {
+		address := "jaipur"
+		repo.patch(context.Background(), "hs", CustomerPatch{Address: &address}, 0, conformanceChange)
+	}
+
+	tests := []struct {
+		name         string
+		patch        func() (DocumentPatch, error)
+		ifVersion    int64
+		write        func(repo Repo)
+		wantCustomer Customer
+		wantErr      error
+	}{
+		{
+			name: "applied on top of the other write",
+			patch: func() (DocumentPatch, error) {
+				return ParseMergePatch([]byte(`{"customerDetails": {"address": "jaipur"}}`))
+			},
+			write:        rename,
+			wantCustomer: Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "varshil", Address: "jaipur", ContactNo: "+919999999999"}, Version: 4},
+		},
+		{
+			name: "test op checked against the other write",
+			patch: func() (DocumentPatch, error) {
+				return ParseJSONPatch([]byte(`[{"op": "test", "path": "/customerDetails/name", "value": "hardik"}, {"op": "replace", "path": "/customerDetails/address", "value": "jaipur"}]`))
+			},
+			write:   rename,
+			wantErr: ErrPatchTestFailed,
+		},
+		{
+			name: "no changes to the stale read",
+			patch: func() (DocumentPatch, error) {
+				return ParseMergePatch([]byte(`{"customerDetails": {"address": "udaipur"}}`))
+			},
+			write:        move,
+			wantCustomer: Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}, Version: 4},
+		},
+		{
+			name: "conflict with If-Match",
+			patch: func() (DocumentPatch, error) {
+				return ParseMergePatch([]byte(`{"customerDetails": {"address": "ajmer"}}`))
+			},
+			ifVersion: 2,
+			write:     rename,
+			wantErr:   ErrVersionConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &racingRepo{Repo: &InMemoryRepo{customers: []Customer{existing}}, write: tt.write}
+			service := NewService(repo)
+			defer service.Close()
+
+			patch, err := tt.patch()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotCustomer, gotErr := service.patchCustomer(context.Background(), "hs", patch, tt.ifVersion)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
+			assert.Equal(t, tt.wantCustomer, gotCustomer, "expected customer to be same")
 		})
 	}
 }
@@ -553,7 +833,7 @@ func TestService_getAll(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "rj",
-							ContactNo: 9999999999,
+							ContactNo: "+919999999999",
 						},
 					},
 					{
@@ -561,7 +841,7 @@ func TestService_getAll(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "varshil",
 							Address:   "rj",
-							ContactNo: 8888888888,
+							ContactNo: "+918888888888",
 						},
 					},
 				},
@@ -572,7 +852,7 @@ func TestService_getAll(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "rj",
-						ContactNo: 9999999999,
+						ContactNo: "+919999999999",
 					},
 				},
 				{
@@ -580,7 +860,7 @@ func TestService_getAll(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "varshil",
 						Address:   "rj",
-						ContactNo: 8888888888,
+						ContactNo: "+918888888888",
 					},
 				},
 			},
@@ -598,12 +878,12 @@ func TestService_getAll(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := &InMemoryRepo{tt.fields.customers}
+			repo := &InMemoryRepo{customers: tt.fields.customers}
 			service := NewService(repo)
 
-			gotCustomers, gotErr := service.getAllCustomer()
+			gotCustomers, gotErr := service.getAllCustomer(context.Background())
 
-			assert.ErrorIs(t, tt.wantErr, gotErr, "expected error to be matched")
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be matched")
 
 			assert.Equal(t, tt.wantCustomers, gotCustomers, "expected customer list to be matched")
 		})
@@ -635,7 +915,7 @@ func TestService_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "varshil",
 							Address:   "udaipur",
-							ContactNo: 9999999959,
+							ContactNo: "+919999999959",
 						},
 					},
 					{
@@ -643,7 +923,7 @@ func TestService_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 8619185565,
+							ContactNo: "+918619185565",
 						},
 					},
 				},
@@ -663,7 +943,7 @@ func TestService_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "varshil",
 							Address:   "udaipur",
-							ContactNo: 9999999959,
+							ContactNo: "+919999999959",
 						},
 					},
 					{
@@ -671,7 +951,7 @@ func TestService_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 8619185565,
+							ContactNo: "+918619185565",
 						},
 					},
 				},
@@ -684,7 +964,7 @@ func TestService_getCustomerById(t *testing.T) {
 				CustomerDetails: CustomerDetails{
 					Name:      "hardik",
 					Address:   "udaipur",
-					ContactNo: 8619185565,
+					ContactNo: "+918619185565",
 				},
 			},
 			wantErr: nil,
@@ -698,7 +978,7 @@ func TestService_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "varshil",
 							Address:   "udaipur",
-							ContactNo: 9999999959,
+							ContactNo: "+919999999959",
 						},
 					},
 					{
@@ -706,7 +986,7 @@ func TestService_getCustomerById(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 8619185565,
+							ContactNo: "+918619185565",
 						},
 					},
 				},
@@ -721,12 +1001,12 @@ func TestService_getCustomerById(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := &InMemoryRepo{tt.fields.customers}
+			repo := &InMemoryRepo{customers: tt.fields.customers}
 			service := NewService(repo)
 
-			gotCustomer, gotErr := service.getCustomerById(tt.args.id)
+			gotCustomer, gotErr := service.getCustomerById(context.Background(), tt.args.id)
 
-			assert.ErrorIs(t, tt.wantErr, gotErr, "expected error to be same")
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
 
 			assert.Equal(t, tt.wantCustomer, gotCustomer, "expected customer to be same")
 		})
@@ -743,13 +1023,13 @@ func TestService_deleteCustomer(t *testing.T) {
 	}
 
 	tests := []struct {
-		name                  string
-		fields                fields
-		args                  args
-		wantCustomers         []Customer
-		wantErr               error
-		isSubscriber          bool
-		wantNotifiedCustomers []Customer
+		name          string
+		fields        fields
+		args          args
+		wantCustomers []Customer
+		wantErr       error
+		isSubscriber  bool
+		wantEvents    []ChangeEvent
 	}{
 		{
 			name: "invalid id without subscriber",
@@ -760,7 +1040,7 @@ func TestService_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 7777777777,
+							ContactNo: "+917777777777",
 						},
 					},
 					{
@@ -768,7 +1048,7 @@ func TestService_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hk",
 							Address:   "udr",
-							ContactNo: 8888888888,
+							ContactNo: "+918888888888",
 						},
 					},
 				},
@@ -782,7 +1062,7 @@ func TestService_deleteCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 7777777777,
+						ContactNo: "+917777777777",
 					},
 				},
 				{
@@ -790,13 +1070,13 @@ func TestService_deleteCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hk",
 						Address:   "udr",
-						ContactNo: 8888888888,
+						ContactNo: "+918888888888",
 					},
 				},
 			},
-			wantErr:               ErrInvalidId,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantErr:      ErrInvalidId,
+			isSubscriber: false,
+			wantEvents:   []ChangeEvent{},
 		},
 		{
 			name: "deleting non existing customer without subscriber",
@@ -807,7 +1087,7 @@ func TestService_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 7777777777,
+							ContactNo: "+917777777777",
 						},
 					},
 					{
@@ -815,7 +1095,7 @@ func TestService_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hk",
 							Address:   "udr",
-							ContactNo: 8888888888,
+							ContactNo: "+918888888888",
 						},
 					},
 				},
@@ -829,7 +1109,7 @@ func TestService_deleteCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hardik",
 						Address:   "udaipur",
-						ContactNo: 7777777777,
+						ContactNo: "+917777777777",
 					},
 				},
 				{
@@ -837,13 +1117,13 @@ func TestService_deleteCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hk",
 						Address:   "udr",
-						ContactNo: 8888888888,
+						ContactNo: "+918888888888",
 					},
 				},
 			},
-			wantErr:               ErrNotFound,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantErr:      ErrNotFound,
+			isSubscriber: false,
+			wantEvents:   []ChangeEvent{},
 		},
 		{
 			name: "deleting customer without subscriber",
@@ -854,7 +1134,7 @@ func TestService_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 7777777777,
+							ContactNo: "+917777777777",
 						},
 					},
 					{
@@ -862,7 +1142,7 @@ func TestService_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hk",
 							Address:   "udr",
-							ContactNo: 8888888888,
+							ContactNo: "+918888888888",
 						},
 					},
 				},
@@ -876,13 +1156,13 @@ func TestService_deleteCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hk",
 						Address:   "udr",
-						ContactNo: 8888888888,
+						ContactNo: "+918888888888",
 					},
 				},
 			},
-			wantErr:               nil,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantErr:      nil,
+			isSubscriber: false,
+			wantEvents:   []ChangeEvent{},
 		},
 		{
 			name:   "deleting from empty database without subscriber",
@@ -890,10 +1170,10 @@ func TestService_deleteCustomer(t *testing.T) {
 			args: args{
 				id: "hs",
 			},
-			wantCustomers:         []Customer{},
-			wantErr:               ErrNotFound,
-			isSubscriber:          false,
-			wantNotifiedCustomers: []Customer{},
+			wantCustomers: []Customer{},
+			wantErr:       ErrNotFound,
+			isSubscriber:  false,
+			wantEvents:    []ChangeEvent{},
 		},
 		{
 			name: "deleting customer with subscriber",
@@ -904,7 +1184,7 @@ func TestService_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hardik",
 							Address:   "udaipur",
-							ContactNo: 7777777777,
+							ContactNo: "+917777777777",
 						},
 					},
 					{
@@ -912,7 +1192,7 @@ func TestService_deleteCustomer(t *testing.T) {
 						CustomerDetails: CustomerDetails{
 							Name:      "hk",
 							Address:   "udr",
-							ContactNo: 8888888888,
+							ContactNo: "+918888888888",
 						},
 					},
 				},
@@ -926,19 +1206,25 @@ func TestService_deleteCustomer(t *testing.T) {
 					CustomerDetails: CustomerDetails{
 						Name:      "hk",
 						Address:   "udr",
-						ContactNo: 8888888888,
+						ContactNo: "+918888888888",
 					},
 				},
 			},
 			wantErr:      nil,
 			isSubscriber: true,
-			wantNotifiedCustomers: []Customer{
+			wantEvents: []ChangeEvent{
 				{
-					Id: "hf",
-					CustomerDetails: CustomerDetails{
-						Name:      "hk",
-						Address:   "udr",
-						ContactNo: 8888888888,
+					Type:     EventCustomerDeleted,
+					Sequence: 1,
+					Tenant:   DefaultTenant,
+					Customer: &Customer{
+						Id: "hs",
+						CustomerDetails: CustomerDetails{
+							Name:      "hardik",
+							Address:   "udaipur",
+							ContactNo: "+917777777777",
+						},
+						DeletedAt: &conformanceDeletedAt,
 					},
 				},
 			},
@@ -949,34 +1235,364 @@ func TestService_deleteCustomer(t *testing.T) {
 			args: args{
 				id: "hs",
 			},
-			wantCustomers:         []Customer{},
-			wantErr:               ErrNotFound,
-			isSubscriber:          true,
-			wantNotifiedCustomers: []Customer{},
+			wantCustomers: []Customer{},
+			wantErr:       ErrNotFound,
+			isSubscriber:  true,
+			wantEvents:    []ChangeEvent{},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := &InMemoryRepo{tt.fields.customers}
-			service := NewService(repo)
+			repo := &InMemoryRepo{customers: tt.fields.customers}
+			service := NewService(repo, WithClock(func() time.Time { return conformanceDeletedAt }))
 			subscriber1 := newMockSubscriber("1")
 
 			if tt.isSubscriber {
-				service.subscribe(subscriber1)
+				service.subscribe(context.Background(), subscriber1)
 			}
 
-			gotErr := service.deleteCustomer(tt.args.id)
+			gotErr := service.deleteCustomer(context.Background(), tt.args.id, 0)
+			service.Close()
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
+
+			assert.Equal(t, tt.wantCustomers, repo.customers, "expected customer list to be same")
+
+			assert.Equal(t, tt.wantEvents, subscriber1.events, "expected events to be same")
+		})
+	}
+}
+
+func TestService_restoreCustomer(t *testing.T) {
+	customer := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}, Version: 2}
 
-			assert.ErrorIs(t, tt.wantErr, gotErr, "expected error to be same")
+	tests := []struct {
+		name          string
+		id            string
+		ifVersion     int64
+		wantCustomers []Customer
+		wantErr       error
+		wantEvents    []ChangeEvent
+	}{
+		{
+			name:          "restoring deleted customer",
+			id:            "hs",
+			wantCustomers: []Customer{customer},
+			wantEvents:    []ChangeEvent{{Type: EventCustomerRestored, Sequence: 1, Tenant: DefaultTenant, Customer: &customer}},
+		},
+		{
+			name:          "restoring a stale version",
+			id:            "hs",
+			ifVersion:     1,
+			wantCustomers: []Customer{},
+			wantErr:       ErrVersionConflict,
+			wantEvents:    []ChangeEvent{},
+		},
+		{
+			name:          "restoring customer that isn't deleted",
+			id:            "hm",
+			wantCustomers: []Customer{},
+			wantErr:       ErrNotFound,
+			wantEvents:    []ChangeEvent{},
+		},
+		{
+			name:          "invalid id",
+			id:            "hsss",
+			wantCustomers: []Customer{},
+			wantErr:       ErrInvalidId,
+			wantEvents:    []ChangeEvent{},
+		},
+	}
 
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &InMemoryRepo{customers: []Customer{}, trashed: []Customer{trashed(customer, conformanceDeletedAt)}}
+			service := NewService(repo)
+			subscriber := newMockSubscriber("1")
+			service.subscribe(context.Background(), subscriber)
+
+			_, gotErr := service.restoreCustomer(context.Background(), tt.id, tt.ifVersion)
+			service.Close()
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
 			assert.Equal(t, tt.wantCustomers, repo.customers, "expected customer list to be same")
+			assert.Equal(t, tt.wantEvents, subscriber.events, "expected events to be same")
+		})
+	}
+}
 
-			assert.Equal(t, tt.wantNotifiedCustomers, subscriber1.customerList, "expected customer list to be same")
+func TestService_trashPurge(t *testing.T) {
+	now := conformanceDeletedAt.Add(48 * time.Hour)
+	repo := &InMemoryRepo{
+		customers: []Customer{},
+		trashed: []Customer{
+			trashed(Customer{Id: "hm", Version: 1}, conformanceDeletedAt),
+			trashed(Customer{Id: "hs", Version: 1}, now.Add(-time.Hour)),
+		},
+	}
+
+	service := NewService(repo, WithTrashPurge(24*time.Hour, time.Millisecond), WithClock(func() time.Time { return now }))
+
+	// the first purge runs as soon as the service starts
+	assert.Eventually(t, func() bool {
+		trash, _ := repo.trash(context.Background())
+		return len(trash) == 1
+	}, time.Second, time.Millisecond, "expect customers deleted before the retention to be purged")
+	service.Close()
+
+	trash, _ := repo.trash(context.Background())
+	assert.Equal(t, []Customer{trashed(Customer{Id: "hs", Version: 1}, now.Add(-time.Hour))}, trash, "expect recently deleted customers to be kept")
+}
+
+func TestService_trashPurgeAudit(t *testing.T) {
+	now := conformanceDeletedAt.Add(48 * time.Hour)
+	repo := NewTenantRepos(func() Repo { return NewInMemoryRepo() })
+	repo.repos[DefaultTenant] = &InMemoryRepo{trashed: []Customer{trashed(Customer{Id: "hs", Version: 1}, conformanceDeletedAt)}}
+	repo.repos["acme"] = &InMemoryRepo{trashed: []Customer{trashed(Customer{Id: "vs", Version: 1}, conformanceDeletedAt)}}
+
+	service := NewService(repo, WithTrashPurge(24*time.Hour, 0), WithClock(func() time.Time { return now }), WithAuditLog(NewMemoryAuditLog()))
+	defer service.Close()
+
+	acme := contextWithTenant(context.Background(), "acme")
+	request := context.WithValue(contextWithActor(acme, "hardik"), requestIdKey{}, "r1")
+	cancelled, cancel := context.WithCancel(request)
+	cancel()
+
+	purged, err := service.purgeTrash(request)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 1, purged, "expected the trash of the tenant to be purged")
+
+	purged, err = service.purgeTrash(contextWithActor(contextWithTenant(context.Background(), allTenants), systemActor))
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 1, purged, "expected the trash of every tenant to be purged")
+
+	_, err = service.purgeTrash(cancelled)
+	assert.Error(t, err, "expected purge with a cancelled context to fail")
+
+	entries := func(ctx context.Context) []AuditEntry {
+		page, err := service.queryAudit(ctx, AuditFilter{Operation: AuditPurge})
+		assert.NoError(t, err, "expected no error")
+
+		for i := range page.Entries {
+			page.Entries[i].Seq, page.Entries[i].PrevHash, page.Entries[i].Hash = 0, "", ""
+		}
+		return page.Entries
+	}
+	wantAcme := []AuditEntry{
+		{Tenant: "acme", At: now, Actor: "hardik", RequestId: "r1", Operation: AuditPurge, Outcome: AuditFailure, Reason: problemTypeOf(context.Canceled).code},
+		{Tenant: "acme", At: now, Actor: "hardik", RequestId: "r1", Operation: AuditPurge, TargetId: "vs", Outcome: AuditSuccess},
+	}
+	wantDefault := []AuditEntry{
+		{Tenant: DefaultTenant, At: now, Actor: systemActor, Operation: AuditPurge, TargetId: "hs", Outcome: AuditSuccess},
+	}
+	assert.Equal(t, wantAcme, entries(acme), "expected purges of the tenant to be recorded, failures without a target")
+	assert.Equal(t, wantDefault, entries(context.Background()), "expected purges of the job to be recorded under the tenant of each customer")
+}
+
+// steppingClock starts at conformanceDeletedAt and moves on an hour every
+// time it is read.
+func steppingClock() func() time.Time {
+	var mu sync.Mutex
+	next := conformanceDeletedAt
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := next
+		next = next.Add(time.Hour)
+		return now
+	}
+}
+
+func TestService_customerHistory(t *testing.T) {
+	at := func(hours int) time.Time { return conformanceDeletedAt.Add(time.Duration(hours) * time.Hour) }
+	created := CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}
+	updated := CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+917777777777"}
+
+	service := NewService(NewInMemoryRepo(), WithIdGenerator(fixedId("hs")), WithClock(steppingClock()))
+	defer service.Close()
+
+	ctx := contextWithActor(context.Background(), "hardik")
+	if _, err := service.addCustomer(ctx, Customer{CustomerDetails: created}); err != nil {
+		t.Fatal("failed to add customer:", err)
+	}
+	if _, err := service.updateCustomer(contextWithActor(ctx, "varshil"), Customer{Id: "hs", CustomerDetails: updated}, 0); err != nil {
+		t.Fatal("failed to update customer:", err)
+	}
+	if err := service.deleteCustomer(context.Background(), "hs", 0); err != nil {
+		t.Fatal("failed to delete customer:", err)
+	}
+
+	tests := []struct {
+		name          string
+		id            string
+		wantRevisions []Revision
+		wantErr       error
+	}{
+		{
+			name: "every write with its actor and time",
+			id:   "hs",
+			wantRevisions: []Revision{
+				{CustomerId: "hs", Version: 1, Type: RevisionCreated, Actor: "hardik", At: at(0), NewDetails: &created},
+				{CustomerId: "hs", Version: 2, Type: RevisionUpdated, Actor: "varshil", At: at(1), OldDetails: &created, NewDetails: &updated},
+				{CustomerId: "hs", Version: 2, Type: RevisionDeleted, Actor: anonymousActor, At: at(2), OldDetails: &updated},
+			},
+		},
+		{
+			name:    "customer that never existed",
+			id:      "hm",
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "invalid id",
+			id:      "hsss",
+			wantErr: ErrInvalidId,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotRevisions, gotErr := service.customerHistory(context.Background(), tt.id)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
+			assert.Equal(t, tt.wantRevisions, gotRevisions, "expected revisions to be same")
 		})
 	}
 }
 
+func TestService_asOf(t *testing.T) {
+	at := func(hours int) time.Time { return conformanceDeletedAt.Add(time.Duration(hours) * time.Hour) }
+	hs := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}, Version: 1}
+	hsUpdated := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "jaipur", ContactNo: "+917777777777"}, Version: 2}
+
+	service := NewService(NewInMemoryRepo(), WithIdGenerator(fixedId("hs")), WithClock(steppingClock()))
+	defer service.Close()
+
+	if _, err := service.addCustomer(context.Background(), hs); err != nil {
+		t.Fatal("failed to add customer:", err)
+	}
+	if _, err := service.updateCustomer(context.Background(), hsUpdated, 0); err != nil {
+		t.Fatal("failed to update customer:", err)
+	}
+	if err := service.deleteCustomer(context.Background(), "hs", 0); err != nil {
+		t.Fatal("failed to delete customer:", err)
+	}
+
+	tests := []struct {
+		name         string
+		at           time.Time
+		wantCustomer Customer
+		wantErr      error
+	}{
+		{
+			name:    "before it was created",
+			at:      at(-1),
+			wantErr: ErrNotFound,
+		},
+		{
+			name:         "as created",
+			at:           at(0).Add(30 * time.Minute),
+			wantCustomer: hs,
+		},
+		{
+			name:         "as updated",
+			at:           at(1),
+			wantCustomer: hsUpdated,
+		},
+		{
+			name:    "once deleted",
+			at:      at(2),
+			wantErr: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCustomer, gotErr := service.getCustomerAsOf(context.Background(), "hs", tt.at)
+
+			assert.ErrorIs(t, gotErr, tt.wantErr, "expected error to be same")
+			assert.Equal(t, tt.wantCustomer, gotCustomer, "expected customer to be same")
+
+			wantPage := CustomerPage{Customers: []Customer{}}
+			if tt.wantErr == nil {
+				wantPage.Customers = []Customer{tt.wantCustomer}
+			}
+
+			gotPage, err := service.listCustomers(context.Background(), ListOptions{AsOf: tt.at})
+			assert.NoError(t, err, "expected no error")
+			assert.Equal(t, wantPage, gotPage, "expected listing to be same")
+		})
+	}
+}
+
+func TestService_audit(t *testing.T) {
+	at := func(hours int) time.Time { return conformanceDeletedAt.Add(time.Duration(hours) * time.Hour) }
+	hs := Customer{CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+917777777777"}}
+
+	auditLog := NewMemoryAuditLog()
+	service := NewService(NewInMemoryRepo(), WithIdGenerator(fixedId("hs")), WithClock(steppingClock()), WithAuditLog(auditLog))
+	defer service.Close()
+
+	request := func(actor string, requestId string) context.Context {
+		ctx := contextWithActor(context.Background(), actor)
+		ctx = context.WithValue(ctx, requestIdKey{}, requestId)
+		return context.WithValue(ctx, sourceIPKey{}, "10.0.0.1")
+	}
+	mergePatch, err := ParseMergePatch([]byte(`{"customerDetails": {"address": "jaipur"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service.addCustomer(request("hardik", "r1"), hs)
+	service.addCustomer(request("hardik", "r2"), Customer{})
+	service.addCustomer(request("hardik", "r3"), hs)
+	service.updateCustomer(request("varshil", "r4"), Customer{Id: "hs", CustomerDetails: hs.CustomerDetails}, 7)
+	service.patchCustomer(request("varshil", "r5"), "hs", mergePatch, 0)
+	service.deleteCustomer(request("varshil", "r6"), "hm", 0)
+	service.deleteCustomer(request("varshil", "r7"), "hs", 0)
+	service.restoreCustomer(request("hardik", "r8"), "hs", 0)
+
+	entry := func(hours int, actor string, requestId string, operation string, outcome string, reason string, targetId string) AuditEntry {
+		return AuditEntry{
+			Tenant: DefaultTenant, At: at(hours), Actor: actor, SourceIP: "10.0.0.1", RequestId: requestId,
+			Operation: operation, TargetId: targetId, Outcome: outcome, Reason: reason,
+		}
+	}
+	wantEntries := []AuditEntry{
+		entry(0, "hardik", "r1", AuditCreate, AuditSuccess, "", "hs"),
+		entry(1, "hardik", "r2", AuditCreate, AuditFailure, "validation_failed", "hs"),
+		entry(2, "hardik", "r3", AuditCreate, AuditFailure, "conflict", "hs"),
+		entry(3, "varshil", "r4", AuditUpdate, AuditFailure, "version_conflict", "hs"),
+		entry(4, "varshil", "r5", AuditPatch, AuditSuccess, "", "hs"),
+		entry(5, "varshil", "r6", AuditDelete, AuditFailure, "not_found", "hm"),
+		entry(6, "varshil", "r7", AuditDelete, AuditSuccess, "", "hs"),
+		entry(7, "hardik", "r8", AuditRestore, AuditSuccess, "", "hs"),
+	}
+
+	page, err := service.queryAudit(context.Background(), AuditFilter{})
+	assert.NoError(t, err, "expected no error")
+
+	gotEntries := []AuditEntry{}
+	for i := len(page.Entries) - 1; i >= 0; i-- {
+		entry := page.Entries[i]
+		entry.Seq, entry.PrevHash, entry.Hash = 0, "", ""
+		gotEntries = append(gotEntries, entry)
+	}
+	assert.Equal(t, wantEntries, gotEntries, "expected every attempt to be recorded")
+
+	revisions, err := service.customerHistory(context.Background(), "hs")
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, at(4), revisions[1].At, "expected revision and audit entry of a write to share its time")
+
+	verification, err := service.verifyAudit(context.Background())
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, AuditVerification{Valid: true, Entries: 8}, verification, "expected chain to be intact")
+
+	_, err = service.queryAudit(context.Background(), AuditFilter{Operation: "archive"})
+	assert.ErrorIs(t, err, ErrInvalidAuditFilter, "expected unknown operation to be rejected")
+}
+
 func TestService_subscribe(t *testing.T) {
 	subscriber1 := newMockSubscriber("1")
 	subscriber2 := newMockSubscriber("2")
@@ -1034,20 +1650,68 @@ func TestService_subscribe(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			service := &Service{
-				customerRepo:   repo,
-				subscriberList: tt.fields.subscribers}
+			service := NewService(NewInMemoryRepo())
+			defer service.Close()
+
+			for _, subscriber := range tt.fields.subscribers {
+				service.subscribe(context.Background(), subscriber)
+			}
 
 			for _, subscriber := range tt.subscribers {
-				service.subscribe(subscriber)
+				service.subscribe(context.Background(), subscriber)
 			}
 
-			assert.Equal(t, tt.wantLen, len(service.subscriberList), "expected length to be same")
+			assert.Equal(t, tt.wantLen, len(service.subscribers()), "expected length to be same")
 		})
 	}
 }
 
+func TestService_tenants(t *testing.T) {
+	service := NewService(NewTenantRepos(func() Repo { return NewInMemoryRepo() }), WithIdGenerator(fixedId("hs")))
+	acme := contextWithTenant(context.Background(), "acme")
+
+	acmeSubscriber := newMockSubscriber("1")
+	if err := service.subscribeWithSnapshot(acme, acmeSubscriber); err != nil {
+		t.Fatalf("subscribe failed :%v", err)
+	}
+	defaultSubscriber := newMockSubscriber("2")
+	service.subscribe(context.Background(), defaultSubscriber)
+
+	acmeCustomer, err := service.addCustomer(acme, Customer{CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}})
+	if err != nil {
+		t.Fatalf("add failed :%v", err)
+	}
+	defaultCustomer, err := service.addCustomer(context.Background(), Customer{CustomerDetails: CustomerDetails{Name: "varshil", Address: "udr", ContactNo: "+918888888888"}})
+	if err != nil {
+		t.Fatalf("add failed :%v", err)
+	}
+
+	resumed := newMockSubscriber("3")
+	if err := service.resumeSubscription(acme, resumed, 1); err != nil {
+		t.Fatalf("resume failed :%v", err)
+	}
+	moved := acmeCustomer
+	moved.CustomerDetails.Address = "jaipur"
+	updated, err := service.updateCustomer(acme, moved, 0)
+	if err != nil {
+		t.Fatalf("update failed :%v", err)
+	}
+	service.Close()
+
+	assert.Equal(t, "hs", defaultCustomer.Id, "expect id of another tenant to be free")
+	assert.Equal(t, []ChangeEvent{
+		{Type: EventSnapshot, Sequence: 0, Customers: []Customer{}},
+		{Type: EventCustomerCreated, Sequence: 1, Tenant: "acme", Customer: &acmeCustomer},
+		{Type: EventCustomerUpdated, Sequence: 3, Tenant: "acme", Customer: &updated},
+	}, acmeSubscriber.events, "expect only changes of the tenant")
+	assert.Equal(t, []ChangeEvent{
+		{Type: EventCustomerCreated, Sequence: 2, Tenant: DefaultTenant, Customer: &defaultCustomer},
+	}, defaultSubscriber.events, "expect only changes of the default tenant")
+	assert.Equal(t, []ChangeEvent{
+		{Type: EventCustomerUpdated, Sequence: 3, Tenant: "acme", Customer: &updated},
+	}, resumed.events, "expect missed changes of other tenants to be skipped")
+}
+
 func TestService_unSubscribe(t *testing.T) {
 	subscriber2 := newMockSubscriber("1")
 	subscriber1 := newMockSubscriber("2")
@@ -1090,56 +1754,136 @@ func TestService_unSubscribe(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			service := &Service{
-				customerRepo:   repo,
-				subscriberList: tt.fields.subscribers,
+			service := NewService(NewInMemoryRepo())
+			defer service.Close()
+
+			for _, subscriber := range tt.fields.subscribers {
+				service.subscribe(context.Background(), subscriber)
 			}
 
 			service.unSubscribe(tt.unSubscriber)
 
-			assert.Equal(t, tt.wantLen, len(service.subscriberList), "expected length to be same")
+			assert.Equal(t, tt.wantLen, len(service.subscribers()), "expected length to be same")
 		})
 	}
 }
 
-func Test_calculateDigits(t *testing.T) {
-	type args struct {
-		num int
-	}
-	tests := []struct {
-		name      string
-		args      args
-		wantCount int
-	}{
-		{
-			name: "10 digit number",
-			args: args{
-				num: 9649127550,
-			},
-			wantCount: 10,
-		},
-		{
-			name: "10 digit number having 0",
-			args: args{
-				num: 9602201100,
-			},
-			wantCount: 10,
-		},
-		{
-			name: "having all digits 0",
-			args: args{
-				num: 000000000,
-			},
-			wantCount: 0,
+func TestService_operationTimeouts(t *testing.T) {
+	service := NewService(&blockingRepo{}, WithOperationTimeouts(OperationTimeouts{
+		Create: 10 * time.Millisecond,
+		GetAll: 10 * time.Millisecond,
+	}))
+
+	customer := Customer{
+		Id: "hs",
+		CustomerDetails: CustomerDetails{
+			Name:      "hardik",
+			Address:   "udaipur",
+			ContactNo: "+919999999999",
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gotCount := calculateDigits(tt.args.num)
+	_, gotErr := service.addCustomer(context.Background(), customer)
+	assert.ErrorIs(t, gotErr, context.DeadlineExceeded, "expected create to hit its deadline")
 
-			assert.Equal(t, tt.wantCount, gotCount, "expected count to be equal")
-		})
+	_, gotErr = service.getAllCustomer(context.Background())
+	assert.ErrorIs(t, gotErr, context.DeadlineExceeded, "expected getAll to hit its deadline")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, gotErr = service.getAllCustomer(ctx)
+	assert.ErrorIs(t, gotErr, context.Canceled, "expected caller cancellation to reach the repo")
+}
+
+// deadlineAuditLog records the state of the context every entry is
+// appended with.
+type deadlineAuditLog struct {
+	AuditLog
+	err      error
+	deadline time.Duration
+}
+
+func (l *deadlineAuditLog) append(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	l.err = ctx.Err()
+	if deadline, ok := ctx.Deadline(); ok {
+		l.deadline = time.Until(deadline)
 	}
+	return l.AuditLog.append(ctx, entry)
+}
+
+func TestService_auditTimeout(t *testing.T) {
+	auditLog := &deadlineAuditLog{AuditLog: NewMemoryAuditLog()}
+	service := NewService(&blockingRepo{}, WithAuditLog(auditLog), WithOperationTimeouts(OperationTimeouts{
+		Create: 10 * time.Millisecond,
+		Audit:  time.Minute,
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, gotErr := service.addCustomer(ctx, Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}})
+	assert.ErrorIs(t, gotErr, context.Canceled, "expected create to be canceled")
+
+	assert.NoError(t, auditLog.err, "expected audit entry to outlive the request")
+	assert.Greater(t, auditLog.deadline, 10*time.Millisecond, "expected audit entry to have its own deadline")
+
+	page, err := service.queryAudit(context.Background(), AuditFilter{})
+	assert.NoError(t, err, "expected no error")
+	assert.Len(t, page.Entries, 1, "expected failed create to be recorded")
+}
+
+type countingSubscriber struct {
+	id      string
+	updates atomic.Int64
+}
+
+func (c *countingSubscriber) update(event ChangeEvent) {
+	c.updates.Add(1)
+}
+
+func (c *countingSubscriber) getSubscriberId() string {
+	return c.id
+}
+
+func TestService_concurrentSubscribers(t *testing.T) {
+	const workers = 10
+	const perWorker = 20
+
+	service := NewService(NewInMemoryRepo(), WithNotifyQueue(workers*perWorker, DropOldest))
+	steady := &countingSubscriber{id: "steady"}
+	service.subscribe(context.Background(), steady)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				customer := Customer{CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"}}
+				if _, err := service.addCustomer(context.Background(), customer); err != nil {
+					t.Errorf("add failed :%v", err)
+				}
+			}
+		}()
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				transient := &countingSubscriber{id: fmt.Sprintf("%d-%d", w, i)}
+				service.subscribe(context.Background(), transient)
+				service.unSubscribe(transient)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	remaining := service.subscribers()
+	assert.Equal(t, 1, len(remaining), "expected only the steady subscriber to remain")
+	assert.Equal(t, Subscriber(steady), remaining[0].subscriber, "expected only the steady subscriber to remain")
+
+	service.Close()
+
+	assert.Equal(t, int64(workers*perWorker), steady.updates.Load(), "expected one notification per write")
 }
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteSchema is applied up to PRAGMA user_version, append new steps and
+// never edit released ones.
+var sqliteSchema = [][]string{
+	{
+		`CREATE TABLE customers(
+			id TEXT PRIMARY KEY,
+			customerdetails_name TEXT,
+			customerdetails_address TEXT,
+			customerdetails_contact_no TEXT,
+			version INTEGER NOT NULL DEFAULT 1
+		)`,
+		`CREATE INDEX customers_name_id_idx ON customers (customerdetails_name, id)`,
+		`CREATE INDEX customers_address_id_idx ON customers (customerdetails_address, id)`,
+		`CREATE INDEX customers_contact_no_id_idx ON customers (customerdetails_contact_no, id)`,
+	},
+	{
+		`ALTER TABLE customers ADD COLUMN deleted_at TIMESTAMP`,
+		`CREATE INDEX customers_deleted_at_idx ON customers (deleted_at) WHERE deleted_at IS NOT NULL`,
+	},
+	{
+		`CREATE TABLE customer_revisions(
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			customer_id TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			changed_at TIMESTAMP NOT NULL,
+			old_details TEXT,
+			new_details TEXT
+		)`,
+		`CREATE INDEX customer_revisions_customer_id_idx ON customer_revisions (customer_id, seq)`,
+		`CREATE INDEX customer_revisions_changed_at_idx ON customer_revisions (changed_at)`,
+		// customers stored until now start their history here
+		`INSERT INTO customer_revisions (customer_id, version, type, actor, changed_at, old_details, new_details)
+			SELECT id, version,
+				CASE WHEN deleted_at IS NULL THEN 'created' ELSE 'deleted' END,
+				'system',
+				coalesce(deleted_at, strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+				CASE WHEN deleted_at IS NULL THEN NULL ELSE json_object('name', customerdetails_name, 'address', customerdetails_address, 'contactNo', customerdetails_contact_no) END,
+				CASE WHEN deleted_at IS NULL THEN json_object('name', customerdetails_name, 'address', customerdetails_address, 'contactNo', customerdetails_contact_no) END
+			FROM customers ORDER BY id`,
+	},
+	{
+		`CREATE TABLE audit_log(
+			seq INTEGER PRIMARY KEY,
+			at TIMESTAMP NOT NULL,
+			actor TEXT NOT NULL,
+			source_ip TEXT NOT NULL,
+			request_id TEXT NOT NULL,
+			operation TEXT NOT NULL,
+			target_id TEXT NOT NULL,
+			outcome TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			prev_hash TEXT NOT NULL,
+			hash TEXT NOT NULL
+		)`,
+		`CREATE INDEX audit_log_actor_idx ON audit_log (actor, seq)`,
+		`CREATE INDEX audit_log_target_id_idx ON audit_log (target_id, seq)`,
+		`CREATE INDEX audit_log_at_idx ON audit_log (at)`,
+		// entries are only ever appended, see audit.go
+		`CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+			BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+		`CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+			BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+	},
+	{
+		`CREATE TABLE api_keys(
+			id TEXT PRIMARY KEY,
+			subject TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP
+		)`,
+	},
+	{
+		`ALTER TABLE api_keys ADD COLUMN roles TEXT NOT NULL DEFAULT '[]'`,
+	},
+	{
+		// sqlite can't change a primary key, the customers of the default
+		// tenant move to a table where ids are unique per tenant
+		`CREATE TABLE customers_by_tenant(
+			tenant_id TEXT NOT NULL DEFAULT 'default',
+			id TEXT NOT NULL,
+			customerdetails_name TEXT,
+			customerdetails_address TEXT,
+			customerdetails_contact_no TEXT,
+			version INTEGER NOT NULL DEFAULT 1,
+			deleted_at TIMESTAMP,
+			PRIMARY KEY (tenant_id, id)
+		)`,
+		`INSERT INTO customers_by_tenant (id, customerdetails_name, customerdetails_address, customerdetails_contact_no, version, deleted_at)
+			SELECT id, customerdetails_name, customerdetails_address, customerdetails_contact_no, version, deleted_at FROM customers`,
+		`DROP TABLE customers`,
+		`ALTER TABLE customers_by_tenant RENAME TO customers`,
+		`CREATE INDEX customers_name_id_idx ON customers (tenant_id, customerdetails_name, id)`,
+		`CREATE INDEX customers_address_id_idx ON customers (tenant_id, customerdetails_address, id)`,
+		`CREATE INDEX customers_contact_no_id_idx ON customers (tenant_id, customerdetails_contact_no, id)`,
+		`CREATE INDEX customers_deleted_at_idx ON customers (deleted_at) WHERE deleted_at IS NOT NULL`,
+		`ALTER TABLE customer_revisions ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default'`,
+		`DROP INDEX customer_revisions_customer_id_idx`,
+		`CREATE INDEX customer_revisions_customer_id_idx ON customer_revisions (tenant_id, customer_id, seq)`,
+		`ALTER TABLE audit_log ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default'`,
+		`CREATE INDEX audit_log_tenant_id_idx ON audit_log (tenant_id, seq)`,
+		`ALTER TABLE api_keys ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default'`,
+	},
+}
+
+// openSQLite uses a single connection so that writers never race for the
+// file lock.
+func openSQLite(path string) (*bun.DB, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+	sqldb, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqldb.SetMaxOpenConns(1)
+
+	return bun.NewDB(sqldb, sqlitedialect.New()), nil
+}
+
+func migrateSQLite(ctx context.Context, db *bun.DB) error {
+	var applied int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&applied); err != nil {
+		return err
+	}
+
+	if applied > len(sqliteSchema) {
+		return fmt.Errorf("sqlite schema version %d is newer than this build knows", applied)
+	}
+
+	for version := applied + 1; version <= len(sqliteSchema); version++ {
+		err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			for _, statement := range sqliteSchema[version-1] {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("sqlite schema version %d: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+type sqliteRepo struct {
+	db *bun.DB
+}
+
+func NewSQLiteRepo(db *bun.DB) *sqliteRepo {
+	return &sqliteRepo{
+		db: db,
+	}
+}
+
+func (repo *sqliteRepo) create(ctx context.Context, customer Customer, change Change) error {
+	err := repo.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewInsert().Model(&customer).Value("tenant_id", "?", tenantFromContext(ctx)).Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionCreated, customer, nil, &customer.CustomerDetails, change))
+	})
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+			return ErrConflict
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (repo *sqliteRepo) getAll(ctx context.Context) ([]Customer, error) {
+	customers := []Customer{}
+	err := repo.db.NewSelect().
+		Model(&customers).
+		Where("tenant_id = ?", tenantFromContext(ctx)).
+		Where("deleted_at IS NULL").
+		Scan(ctx)
+	if err != nil {
+		return customers, err
+	}
+
+	return customers, nil
+}
+
+func (repo *sqliteRepo) list(ctx context.Context, opts ListOptions) (CustomerPage, error) {
+	column := sortColumns[opts.SortBy]
+	direction, comparison := "ASC", ">"
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	customers := []Customer{}
+	query := repo.db.NewSelect().
+		Model(&customers).
+		Where("tenant_id = ?", tenantFromContext(ctx)).
+		Where("deleted_at IS NULL")
+
+	if opts.Name != "" {
+		query = query.Where(`customerdetails_name LIKE ? ESCAPE '\'`, likePattern(opts.Name))
+	}
+
+	if opts.Address != "" {
+		query = query.Where(`customerdetails_address LIKE ? ESCAPE '\'`, likePattern(opts.Address))
+	}
+
+	if opts.ContactNo != "" {
+		query = query.Where("customerdetails_contact_no = ?", opts.ContactNo)
+	}
+
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts)
+		if err != nil {
+			return CustomerPage{}, err
+		}
+
+		query = query.Where("(?, id) "+comparison+" (?, ?)", bun.Ident(column), cursor.Key, cursor.Id)
+	}
+
+	query = query.
+		OrderExpr("? "+direction, bun.Ident(column)).
+		OrderExpr("id " + direction).
+		Limit(opts.Limit + 1)
+
+	if err := query.Scan(ctx); err != nil {
+		return CustomerPage{}, err
+	}
+
+	return newCustomerPage(customers, opts), nil
+}
+
+func (repo *sqliteRepo) search(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	customers, err := repo.getAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return rankCustomers(customers, opts), nil
+}
+
+func (repo *sqliteRepo) getById(ctx context.Context, id string) (Customer, error) {
+	var customer Customer
+	err := repo.db.NewSelect().
+		Model(&customer).
+		Where("tenant_id = ?", tenantFromContext(ctx)).
+		Where("id = ?", id).
+		Where("deleted_at IS NULL").
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Customer{}, ErrNotFound
+		}
+
+		return Customer{}, err
+	}
+
+	return customer, nil
+}
+
+func (repo *sqliteRepo) update(ctx context.Context, id string, customer Customer, ifVersion int64, change Change) (Customer, error) {
+	err := repo.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		existing, err := repo.lock(ctx, tx, id, false, ifVersion)
+		if err != nil {
+			return err
+		}
+
+		details := customer.CustomerDetails
+		_, err = tx.NewUpdate().
+			Model(&customer).
+			Set("customerdetails_name = ?", details.Name).
+			Set("customerdetails_address = ?", details.Address).
+			Set("customerdetails_contact_no = ?", details.ContactNo).
+			Set("version = version + 1").
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("id = ?", id).
+			Returning("?Columns").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionUpdated, customer, &existing.CustomerDetails, &customer.CustomerDetails, change))
+	})
+	if err != nil {
+		return Customer{}, err
+	}
+
+	return customer, nil
+}
+
+func (repo *sqliteRepo) patch(ctx context.Context, id string, p CustomerPatch, ifVersion int64, change Change) (Customer, error) {
+	var customer Customer
+	err := repo.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		existing, err := repo.lock(ctx, tx, id, false, ifVersion)
+		if err != nil {
+			return err
+		}
+
+		query := tx.NewUpdate().
+			Model(&customer).
+			Set("version = version + 1").
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("id = ?", id).
+			Returning("?Columns")
+
+		if p.Name != nil {
+			query = query.Set("customerdetails_name = ?", *p.Name)
+		}
+
+		if p.Address != nil {
+			query = query.Set("customerdetails_address = ?", *p.Address)
+		}
+
+		if p.ContactNo != nil {
+			query = query.Set("customerdetails_contact_no = ?", *p.ContactNo)
+		}
+
+		if _, err := query.Exec(ctx); err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionUpdated, customer, &existing.CustomerDetails, &customer.CustomerDetails, change))
+	})
+	if err != nil {
+		return Customer{}, err
+	}
+
+	return customer, nil
+}
+
+func (repo *sqliteRepo) delete(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	var customer Customer
+	err := repo.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := repo.lock(ctx, tx, id, false, ifVersion); err != nil {
+			return err
+		}
+
+		_, err := tx.NewUpdate().
+			Model(&customer).
+			Set("deleted_at = ?", change.At).
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("id = ?", id).
+			Returning("?Columns").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionDeleted, customer, &customer.CustomerDetails, nil, change))
+	})
+	if err != nil {
+		return Customer{}, err
+	}
+
+	return customer, nil
+}
+
+func (repo *sqliteRepo) trash(ctx context.Context) ([]Customer, error) {
+	customers := []Customer{}
+	err := repo.db.NewSelect().
+		Model(&customers).
+		Where("tenant_id = ?", tenantFromContext(ctx)).
+		Where("deleted_at IS NOT NULL").
+		OrderExpr("deleted_at DESC").
+		OrderExpr("id").
+		Scan(ctx)
+	if err != nil {
+		return customers, err
+	}
+
+	return customers, nil
+}
+
+func (repo *sqliteRepo) restore(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	var customer Customer
+	err := repo.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := repo.lock(ctx, tx, id, true, ifVersion); err != nil {
+			return err
+		}
+
+		_, err := tx.NewUpdate().
+			Model(&customer).
+			Set("deleted_at = NULL").
+			Where("tenant_id = ?", tenantFromContext(ctx)).
+			Where("id = ?", id).
+			Returning("?Columns").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, newRevision(RevisionRestored, customer, nil, &customer.CustomerDetails, change))
+	})
+	if err != nil {
+		return Customer{}, err
+	}
+
+	return customer, nil
+}
+
+// purge removes the history itself, sqlite doesn't enforce foreign keys
+// unless asked to.
+func (repo *sqliteRepo) purge(ctx context.Context, before time.Time) ([]purgedCustomer, error) {
+	var purged []purgedCustomer
+	err := repo.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		expired := tx.NewSelect().
+			Model((*Customer)(nil)).
+			ColumnExpr("tenant_id, id").
+			Where("deleted_at < ?", before)
+		purge := tx.NewDelete().
+			Model((*Customer)(nil)).
+			Where("deleted_at < ?", before).
+			Returning("tenant_id, id")
+		if tenant := tenantFromContext(ctx); tenant != allTenants {
+			expired = expired.Where("tenant_id = ?", tenant)
+			purge = purge.Where("tenant_id = ?", tenant)
+		}
+
+		_, err := tx.NewDelete().
+			Model((*revisionRow)(nil)).
+			Where("(tenant_id, customer_id) IN (?)", expired).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return purge.Scan(ctx, &purged)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, nil
+}
+
+func (repo *sqliteRepo) history(ctx context.Context, id string) ([]Revision, error) {
+	return selectHistory(ctx, repo.db, id)
+}
+
+func (repo *sqliteRepo) asOf(ctx context.Context, at time.Time) ([]Customer, error) {
+	return selectAsOf(ctx, repo.db, at)
+}
+
+// lock needs no row lock, transactions take the database lock when they
+// begin.
+func (repo *sqliteRepo) lock(ctx context.Context, tx bun.Tx, id string, trashed bool, ifVersion int64) (Customer, error) {
+	var customer Customer
+	query := tx.NewSelect().
+		Model(&customer).
+		Where("tenant_id = ?", tenantFromContext(ctx)).
+		Where("id = ?", id)
+	if trashed {
+		query = query.Where("deleted_at IS NOT NULL")
+	} else {
+		query = query.Where("deleted_at IS NULL")
+	}
+
+	if err := query.Scan(ctx); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Customer{}, ErrNotFound
+		}
+
+		return Customer{}, err
+	}
+
+	if ifVersion != 0 && customer.Version != ifVersion {
+		return Customer{}, ErrVersionConflict
+	}
+
+	return customer, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func setupSQLite(t *testing.T, existingCustomers []Customer) *bun.DB {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "customers.db"))
+	if err != nil {
+		t.Fatal("failed to open database:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := migrateSQLite(context.Background(), db); err != nil {
+		t.Fatal("failed to migrate:", err)
+	}
+
+	if len(existingCustomers) > 0 {
+		if _, err := db.NewInsert().Model(&existingCustomers).Exec(context.Background()); err != nil {
+			t.Fatal("failed to add customers:", err)
+		}
+	}
+
+	return db
+}
+
+func Test_migrateSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "customers.db")
+
+	for run := 0; run < 2; run++ {
+		db, err := openSQLite(path)
+		if err != nil {
+			t.Fatal("failed to open database:", err)
+		}
+
+		if err := migrateSQLite(context.Background(), db); err != nil {
+			t.Fatalf("run %d: failed to migrate: %v", run, err)
+		}
+
+		var version int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			t.Fatal("failed to read schema version:", err)
+		}
+		db.Close()
+
+		assert.Equal(t, len(sqliteSchema), version, "expect every schema step to be applied once")
+	}
+}
+
+func Test_migrateSQLite_backfillsHistory(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "customers.db"))
+	if err != nil {
+		t.Fatal("failed to open database:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// a database from before there was a history
+	schema := sqliteSchema
+	sqliteSchema = schema[:2]
+	err = migrateSQLite(context.Background(), db)
+	sqliteSchema = schema
+	if err != nil {
+		t.Fatal("failed to migrate:", err)
+	}
+
+	existing := []Customer{
+		{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919649127559"}, Version: 2},
+		trashed(Customer{Id: "vs", CustomerDetails: CustomerDetails{Name: "varshil", Address: "jaipur", ContactNo: "+917777777777"}, Version: 1}, conformanceDeletedAt),
+	}
+	if _, err := db.NewInsert().Model(&existing).Exec(context.Background()); err != nil {
+		t.Fatal("failed to add customers:", err)
+	}
+
+	if err := migrateSQLite(context.Background(), db); err != nil {
+		t.Fatal("failed to migrate:", err)
+	}
+
+	repo := NewSQLiteRepo(db)
+	live := customerHistory(t, repo, "hs")
+	if assert.Len(t, live, 1, "expect stored customer to get a revision") {
+		assert.Equal(t, RevisionCreated, live[0].Type, "expect customer to start out created")
+		assert.Equal(t, systemActor, live[0].Actor, "expect revision to be made by the system")
+		assert.Equal(t, &existing[0].CustomerDetails, live[0].NewDetails, "expect current details")
+		assert.Equal(t, int64(2), live[0].Version, "expect current version")
+	}
+
+	assert.Equal(t, []Revision{{
+		CustomerId: "vs",
+		Version:    1,
+		Type:       RevisionDeleted,
+		Actor:      systemActor,
+		At:         conformanceDeletedAt,
+		OldDetails: &existing[1].CustomerDetails,
+	}}, customerHistory(t, repo, "vs"), "expect trashed customer to start out deleted")
+
+	gotCustomers, err := repo.asOf(context.Background(), time.Now())
+	assert.NoError(t, err, "expect no error")
+	assert.Equal(t, []Customer{existing[0]}, gotCustomers, "expect backfilled history to be readable as of now")
+}
+
+func Test_migrateSQLite_tenants(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "customers.db"))
+	if err != nil {
+		t.Fatal("failed to open database:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// a database from before there were tenants
+	schema := sqliteSchema
+	sqliteSchema = schema[:len(schema)-1]
+	err = migrateSQLite(context.Background(), db)
+	sqliteSchema = schema
+	if err != nil {
+		t.Fatal("failed to migrate:", err)
+	}
+
+	repo := NewSQLiteRepo(db)
+	existing := Customer{Id: "hs", CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919649127559"}, Version: 1}
+	if _, err := db.NewInsert().Model(&existing).Exec(context.Background()); err != nil {
+		t.Fatal("failed to add customer:", err)
+	}
+
+	if err := migrateSQLite(context.Background(), db); err != nil {
+		t.Fatal("failed to migrate:", err)
+	}
+
+	gotCustomer, err := repo.getById(context.Background(), "hs")
+	assert.NoError(t, err, "expect no error")
+	assert.Equal(t, existing, gotCustomer, "expect stored customer to belong to the default tenant")
+
+	acme := contextWithTenant(context.Background(), "acme")
+	assert.NoError(t, repo.create(acme, existing, conformanceChange), "expect id to be free in another tenant")
+}
+
+func Test_sqliteRepo(t *testing.T) {
+	RunRepoConformance(t, func(t *testing.T, existing []Customer) Repo {
+		return NewSQLiteRepo(setupSQLite(t, existing))
+	})
+}
+
+func Test_sqliteRepo_tenants(t *testing.T) {
+	RunTenantConformance(t, func(t *testing.T, existing []Customer) Repo {
+		return NewSQLiteRepo(setupSQLite(t, existing))
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Storage is an opened storage backend, the fields it lacks are nil.
+type Storage struct {
+	Repo       Repo
+	AuditLog   AuditLog
+	APIKeys    APIKeyStore
+	Migrator   *Migrator
+	ChangeFeed ChangeFeed
+	Close      func() error
+}
+
+var storageFactories = map[string]func(ctx context.Context, config Config) (Storage, error){
+	"postgres": openPostgresStorage,
+	"sqlite":   openSQLiteStorage,
+	"file":     openFileStorage,
+	"memory":   openMemoryStorage,
+}
+
+func OpenStorage(ctx context.Context, config Config) (Storage, error) {
+	open, ok := storageFactories[config.Repo]
+	if !ok {
+		return Storage{}, fmt.Errorf("unknown repo %q", config.Repo)
+	}
+
+	return open(ctx, config)
+}
+
+func openPostgresStorage(ctx context.Context, config Config) (Storage, error) {
+	db := openPostgres(config.DB)
+
+	migrator, err := NewEmbeddedMigrator(db)
+	if err != nil {
+		db.Close()
+		return Storage{}, err
+	}
+
+	var opts []PostgresRepoOption
+	if config.DB.RowLevelSecurity {
+		opts = append(opts, WithRowLevelSecurity())
+	}
+
+	storage := Storage{
+		Repo:     NewPostgresRepo(db, opts...),
+		AuditLog: NewPostgresAuditLog(db),
+		APIKeys:  NewSQLAPIKeyStore(db),
+		Migrator: migrator,
+		Close:    db.Close,
+	}
+	if config.Notify.ChangeFeed {
+		storage.ChangeFeed = NewPostgresChangeFeed(db)
+	}
+
+	return storage, nil
+}
+
+func openSQLiteStorage(ctx context.Context, config Config) (Storage, error) {
+	db, err := openSQLite(config.SQLite.Path)
+	if err != nil {
+		return Storage{}, err
+	}
+
+	if err := migrateSQLite(ctx, db); err != nil {
+		db.Close()
+		return Storage{}, fmt.Errorf("%s: %w", config.SQLite.Path, err)
+	}
+
+	return Storage{
+		Repo:     NewSQLiteRepo(db),
+		AuditLog: NewSQLiteAuditLog(db),
+		APIKeys:  NewSQLAPIKeyStore(db),
+		Close:    db.Close,
+	}, nil
+}
+
+func openFileStorage(ctx context.Context, config Config) (Storage, error) {
+	// checked by LoadConfig
+	fsync, _ := fsyncPolicyByName(config.File.Fsync)
+
+	repo, err := OpenFileRepo(config.File.Dir,
+		WithFsyncPolicy(fsync, config.File.SyncInterval),
+		WithSnapshotEvery(config.File.SnapshotEvery))
+	if err != nil {
+		return Storage{}, fmt.Errorf("%s: %w", config.File.Dir, err)
+	}
+
+	audit, err := OpenFileAuditLog(config.File.Dir)
+	if err != nil {
+		repo.Close()
+		return Storage{}, fmt.Errorf("%s: %w", config.File.Dir, err)
+	}
+
+	close := func() error {
+		return errors.Join(repo.Close(), audit.Close())
+	}
+
+	return Storage{Repo: repo, AuditLog: audit, Close: close}, nil
+}
+
+func openMemoryStorage(ctx context.Context, config Config) (Storage, error) {
+	repo := NewTenantRepos(func() Repo { return NewInMemoryRepo() })
+	return Storage{Repo: repo, AuditLog: NewMemoryAuditLog(), Close: func() error { return nil }}, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenStorage(t *testing.T) {
+	tests := []struct {
+		name         string
+		repo         string
+		wantMigrator bool
+		wantErr      bool
+	}{
+		{name: "memory repo", repo: "memory"},
+		{name: "sqlite repo", repo: "sqlite"},
+		{name: "file repo", repo: "file"},
+		{name: "postgres repo", repo: "postgres", wantMigrator: true},
+		{name: "unknown repo", repo: "mongo", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.Repo = tt.repo
+			config.DB.DSN = testDSN
+			config.SQLite.Path = filepath.Join(t.TempDir(), "customers.db")
+			config.File.Dir = t.TempDir()
+			config.Notify.ChangeFeed = false
+
+			storage, err := OpenStorage(context.Background(), config)
+			if tt.wantErr {
+				assert.Error(t, err, "expect unknown repo to fail")
+				return
+			}
+			if !assert.NoError(t, err, "expect storage to open") {
+				return
+			}
+			defer storage.Close()
+
+			assert.NotNil(t, storage.Repo, "expect a repo")
+			assert.NotNil(t, storage.AuditLog, "expect an audit log")
+			assert.Equal(t, tt.wantMigrator, storage.Migrator != nil, "expect a migrator only for postgres")
+		})
+	}
+}
+
+func Test_storageFactories(t *testing.T) {
+	for _, name := range repoBackends {
+		assert.Contains(t, storageFactories, name, "expect every repo backend to have a factory")
+	}
+	assert.Len(t, storageFactories, len(repoBackends), "expect every factory to be listed as a repo backend")
+}
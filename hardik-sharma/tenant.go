@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+)
+
+// maxTenantLength is that of postgres identifiers.
+const maxTenantLength = 63
+
+const (
+	// DefaultTenant is that of callers without one
+	DefaultTenant = "default"
+	// allTenants lets the purge job go through every tenant
+	allTenants = "*"
+)
+
+type tenantKey struct{}
+
+func validTenant(tenant string) bool {
+	if tenant == "" || len(tenant) > maxTenantLength {
+		return false
+	}
+
+	for i := 0; i < len(tenant); i++ {
+		c := tenant[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func tenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// tenantRepos keeps the customers of each tenant in a repo of their own.
+type tenantRepos struct {
+	mu      sync.Mutex
+	repos   map[string]Repo
+	newRepo func() Repo
+}
+
+func NewTenantRepos(newRepo func() Repo) *tenantRepos {
+	return &tenantRepos{repos: map[string]Repo{}, newRepo: newRepo}
+}
+
+func (t *tenantRepos) of(ctx context.Context) Repo {
+	tenant := tenantFromContext(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	repo, ok := t.repos[tenant]
+	if !ok {
+		repo = t.newRepo()
+		t.repos[tenant] = repo
+	}
+	return repo
+}
+
+func (t *tenantRepos) create(ctx context.Context, c Customer, change Change) error {
+	return t.of(ctx).create(ctx, c, change)
+}
+
+func (t *tenantRepos) getAll(ctx context.Context) ([]Customer, error) {
+	return t.of(ctx).getAll(ctx)
+}
+
+func (t *tenantRepos) list(ctx context.Context, opts ListOptions) (CustomerPage, error) {
+	return t.of(ctx).list(ctx, opts)
+}
+
+func (t *tenantRepos) search(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	return t.of(ctx).search(ctx, opts)
+}
+
+func (t *tenantRepos) getById(ctx context.Context, id string) (Customer, error) {
+	return t.of(ctx).getById(ctx, id)
+}
+
+func (t *tenantRepos) update(ctx context.Context, id string, updateCustomer Customer, ifVersion int64, change Change) (Customer, error) {
+	return t.of(ctx).update(ctx, id, updateCustomer, ifVersion, change)
+}
+
+func (t *tenantRepos) patch(ctx context.Context, id string, p CustomerPatch, ifVersion int64, change Change) (Customer, error) {
+	return t.of(ctx).patch(ctx, id, p, ifVersion, change)
+}
+
+func (t *tenantRepos) delete(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	return t.of(ctx).delete(ctx, id, ifVersion, change)
+}
+
+func (t *tenantRepos) trash(ctx context.Context) ([]Customer, error) {
+	return t.of(ctx).trash(ctx)
+}
+
+func (t *tenantRepos) restore(ctx context.Context, id string, ifVersion int64, change Change) (Customer, error) {
+	return t.of(ctx).restore(ctx, id, ifVersion, change)
+}
+
+func (t *tenantRepos) purge(ctx context.Context, before time.Time) ([]purgedCustomer, error) {
+	if tenantFromContext(ctx) != allTenants {
+		return t.of(ctx).purge(ctx, before)
+	}
+
+	t.mu.Lock()
+	repos := maps.Clone(t.repos)
+	t.mu.Unlock()
+
+	var purged []purgedCustomer
+	for tenant, repo := range repos {
+		customers, err := repo.purge(ctx, before)
+		for _, customer := range customers {
+			customer.Tenant = tenant
+			purged = append(purged, customer)
+		}
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+func (t *tenantRepos) history(ctx context.Context, id string) ([]Revision, error) {
+	return t.of(ctx).history(ctx, id)
+}
+
+func (t *tenantRepos) asOf(ctx context.Context, at time.Time) ([]Customer, error) {
+	return t.of(ctx).asOf(ctx, at)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_validTenant(t *testing.T) {
+	tests := []struct {
+		name   string
+		tenant string
+		want   bool
+	}{
+		{name: "letters", tenant: "acme", want: true},
+		{name: "digits, dashes and underscores", tenant: "acme-2_eu", want: true},
+		{name: "longest", tenant: strings.Repeat("a", maxTenantLength), want: true},
+		{name: "empty", tenant: "", want: false},
+		{name: "too long", tenant: strings.Repeat("a", maxTenantLength+1), want: false},
+		{name: "upper case", tenant: "Acme", want: false},
+		{name: "every tenant", tenant: allTenants, want: false},
+		{name: "space", tenant: "acme corp", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, validTenant(tt.tenant), "expect validity to be same")
+		})
+	}
+}
+
+func Test_tenantFromContext(t *testing.T) {
+	assert.Equal(t, DefaultTenant, tenantFromContext(context.Background()), "expect default tenant without one")
+	assert.Equal(t, "acme", tenantFromContext(contextWithTenant(context.Background(), "acme")), "expect tenant of the context")
+}
+
+func newTestTenantRepos(existing []Customer) *tenantRepos {
+	customers := make([]Customer, len(existing))
+	copy(customers, existing)
+
+	repos := NewTenantRepos(func() Repo { return NewInMemoryRepo() })
+	repos.repos[DefaultTenant] = &InMemoryRepo{customers: customers}
+	return repos
+}
+
+func Test_tenantRepos(t *testing.T) {
+	RunRepoConformance(t, func(t *testing.T, existing []Customer) Repo {
+		return newTestTenantRepos(existing)
+	})
+}
+
+func Test_tenantRepos_tenants(t *testing.T) {
+	RunTenantConformance(t, func(t *testing.T, existing []Customer) Repo {
+		return newTestTenantRepos(existing)
+	})
+}
@@ -1,6 +1,6 @@
 import { useEffect, useState } from 'react'
-import { deleteCustomer, createCustomer, fetchAllCustomers, updateCustomer } from '@/api'
-import { Customer } from './customer'
+import { deleteCustomer, createCustomer, errorMessage, fetchAllCustomers, updateCustomer } from '@/api'
+import { ChangeEvent, Customer, applyChangeEvent } from './customer'
 import { Alert, AlertDescription, AlertIcon, AlertTitle, Box, Center } from '@chakra-ui/react'
 import CustomerTable from './CustomerTable'
 
@@ -21,46 +21,60 @@ export default function CustomerManagement() {
     useEffect(() => {
         loadCustomerList()
 
-        const socket = new WebSocket("ws://127.0.0.1:8080/ws")
-        console.log("attempting to connect ......")
+        // lastSeq remembers the newest event applied, a dropped connection
+        // resumes from there and only receives what it missed
+        let lastSeq: number | undefined
+        let socket: WebSocket
+        let reconnectTimer: ReturnType<typeof setTimeout>
+        let closed = false
 
-        socket.onopen = () => {
-            console.log("websocket connected")
-        }
+        const connect = () => {
+            const query = lastSeq === undefined ? "snapshot=true" : `since=${lastSeq}`
+            socket = new WebSocket(`ws://127.0.0.1:8080/ws?${query}`)
+            console.log("attempting to connect ......")
 
-        socket.onmessage = (msg) => {
-            const message = msg.data
-            const customerList = JSON.parse(message)
-            setCustomers(customerList)
-        }
+            socket.onopen = () => {
+                console.log("websocket connected")
+            }
 
-        socket.onclose = () => {
-            console.log("connection closed")
-        }
+            socket.onmessage = (msg) => {
+                const event: ChangeEvent = JSON.parse(msg.data)
+                lastSeq = event.seq
+                setCustomers((customers) => applyChangeEvent(customers, event))
+            }
+
+            socket.onclose = () => {
+                console.log("connection closed")
+                if (!closed) {
+                    reconnectTimer = setTimeout(connect, 1000)
+                }
+            }
 
-        socket.onerror = (err) => {
-            console.log(err)
+            socket.onerror = (err) => {
+                console.log(err)
+            }
         }
 
+        connect()
+
         return () => {
+            closed = true
+            clearTimeout(reconnectTimer)
             socket.close()
         }
     }, [])
 
     function addCustomer(cust: Customer): Promise<void> {
-        const id = Math.random().toString(36).substring(2, 4)
-        cust.id = id
-
         return createCustomer(cust)
-            .then((res) => {
+            .then(() => {
                 setErrMsg("")
-                setSuccessMsg(res)
+                setSuccessMsg("customer registered")
                 setTimeout(() => {
                     setSuccessMsg("")
                 }, 1000)
             })
             .catch((err) => {
-                setErrMsg(err.response.data)
+                setErrMsg(errorMessage(err))
                 setSuccessMsg("")
                 throw err
             })
@@ -75,19 +89,19 @@ export default function CustomerManagement() {
             }, 1000)
         })
             .catch((err) => {
-                setErrMsg(err.response.data)
+                setErrMsg(errorMessage(err))
                 setSuccessMsg("")
                 throw err
             })
     }
 
-    const onDelete = (id: string): Promise<void> => {
-        return deleteCustomer(id)
+    const onDelete = (cust: Customer): Promise<void> => {
+        return deleteCustomer(cust)
             .then(() => {
                 setErrMsg("")
             })
             .catch((err) => {
-                setErrMsg(err.response.data)
+                setErrMsg(errorMessage(err))
             })
 
     }
@@ -10,7 +10,7 @@ import CustomerRowForm from './CustomerRowForm'
 export interface CustomerRowProps {
     customer: Customer
     saveCustomer(customer: Customer): Promise<void>
-    onDelete(id: string): Promise<void>
+    onDelete(customer: Customer): Promise<void>
 }
 
 export default function CustomerRow({ customer, saveCustomer, onDelete }: CustomerRowProps) {
@@ -41,12 +41,12 @@ export default function CustomerRow({ customer, saveCustomer, onDelete }: Custom
 interface RowDataProps {
     customer: Customer
     setShowFormTrue(): void
-    onDelete(id: string): void
+    onDelete(customer: Customer): void
 }
 
 function DataRow({ customer, setShowFormTrue, onDelete }: RowDataProps) {
     const handleDelete = () => {
-        onDelete(customer.id)
+        onDelete(customer)
     }
 
     return (
@@ -17,7 +17,7 @@ export default function CustomerRowForm({ customer, submit }: CustomerRowFormPro
     const [formInputData, setFormInputData] = useState<CustomerFormData>({
         name: customer.customerDetails.name,
         address: customer.customerDetails.address,
-        contactNo: customer.customerDetails.contactNo.toString()
+        contactNo: customer.customerDetails.contactNo
     })
 
     const handleChange = (e: ChangeEvent<HTMLInputElement>): void => {
@@ -27,10 +27,11 @@ export default function CustomerRowForm({ customer, submit }: CustomerRowFormPro
     const handleSubmit = (): void => {
         const cust: Customer = {
             id: customer.id,
+            version: customer.version,
             customerDetails: {
                 name: formInputData.name,
                 address: formInputData.address,
-                contactNo: parseInt(formInputData.contactNo)
+                contactNo: formInputData.contactNo
             }
         }
         submit(cust)
@@ -79,7 +80,7 @@ export default function CustomerRowForm({ customer, submit }: CustomerRowFormPro
                             variant="filled"
                             color="chakra-body-bg._dark"
                             width="240px"
-                            type='number'
+                            type='tel'
                             placeholder="Contact Number"
                             name="contactNo"
                             defaultValue={formInputData.contactNo}
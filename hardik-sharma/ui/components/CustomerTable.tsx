@@ -18,7 +18,7 @@ const defaultCustomer: Customer = {
     customerDetails: {
         name: "",
         address: "",
-        contactNo: 0
+        contactNo: ""
     }
 }
 
@@ -26,7 +26,7 @@ export interface CustomerTableProps {
     customers: Customer[]
     saveCustomer(customer: Customer): Promise<void>
     addCustomer(customer: Customer): Promise<void>
-    onDelete(id: string): Promise<void>
+    onDelete(customer: Customer): Promise<void>
 }
 
 export default function CustomerTable({ customers, saveCustomer, addCustomer, onDelete }: CustomerTableProps) {
@@ -2,12 +2,40 @@
 export interface Customer {
     id: string
     customerDetails: CustomerDetails
+    // version is what the customer was read at, unset before it is created
+    version?: number
+    // deletedAt is only set on customers in the trash
+    deletedAt?: string
 }
 
 export interface CustomerDetails {
     name: string
     address: string
-    contactNo: number
+    contactNo: string
 }
 
+export interface CustomerPage {
+    customers: Customer[]
+    next?: string
+}
+
+export interface ChangeEvent {
+    type: "snapshot" | "customer.created" | "customer.updated" | "customer.deleted" | "customer.restored"
+    seq: number
+    customer?: Customer
+    customers?: Customer[]
+}
 
+export function applyChangeEvent(customers: Customer[], event: ChangeEvent): Customer[] {
+    switch (event.type) {
+        case "snapshot":
+            return event.customers ?? []
+        case "customer.created":
+        case "customer.restored":
+            return [...customers.filter((c) => c.id !== event.customer!.id), event.customer!]
+        case "customer.updated":
+            return customers.map((c) => c.id === event.customer!.id ? event.customer! : c)
+        case "customer.deleted":
+            return customers.filter((c) => c.id !== event.customer!.id)
+    }
+}
@@ -1,25 +1,53 @@
 import axios from "axios"
-import { Customer } from "../components/customer"
+import { Customer, CustomerPage } from "../components/customer"
 
-export function fetchAllCustomers(): Promise<Customer[]> {
-    return axios.get("/api/customers")
+export function fetchCustomerPage(cursor?: string): Promise<CustomerPage> {
+    return axios.get("/api/customers", { params: { limit: 500, cursor } })
         .then((response) => {
             return response.data
         })
 }
 
-export function createCustomer(data: Customer): Promise<string> {
+export async function fetchAllCustomers(): Promise<Customer[]> {
+    const customers: Customer[] = []
+    let cursor: string | undefined
+    do {
+        const page = await fetchCustomerPage(cursor)
+        customers.push(...page.customers)
+        cursor = page.next
+    } while (cursor)
+    return customers
+}
+
+export function createCustomer(data: Customer): Promise<Customer> {
     return axios.post("/api/customers", data)
         .then((res) => { return res.data })
 }
 
+// ifMatch makes a write fail with 412 when the customer changed since it
+// was read, instead of silently overwriting that change.
+function ifMatch(customer: Customer) {
+    return customer.version === undefined ? {} : { "If-Match": `"${customer.version}"` }
+}
+
 export function updateCustomer(data: Customer): Promise<string> {
-    return axios.put("/api/customers", data)
+    return axios.put("/api/customers", data, { headers: ifMatch(data) })
         .then((res) => {
             return res.data
         })
 }
 
-export function deleteCustomer(id: string): Promise<void> {
-    return axios.delete(`/api/customers/${id}`)
-}
\ No newline at end of file
+export function deleteCustomer(customer: Customer): Promise<void> {
+    return axios.delete(`/api/customers/${customer.id}`, { headers: ifMatch(customer) })
+}
+
+// errorMessage turns a failed request into text for the user, preferring
+// the detail of the problem the API responded with.
+export function errorMessage(err: any): string {
+    const problem = err.response?.data
+    if (problem && typeof problem === "object" && problem.title) {
+        const fields = (problem.errors ?? []).map((e: { field: string, reason: string }) => `${e.field}: ${e.reason}`)
+        return [problem.detail ?? problem.title, ...fields].join(", ")
+    }
+    return err.message
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrValidation = errors.New("validation failed")
+
+var ErrRequired = errors.New("is required")
+var ErrInvalidCharacters = errors.New("contains invalid characters")
+
+const (
+	MaxNameLength    = 100
+	MaxAddressLength = 500
+)
+
+// Violation is one rule a field broke.
+type Violation struct {
+	Field string
+	Err   error
+}
+
+// ValidationError lists every violation found in a customer. It matches
+// ErrValidation as well as the error of each violation with errors.Is.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		reasons[i] = violation.Field + " " + violation.Err.Error()
+	}
+
+	return ErrValidation.Error() + ": " + strings.Join(reasons, ", ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Violations))
+	for i, violation := range e.Violations {
+		errs[i] = violation.Err
+	}
+
+	return errs
+}
+
+func (e *ValidationError) fieldErrors() []FieldError {
+	fields := make([]FieldError, len(e.Violations))
+	for i, violation := range e.Violations {
+		fields[i] = FieldError{Field: violation.Field, Reason: violation.Err.Error()}
+	}
+
+	return fields
+}
+
+// StringRule checks a string value, returning why it is rejected.
+type StringRule func(value string) error
+
+func Required() StringRule {
+	return func(value string) error {
+		if strings.TrimSpace(value) == "" {
+			return ErrRequired
+		}
+		return nil
+	}
+}
+
+// MaxLength limits the number of characters, not bytes.
+func MaxLength(n int) StringRule {
+	return func(value string) error {
+		if utf8.RuneCountInString(value) > n {
+			return fmt.Errorf("must be at most %d characters", n)
+		}
+		return nil
+	}
+}
+
+// Charset accepts values made up only of characters allowed reports true
+// for.
+func Charset(allowed func(r rune) bool) StringRule {
+	return func(value string) error {
+		if !utf8.ValidString(value) || strings.IndexFunc(value, func(r rune) bool { return !allowed(r) }) >= 0 {
+			return ErrInvalidCharacters
+		}
+		return nil
+	}
+}
+
+// Printable allows letters, marks, numbers, punctuation, symbols and plain
+// spaces, which keeps control characters and line breaks out.
+func Printable(r rune) bool {
+	return r == ' ' || (unicode.IsPrint(r) && !unicode.IsSpace(r))
+}
+
+// fieldRules checks one field of a customer, stopping at the first rule it
+// breaks.
+type fieldRules struct {
+	field string
+	check func(customer Customer) error
+}
+
+func stringField(field string, value func(c Customer) string, rules ...StringRule) fieldRules {
+	return fieldRules{field: field, check: func(c Customer) error {
+		for _, rule := range rules {
+			if err := rule(value(c)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
+
+// customerRules describes a valid customer, field paths follow its JSON
+// form.
+var customerRules = []fieldRules{
+	stringField("id", func(c Customer) string { return c.Id }, validateId),
+	stringField("customerDetails.name", func(c Customer) string { return c.CustomerDetails.Name },
+		Required(), MaxLength(MaxNameLength), Charset(Printable)),
+	stringField("customerDetails.address", func(c Customer) string { return c.CustomerDetails.Address },
+		Required(), MaxLength(MaxAddressLength), Charset(Printable)),
+	stringField("customerDetails.contactNo", func(c Customer) string { return string(c.CustomerDetails.ContactNo) },
+		Required(), validateContactNo),
+}
+
+func validate(customer Customer, rules []fieldRules) error {
+	var violations []Violation
+	for _, field := range rules {
+		if err := field.check(customer); err != nil {
+			violations = append(violations, Violation{Field: field.field, Err: err})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// validateCustomer reports every way customer breaks customerRules at once.
+func validateCustomer(customer Customer) error {
+	return validate(customer, customerRules)
+}
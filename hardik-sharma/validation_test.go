@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_validateCustomer(t *testing.T) {
+	valid := Customer{
+		Id:              "hs",
+		CustomerDetails: CustomerDetails{Name: "hardik", Address: "udaipur", ContactNo: "+919999999999"},
+	}
+
+	tests := []struct {
+		name       string
+		change     func(c *Customer)
+		wantFields []FieldError
+	}{
+		{
+			name:   "valid customer",
+			change: func(c *Customer) {},
+		},
+		{
+			name: "unicode name",
+			change: func(c *Customer) {
+				c.CustomerDetails.Name = "Hárdik Śharma"
+			},
+		},
+		{
+			name: "blank name",
+			change: func(c *Customer) {
+				c.CustomerDetails.Name = "  "
+			},
+			wantFields: []FieldError{{Field: "customerDetails.name", Reason: "is required"}},
+		},
+		{
+			name: "overlong address",
+			change: func(c *Customer) {
+				c.CustomerDetails.Address = strings.Repeat("a", MaxAddressLength+1)
+			},
+			wantFields: []FieldError{{Field: "customerDetails.address", Reason: "must be at most 500 characters"}},
+		},
+		{
+			name: "control characters",
+			change: func(c *Customer) {
+				c.CustomerDetails.Name = "hardik\nsharma"
+			},
+			wantFields: []FieldError{{Field: "customerDetails.name", Reason: "contains invalid characters"}},
+		},
+		{
+			name: "every violation is reported",
+			change: func(c *Customer) {
+				*c = Customer{Id: "h", CustomerDetails: CustomerDetails{ContactNo: "99"}}
+			},
+			wantFields: []FieldError{
+				{Field: "id", Reason: "invalid id"},
+				{Field: "customerDetails.name", Reason: "is required"},
+				{Field: "customerDetails.address", Reason: "is required"},
+				{Field: "customerDetails.contactNo", Reason: "invalid contact number"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			customer := valid
+			tt.change(&customer)
+
+			err := validateCustomer(customer)
+			if tt.wantFields == nil {
+				assert.NoError(t, err, "expected customer to be valid")
+				return
+			}
+
+			var validationErr *ValidationError
+			if assert.ErrorAs(t, err, &validationErr, "expected a validation error") {
+				assert.Equal(t, tt.wantFields, validationErr.fieldErrors(), "expected field errors to be same")
+			}
+			assert.ErrorIs(t, err, ErrValidation, "expected error to match ErrValidation")
+		})
+	}
+}
+
+func TestValidationError_Is(t *testing.T) {
+	err := validateCustomer(Customer{Id: "hs", CustomerDetails: CustomerDetails{ContactNo: "99"}})
+
+	assert.ErrorIs(t, err, ErrRequired, "expected violations to be matched")
+	assert.ErrorIs(t, err, ErrInvalidContactNo, "expected violations to be matched")
+	assert.NotErrorIs(t, err, ErrInvalidId, "expected valid fields not to be matched")
+}